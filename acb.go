@@ -0,0 +1,175 @@
+package ledger
+
+import (
+	"sort"
+	"time"
+)
+
+// SuperficialLossDays is the number of days before and after a disposition at a loss in which acquiring the same
+// property makes the loss superficial, under Canada's superficial loss rule.
+const SuperficialLossDays = 30
+
+type (
+	// adjustedCostBase replays the journal, keeping a running adjusted cost base (ACB) for each currency across all
+	// accounts, see CanadianACB. It sets the cost basis of every TaxableGains lot to the ACB of the amount disposed of,
+	// and denies superficial losses.
+	//
+	// A disposition takes the average cost of what's held, and so does an amount withdrawn by a non-taxable exchange,
+	// whose ACB is carried over to the currency received. A loss is superficial when the same currency is acquired in
+	// the SuperficialLossDays before or after the disposition, and some is still held at the end of that period.
+	// The loss is denied for as much of the amount disposed of as was acquired and is still held, and added to the ACB
+	// of the currency held instead. Each disposition is considered on its own, so an acquisition may make the losses of
+	// several dispositions superficial.
+	adjustedCostBase struct {
+		basisPlaces int32
+		// events are each currency's events, in date order and then the order they were added
+		events map[Currency][]*acbEvent
+		added  int
+	}
+
+	// acbEvent is an event, with what's held afterwards.
+	acbEvent struct {
+		costBasisEvent
+		// seq is the order the event was added in.
+		seq int
+		// held is the amount held after the event, and acb its adjusted cost base.
+		held, acb Decimal
+	}
+)
+
+func newAdjustedCostBase(basisPlaces int32) *adjustedCostBase {
+	return &adjustedCostBase{basisPlaces: basisPlaces, events: map[Currency][]*acbEvent{}}
+}
+
+// add adds the event after the currency's events on or before its date.
+func (r *adjustedCostBase) add(e costBasisEvent) {
+	events := r.events[e.currency]
+	i := sort.Search(len(events), func(i int) bool { return events[i].date.After(e.date) })
+	events = append(events, nil)
+	copy(events[i+1:], events[i:])
+	events[i] = &acbEvent{costBasisEvent: e, seq: r.added}
+	r.events[e.currency] = events
+	r.added++
+}
+
+// replay works out the ACB again from SuperficialLossDays before the changed date onwards, since the losses of the
+// dispositions before then can't be made superficial by anything that changed. It goes through the events in date
+// order across the currencies, since non-taxable exchanges carry the ACB over from one currency to another.
+func (r *adjustedCostBase) replay(changed time.Time) {
+	from := changed.AddDate(0, 0, -SuperficialLossDays)
+	next := map[Currency]int{}
+	for currency, events := range r.events {
+		start := firstACBEvent(events, from)
+		next[currency] = start
+		// the amounts held, to look ahead to the end of each superficial loss period
+		var held Decimal
+		if start > 0 {
+			held = events[start-1].held
+		}
+		for _, e := range events[start:] {
+			switch e.event {
+			case acquiredEvent:
+				held = held.Add(e.amount)
+			case disposedEvent, withdrawnEvent:
+				held = held.Sub(e.amount)
+			}
+			e.held = held
+		}
+	}
+
+	for {
+		var (
+			currency Currency
+			first    *acbEvent
+		)
+		for c, i := range next {
+			if events := r.events[c]; i < len(events) {
+				if e := events[i]; first == nil || e.date.Before(first.date) || e.date.Equal(first.date) && e.seq < first.seq {
+					currency, first = c, e
+				}
+			}
+		}
+		if first == nil {
+			return
+		}
+		r.apply(currency, next[currency])
+		next[currency]++
+	}
+}
+
+// apply applies the i'th event of the currency to the ACB held after the event before it.
+func (r *adjustedCostBase) apply(currency Currency, i int) {
+	events := r.events[currency]
+	e := events[i]
+	var held, acb Decimal
+	if i > 0 {
+		held, acb = events[i-1].held, events[i-1].acb
+	}
+	averageCost := func() Decimal {
+		if e.amount.Cmp(held) >= 0 {
+			return acb
+		}
+		return acb.Mul(e.amount).Quo(held, r.basisPlaces)
+	}
+	switch e.event {
+	case acquiredEvent:
+		if e.carry != nil {
+			acb = acb.Add(e.carry.cost)
+		} else {
+			acb = acb.Add(e.cost)
+		}
+	case feeEvent:
+		acb = acb.Add(e.cost)
+	case withdrawnEvent:
+		cost := averageCost()
+		acb = acb.Sub(cost)
+		if e.carry != nil {
+			e.carry.cost = cost
+		}
+	case disposedEvent:
+		cost := averageCost()
+		acb = acb.Sub(cost)
+
+		details := e.gains.taxableGainsDetails
+		details.costBasis = cost
+		details.superficialLoss = r.superficialLoss(events, i)
+		acb = acb.Add(details.superficialLoss)
+	}
+	e.acb = acb
+}
+
+// superficialLoss returns the part of the loss from the i'th event, a disposition whose cost basis is set,
+// which is denied by the superficial loss rule.
+func (r *adjustedCostBase) superficialLoss(events []*acbEvent, i int) Decimal {
+	disposal := events[i]
+	details := disposal.gains.taxableGainsDetails
+	loss := details.costBasis.Sub(details.proceeds)
+	if loss.Sign() <= 0 {
+		return Decimal{}
+	}
+	start, end := disposal.date.AddDate(0, 0, -SuperficialLossDays), disposal.date.AddDate(0, 0, SuperficialLossDays)
+	var acquired Decimal
+	heldAtEnd := disposal.held
+	for j := firstACBEvent(events, start); j < len(events) && !events[j].date.After(end); j++ {
+		if events[j].event == acquiredEvent {
+			acquired = acquired.Add(events[j].amount)
+		}
+		if j > i {
+			heldAtEnd = events[j].held
+		}
+	}
+
+	substituted := minDecimal(disposal.amount, minDecimal(acquired, heldAtEnd))
+	switch {
+	case substituted.Sign() <= 0:
+		return Decimal{}
+	case substituted.Cmp(disposal.amount) == 0:
+		return loss
+	}
+	return loss.Mul(substituted).Quo(disposal.amount, r.basisPlaces)
+}
+
+// firstACBEvent returns the index of the first event on or after the date.
+func firstACBEvent(events []*acbEvent, date time.Time) int {
+	return sort.Search(len(events), func(i int) bool { return !events[i].date.Before(date) })
+}
@@ -0,0 +1,171 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestCanadianACB(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, nil)
+	g.Expect(l.SetCostBasisMethod(ledger.CanadianACB)).To(Succeed())
+	_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("10"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// held in another account, but it's the same property
+	_, err = l.Purchase(d("2018-02-01"), "1", Bitfinex, BTC, n("10"), n("3000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// at the average cost of $200, whichever lot it comes from
+	_, err = l.SellTaxable(d("2018-03-01"), "1.1", BTC, n("5"), n("2000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-06-01"), "1.2", BTC, n("10"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// reacquired within 30 days and still held, so 4/10 of the loss is superficial
+	_, err = l.Purchase(d("2018-06-20"), "1", Coinbase, BTC, n("4"), n("400"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-09-01"), "1.1", BTC, n("5"), n("1500"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// nothing's reacquired
+	_, err = l.SellTaxable(d("2019-01-10"), "1.3", BTC, n("4"), n("300"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(l.PrintTaxableGains()).To(Equal(
+		`1.1.1	2018-03-01 Taxable Gains (short-term) from sale on Coinbase of BTC 5.000000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 2000.000000, gains=USD 1000.000000, note=sold BTC for USD
+1.2.1	2018-06-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 10.000000000 originally purchased 2018-02-01 for USD 2000.000000. proceeds=USD 1000.000000, gains=USD -600.000000, note=sold BTC for USD, superficial loss denied=USD 400.000000
+1.1.2	2018-09-01 Taxable Gains (short-term) from sale on Coinbase of BTC 5.000000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 1500.000000, gains=USD 500.000000, note=sold BTC for USD
+1.3.1	2019-01-10 Taxable Gains (short-term) from sale on Coinbase of BTC 4.000000000 originally purchased 2018-06-20 for USD 800.000000. proceeds=USD 300.000000, gains=USD -500.000000, note=sold BTC for USD
+(2018's capital gains: short-term:$900.00 long-term:$0.00)
+(2019's capital gains: short-term:$-500.00 long-term:$0.00)
+(Total capital gains: short-term:$400.00 long-term:$0.00)
+`))
+	g.Expect(ledger.PrintSchedule3(l.Schedule3())).To(Equal(
+		`2018 Schedule 3
+description       disposed    proceeds of disposition  adjusted cost base  superficial loss  gain (or loss)
+5.000000000 BTC   2018-03-01  2000.00                  1000.00                               1000.00
+10.000000000 BTC  2018-06-01  1000.00                  2000.00             400.00            -600.00
+5.000000000 BTC   2018-09-01  1500.00                  1000.00                               500.00
+totals                        4500.00                  4000.00             400.00            900.00
+(taxable capital gain: 450.00)
+
+2019 Schedule 3
+description      disposed    proceeds of disposition  adjusted cost base  superficial loss  gain (or loss)
+4.000000000 BTC  2019-01-10  300.00                   800.00                                -500.00
+totals                       300.00                   800.00              0.00              -500.00
+(allowable capital loss: 250.00)
+`))
+
+	saved := &bytes.Buffer{}
+	g.Expect(l.Save(saved)).To(Succeed())
+	loaded, err := ledger.Load(saved, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.CostBasisMethod()).To(Equal(ledger.CanadianACB))
+	g.Expect(loaded.PrintTaxableGains()).To(Equal(l.PrintTaxableGains()))
+
+	// the loaded ledger carries on the same way: reacquired, so the last loss is superficial
+	for _, l := range []*ledger.Ledger{l, loaded} {
+		_, err = l.Purchase(d("2019-01-20"), "1", Coinbase, BTC, n("4"), n("300"))
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(loaded.PrintTaxableGains()).To(Equal(l.PrintTaxableGains()))
+	g.Expect(ledger.PrintSchedule3(loaded.Schedule3())).To(Equal(ledger.PrintSchedule3(l.Schedule3())))
+}
+
+func TestCanadianACBNonTaxableExchanges(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, nil)
+	g.Expect(l.SetCostBasisMethod(ledger.CanadianACB)).To(Succeed())
+	_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("10"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-05-01"), "1", Coinbase, BTC, n("2"), n("700"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// at the average cost of $141.67 a BTC, which is carried over to ETH
+	_, err = l.ExchangeNonTaxable(d("2018-05-01"), "1.2", BTC, n("2"), n("0"), ETH, n("20"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-08-01"), "1.1", BTC, n("5"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-08-01"), "1.2.1", ETH, n("10"), n("500"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(ledger.PrintSchedule3(l.Schedule3())).To(Equal(
+		`2018 Schedule 3
+description       disposed    proceeds of disposition  adjusted cost base  superficial loss  gain (or loss)
+5.000000000 BTC   2018-08-01  1000.00                  708.34                                291.66
+10.000000000 ETH  2018-08-01  500.00                   141.67                                358.33
+totals                        1500.00                  850.01              0.00              649.99
+(taxable capital gain: 325.00)
+`))
+}
+
+func TestCanadianACBOutOfDateOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	newLedger := func() *ledger.Ledger {
+		l := ledger.New(USD, nil)
+		g.Expect(l.SetCostBasisMethod(ledger.CanadianACB)).To(Succeed())
+		_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("10"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		return l
+	}
+	inOrder, outOfOrder := newLedger(), newLedger()
+	for _, l := range []*ledger.Ledger{inOrder, outOfOrder} {
+		_, err := l.SellTaxable(d("2018-06-01"), "1.1", BTC, n("5"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		if l == inOrder {
+			_, err = l.Purchase(d("2018-06-20"), "1", Coinbase, BTC, n("2"), n("400"))
+			g.Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = l.SellTaxable(d("2018-09-01"), "1.1", BTC, n("5"), n("1500"))
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	// makes part of the loss before it superficial, which changes the ACB of the sale after it
+	_, err := outOfOrder.Purchase(d("2018-06-20"), "1", Coinbase, BTC, n("2"), n("400"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(outOfOrder.PrintTaxableGains()).To(Equal(inOrder.PrintTaxableGains()))
+	g.Expect(ledger.PrintSchedule3(outOfOrder.Schedule3())).To(Equal(
+		`2018 Schedule 3
+description      disposed    proceeds of disposition  adjusted cost base  superficial loss  gain (or loss)
+5.000000000 BTC  2018-06-01  1000.00                  1500.00             200.00            -300.00
+5.000000000 BTC  2018-09-01  1500.00                  1500.00                               0.00
+totals                       2500.00                  3000.00             200.00            -300.00
+(allowable capital loss: 150.00)
+`))
+}
+
+func TestCanadianACBSpendingAtTheLotsCostBasis(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, ledger.PriceMap{BTC: {d("2018-03-01"): n("300")}})
+	g.Expect(l.SetCostBasisMethod(ledger.CanadianACB)).To(Succeed())
+	_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("10"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-02-01"), "1", Coinbase, BTC, n("10"), n("3000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// no gains by the lot's cost basis, but it's still a disposition, at the average cost of $200
+	_, err = l.Spend(d("2018-03-01"), Coinbase, "1.2", BTC, n("5"), "pizza")
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-09-01"), "1.1", BTC, n("10"), n("3000"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(ledger.PrintSchedule3(l.Schedule3())).To(Equal(
+		`2018 Schedule 3
+description       disposed    proceeds of disposition  adjusted cost base  superficial loss  gain (or loss)
+5.000000000 BTC   2018-03-01  1500.00                  1000.00                               500.00
+10.000000000 BTC  2018-09-01  3000.00                  2000.00                               1000.00
+totals                        4500.00                  3000.00             0.00              1500.00
+(taxable capital gain: 750.00)
+`))
+}
@@ -0,0 +1,268 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// BasisAllocationMethod chooses how AllocateUnusedBasis spreads the unused basis of a currency over the accounts
+// holding it.
+type BasisAllocationMethod int
+
+const (
+	// ProRataAllocation gives each account a share of every lot, in proportion to the amount of the currency it holds,
+	// so every account ends up with the same mix of purchase dates and unit costs.
+	ProRataAllocation BasisAllocationMethod = iota
+	// KeepLotsAllocation allocates each account the lots it already holds, for ledgers which have tracked where each
+	// lot is held all along.
+	KeepLotsAllocation
+)
+
+var basisAllocationMethodNames = enumNames[BasisAllocationMethod]{
+	ProRataAllocation:  "pro-rata",
+	KeepLotsAllocation: "keep-lots",
+}
+
+// String returns the name of the method, e.g. "pro-rata".
+func (m BasisAllocationMethod) String() string {
+	return basisAllocationMethodNames.name(m)
+}
+
+// MarshalText encodes the BasisAllocationMethod by name, e.g. "pro-rata".
+func (m BasisAllocationMethod) MarshalText() ([]byte, error) {
+	return basisAllocationMethodNames.marshal("basis allocation method", m)
+}
+
+// UnmarshalText decodes a BasisAllocationMethod encoded by MarshalText.
+func (m *BasisAllocationMethod) UnmarshalText(text []byte) error {
+	return basisAllocationMethodNames.unmarshal("basis allocation method", text, m)
+}
+
+type (
+	// BasisAllocation records how AllocateUnusedBasis allocated the unused basis to the accounts.
+	BasisAllocation struct {
+		Cutoff time.Time             `json:"cutoff"`
+		Method BasisAllocationMethod `json:"method"`
+		// Lots are the allocated lots, by currency and then in the order they were created.
+		Lots []AllocatedLot `json:"lots"`
+	}
+
+	// AllocatedLot is a lot created by AllocateUnusedBasis, from part or all of an original lot.
+	AllocatedLot struct {
+		Currency        Currency `json:"currency"`
+		OriginalLot     string   `json:"originalLot"`
+		OriginalAccount Account  `json:"originalAccount"`
+		Lot             string   `json:"lot"`
+		Account         Account  `json:"account"`
+		// Acquired is when the original lot was acquired, which the allocated lot keeps.
+		Acquired  time.Time `json:"acquired"`
+		Amount    Decimal   `json:"amount"`
+		CostBasis Decimal   `json:"costBasis"`
+	}
+)
+
+// AllocateUnusedBasis allocates the unused basis of each currency to the amounts held in each account at the cutoff,
+// following the IRS safe harbor for the switch from universal to per-wallet lot tracking. The open lots of every
+// currency other than the local currency are replaced by allocated lots: each is a child of the lot its basis came
+// from, and keeps its purchase date. The amount each account holds is unchanged.
+//
+// It's recorded as an allocation transaction on the cutoff date, which must be after every transaction recorded so
+// far, and lots are tracked per wallet from then on (see SetLotTracking). The allocation is also kept, for
+// BasisAllocations and PrintBasisAllocation.
+func (l *Ledger) AllocateUnusedBasis(cutoff time.Time, method BasisAllocationMethod) (*BasisAllocation, error) {
+	if _, ok := basisAllocationMethodNames[method]; !ok {
+		return nil, fmt.Errorf("unknown basis allocation method %d", int(method))
+	}
+	for _, tx := range l.transactions {
+		if !tx.Date.Before(cutoff) {
+			return nil, fmt.Errorf("can't allocate the unused basis as of %s, since a %s transaction was recorded on %s",
+				cutoff.Format("2006-01-02"), tx.Type, tx.Date.Format("2006-01-02"))
+		}
+	}
+
+	// the allocation is the first transaction tracked per wallet
+	lotTracking := l.lotTracking
+	if err := l.SetLotTracking(cutoff, PerWalletTracking); err != nil {
+		return nil, err
+	}
+
+	allocated := &BasisAllocation{Cutoff: cutoff, Method: method}
+	err := l.transact(AllocationTransaction, cutoff, "safe harbor allocation, "+method.String(), func() error {
+		lotsByCurrency := map[Currency][]*Lot{}
+		for _, lot := range l.lots {
+			if lot.lotType != TaxableGains && lot.currency != l.localCurrency && lot.amount.Sign() > 0 {
+				lotsByCurrency[lot.currency] = append(lotsByCurrency[lot.currency], lot)
+			}
+		}
+		currencies := make([]Currency, 0, len(lotsByCurrency))
+		for currency := range lotsByCurrency {
+			currencies = append(currencies, currency)
+		}
+		sort.Slice(currencies, func(i, j int) bool { return currencies[i] < currencies[j] })
+
+		for _, currency := range currencies {
+			lots, err := l.allocateLots(method, lotsByCurrency[currency])
+			if err != nil {
+				return err
+			}
+			allocated.Lots = append(allocated.Lots, lots...)
+		}
+		return nil
+	})
+	if err != nil {
+		l.lotTracking = lotTracking
+		return nil, err
+	}
+	l.basisAllocations = append(l.basisAllocations, *allocated)
+	return allocated, nil
+}
+
+// allocateLots replaces the open lots of one currency with the lots allocated by the method.
+func (l *Ledger) allocateLots(method BasisAllocationMethod, lots []*Lot) ([]AllocatedLot, error) {
+	// the shares of each lot, by account
+	shares := make([]map[Account]Decimal, len(lots))
+	switch method {
+	case KeepLotsAllocation:
+		for i, lot := range lots {
+			shares[i] = map[Account]Decimal{lot.account: lot.amount}
+		}
+
+	case ProRataAllocation:
+		var (
+			accounts []Account
+			needs    = map[Account]Decimal{}
+		)
+		for _, lot := range lots {
+			if _, ok := needs[lot.account]; !ok {
+				accounts = append(accounts, lot.account)
+			}
+			needs[lot.account] = needs[lot.account].Add(lot.amount)
+		}
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+
+		// each account needs as much as it holds, and each lot is split in proportion to what each account still needs,
+		// which always adds up to the amount left in the remaining lots. A share is rounded, but never more than the
+		// account needs or than is left of the lot, and whatever rounding leaves over goes to the accounts which still
+		// need some, so the last lot makes up each account's holdings exactly.
+		for i, lot := range lots {
+			var totalNeed Decimal
+			for _, account := range accounts {
+				totalNeed = totalNeed.Add(needs[account])
+			}
+			shares[i] = map[Account]Decimal{}
+			left := lot.amount
+			for _, account := range accounts {
+				share := lot.amount.Mul(needs[account]).Quo(totalNeed, l.Precision(lot.currency))
+				share = minDecimal(minDecimal(share, needs[account]), left)
+				shares[i][account] = share
+				left = left.Sub(share)
+			}
+			for _, account := range accounts {
+				extra := minDecimal(needs[account].Sub(shares[i][account]), left)
+				shares[i][account] = shares[i][account].Add(extra)
+				left = left.Sub(extra)
+				needs[account] = needs[account].Sub(shares[i][account])
+			}
+		}
+	}
+
+	var result []AllocatedLot
+	for i, lot := range lots {
+		var (
+			accounts []Account
+			split    []allocation
+		)
+		for account, share := range shares[i] {
+			if share.Sign() > 0 {
+				accounts = append(accounts, account)
+			}
+		}
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+		for _, account := range accounts {
+			split = append(split, allocation{lot: lot, amount: shares[i][account]})
+		}
+		bases := portions(lot.costBasis, split, l.basisPlaces())
+
+		amount := lot.amount
+		costBasis, err := lot.Remove(lot.currency, amount, l.basisPlaces())
+		if err != nil {
+			return nil, err
+		}
+		l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: lot.currency, Amount: amount, CostBasis: costBasis})
+
+		for j, account := range accounts {
+			newLot := NewChildLot(lot, Asset, lot.originalPurchaseTime, account, lot.currency, split[j].amount, bases[j])
+			l.lots = append(l.lots, newLot)
+			result = append(result, AllocatedLot{
+				Currency:        lot.currency,
+				OriginalLot:     lot.name,
+				OriginalAccount: lot.account,
+				Lot:             newLot.name,
+				Account:         account,
+				Acquired:        lot.originalPurchaseTime,
+				Amount:          newLot.amount,
+				CostBasis:       newLot.costBasis,
+			})
+		}
+	}
+	return result, nil
+}
+
+// BasisAllocations returns the allocations made by AllocateUnusedBasis, in the order they were made.
+func (l *Ledger) BasisAllocations() []BasisAllocation {
+	return append([]BasisAllocation(nil), l.basisAllocations...)
+}
+
+// PrintBasisAllocation prints the allocation for the records: each original lot and the lots allocated from it,
+// followed by the amount and basis allocated to each account.
+func PrintBasisAllocation(a BasisAllocation) string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "Allocation of unused basis as of %s (%s)\n", a.Cutoff.Format("2006-01-02"), a.Method)
+	tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "currency\toriginal lot\toriginal account\tacquired\tallocated lot\taccount\tamount\tcost basis")
+
+	type accountKey struct {
+		account  Account
+		currency Currency
+	}
+	var (
+		keys   []accountKey
+		totals = map[accountKey]*AllocatedLot{}
+	)
+	for _, lot := range a.Lots {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%0.9f\t%s\n", lot.Currency, lot.OriginalLot, lot.OriginalAccount,
+			lot.Acquired.Format("2006-01-02"), lot.Lot, lot.Account, lot.Amount, lot.CostBasis.StringFixed(2))
+		key := accountKey{lot.Account, lot.Currency}
+		total := totals[key]
+		if total == nil {
+			total = &AllocatedLot{}
+			totals[key] = total
+			keys = append(keys, key)
+		}
+		total.Amount, total.CostBasis = total.Amount.Add(lot.Amount), total.CostBasis.Add(lot.CostBasis)
+	}
+	if err := tw.Flush(); err != nil {
+		panic(err.Error())
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+		return keys[i].currency < keys[j].currency
+	})
+	fmt.Fprintln(b)
+	tw = tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "account\tcurrency\tamount\tcost basis")
+	for _, key := range keys {
+		total := totals[key]
+		fmt.Fprintf(tw, "%s\t%s\t%0.9f\t%s\n", key.account, key.currency, total.Amount, total.CostBasis.StringFixed(2))
+	}
+	if err := tw.Flush(); err != nil {
+		panic(err.Error())
+	}
+	return b.String()
+}
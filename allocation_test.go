@@ -0,0 +1,146 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestAllocateUnusedBasis(t *testing.T) {
+	newLedger := func(g *GomegaWithT) *ledger.Ledger {
+		l := ledger.New(USD, historicalPrices)
+		_, err := l.DepositNewMoney(d("2017-01-02"), Coinbase, n("10000"), n("10000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-01-02"), "1", Coinbase, BTC, n("3"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-06-01"), "1", Coinbase, BTC, n("1"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Transfer(d("2017-11-01"), "1.2", BTC, n("1"), n("0"), Bitfinex)
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-11-01"), "1", Coinbase, ETH, n("10"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		return l
+	}
+
+	t.Run("pro rata", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		allocation, err := l.AllocateUnusedBasis(d("2025-01-01"), ledger.ProRataAllocation)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintBasisAllocation(*allocation)).To(Equal(
+			`Allocation of unused basis as of 2025-01-01 (pro-rata)
+currency  original lot  original account  acquired    allocated lot  account   amount        cost basis
+BTC       1.1           Coinbase          2017-01-02  1.1.1          Bitfinex  0.750000000   750.00
+BTC       1.1           Coinbase          2017-01-02  1.1.2          Coinbase  2.250000000   2250.00
+BTC       1.2.1         Bitfinex          2017-06-01  1.2.1.1        Bitfinex  0.250000000   750.00
+BTC       1.2.1         Bitfinex          2017-06-01  1.2.1.2        Coinbase  0.750000000   2250.00
+ETH       1.3           Coinbase          2017-11-01  1.3.1          Coinbase  10.000000000  3000.00
+
+account   currency  amount        cost basis
+Bitfinex  BTC       1.000000000   1500.00
+Coinbase  BTC       3.000000000   4500.00
+Coinbase  ETH       10.000000000  3000.00
+`))
+		g.Expect(l.PrintAccounts()).To(Equal(
+			`Bitfinex
+	BTC 1.000000000 (basis:1500.000000	price:$1500.000000)
+		1.1.1    2017-01-02 Bitfinex BTC 0.750000000  (basis:$750.000000  price:$1000.000000)
+		1.2.1.1  2017-06-01 Bitfinex BTC 0.250000000  (basis:$750.000000  price:$3000.000000)
+Coinbase
+	BTC 3.000000000 (basis:4500.000000	price:$1500.000000)
+		1.1.2    2017-01-02 Coinbase BTC 2.250000000  (basis:$2250.000000  price:$1000.000000)
+		1.2.1.2  2017-06-01 Coinbase BTC 0.750000000  (basis:$2250.000000  price:$3000.000000)
+	ETH 10.000000000 (basis:3000.000000	price:$300.000000)
+		1.3.1  2017-11-01 Coinbase ETH 10.000000000  (basis:$3000.000000  price:$300.000000)
+	USD 1000.000000000 (basis:1000.000000	price:$1.000000)
+		1  2017-01-02 Coinbase USD 1000.000000000  (basis:$1000.000000  price:$1.000000)
+(Total basis: $10000.00)
+(Total initial investment: $10000.00)
+`))
+		g.Expect(l.LotTracking(d("2025-01-01"))).To(Equal(ledger.PerWalletTracking))
+		g.Expect(l.LotTracking(d("2024-12-31"))).To(Equal(ledger.UniversalTracking))
+
+		saved := &bytes.Buffer{}
+		g.Expect(l.Save(saved)).To(Succeed())
+		loaded, err := ledger.Load(saved, historicalPrices)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.BasisAllocations()).To(Equal([]ledger.BasisAllocation{*allocation}))
+	})
+
+	t.Run("pro rata rounding", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		// a satoshi held in each of three accounts can't be split in thirds, so each lot goes whole to one account
+		kraken := ledger.Account("Kraken")
+		l := ledger.New(USD, historicalPrices)
+		_, err := l.DepositNewMoney(d("2017-01-02"), Coinbase, n("10"), n("10"))
+		g.Expect(err).NotTo(HaveOccurred())
+		for i, account := range []ledger.Account{Bitfinex, Coinbase, kraken} {
+			_, err = l.Purchase(d("2017-01-02").AddDate(0, 0, i), "1", account, BTC, n("0.00000001"), n("0.01"))
+			g.Expect(err).NotTo(HaveOccurred())
+		}
+		allocation, err := l.AllocateUnusedBasis(d("2025-01-01"), ledger.ProRataAllocation)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintBasisAllocation(*allocation)).To(Equal(
+			`Allocation of unused basis as of 2025-01-01 (pro-rata)
+currency  original lot  original account  acquired    allocated lot  account   amount       cost basis
+BTC       1.1           Bitfinex          2017-01-02  1.1.1          Bitfinex  0.000000010  0.01
+BTC       1.2           Coinbase          2017-01-03  1.2.1          Coinbase  0.000000010  0.01
+BTC       1.3           Kraken            2017-01-04  1.3.1          Kraken    0.000000010  0.01
+
+account   currency  amount       cost basis
+Bitfinex  BTC       0.000000010  0.01
+Coinbase  BTC       0.000000010  0.01
+Kraken    BTC       0.000000010  0.01
+`))
+	})
+
+	t.Run("keep lots", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		allocation, err := l.AllocateUnusedBasis(d("2025-01-01"), ledger.KeepLotsAllocation)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintBasisAllocation(*allocation)).To(Equal(
+			`Allocation of unused basis as of 2025-01-01 (keep-lots)
+currency  original lot  original account  acquired    allocated lot  account   amount        cost basis
+BTC       1.1           Coinbase          2017-01-02  1.1.1          Coinbase  3.000000000   3000.00
+BTC       1.2.1         Bitfinex          2017-06-01  1.2.1.1        Bitfinex  1.000000000   3000.00
+ETH       1.3           Coinbase          2017-11-01  1.3.1          Coinbase  10.000000000  3000.00
+
+account   currency  amount        cost basis
+Bitfinex  BTC       1.000000000   3000.00
+Coinbase  BTC       3.000000000   3000.00
+Coinbase  ETH       10.000000000  3000.00
+`))
+	})
+
+	t.Run("income", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		_, err := l.Income(d("2017-11-02"), Coinbase, BTC, n("1"), n("1000"), "airdrop")
+		g.Expect(err).NotTo(HaveOccurred())
+		income := l.PrintIncome()
+		g.Expect(income).To(ContainSubstring("$1000.00"))
+
+		// the income was earned once, when it was received, however the lot is allocated
+		_, err = l.AllocateUnusedBasis(d("2025-01-01"), ledger.KeepLotsAllocation)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(l.PrintIncome()).To(Equal(income))
+	})
+
+	t.Run("after the cutoff", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		_, err := l.AllocateUnusedBasis(d("2017-11-01"), ledger.ProRataAllocation)
+		g.Expect(err).To(MatchError("can't allocate the unused basis as of 2017-11-01, since a transfer transaction was recorded on 2017-11-01"))
+		g.Expect(l.LotTrackingChanges()).To(BeEmpty())
+		g.Expect(l.BasisAllocations()).To(BeEmpty())
+	})
+}
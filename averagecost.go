@@ -0,0 +1,60 @@
+package ledger
+
+// averageCostPool is the lots of one currency held in one account, which share their cost basis under AverageCost.
+type averageCostPool struct {
+	lots         []*Lot
+	amount, cost Decimal
+}
+
+// applyAverageCost restates the cost basis of the lots of each currency held in each account, so that they all have
+// the pool's weighted average price. The pool's total cost basis is unchanged: the lots' shares are rounded, and the
+// last lot takes what's left over.
+//
+// It's run as each transaction is recorded, before its outputs are, so purchases and transfers in are averaged into
+// the pool, and sales and transfers out remove the average cost of the amount removed, whichever lot it's taken from.
+// The lots held before the transaction, whose lots start at index numLots, record the cost basis restated as
+// adjustments, so the journal has the same cost basis as the lots. The average follows the order in which
+// transactions are recorded. Lots of the local currency keep their own cost basis.
+func (l *Ledger) applyAverageCost(numLots int) {
+	type poolKey struct {
+		account  Account
+		currency Currency
+	}
+	var (
+		pools = map[poolKey]*averageCostPool{}
+		order []*averageCostPool
+	)
+	for _, lot := range l.lots {
+		if lot.lotType == TaxableGains || lot.currency == l.localCurrency || lot.amount.Sign() <= 0 {
+			continue
+		}
+		key := poolKey{lot.account, lot.currency}
+		pool := pools[key]
+		if pool == nil {
+			pool = &averageCostPool{}
+			pools[key] = pool
+			order = append(order, pool)
+		}
+		pool.lots = append(pool.lots, lot)
+		pool.amount, pool.cost = pool.amount.Add(lot.amount), pool.cost.Add(lot.costBasis)
+	}
+
+	newLots := map[*Lot]bool{}
+	for _, lot := range l.lots[numLots:] {
+		newLots[lot] = true
+	}
+	for _, pool := range order {
+		remaining := pool.cost
+		for i, lot := range pool.lots {
+			costBasis := remaining
+			if i < len(pool.lots)-1 {
+				costBasis = pool.cost.Mul(lot.amount).Quo(pool.amount, l.basisPlaces())
+			}
+			remaining = remaining.Sub(costBasis)
+			if change := costBasis.Sub(lot.costBasis); !change.IsZero() && !newLots[lot] {
+				l.recordAdjustment(Posting{LotName: lot.name, Account: lot.account, Currency: lot.currency, CostBasis: change})
+			}
+			lot.costBasis = costBasis
+		}
+	}
+}
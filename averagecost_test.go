@@ -0,0 +1,113 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestAverageCost(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, nil)
+	g.Expect(l.SetCostBasisMethod(ledger.AverageCost)).To(Succeed())
+	_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("1"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2018-02-01"), "1", Coinbase, BTC, n("2"), n("5000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// a separate pool, since it's held in another account
+	_, err = l.Purchase(d("2018-02-01"), "1", Bitfinex, BTC, n("1"), n("4000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(l.PrintAccounts()).To(Equal(
+		`Bitfinex
+	BTC 1.000000000 (basis:4000.000000	price:$4000.000000)
+		1.3  2018-02-01 Bitfinex BTC 1.000000000  (basis:$4000.000000  price:$4000.000000)
+Coinbase
+	BTC 3.000000000 (basis:6000.000000	price:$2000.000000)
+		1.1  2018-01-02 Coinbase BTC 1.000000000  (basis:$2000.000000  price:$2000.000000)
+		1.2  2018-02-01 Coinbase BTC 2.000000000  (basis:$4000.000000  price:$2000.000000)
+(Total basis: $10000.00)
+(Total initial investment: $10000.00)
+`))
+
+	// at the average cost of $2000, though it's taken from the cheapest lot
+	_, err = l.SellTaxable(d("2018-03-01"), "1.1", BTC, n("0.5"), n("1500"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// moves $2000 of cost basis to Bitfinex, where the average becomes $3000
+	_, err = l.Transfer(d("2018-04-01"), "1.2", BTC, n("1"), n("0"), Bitfinex)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-05-01"), "1.3", BTC, n("1"), n("2500"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(l.PrintLots()).To(Equal(
+		`1      2018-01-02 Coinbase USD 0.000000000  (basis:$0.000000     price:$NaN)
+1.1    2018-01-02 Coinbase BTC 0.500000000  (basis:$1000.000000  price:$2000.000000)
+1.2    2018-02-01 Coinbase BTC 1.000000000  (basis:$2000.000000  price:$2000.000000)
+1.3    2018-02-01 Bitfinex BTC 0.000000000  (basis:$0.000000     price:$NaN)
+1.1.1  2018-03-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.500000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 1500.000000, gains=USD 500.000000, note=sold BTC for USD
+1.2.1  2018-02-01 Bitfinex BTC 1.000000000  (basis:$3000.000000  price:$3000.000000)
+1.3.1  2018-05-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 1.000000000 originally purchased 2018-02-01 for USD 3000.000000. proceeds=USD 2500.000000, gains=USD -500.000000, note=sold BTC for USD
+`))
+	g.Expect(l.PrintAccounts()).To(Equal(
+		`Bitfinex
+	BTC 1.000000000 (basis:3000.000000	price:$3000.000000)
+		1.2.1  2018-02-01 Bitfinex BTC 1.000000000  (basis:$3000.000000  price:$3000.000000)
+Coinbase
+	BTC 1.500000000 (basis:3000.000000	price:$2000.000000)
+		1.1  2018-01-02 Coinbase BTC 0.500000000  (basis:$1000.000000  price:$2000.000000)
+		1.2  2018-02-01 Coinbase BTC 1.000000000  (basis:$2000.000000  price:$2000.000000)
+(Total basis: $6000.00)
+(Total initial investment: $10000.00)
+`))
+	g.Expect(l.PrintTaxableGains()).To(Equal(
+		`1.1.1	2018-03-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.500000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 1500.000000, gains=USD 500.000000, note=sold BTC for USD
+1.3.1	2018-05-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 1.000000000 originally purchased 2018-02-01 for USD 3000.000000. proceeds=USD 2500.000000, gains=USD -500.000000, note=sold BTC for USD
+(2018's capital gains: short-term:$0.00 long-term:$0.00)
+(Total capital gains: short-term:$0.00 long-term:$0.00)
+`))
+
+	// the cost basis restated is recorded as adjustments, so the journal and its export agree with the lots
+	g.Expect(l.PrintTransactions()).To(Equal(
+		`2018-01-02 deposit Coinbase lots:1
+  out  1  Coinbase USD 10000.000000000  (basis:$10000.00)
+2018-01-02 purchase Coinbase lots:1.1
+  in   1    Coinbase USD 1000.000000000  (basis:$1000.00)
+  out  1.1  Coinbase BTC 1.000000000     (basis:$1000.00)
+2018-02-01 purchase Coinbase lots:1.2
+  in   1    Coinbase USD 5000.000000000  (basis:$5000.00)
+  out  1.2  Coinbase BTC 2.000000000     (basis:$4000.00)
+  adj  1.1  Coinbase BTC 0.000000000     (basis:$1000.00)
+2018-02-01 purchase Coinbase,Bitfinex lots:1.3
+  in   1    Coinbase USD 4000.000000000  (basis:$4000.00)
+  out  1.3  Bitfinex BTC 1.000000000     (basis:$4000.00)
+2018-03-01 sell Coinbase lots:1.1.1
+  in  1.1  Coinbase BTC 0.500000000  (basis:$1000.00  value:$1500.00)
+2018-04-01 transfer Coinbase,Bitfinex lots:1.2.1
+  in   1.2    Coinbase BTC 1.000000000  (basis:$2000.00)
+  out  1.2.1  Bitfinex BTC 1.000000000  (basis:$3000.00)
+  adj  1.3    Bitfinex BTC 0.000000000  (basis:$-1000.00)
+2018-05-01 sell Bitfinex lots:1.3.1
+  in  1.3  Bitfinex BTC 1.000000000  (basis:$3000.00  value:$2500.00)
+`))
+	b := &bytes.Buffer{}
+	g.Expect(l.WriteBeancount(b)).To(Succeed())
+	g.Expect(b.String()).To(ContainSubstring(`2018-04-01 * "transfer"
+  Assets:Coinbase  -2 BTC {"1.2"}
+  Assets:Coinbase  1 BTC {{2000.00 USD, 2018-02-01, "1.2"}}
+  Assets:Bitfinex  -1 BTC {"1.3"}
+  Assets:Bitfinex  1 BTC {{3000.00 USD, 2018-02-01, "1.3"}}
+  Assets:Bitfinex  1 BTC {{3000.00 USD, 2018-02-01, "1.2.1"}}
+`))
+
+	saved := &bytes.Buffer{}
+	g.Expect(l.Save(saved)).To(Succeed())
+	loaded, err := ledger.Load(saved, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.CostBasisMethod()).To(Equal(ledger.AverageCost))
+	g.Expect(loaded.PrintAccounts()).To(Equal(l.PrintAccounts()))
+}
@@ -0,0 +1,302 @@
+package ledger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The Beancount accounts which balance the lots' postings.
+const (
+	// BeancountContributions is where deposited money comes from, at its cost basis.
+	BeancountContributions = "Equity:Contributions"
+	// BeancountCapitalizedFees holds the part of the local currency's cost basis above its amount,
+	// e.g. the wire transfer fees paid to deposit it, until it's spent on another currency.
+	BeancountCapitalizedFees = "Equity:CapitalizedFees"
+	// BeancountWithdrawals is where the local currency from sales goes, since the ledger doesn't keep it.
+	BeancountWithdrawals = "Equity:Withdrawals"
+	// BeancountIncome is where assets received as income come from, at their value.
+	BeancountIncome = "Income:Received"
+	// BeancountShortTermGains and BeancountLongTermGains receive the taxable gains (as negative amounts, since
+	// they're income) and losses.
+	BeancountShortTermGains = "Income:CapitalGains:ShortTerm"
+	BeancountLongTermGains  = "Income:CapitalGains:LongTerm"
+	// BeancountWashSales receives the losses disallowed by the wash sale rule (as negative amounts), as they're added
+	// to the cost basis of the replacement lots.
+	BeancountWashSales = "Income:CapitalGains:WashSales"
+	// BeancountSpent is where the value of spent assets goes.
+	BeancountSpent = "Expenses:Spent"
+)
+
+// beancountLot is a lot as it stood at some point in the journal of transactions.
+type beancountLot struct {
+	account       Account
+	currency      Currency
+	date          time.Time
+	amount, basis Decimal
+}
+
+// WriteBeancount writes the journal of transactions as a Beancount file, which bean-check can validate independently.
+//
+// Each lot holding some currency other than the local currency is a Beancount lot with its total cost basis, its
+// original purchase date and its name as a label, e.g. `0.799 BTC {{1039.10 USD, 2017-04-06, "1.1.1"}}`.
+// A lot is reduced by its label, and when it's only partly removed, or its cost basis changes, the rest of it is
+// booked again with its new cost basis, so the weights are exactly the ledger's cost basis.
+// Assets are kept in an account under "Assets:" named after the ledger's account, e.g. "Assets:Coinbase", and the
+// postings are balanced by the Beancount* accounts. The gains posted are worked out from the cost basis of the lots
+// the amounts sold were removed from, so under a pooled cost basis method they may differ from the gains reports.
+//
+// Prices are written for the currencies of each transaction on its date, when the ledger's PriceSource has them, and
+// the file ends with balance assertions for every account's holdings, the day after the last transaction.
+func (l *Ledger) WriteBeancount(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "option \"operating_currency\" %s\n", strconv.Quote(string(l.localCurrency)))
+	if len(l.transactions) == 0 {
+		return b.Flush()
+	}
+
+	var first, last time.Time
+	accounts := map[string]bool{}
+	for i, tx := range l.transactions {
+		if i == 0 || tx.Date.Before(first) {
+			first = tx.Date
+		}
+		if tx.Date.After(last) {
+			last = tx.Date
+		}
+		for _, account := range tx.Accounts {
+			accounts[beancountAccount(account)] = true
+		}
+	}
+	for _, account := range []string{BeancountContributions, BeancountCapitalizedFees, BeancountWithdrawals,
+		BeancountIncome, BeancountShortTermGains, BeancountLongTermGains, BeancountWashSales, BeancountSpent} {
+		accounts[account] = true
+	}
+	fmt.Fprintln(b)
+	for _, account := range sortedKeys(accounts) {
+		fmt.Fprintf(b, "%s open %s\n", beancountDate(first), account)
+	}
+
+	lotsByName := map[string]*Lot{}
+	for _, lot := range l.lots {
+		lotsByName[lot.name] = lot
+	}
+	state := map[string]*beancountLot{}
+	priced := map[string]bool{}
+	for _, tx := range l.transactions {
+		fmt.Fprintln(b)
+		l.writeBeancountPrices(b, tx, priced)
+		if err := l.writeBeancountTransaction(b, tx, state, lotsByName); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(b)
+	summary := l.AccountSummary()
+	var names []string
+	for account := range summary {
+		names = append(names, string(account))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		currencies := summary[Account(name)]
+		var codes []string
+		for currency := range currencies {
+			codes = append(codes, string(currency))
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(b, "%s balance %s  %s %s\n", beancountDate(last.AddDate(0, 0, 1)), beancountAccount(Account(name)),
+				currencies[Currency(code)].Balance, code)
+		}
+	}
+	return b.Flush()
+}
+
+// writeBeancountPrices writes the prices of the currencies in the transaction which haven't been written for its date.
+func (l *Ledger) writeBeancountPrices(b *bufio.Writer, tx *Transaction, priced map[string]bool) {
+	for _, postings := range [][]Posting{tx.Inputs, tx.Outputs, tx.Fees} {
+		for _, p := range postings {
+			key := beancountDate(tx.Date) + " " + string(p.Currency)
+			if p.Currency == l.localCurrency || priced[key] {
+				continue
+			}
+			priced[key] = true
+			if quote, err := l.lookupPrice(p.Currency, tx.Date); err == nil {
+				fmt.Fprintf(b, "%s price %s %s %s\n", beancountDate(tx.Date), p.Currency, quote.Price, l.localCurrency)
+			}
+		}
+	}
+}
+
+// writeBeancountTransaction writes the transaction, updating the state of the lots it touches.
+func (l *Ledger) writeBeancountTransaction(b *bufio.Writer, tx *Transaction, state map[string]*beancountLot, lotsByName map[string]*Lot) error {
+	narration := tx.Type.String()
+	if tx.Note != "" {
+		narration += ": " + tx.Note
+	}
+	for _, fee := range tx.Fees {
+		narration += fmt.Sprintf(", fee %s %s", fee.Amount, fee.Currency)
+	}
+	fmt.Fprintf(b, "%s * %s\n", beancountDate(tx.Date), strconv.Quote(narration))
+
+	// work out how much each existing lot changed. The outputs are the new lots as they stood at the end.
+	// The fees of exchanges were already deducted by the exchange, so they're only noted.
+	outputs := map[string]bool{}
+	for _, p := range tx.Outputs {
+		outputs[p.LotName] = true
+	}
+	removed := tx.Inputs
+	if tx.Type != ExchangeTaxableTransaction && tx.Type != ExchangeNonTaxableTransaction {
+		removed = append(removed[:len(removed):len(removed)], tx.Fees...)
+	}
+	type change struct{ amount, basis Decimal }
+	var (
+		touched []string
+		changes = map[string]*change{}
+	)
+	changeOf := func(name string) *change {
+		c, ok := changes[name]
+		if !ok {
+			c = &change{}
+			changes[name] = c
+			touched = append(touched, name)
+		}
+		return c
+	}
+	for _, p := range removed {
+		if !outputs[p.LotName] {
+			c := changeOf(p.LotName)
+			c.amount = c.amount.Sub(p.Amount)
+			c.basis = c.basis.Sub(p.CostBasis)
+		}
+	}
+	for _, p := range tx.Adjustments {
+		c := changeOf(p.LotName)
+		c.basis = c.basis.Add(p.CostBasis)
+	}
+
+	// the weight of the postings, in the local currency
+	var weight, capitalizedFees Decimal
+	for _, name := range touched {
+		lot, ok := state[name]
+		if !ok {
+			return &LotNotFoundError{Name: name}
+		}
+		c := changes[name]
+		weight = weight.Add(c.basis)
+		account := beancountAccount(lot.account)
+		if lot.currency == l.localCurrency {
+			if !c.amount.IsZero() {
+				fmt.Fprintf(b, "  %s  %s %s\n", account, c.amount, lot.currency)
+			}
+			capitalizedFees = capitalizedFees.Add(c.basis.Sub(c.amount))
+		} else {
+			fmt.Fprintf(b, "  %s  %s %s {%s}\n", account, lot.amount.Neg(), lot.currency, strconv.Quote(name))
+		}
+		lot.amount = lot.amount.Add(c.amount)
+		lot.basis = lot.basis.Add(c.basis)
+		if lot.currency != l.localCurrency && lot.amount.Sign() > 0 {
+			fmt.Fprintf(b, "  %s  %s\n", account, l.beancountPosition(name, lot))
+		}
+	}
+	for _, p := range tx.Outputs {
+		lot := &beancountLot{account: p.Account, currency: p.Currency, amount: p.Amount, basis: p.CostBasis,
+			date: lotsByName[p.LotName].originalPurchaseTime}
+		state[p.LotName] = lot
+		weight = weight.Add(p.CostBasis)
+		if lot.currency == l.localCurrency {
+			fmt.Fprintf(b, "  %s  %s %s\n", beancountAccount(lot.account), lot.amount, lot.currency)
+			capitalizedFees = capitalizedFees.Add(p.CostBasis.Sub(p.Amount))
+		} else {
+			fmt.Fprintf(b, "  %s  %s\n", beancountAccount(lot.account), l.beancountPosition(p.LotName, lot))
+		}
+	}
+	if !capitalizedFees.IsZero() {
+		fmt.Fprintf(b, "  %s  %s %s\n", BeancountCapitalizedFees, capitalizedFees, l.localCurrency)
+	}
+
+	// the gains, from the TaxableGains lots, before any loss was disallowed by a wash sale. Disallowed losses are
+	// posted when they're added to the replacement lots. They're worked out from the cost basis removed from the lots,
+	// as posted above, so a pooled cost basis method revising the cost basis of the amount sold doesn't unbalance them.
+	var shortTerm, longTerm, washSales Decimal
+	for _, name := range tx.LotNames {
+		lot := lotsByName[name]
+		if lot.lotType == TaxableGains {
+			details := lot.taxableGainsDetails
+			if l.GainsCategory(details).LongTerm {
+				longTerm = longTerm.Add(details.proceeds.Sub(details.lotCostBasis))
+			} else {
+				shortTerm = shortTerm.Add(details.proceeds.Sub(details.lotCostBasis))
+			}
+		}
+		washSales = washSales.Add(lot.washSaleLoss)
+	}
+	for _, gains := range []struct {
+		account string
+		amount  Decimal
+	}{{BeancountShortTermGains, shortTerm}, {BeancountLongTermGains, longTerm}, {BeancountWashSales, washSales}} {
+		if !gains.amount.IsZero() {
+			fmt.Fprintf(b, "  %s  %s %s\n", gains.account, gains.amount.Neg(), l.localCurrency)
+			weight = weight.Sub(gains.amount)
+		}
+	}
+
+	// whatever's left comes from, or goes, outside of the ledger
+	if weight.IsZero() {
+		return nil
+	}
+	var account string
+	switch tx.Type {
+	case DepositTransaction:
+		account = BeancountContributions
+	case IncomeTransaction:
+		account = BeancountIncome
+	case SellTransaction:
+		account = BeancountWithdrawals
+	case SpendTransaction:
+		account = BeancountSpent
+	default:
+		return &InvalidOperationError{Op: "WriteBeancount", LotName: strings.Join(tx.LotNames, ","),
+			Reason: fmt.Sprintf("the %s transaction on %s doesn't balance by %s", tx.Type, beancountDate(tx.Date), weight)}
+	}
+	fmt.Fprintf(b, "  %s  %s %s\n", account, weight.Neg(), l.localCurrency)
+	return nil
+}
+
+// beancountPosition formats the amount of the lot, with its cost basis, purchase date and name.
+func (l *Ledger) beancountPosition(name string, lot *beancountLot) string {
+	return fmt.Sprintf("%s %s {{%s %s, %s, %s}}", lot.amount, lot.currency, lot.basis, l.localCurrency,
+		beancountDate(lot.date), strconv.Quote(name))
+}
+
+// beancountAccount returns the Beancount account holding the assets of the account, e.g. "Assets:In-Transit".
+func beancountAccount(account Account) string {
+	name := strings.Join(strings.FieldsFunc(string(account), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-")
+	if name == "" {
+		name = "Unknown"
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return "Assets:" + string(runes)
+}
+
+func beancountDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
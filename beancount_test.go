@@ -0,0 +1,98 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestWriteBeancount(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.83976678"), n("960"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Income(d("2017-08-01"), Bitfinex, BCH, n("0.35853168"), n("212.25"), "fork from BTC")
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.8"), n("0.001"), Coinbase)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.ExchangeTaxable(d("2017-11-02"), "2", BCH, n("0.35853168"), n("0.0001"), false, BTC, n("0.02764547"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(l.Fee(d("2017-12-01"), "2.1", BTC, n("0.00001"), "1.1.1", "some random fee")).To(Succeed())
+	_, err = l.SellTaxable(d("2017-12-01"), "1.1.1", BTC, n("0.1"), n("1097.56"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Spend(d("2017-12-01"), Bitfinex, "1.1", BTC, n("0.01"), "pizza")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	b := &bytes.Buffer{}
+	g.Expect(l.WriteBeancount(b)).To(Succeed())
+	g.Expect(b.String()).To(Equal(
+		`option "operating_currency" "USD"
+
+2017-04-06 open Assets:Bitfinex
+2017-04-06 open Assets:Coinbase
+2017-04-06 open Equity:CapitalizedFees
+2017-04-06 open Equity:Contributions
+2017-04-06 open Equity:Withdrawals
+2017-04-06 open Expenses:Spent
+2017-04-06 open Income:CapitalGains:LongTerm
+2017-04-06 open Income:CapitalGains:ShortTerm
+2017-04-06 open Income:CapitalGains:WashSales
+2017-04-06 open Income:Received
+
+2017-04-06 * "deposit"
+  Assets:Bitfinex  960 USD
+  Equity:CapitalizedFees  125 USD
+  Equity:Contributions  -1085 USD
+
+2017-04-06 * "purchase"
+  Assets:Bitfinex  -960 USD
+  Assets:Bitfinex  0.83976678 BTC {{1085 USD, 2017-04-06, "1.1"}}
+  Equity:CapitalizedFees  -125 USD
+
+2017-08-01 * "income: fork from BTC"
+  Assets:Bitfinex  0.35853168 BCH {{212.25 USD, 2017-08-01, "2"}}
+  Income:Received  -212.25 USD
+
+2017-11-01 price BTC 6767.31 USD
+2017-11-01 * "transfer, fee 0.001 BTC"
+  Assets:Bitfinex  -0.83976678 BTC {"1.1"}
+  Assets:Bitfinex  0.03976678 BTC {{51.38 USD, 2017-04-06, "1.1"}}
+  Assets:Coinbase  0.799 BTC {{1039.10 USD, 2017-04-06, "1.1.1"}}
+  Income:CapitalGains:ShortTerm  -5.48 USD
+
+2017-11-02 price BTC 6960.07 USD
+2017-11-02 * "exchange, fee 0.0001 BCH"
+  Assets:Bitfinex  -0.35853168 BCH {"2"}
+  Assets:Bitfinex  0.02764547 BTC {{192.41 USD, 2017-11-02, "2.1"}}
+  Income:CapitalGains:ShortTerm  19.84 USD
+
+2017-12-01 price BTC 10975.60 USD
+2017-12-01 * "fee: some random fee, fee 0.00001 BTC"
+  Assets:Bitfinex  -0.02764547 BTC {"2.1"}
+  Assets:Bitfinex  0.02763547 BTC {{192.34 USD, 2017-11-02, "2.1"}}
+  Assets:Coinbase  -0.799 BTC {"1.1.1"}
+  Assets:Coinbase  0.799 BTC {{1039.21 USD, 2017-04-06, "1.1.1"}}
+  Income:CapitalGains:ShortTerm  -0.04 USD
+
+2017-12-01 * "sell"
+  Assets:Coinbase  -0.799 BTC {"1.1.1"}
+  Assets:Coinbase  0.699 BTC {{909.15 USD, 2017-04-06, "1.1.1"}}
+  Income:CapitalGains:ShortTerm  -967.50 USD
+  Equity:Withdrawals  1097.56 USD
+
+2017-12-01 * "spend: pizza"
+  Assets:Bitfinex  -0.03976678 BTC {"1.1"}
+  Assets:Bitfinex  0.02976678 BTC {{38.46 USD, 2017-04-06, "1.1"}}
+  Income:CapitalGains:ShortTerm  -96.84 USD
+  Expenses:Spent  109.76 USD
+
+2017-12-02 balance Assets:Bitfinex  0.05740225 BTC
+2017-12-02 balance Assets:Coinbase  0.699 BTC
+`))
+}
@@ -0,0 +1,262 @@
+// Command costbasis replays journal files (or loads a saved ledger) and prints reports about it.
+//
+// Usage:
+//
+//	costbasis <command> [flags] [journal files...]
+//
+// The commands are:
+//
+//	lots           the lots still holding some amount
+//	gains          the taxable gains from sales and exchanges
+//	income         the assets received as income
+//	accounts       the balance and cost basis of each currency in each account
+//	form8949       the taxable gains laid out like IRS Form 8949, with the totals for Schedule D
+//	present-value  the value of the lots on a date, and their unrealized gains
+//	pools          the changes to each currency's Section 104 pool, under the UK share matching rules
+//	schedule3      the dispositions laid out like Canada's Schedule 3, with each year's taxable capital gain
+//	allocation     the unused basis allocated to each account by a safe harbor allocation
+//
+// Historical prices are loaded from CoinGecko's price history downloads, e.g. "btc-usd-max.csv", see
+// ledger.CoinGeckoPriceCSV. Run "costbasis <command> -h" for the flags.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/journal"
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.As(err, new(usageError)):
+		fmt.Fprintln(os.Stderr, "costbasis:", err)
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "costbasis:", err)
+		os.Exit(1)
+	}
+}
+
+// usageError is returned for mistakes in the command line.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// options are the flags shared by all of the commands.
+type options struct {
+	localCurrency string
+	priceFiles    listFlag
+	ledgerFile    string
+	format        string
+	year          int
+	account       string
+	currency      string
+	method        string
+	rules         string
+	perWallet     string
+
+	// present-value only
+	date          string
+	currentPrices listFlag
+	valueDate     time.Time
+	prices        map[ledger.Currency]ledger.Decimal
+}
+
+// listFlag is a flag which may be given several times.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return usageError(fmt.Sprintf("missing the command, one of: %s", strings.Join(commandNames(), ", ")))
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown command %q, expected one of: %s", args[0], strings.Join(commandNames(), ", ")))
+	}
+
+	var o options
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: costbasis %s [flags] [journal files...]\n\n%s\n\n", args[0], command.description)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&o.localCurrency, "local", "USD", "the local `currency` cost basis and gains are measured in")
+	flags.Var(&o.priceFiles, "prices", "a CoinGecko price history `file`, e.g. btc-usd-max.csv (repeatable)")
+	flags.StringVar(&o.ledgerFile, "ledger", "", "a ledger saved as JSON `file`, to report on instead of journals")
+	flags.StringVar(&o.format, "format", "text", "the output `format`: text, tsv, csv or json")
+	flags.IntVar(&o.year, "year", 0, "only include this `year`: of purchase for lots, of sale for gains, of receipt for income")
+	flags.StringVar(&o.account, "account", "", "only include this `account`")
+	flags.StringVar(&o.currency, "currency", "", "only include this `currency`")
+	flags.StringVar(&o.rules, "rules", "", "the tax `rules` which classify the gains: us, de, au or flat (default us, or the saved ledger's)")
+	flags.StringVar(&o.method, "method", "", "the cost basis `method` for gains: lots, uk, acb or average (default lots, or the saved ledger's)")
+	flags.StringVar(&o.perWallet, "per-wallet", "", "track lots per wallet from this `date`, as YYYY-MM-DD, when replaying journals (default universal)")
+	if command.presentValue {
+		flags.StringVar(&o.date, "date", "", "the `date` to value the lots on, as YYYY-MM-DD (default today)")
+		flags.Var(&o.currentPrices, "price", "the price of a currency on the date, e.g. BTC=4028.89, "+
+			"instead of looking it up in the historical prices (repeatable)")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if command.noYear && o.year != 0 {
+		return usageError(fmt.Sprintf("-year doesn't apply to %s", args[0]))
+	}
+	if command.noAccount && o.account != "" {
+		return usageError(fmt.Sprintf("-account doesn't apply to %s, since its pools are shared by all the accounts", args[0]))
+	}
+	write, ok := formats[o.format]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown format %q, expected text, tsv, csv or json", o.format))
+	}
+	if command.presentValue {
+		if err := o.parsePresentValueFlags(); err != nil {
+			return err
+		}
+	}
+
+	l, err := loadLedger(&o, flags.Args())
+	if err != nil {
+		return err
+	}
+	r, err := command.report(l, &o)
+	if err != nil {
+		return err
+	}
+	return write(stdout, r)
+}
+
+// loadLedger replays the journal files, or loads the saved ledger, with the historical prices from the price files.
+func loadLedger(o *options, journals []string) (*ledger.Ledger, error) {
+	local := ledger.Currency(strings.ToUpper(o.localCurrency))
+	var rules ledger.TaxRules
+	if o.rules != "" {
+		var ok bool
+		if rules, ok = ledger.LookupTaxRules(o.rules); !ok {
+			return nil, usageError(fmt.Sprintf("unknown tax rules %q, expected us, de, au or flat", o.rules))
+		}
+	}
+	var method ledger.CostBasisMethod
+	if o.method != "" {
+		if err := method.UnmarshalText([]byte(o.method)); err != nil {
+			return nil, usageError(fmt.Sprintf("unknown cost basis method %q, expected lots, uk, acb or average", o.method))
+		}
+	}
+	var perWallet time.Time
+	if o.perWallet != "" {
+		var err error
+		if perWallet, err = parseDate(o.perWallet); err != nil {
+			return nil, err
+		}
+	}
+	var prices ledger.PriceSource
+	if len(o.priceFiles) > 0 {
+		priceMap, err := ledger.LoadPriceCSVFiles(ledger.CoinGeckoPriceCSV, o.priceFiles...)
+		if err != nil {
+			return nil, err
+		}
+		prices = ledger.NearestPrevious(priceMap)
+	}
+
+	switch {
+	case o.ledgerFile != "" && len(journals) > 0:
+		return nil, usageError("give either -ledger or journal files, not both")
+
+	case o.ledgerFile != "" && o.perWallet != "":
+		return nil, usageError("-per-wallet only applies to journal files, since a saved ledger keeps its own lot tracking")
+
+	case o.ledgerFile != "":
+		f, err := os.Open(o.ledgerFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		l, err := ledger.Load(f, prices)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", o.ledgerFile, err)
+		}
+		if l.LocalCurrency() != local {
+			return nil, fmt.Errorf("%s: the ledger's local currency is %s, not %s", o.ledgerFile, l.LocalCurrency(), local)
+		}
+		if o.method != "" && l.CostBasisMethod() != method {
+			return nil, fmt.Errorf("%s: the ledger's cost basis method is %s, not %s", o.ledgerFile, l.CostBasisMethod(), method)
+		}
+		if rules != nil {
+			l.SetTaxRules(rules)
+		}
+		return l, nil
+
+	case len(journals) > 0:
+		l := ledger.New(local, prices)
+		if err := l.SetCostBasisMethod(method); err != nil {
+			return nil, err
+		}
+		if o.perWallet != "" {
+			if err := l.SetLotTracking(perWallet, ledger.PerWalletTracking); err != nil {
+				return nil, err
+			}
+		}
+		if rules != nil {
+			l.SetTaxRules(rules)
+		}
+		return l, journal.ReplayFiles(l, journals...)
+	}
+	return nil, usageError("missing the journal files, or a -ledger file")
+}
+
+// parsePresentValueFlags parses the -date and -price flags.
+func (o *options) parsePresentValueFlags() error {
+	var err error
+	if o.valueDate, err = parseDate(o.date); err != nil {
+		return err
+	}
+	o.prices = map[ledger.Currency]ledger.Decimal{}
+	for _, price := range o.currentPrices {
+		currency, value, ok := strings.Cut(price, "=")
+		d, err := ledger.ParseDecimal(value)
+		if !ok || err != nil {
+			return usageError(fmt.Sprintf("invalid price %q, expected e.g. BTC=4028.89", price))
+		}
+		o.prices[ledger.Currency(strings.ToUpper(currency))] = d
+	}
+	return nil
+}
+
+// parseDate parses a date given on the command line, or returns today's date if it's empty.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		now := time.Now().UTC()
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	date, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, usageError(fmt.Sprintf("invalid date %q, expected YYYY-MM-DD", s))
+	}
+	return date, nil
+}
+
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+const testJournal = `2017-04-06 deposit  Bitfinex 960 USD basis 1085
+2017-04-06 purchase 1 0.83976678 BTC cost 960
+2017-08-01 income Bitfinex 0.35853168 BCH value 212.25 "fork from BTC"
+2017-11-01 transfer 1.1 0.8 BTC fee 0.001 to Coinbase
+2017-11-02 exchange 2 0.35853168 BCH for 0.02764547 BTC priced-by BTC
+2017-12-01 sell 1.1.1 0.1 BTC for 1097.56
+`
+
+const testPrices = `snapped_at,price,market_cap,total_volume
+2017-11-01 00:00:00 UTC,6767.31,0,0
+2017-11-02 00:00:00 UTC,6960.07,0,0
+2017-12-01 00:00:00 UTC,10975.60,0,0
+`
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	books, prices := filepath.Join(dir, "books.journal"), filepath.Join(dir, "btc-usd-max.csv")
+	allocate := filepath.Join(dir, "2025.journal")
+	g := NewGomegaWithT(t)
+	g.Expect(os.WriteFile(books, []byte(testJournal), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(prices, []byte(testPrices), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(allocate, []byte("2025-01-01 allocate pro-rata\n"), 0644)).To(Succeed())
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"lots", []string{"lots", "-prices", prices, books}, `lot    account   currency  amount      costBasis  purchaseDate
+1.1    Bitfinex  BTC       0.03976678  51.38      2017-04-06
+1.1.1  Coinbase  BTC       0.699       909.05     2017-04-06
+2.1    Bitfinex  BTC       0.02764547  192.41     2017-11-02
+(total basis: 1152.84)
+`},
+		{"lots at their average cost", []string{"lots", "-prices", prices, "-method", "average", books}, `lot    account   currency  amount      costBasis  purchaseDate
+1.1    Bitfinex  BTC       0.03976678  143.81     2017-04-06
+1.1.1  Coinbase  BTC       0.699       909.05     2017-04-06
+2.1    Bitfinex  BTC       0.02764547  99.98      2017-11-02
+(total basis: 1152.84)
+`},
+		{"gains for one year, currency and account", []string{"gains", "-prices", prices, "-format", "tsv", "-year", "2017", "-currency", "btc", "-account", "Coinbase", books},
+			"lot\taccount\tcurrency\tamount\tpurchaseDate\tcostBasis\tsaleDate\tproceeds\tcategory\tcode\tadjustment\tgains\tnote\n" +
+				"1.1.1.1\tCoinbase\tBTC\t0.1\t2017-04-06\t130.05\t2017-12-01\t1097.56\tshort-term\t\t0.00\t967.51\tsold BTC for USD\n"},
+		{"form 8949", []string{"form8949", "-prices", prices, "-year", "2017", books},
+			`year  box  description      acquired    sold        proceeds  costBasis  code  adjustment  gain
+2017  C    0.001000000 BTC  04/06/2017  11/01/2017  6.77      1.29             0.00        5.48
+2017  C    0.358531680 BCH  08/01/2017  11/02/2017  192.41    212.25           0.00        -19.84
+2017  C    0.100000000 BTC  04/06/2017  12/01/2017  1097.56   130.05           0.00        967.51
+(2017 box C, Schedule D line 3: proceeds:1296.74 cost basis:343.59 adjustment:0.00 gain:953.15)
+`},
+		{"gains by the UK share matching rules", []string{"gains", "-prices", prices, "-method", "uk", "-currency", "btc", books}, `lot                        account   currency  amount  purchaseDate  costBasis  saleDate    proceeds  category    code  adjustment  gains   note
+1.1.1.spendCapitalGains.1  Bitfinex  BTC       0.001   2017-04-06    6.96       2017-11-01  6.77      short-term        0.00        -0.19   fee for transferring from Bitfinex to Coinbase
+1.1.1.1                    Coinbase  BTC       0.1     2017-04-06    146.63     2017-12-01  1097.56   short-term        0.00        950.93  sold BTC for USD
+(total gains: short-term:950.74 long-term:0.00)
+`},
+		{"gains by the German rules", []string{"gains", "-prices", prices, "-rules", "de", "-currency", "btc", books}, `lot                        account   currency  amount  purchaseDate  costBasis  saleDate    proceeds  category  code  adjustment  gains   note
+1.1.1.spendCapitalGains.1  Bitfinex  BTC       0.001   2017-04-06    1.29       2017-11-01  6.77      taxable         0.00        5.48    fee for transferring from Bitfinex to Coinbase
+1.1.1.1                    Coinbase  BTC       0.1     2017-04-06    130.05     2017-12-01  1097.56   taxable         0.00        967.51  sold BTC for USD
+(total gains: taxable:972.99 exempt:0.00 net taxable:972.99)
+`},
+		{"pools", []string{"pools", "-prices", prices, "-year", "2017", books}, `date        currency  event     amount       cost     poolAmount  poolCost
+2017-08-01  BCH       acquired  0.35853168   212.25   0.35853168  212.25
+2017-11-02  BCH       disposed  -0.35853168  -212.25  0.00000000  0.00
+2017-04-06  BTC       acquired  0.83976678   1085.00  0.83976678  1085.00
+2017-11-02  BTC       acquired  0.02664547   185.45   0.86641225  1270.45
+2017-12-01  BTC       disposed  -0.1         -146.63  0.76641225  1123.82
+`},
+		{"schedule 3", []string{"schedule3", "-prices", prices, "-method", "acb", books}, `year  description      disposed    proceeds  adjustedCostBase  superficialLoss  gain
+2017  0.001000000 BTC  2017-11-01  6.77      1.29              0.00             5.48
+2017  0.358531680 BCH  2017-11-02  192.41    212.25            0.00             -19.84
+2017  0.100000000 BTC  2017-12-01  1097.56   147.29            0.00             950.27
+(2017 gain: 935.91, taxable capital gain: 467.96)
+`},
+		{"allocation", []string{"allocation", "-prices", prices, "-account", "Coinbase", books, allocate}, `cutoff      currency  originalLot  originalAccount  acquired    lot      account   amount      costBasis
+2025-01-01  BTC       1.1          Bitfinex         2017-04-06  1.1.3    Coinbase  0.03626897  46.86
+2025-01-01  BTC       1.1.1        Coinbase         2017-04-06  1.1.1.3  Coinbase  0.63751721  829.09
+2025-01-01  BTC       2.1          Bitfinex         2017-11-02  2.1.2    Coinbase  0.02521382  175.49
+(basis allocated pro-rata as of 2025-01-01: 1051.44)
+`},
+		{"income", []string{"income", "-prices", prices, "-format", "csv", books}, `lot,date,account,currency,amount,value,note
+2,2017-08-01,Bitfinex,BCH,0.35853168,212.25,fork from BTC
+`},
+		{"accounts", []string{"accounts", "-prices", prices, books}, `account   currency  balance     costBasis  lots
+Bitfinex  BTC       0.06741225  243.79     2
+Coinbase  BTC       0.699       909.05     1
+(total basis: 1152.84)
+`},
+		{"present value", []string{"present-value", "-prices", prices, "-date", "2018-12-23", "-price", "BTC=4028.89", "-account", "Coinbase", "-format", "json", books}, `[
+  {"lot": "1.1.1", "account": "Coinbase", "currency": "BTC", "amount": "0.699", "costBasis": "909.05", "purchaseDate": "2017-04-06", "category": "long-term", "presentValue": "2816.19", "unrealizedGains": "1907.14"}
+]
+`},
+		{"present value from historical prices", []string{"present-value", "-prices", prices, "-date", "2017-12-02", "-account", "Coinbase", books},
+			`lot    account   currency  amount  costBasis  purchaseDate  category    presentValue  unrealizedGains
+1.1.1  Coinbase  BTC       0.699   909.05     2017-04-06    short-term  7671.94       6762.89
+(total present value on 2017-12-02: 7671.94, unrealized gains: 6762.89)
+`},
+	} {
+		g := NewGomegaWithT(t)
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		g.Expect(run(tc.args, stdout, stderr)).To(Succeed(), tc.name)
+		g.Expect(stdout.String()).To(Equal(tc.want), tc.name)
+	}
+}
+
+func TestRunSavedLedger(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New("USD", nil)
+	_, err := l.DepositNewMoney(time.Date(2017, 4, 6, 0, 0, 0, 0, time.UTC), "Bitfinex", ledger.MustParseDecimal("960"), ledger.MustParseDecimal("1085"))
+	g.Expect(err).NotTo(HaveOccurred())
+	saved := filepath.Join(t.TempDir(), "ledger.json")
+	f, err := os.Create(saved)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(l.Save(f)).To(Succeed())
+	g.Expect(f.Close()).To(Succeed())
+
+	stdout := &bytes.Buffer{}
+	g.Expect(run([]string{"lots", "-ledger", saved}, stdout, &bytes.Buffer{})).To(Succeed())
+	g.Expect(stdout.String()).To(Equal(`lot  account   currency  amount  costBasis  purchaseDate
+1    Bitfinex  USD       960     1085.00    2017-04-06
+(total basis: 1085.00)
+`))
+	g.Expect(run([]string{"lots", "-ledger", saved, "-local", "EUR"}, stdout, &bytes.Buffer{})).
+		To(MatchError(saved + ": the ledger's local currency is USD, not EUR"))
+}
+
+func TestRunErrors(t *testing.T) {
+	dir := t.TempDir()
+	books, wallets := filepath.Join(dir, "books.journal"), filepath.Join(dir, "wallets.journal")
+	g := NewGomegaWithT(t)
+	g.Expect(os.WriteFile(books, []byte("2017-04-06 deposit Bitfinex 960\n2017-04-07 spend 1 1 BTC\n"), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(wallets, []byte("2017-04-06 deposit Bitfinex 960\n2017-04-06 purchase 1 0.1 BTC cost 960\n"+
+		"2017-12-01 spend 1.1 0.01 BTC from Coinbase\n"), 0644)).To(Succeed())
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "missing the command, one of: accounts, allocation, form8949, gains, income, lots, pools, present-value, schedule3"},
+		{[]string{"balance"}, `unknown command "balance", expected one of: accounts, allocation, form8949, gains, income, lots, pools, present-value, schedule3`},
+		{[]string{"lots"}, "missing the journal files, or a -ledger file"},
+		{[]string{"lots", "-ledger", "ledger.json", books}, "give either -ledger or journal files, not both"},
+		{[]string{"lots", "-format", "xml", books}, `unknown format "xml", expected text, tsv, csv or json`},
+		{[]string{"accounts", "-year", "2017", books}, "-year doesn't apply to accounts"},
+		{[]string{"pools", "-account", "Coinbase", books}, "-account doesn't apply to pools, since its pools are shared by all the accounts"},
+		{[]string{"gains", "-method", "fifo", books}, `unknown cost basis method "fifo", expected lots, uk, acb or average`},
+		{[]string{"gains", "-rules", "uk", books}, `unknown tax rules "uk", expected us, de, au or flat`},
+		{[]string{"lots", "-per-wallet", "2017-12-01", wallets}, wallets + ":3: Spend: lot 1.1: the lot is held in Bitfinex, not Coinbase, and lots are tracked per wallet on 2017-12-01"},
+		{[]string{"lots", "-per-wallet", "2017-12-01", "-ledger", "ledger.json"}, "-per-wallet only applies to journal files, since a saved ledger keeps its own lot tracking"},
+		{[]string{"lots", books}, books + ":2: lot 1 does not contain BTC, it contains USD"},
+		{[]string{"present-value", "-date", "2017-04-07", "-price", "BTC", books}, `invalid price "BTC", expected e.g. BTC=4028.89`},
+		{[]string{"present-value", "-date", "April", books}, `invalid date "April", expected YYYY-MM-DD`},
+		{[]string{"lots", "-year", "last", books}, `invalid value "last" for flag -year: parse error`},
+	} {
+		g := NewGomegaWithT(t)
+		g.Expect(run(tc.args, &bytes.Buffer{}, &bytes.Buffer{})).To(MatchError(tc.want), strings.Join(tc.args, " "))
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// report is a table of results. Totals are only printed in the text format, after the table.
+type report struct {
+	columns []string
+	rows    [][]string
+	totals  []string
+}
+
+type command struct {
+	description string
+	report      func(l *ledger.Ledger, o *options) (*report, error)
+	// noYear means the -year flag doesn't apply
+	noYear bool
+	// noAccount means the -account flag doesn't apply
+	noAccount bool
+	// presentValue adds the flags for valuing lots on a date
+	presentValue bool
+}
+
+var commands = map[string]command{
+	"lots":          {description: "Prints the lots still holding some amount.", report: lotsReport},
+	"gains":         {description: "Prints the taxable gains from sales and exchanges.", report: gainsReport},
+	"income":        {description: "Prints the assets received as income.", report: incomeReport},
+	"accounts":      {description: "Prints the balance and cost basis of each currency in each account.", report: accountsReport, noYear: true},
+	"form8949":      {description: "Prints the taxable gains laid out like IRS Form 8949, with the totals for each Schedule D line.", report: form8949Report},
+	"allocation":    {description: "Prints the unused basis allocated to each account by a safe harbor allocation, from each original lot.", report: allocationReport},
+	"pools":         {description: "Prints the changes to each currency's Section 104 pool, under the UK share matching rules.", report: poolsReport, noAccount: true},
+	"schedule3":     {description: "Prints the dispositions laid out like Canada's Schedule 3, with each year's taxable capital gain.", report: schedule3Report},
+	"present-value": {description: "Prints the value of the lots on a date, and their unrealized gains.", report: presentValueReport, noYear: true, presentValue: true},
+}
+
+// includes returns true if the lot passes the -account and -currency filters, and its year passes the -year filter.
+func (o *options) includes(account ledger.Account, currency ledger.Currency, year int) bool {
+	return (o.account == "" || string(account) == o.account) &&
+		(o.currency == "" || strings.EqualFold(string(currency), o.currency)) &&
+		(o.year == 0 || year == o.year)
+}
+
+// openLots returns the lots still holding some amount, which pass the filters.
+func openLots(l *ledger.Ledger, o *options) []*ledger.Lot {
+	var lots []*ledger.Lot
+	for _, lot := range l.Lots() {
+		if lot.Amount().Sign() > 0 && lot.Type() != ledger.TaxableGains &&
+			o.includes(lot.Account(), lot.Currency(), lot.OriginalPurchaseTime().Year()) {
+			lots = append(lots, lot)
+		}
+	}
+	return lots
+}
+
+func lotsReport(l *ledger.Ledger, o *options) (*report, error) {
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"lot", "account", "currency", "amount", "costBasis", "purchaseDate"}}
+	var totalBasis ledger.Decimal
+	for _, lot := range openLots(l, o) {
+		r.rows = append(r.rows, []string{lot.Name(), lot.Account().String(), lot.Currency().String(), lot.Amount().String(),
+			money(lot.CostBasis()), date(lot.OriginalPurchaseTime())})
+		totalBasis = totalBasis.Add(lot.CostBasis())
+	}
+	r.totals = append(r.totals, fmt.Sprintf("(total basis: %s)", money(totalBasis)))
+	return r, nil
+}
+
+func gainsReport(l *ledger.Ledger, o *options) (*report, error) {
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"lot", "account", "currency", "amount", "purchaseDate", "costBasis", "saleDate",
+		"proceeds", "category", "code", "adjustment", "gains", "note"}}
+	// the gains by category name, and the taxable gains under ""
+	totals := map[string]ledger.Decimal{}
+	for _, lot := range l.Lots() {
+		details := lot.TaxableGainsDetails()
+		if details == nil || !o.includes(details.Account(), details.Currency(), details.DateOfSale().Year()) {
+			continue
+		}
+		category := l.GainsCategory(details)
+		totals[category.Name] = totals[category.Name].Add(details.Gains())
+		totals[""] = totals[""].Add(category.TaxableGain(details.Gains(), l.Precision(l.LocalCurrency())))
+		var code string
+		if !details.WashSaleDisallowed().IsZero() {
+			code = "W"
+		}
+		r.rows = append(r.rows, []string{lot.Name(), details.Account().String(), details.Currency().String(),
+			details.SoldAmount().String(), date(details.OriginalPurchaseTime()), money(details.CostBasis()),
+			date(details.DateOfSale()), money(details.Proceeds()), category.Name, code, money(details.WashSaleDisallowed()),
+			money(details.Gains()), details.Note()})
+	}
+	var (
+		parts    []string
+		adjusted bool
+	)
+	for _, category := range l.TaxRules().Categories() {
+		parts = append(parts, fmt.Sprintf("%s:%s", category.Name, money(totals[category.Name])))
+		adjusted = adjusted || category.Exempt || !category.Discount.IsZero()
+	}
+	if adjusted {
+		parts = append(parts, fmt.Sprintf("net taxable:%s", money(totals[""])))
+	}
+	r.totals = append(r.totals, fmt.Sprintf("(total gains: %s)", strings.Join(parts, " ")))
+	return r, nil
+}
+
+func incomeReport(l *ledger.Ledger, o *options) (*report, error) {
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"lot", "date", "account", "currency", "amount", "value", "note"}}
+	var total ledger.Decimal
+	for _, lot := range l.Lots() {
+		if lot.Type() != ledger.AssetIncome || !o.includes(lot.Account(), lot.Currency(), lot.OriginalPurchaseTime().Year()) {
+			continue
+		}
+		var note string
+		if tx, ok := l.TransactionForLot(lot.Name()); ok {
+			note = tx.Note
+		}
+		r.rows = append(r.rows, []string{lot.Name(), date(lot.OriginalPurchaseTime()), lot.Account().String(),
+			lot.Currency().String(), lot.OriginalAmount().String(), money(lot.OriginalCostBasis()), note})
+		total = total.Add(lot.OriginalCostBasis())
+	}
+	r.totals = append(r.totals, fmt.Sprintf("(total income: %s)", money(total)))
+	return r, nil
+}
+
+func form8949Report(l *ledger.Ledger, o *options) (*report, error) {
+	sections, err := l.Form8949(ledger.Form8949Options{})
+	if err != nil {
+		return nil, err
+	}
+	lots := map[string]*ledger.Lot{}
+	for _, lot := range l.Lots() {
+		lots[lot.Name()] = lot
+	}
+
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"year", "box", "description", "acquired", "sold", "proceeds", "costBasis", "code",
+		"adjustment", "gain"}}
+	for _, section := range sections {
+		var proceeds, basis, adjustment, gain ledger.Decimal
+		for _, row := range section.Rows {
+			details := lots[row.LotName].TaxableGainsDetails()
+			if !o.includes(details.Account(), details.Currency(), section.Year) {
+				continue
+			}
+			r.rows = append(r.rows, []string{strconv.Itoa(section.Year), section.Box.String(), row.Description,
+				row.Acquired.Format("01/02/2006"), row.Sold.Format("01/02/2006"), money(row.Proceeds), money(row.CostBasis),
+				row.Adjustment.Codes, money(row.Adjustment.Amount), money(row.Gain)})
+			proceeds, basis = proceeds.Add(row.Proceeds), basis.Add(row.CostBasis)
+			adjustment, gain = adjustment.Add(row.Adjustment.Amount), gain.Add(row.Gain)
+		}
+		if !proceeds.IsZero() || !basis.IsZero() {
+			r.totals = append(r.totals, fmt.Sprintf("(%d box %s, Schedule D line %s: proceeds:%s cost basis:%s adjustment:%s gain:%s)",
+				section.Year, section.Box, section.Box.ScheduleDLine(), money(proceeds), money(basis), money(adjustment), money(gain)))
+		}
+	}
+	return r, nil
+}
+
+func poolsReport(l *ledger.Ledger, o *options) (*report, error) {
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"date", "currency", "event", "amount", "cost", "poolAmount", "poolCost"}}
+	for _, entry := range l.Section104Pools() {
+		if !o.includes("", entry.Currency, entry.Date.Year()) {
+			continue
+		}
+		r.rows = append(r.rows, []string{date(entry.Date), entry.Currency.String(), entry.Event, entry.Amount.String(),
+			money(entry.Cost), entry.PoolAmount.String(), money(entry.PoolCost)})
+	}
+	return r, nil
+}
+
+func schedule3Report(l *ledger.Ledger, o *options) (*report, error) {
+	lots := map[string]*ledger.Lot{}
+	for _, lot := range l.Lots() {
+		lots[lot.Name()] = lot
+	}
+
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"year", "description", "disposed", "proceeds", "adjustedCostBase", "superficialLoss", "gain"}}
+	for _, year := range l.Schedule3() {
+		var total ledger.Decimal
+		included := false
+		for _, row := range year.Rows {
+			details := lots[row.LotName].TaxableGainsDetails()
+			if !o.includes(details.Account(), details.Currency(), year.Year) {
+				continue
+			}
+			r.rows = append(r.rows, []string{strconv.Itoa(year.Year), row.Description, date(row.Disposed),
+				money(row.Proceeds), money(row.AdjustedCostBase), money(row.SuperficialLoss), money(row.Gain)})
+			total, included = total.Add(row.Gain), true
+		}
+		if included {
+			r.totals = append(r.totals, fmt.Sprintf("(%d gain: %s, taxable capital gain: %s)", year.Year, money(total),
+				money(total.Quo(ledger.NewDecimal(2, 0), 2))))
+		}
+	}
+	return r, nil
+}
+
+func allocationReport(l *ledger.Ledger, o *options) (*report, error) {
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"cutoff", "currency", "originalLot", "originalAccount", "acquired", "lot", "account",
+		"amount", "costBasis"}}
+	for _, allocation := range l.BasisAllocations() {
+		var total ledger.Decimal
+		included := false
+		for _, lot := range allocation.Lots {
+			if !o.includes(lot.Account, lot.Currency, allocation.Cutoff.Year()) {
+				continue
+			}
+			r.rows = append(r.rows, []string{date(allocation.Cutoff), lot.Currency.String(), lot.OriginalLot,
+				lot.OriginalAccount.String(), date(lot.Acquired), lot.Lot, lot.Account.String(), lot.Amount.String(),
+				money(lot.CostBasis)})
+			total, included = total.Add(lot.CostBasis), true
+		}
+		if included {
+			r.totals = append(r.totals, fmt.Sprintf("(basis allocated %s as of %s: %s)", allocation.Method,
+				date(allocation.Cutoff), money(total)))
+		}
+	}
+	return r, nil
+}
+
+func accountsReport(l *ledger.Ledger, o *options) (*report, error) {
+	money := moneyFormatter(l)
+	r := &report{columns: []string{"account", "currency", "balance", "costBasis", "lots"}}
+	var totalBasis ledger.Decimal
+	for account, currencies := range l.AccountSummary() {
+		for currency, summary := range currencies {
+			if !o.includes(account, currency, 0) {
+				continue
+			}
+			r.rows = append(r.rows, []string{account.String(), currency.String(), summary.Balance.String(),
+				money(summary.Basis), strconv.Itoa(len(summary.Lots))})
+			totalBasis = totalBasis.Add(summary.Basis)
+		}
+	}
+	sort.Slice(r.rows, func(i, j int) bool {
+		a, b := r.rows[i], r.rows[j]
+		return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+	})
+	r.totals = append(r.totals, fmt.Sprintf("(total basis: %s)", money(totalBasis)))
+	return r, nil
+}
+
+func presentValueReport(l *ledger.Ledger, o *options) (*report, error) {
+	on := o.valueDate
+	money := moneyFormatter(l)
+	places := l.Precision(l.LocalCurrency())
+	r := &report{columns: []string{"lot", "account", "currency", "amount", "costBasis", "purchaseDate", "category",
+		"presentValue", "unrealizedGains"}}
+	var totalBasis, totalValue ledger.Decimal
+	for _, lot := range openLots(l, o) {
+		value, err := presentValue(l, o, lot, places)
+		if err != nil {
+			return nil, err
+		}
+		category := l.TaxRules().Classify(lot.OriginalPurchaseTime(), on)
+		r.rows = append(r.rows, []string{lot.Name(), lot.Account().String(), lot.Currency().String(), lot.Amount().String(),
+			money(lot.CostBasis()), date(lot.OriginalPurchaseTime()), category.Name, money(value), money(value.Sub(lot.CostBasis()))})
+		totalBasis = totalBasis.Add(lot.CostBasis())
+		totalValue = totalValue.Add(value)
+	}
+	r.totals = append(r.totals, fmt.Sprintf("(total present value on %s: %s, unrealized gains: %s)",
+		date(on), money(totalValue), money(totalValue.Sub(totalBasis))))
+	return r, nil
+}
+
+// presentValue values the lot with the price given on the command line, or else the historical price on the date.
+func presentValue(l *ledger.Ledger, o *options, lot *ledger.Lot, places int32) (ledger.Decimal, error) {
+	if price, ok := o.prices[lot.Currency()]; ok {
+		return lot.Amount().Mul(price).Round(places), nil
+	}
+	value, err := l.Value(lot.Currency(), lot.Amount(), o.valueDate)
+	if err != nil {
+		return ledger.Decimal{}, fmt.Errorf("valuing lot %s: %w", lot.Name(), err)
+	}
+	return value, nil
+}
+
+// moneyFormatter returns a function formatting amounts of the local currency to its precision.
+func moneyFormatter(l *ledger.Ledger) func(d ledger.Decimal) string {
+	places := l.Precision(l.LocalCurrency())
+	return func(d ledger.Decimal) string {
+		return d.StringFixed(places)
+	}
+}
+
+func date(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// formats write a report in each output format.
+var formats = map[string]func(w io.Writer, r *report) error{
+	"text": writeText,
+	"tsv":  func(w io.Writer, r *report) error { return writeCSV(w, r, '\t') },
+	"csv":  func(w io.Writer, r *report) error { return writeCSV(w, r, ',') },
+	"json": writeJSON,
+}
+
+func writeText(w io.Writer, r *report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(r.columns, "\t"))
+	for _, row := range r.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, total := range r.totals {
+		if _, err := fmt.Fprintln(w, total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, r *report, comma rune) error {
+	c := csv.NewWriter(w)
+	c.Comma = comma
+	c.Write(r.columns)
+	c.WriteAll(r.rows)
+	return c.Error()
+}
+
+// writeJSON writes the rows as an array of objects, with the columns in order.
+func writeJSON(w io.Writer, r *report) error {
+	b := &bytes.Buffer{}
+	b.WriteString("[")
+	for i, row := range r.rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for j, column := range r.columns {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			key, _ := json.Marshal(column)
+			value, _ := json.Marshal(row[j])
+			fmt.Fprintf(b, "%s: %s", key, value)
+		}
+		b.WriteString("}")
+	}
+	if len(r.rows) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	_, err := b.WriteTo(w)
+	return err
+}
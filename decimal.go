@@ -0,0 +1,300 @@
+package ledger
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact, arbitrary-precision decimal number, coef × 10^-scale.
+// The zero value is 0. Decimals are immutable: every operation returns a new Decimal.
+type Decimal struct {
+	coef  *big.Int // nil means zero
+	scale int32    // number of digits after the decimal point, never negative
+}
+
+// maxExponent bounds the exponent ParseDecimal accepts, so hostile input can't make it build huge numbers.
+const maxExponent = 100
+
+var (
+	bigOne = big.NewInt(1)
+	bigTen = big.NewInt(10)
+)
+
+// NewDecimal returns value × 10^-scale, e.g. NewDecimal(12345, 2) is 123.45.
+func NewDecimal(value int64, scale int32) Decimal {
+	return newDecimal(big.NewInt(value), scale)
+}
+
+// NewDecimalFromFloat returns the shortest decimal that represents f, e.g. 0.1 is exactly 0.1.
+func NewDecimalFromFloat(f float64) Decimal {
+	d, err := ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+	if err != nil {
+		panic(fmt.Sprintf("can't represent %v as a Decimal", f))
+	}
+	return d
+}
+
+// ParseDecimal parses a decimal number like "-1234.5678" or "1.5e-8". The exponent must be within ±100.
+func ParseDecimal(s string) (Decimal, error) {
+	str := strings.TrimSpace(s)
+
+	var exp int64
+	if i := strings.IndexAny(str, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(str[i+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		if e > maxExponent || e < -maxExponent {
+			return Decimal{}, fmt.Errorf("decimal %q has an exponent beyond ±%d", s, maxExponent)
+		}
+		exp, str = e, str[:i]
+	}
+
+	sign := ""
+	if str != "" && (str[0] == '-' || str[0] == '+') {
+		sign, str = str[:1], str[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(str, ".")
+	if intPart+fracPart == "" || strings.Trim(intPart+fracPart, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	coef, ok := new(big.Int).SetString(sign+intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	scale := int64(len(fracPart)) - exp
+	if scale < 0 {
+		coef.Mul(coef, pow10(int32(-scale)))
+		scale = 0
+	}
+	return newDecimal(coef, int32(scale)), nil
+}
+
+// MustParseDecimal is like ParseDecimal, but panics if s can't be parsed.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return d
+}
+
+func newDecimal(coef *big.Int, scale int32) Decimal {
+	if coef.Sign() == 0 {
+		return Decimal{scale: scale}
+	}
+	return Decimal{coef: coef, scale: scale}
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// int returns the coefficient, never nil. It must not be modified.
+func (d Decimal) int() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// rescaled returns the coefficient of d expressed with the given (larger) scale.
+func (d Decimal) rescaled(scale int32) *big.Int {
+	if scale == d.scale {
+		return new(big.Int).Set(d.int())
+	}
+	return new(big.Int).Mul(d.int(), pow10(scale-d.scale))
+}
+
+// Add returns d + e.
+func (d Decimal) Add(e Decimal) Decimal {
+	scale := maxScale(d, e)
+	return newDecimal(new(big.Int).Add(d.rescaled(scale), e.rescaled(scale)), scale)
+}
+
+// Sub returns d - e.
+func (d Decimal) Sub(e Decimal) Decimal {
+	scale := maxScale(d, e)
+	return newDecimal(new(big.Int).Sub(d.rescaled(scale), e.rescaled(scale)), scale)
+}
+
+// Mul returns d × e, exactly.
+func (d Decimal) Mul(e Decimal) Decimal {
+	return newDecimal(new(big.Int).Mul(d.int(), e.int()), d.scale+e.scale)
+}
+
+// Quo returns d ÷ e rounded to the given number of decimal places (half away from zero).
+// It panics if e is zero.
+func (d Decimal) Quo(e Decimal, places int32) Decimal {
+	if e.IsZero() {
+		panic("ledger: division of " + d.String() + " by zero")
+	}
+	// d/e × 10^places = d.coef × 10^(e.scale + places - d.scale) / e.coef
+	num, den := new(big.Int).Set(d.int()), new(big.Int).Set(e.int())
+	if shift := e.scale + places - d.scale; shift >= 0 {
+		num.Mul(num, pow10(shift))
+	} else {
+		den.Mul(den, pow10(-shift))
+	}
+	return newDecimal(quoRound(num, den), places)
+}
+
+// Round returns d rounded to the given number of decimal places (half away from zero).
+func (d Decimal) Round(places int32) Decimal {
+	if d.scale <= places {
+		return d
+	}
+	return newDecimal(quoRound(new(big.Int).Set(d.int()), pow10(d.scale-places)), places)
+}
+
+// quoRound returns num ÷ den, rounding half away from zero.
+func quoRound(num, den *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	r.Abs(r).Lsh(r, 1)
+	if r.CmpAbs(den) >= 0 {
+		if num.Sign()*den.Sign() < 0 {
+			q.Sub(q, bigOne)
+		} else {
+			q.Add(q, bigOne)
+		}
+	}
+	return q
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return newDecimal(new(big.Int).Neg(d.int()), d.scale)
+}
+
+// Abs returns |d|.
+func (d Decimal) Abs() Decimal {
+	return newDecimal(new(big.Int).Abs(d.int()), d.scale)
+}
+
+// Cmp compares d and e, returning -1, 0 or +1.
+func (d Decimal) Cmp(e Decimal) int {
+	scale := maxScale(d, e)
+	return d.rescaled(scale).Cmp(e.rescaled(scale))
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d.
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// IsZero returns true if d is 0.
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// HasPlacesBeyond returns true if d can't be written with the given number of decimal places.
+func (d Decimal) HasPlacesBeyond(places int32) bool {
+	return d.Round(places).Cmp(d) != 0
+}
+
+// Float64 returns the nearest float64 to d, which is suitable for display but not for arithmetic.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String returns d with all of its decimal places, e.g. "0.83976678".
+func (d Decimal) String() string {
+	digits := d.int().String()
+	neg := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(digits, "-")
+
+	if d.scale > 0 {
+		if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+		digits = digits[:len(digits)-int(d.scale)] + "." + digits[len(digits)-int(d.scale):]
+	}
+	if neg {
+		return "-" + digits
+	}
+	return digits
+}
+
+// StringFixed returns d rounded to exactly the given number of decimal places, e.g. "1.50".
+func (d Decimal) StringFixed(places int32) string {
+	r := d.Round(places)
+	return newDecimal(r.rescaled(places), places).String()
+}
+
+// Format implements fmt.Formatter, so Decimals print like floats with the %f verb (e.g. "%0.9f"),
+// but without any loss of precision.
+func (d Decimal) Format(s fmt.State, verb rune) {
+	var str string
+	switch verb {
+	case 'f', 'F':
+		places, ok := s.Precision()
+		if !ok {
+			places = 6
+		}
+		str = d.StringFixed(int32(places))
+	case 'v', 's':
+		str = d.String()
+	case 'e', 'E', 'g', 'G':
+		fmt.Fprintf(s, fmt.FormatString(s, verb), d.Float64())
+		return
+	default:
+		fmt.Fprintf(s, "%%!%c(ledger.Decimal=%s)", verb, d.String())
+		return
+	}
+
+	if s.Flag('+') && d.Sign() >= 0 {
+		str = "+" + str
+	}
+	if width, ok := s.Width(); ok && len(str) < width {
+		pad := width - len(str)
+		switch {
+		case s.Flag('-'):
+			str += strings.Repeat(" ", pad)
+		case s.Flag('0'):
+			sign := ""
+			if str[0] == '-' || str[0] == '+' {
+				sign, str = str[:1], str[1:]
+			}
+			str = sign + strings.Repeat("0", pad) + str
+		default:
+			str = strings.Repeat(" ", pad) + str
+		}
+	}
+	fmt.Fprint(s, str)
+}
+
+func maxScale(d, e Decimal) int32 {
+	if d.scale > e.scale {
+		return d.scale
+	}
+	return e.scale
+}
+
+// MarshalJSON encodes d as a string, e.g. "0.83976678", so no precision is lost.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON decodes a Decimal from a JSON string or number.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,62 @@
+package ledger_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestDecimal(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(n("0.1").Add(n("0.2")).String()).To(Equal("0.3"))
+	g.Expect(n("1").Sub(n("0.00000001")).String()).To(Equal("0.99999999"))
+	g.Expect(n("-1.5").Mul(n("0.25")).String()).To(Equal("-0.375"))
+	g.Expect(n("10").Quo(n("3"), 4).String()).To(Equal("3.3333"))
+	g.Expect(n("2").Quo(n("3"), 2).String()).To(Equal("0.67"))
+	g.Expect(n("-0.125").Round(2).String()).To(Equal("-0.13"))
+	g.Expect(n("1.5e-8").String()).To(Equal("0.000000015"))
+	g.Expect(n("12e2").String()).To(Equal("1200"))
+	g.Expect(n("1e100").Cmp(n("1e-100"))).To(Equal(1))
+	g.Expect(ledger.NewDecimal(12345, 2).String()).To(Equal("123.45"))
+	g.Expect(ledger.NewDecimalFromFloat(0.1).String()).To(Equal("0.1"))
+
+	g.Expect(n("1.10").Cmp(n("1.1"))).To(Equal(0))
+	g.Expect(n("0.123456789").HasPlacesBeyond(8)).To(BeTrue())
+	g.Expect(n("0.123456780").HasPlacesBeyond(8)).To(BeFalse())
+
+	g.Expect(fmt.Sprintf("%0.9f|%.2f|%8.2f|%-6.1f|%v", n("0.03976678"), n("-19.835"), n("1.5"), n("2"), n("1.50"))).
+		To(Equal("0.039766780|-19.84|    1.50|2.0   |1.50"))
+
+	for _, s := range []string{"", ".", "1.2.3", "abc", "1e", "--1", "1e101", "1e-101", "1e999999999"} {
+		_, err := ledger.ParseDecimal(s)
+		g.Expect(err).To(HaveOccurred(), s)
+	}
+}
+
+func TestRemoveNeverLosesCostBasis(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("100"), n("100"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.00000003"), n("100"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// sell a third at a time: the cost basis can't be split evenly, but it all ends up somewhere
+	var totalBasis ledger.Decimal
+	for i := 0; i < 3; i++ {
+		_, err := l.SellTaxable(d("2017-12-01"), "1.1", BTC, n("0.00000001"), n("50"))
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	for _, summary := range l.AccountSummary()[Bitfinex] {
+		totalBasis = totalBasis.Add(summary.Basis)
+	}
+	g.Expect(totalBasis.IsZero()).To(BeTrue())
+	g.Expect(l.PrintCapitalGainsTSV()).To(ContainSubstring("\t33.33\t2017-12-01\t50.00\tshort-term\t16.67\t"))
+	g.Expect(l.PrintCapitalGainsTSV()).To(ContainSubstring("\t33.34\t2017-12-01\t50.00\tshort-term\t16.66\t"))
+	g.Expect(l.PrintTaxableGains()).To(ContainSubstring("(Total capital gains: short-term:$50.00 long-term:$0.00)"))
+}
@@ -0,0 +1,108 @@
+package ledger
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// LotNotFoundError is returned when no lot has the requested name.
+	LotNotFoundError struct {
+		Name string
+	}
+
+	// CurrencyMismatchError is returned when a lot holds a different currency than the operation expected.
+	CurrencyMismatchError struct {
+		LotName  string
+		Expected Currency
+		Actual   Currency
+	}
+
+	// InsufficientAmountError is returned when more is requested than is available.
+	// LotName is empty when the amount was requested across several lots.
+	InsufficientAmountError struct {
+		LotName   string
+		Currency  Currency
+		Requested Decimal
+		Available Decimal
+	}
+
+	// InvalidAmountError is returned for amounts that can't be recorded,
+	// e.g. negative amounts, or amounts more precise than the currency allows.
+	InvalidAmountError struct {
+		Currency Currency
+		Amount   Decimal
+		Reason   string
+	}
+
+	// MissingPriceError is returned when no historical price is known for the currency on the given date.
+	MissingPriceError struct {
+		Currency Currency
+		Date     time.Time
+	}
+
+	// StalePriceError is returned when the nearest known price for the currency is older than allowed,
+	// see WithMaxStaleness.
+	StalePriceError struct {
+		Currency     Currency
+		Date         time.Time
+		QuoteDate    time.Time
+		MaxStaleness time.Duration
+	}
+
+	// LineError is returned when a line of an input file can't be read. Err describes the problem.
+	LineError struct {
+		File string
+		Line int
+		Err  error
+	}
+
+	// InvalidOperationError is returned when the operation doesn't make sense for the given lot,
+	// e.g. merging lots with different purchase dates.
+	InvalidOperationError struct {
+		Op      string
+		LotName string
+		Reason  string
+	}
+)
+
+func (e *LotNotFoundError) Error() string {
+	return "couldn't find lot with name: " + e.Name
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return fmt.Sprintf("lot %s does not contain %s, it contains %s", e.LotName, e.Expected, e.Actual)
+}
+
+func (e *InsufficientAmountError) Error() string {
+	if e.LotName == "" {
+		return fmt.Sprintf("insufficient %s: requested %s, available %s", e.Currency, e.Requested, e.Available)
+	}
+	return fmt.Sprintf("lot %s has insufficient %s: requested %s, available %s", e.LotName, e.Currency, e.Requested, e.Available)
+}
+
+func (e *InvalidAmountError) Error() string {
+	return fmt.Sprintf("invalid amount %s %s: %s", e.Amount, e.Currency, e.Reason)
+}
+
+func (e *MissingPriceError) Error() string {
+	return fmt.Sprintf("missing %s historical price for %s", e.Currency, e.Date.Format("2006-01-02"))
+}
+
+func (e *StalePriceError) Error() string {
+	return fmt.Sprintf("stale %s historical price for %s: the nearest price is from %s, more than %s away",
+		e.Currency, e.Date.Format("2006-01-02"), e.QuoteDate.Format("2006-01-02"), e.MaxStaleness)
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidOperationError) Error() string {
+	return fmt.Sprintf("%s: lot %s: %s", e.Op, e.LotName, e.Reason)
+}
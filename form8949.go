@@ -0,0 +1,295 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Form8949Box is the box checked at the top of a Form 8949 page, which says how the sales on it were reported to the IRS.
+// Boxes A-C and G-I are in Part I (short-term), and D-F and J-L in Part II (long-term).
+// Boxes G-L are for digital assets, from the 2025 tax year.
+type Form8949Box byte
+
+const (
+	// BoxA is for short-term sales reported on Form 1099-B, with their basis reported to the IRS.
+	BoxA Form8949Box = 'A'
+	// BoxB is for short-term sales reported on Form 1099-B, without their basis reported to the IRS.
+	BoxB Form8949Box = 'B'
+	// BoxC is for short-term sales not reported on Form 1099-B.
+	BoxC Form8949Box = 'C'
+	// BoxD is for long-term sales reported on Form 1099-B, with their basis reported to the IRS.
+	BoxD Form8949Box = 'D'
+	// BoxE is for long-term sales reported on Form 1099-B, without their basis reported to the IRS.
+	BoxE Form8949Box = 'E'
+	// BoxF is for long-term sales not reported on Form 1099-B.
+	BoxF Form8949Box = 'F'
+	// BoxG is for short-term digital asset sales reported on Form 1099-DA, with their basis reported to the IRS.
+	BoxG Form8949Box = 'G'
+	// BoxH is for short-term digital asset sales reported on Form 1099-DA, without their basis reported to the IRS.
+	BoxH Form8949Box = 'H'
+	// BoxI is for short-term digital asset sales not reported on Form 1099-DA.
+	BoxI Form8949Box = 'I'
+	// BoxJ is for long-term digital asset sales reported on Form 1099-DA, with their basis reported to the IRS.
+	BoxJ Form8949Box = 'J'
+	// BoxK is for long-term digital asset sales reported on Form 1099-DA, without their basis reported to the IRS.
+	BoxK Form8949Box = 'K'
+	// BoxL is for long-term digital asset sales not reported on Form 1099-DA.
+	BoxL Form8949Box = 'L'
+)
+
+// FirstDigitalAssetBoxYear is the first tax year with the digital asset boxes, G-L.
+const FirstDigitalAssetBoxYear = 2025
+
+// String returns the letter of the box.
+func (b Form8949Box) String() string {
+	return string(b)
+}
+
+func (b Form8949Box) valid() bool {
+	return b >= BoxA && b <= BoxL
+}
+
+// IsLongTerm returns true for the boxes in Part II, for long-term sales.
+func (b Form8949Box) IsLongTerm() bool {
+	return b >= BoxD && b <= BoxF || b >= BoxJ && b <= BoxL
+}
+
+// ScheduleDLine returns the line of Schedule D that the box's totals are reported on.
+func (b Form8949Box) ScheduleDLine() string {
+	switch b {
+	case BoxA, BoxG:
+		return "1b"
+	case BoxB, BoxH:
+		return "2"
+	case BoxC, BoxI:
+		return "3"
+	case BoxD, BoxJ:
+		return "8b"
+	case BoxE, BoxK:
+		return "9"
+	case BoxF, BoxL:
+		return "10"
+	}
+	return ""
+}
+
+// DefaultForm8949Box returns the box for a sale that wasn't reported to the IRS by a broker:
+// C or F before the digital asset boxes were introduced, and I or L after.
+func DefaultForm8949Box(details *TaxableGainsDetails) Form8949Box {
+	digital := details.DateOfSale().Year() >= FirstDigitalAssetBoxYear
+	switch {
+	case details.IsLongTerm() && digital:
+		return BoxL
+	case details.IsLongTerm():
+		return BoxF
+	case digital:
+		return BoxI
+	}
+	return BoxC
+}
+
+type (
+	// Form8949Options configure Form8949.
+	Form8949Options struct {
+		// Box returns the box for the sale recorded in a TaxableGains lot. Defaults to DefaultForm8949Box.
+		Box func(lot *Lot) Form8949Box
+		// Adjustment returns the adjustment to the gain or loss from the sale recorded in a TaxableGains lot,
+		// if there is one. Defaults to no adjustments.
+		Adjustment func(lot *Lot) Form8949Adjustment
+	}
+
+	// Form8949Adjustment is an adjustment to the gain or loss from a sale, in columns (f) and (g).
+	Form8949Adjustment struct {
+		// Codes are the adjustment codes from the form's instructions, e.g. "W" for a wash sale.
+		Codes string
+		// Amount is added to the gain, e.g. a wash sale's disallowed loss is positive.
+		Amount Decimal
+	}
+
+	// Form8949Row is one sale on Form 8949.
+	Form8949Row struct {
+		// LotName is the TaxableGains lot recording the sale.
+		LotName string
+		// Description is column (a), e.g. "0.039766780 BTC".
+		Description string
+		// Acquired and Sold are columns (b) and (c).
+		Acquired, Sold time.Time
+		// Proceeds and CostBasis are columns (d) and (e).
+		Proceeds, CostBasis Decimal
+		// Adjustment is columns (f) and (g).
+		Adjustment Form8949Adjustment
+		// Gain is column (h): the proceeds minus the cost basis, plus the adjustment.
+		Gain Decimal
+	}
+
+	// Form8949Section is a tax year's sales for one box of Form 8949, which may run over several pages of the form.
+	Form8949Section struct {
+		Year int
+		Box  Form8949Box
+		Rows []Form8949Row
+		// Total is the sum of the rows' amounts, which goes on the Schedule D line for the box.
+		Total Form8949Total
+	}
+
+	// Form8949Total is the sum of the amounts of several rows of Form 8949.
+	Form8949Total struct {
+		Proceeds, CostBasis, Adjustment, Gain Decimal
+	}
+)
+
+func (t *Form8949Total) add(row Form8949Row) {
+	t.Proceeds = t.Proceeds.Add(row.Proceeds)
+	t.CostBasis = t.CostBasis.Add(row.CostBasis)
+	t.Adjustment = t.Adjustment.Add(row.Adjustment.Amount)
+	t.Gain = t.Gain.Add(row.Gain)
+}
+
+// Form8949 groups the sales recorded in TaxableGains lots by tax year and box, for IRS Form 8949.
+// Sections are ordered by year, then box. Rows are in the order of the sales, and amounts are in the local currency.
+// It's an error for a sale to be given a box for the wrong term, or a digital asset box before they were introduced.
+func (l *Ledger) Form8949(options Form8949Options) ([]Form8949Section, error) {
+	type key struct {
+		year int
+		box  Form8949Box
+	}
+	sections := map[key]*Form8949Section{}
+	for _, lot := range l.lots {
+		if lot.lotType != TaxableGains {
+			continue
+		}
+		details := lot.taxableGainsDetails
+		year := details.dateOfSale.Year()
+
+		box := DefaultForm8949Box(details)
+		if options.Box != nil {
+			box = options.Box(lot)
+		}
+		switch {
+		case !box.valid():
+			return nil, &InvalidOperationError{Op: "Form8949", LotName: lot.name, Reason: fmt.Sprintf("%q isn't a Form 8949 box", box)}
+		case box.IsLongTerm() != details.IsLongTerm():
+			return nil, &InvalidOperationError{Op: "Form8949", LotName: lot.name, Reason: fmt.Sprintf("box %s is for %s sales", box, term(box.IsLongTerm()))}
+		case box >= BoxG && year < FirstDigitalAssetBoxYear:
+			return nil, &InvalidOperationError{Op: "Form8949", LotName: lot.name, Reason: fmt.Sprintf("box %s is only on Form 8949 from %d", box, FirstDigitalAssetBoxYear)}
+		}
+
+		row := Form8949Row{
+			LotName:     lot.name,
+			Description: fmt.Sprintf("%0.9f %s", details.soldAmount, details.currency),
+			Acquired:    details.originalPurchaseTime,
+			Sold:        details.dateOfSale,
+			Proceeds:    details.proceeds,
+			CostBasis:   details.costBasis,
+		}
+		if options.Adjustment != nil {
+			row.Adjustment = options.Adjustment(lot)
+		} else if !details.washSaleDisallowed.IsZero() {
+			row.Adjustment = Form8949Adjustment{Codes: "W", Amount: details.washSaleDisallowed}
+		}
+		row.Gain = row.Proceeds.Sub(row.CostBasis).Add(row.Adjustment.Amount)
+
+		k := key{year, box}
+		section, ok := sections[k]
+		if !ok {
+			section = &Form8949Section{Year: year, Box: box}
+			sections[k] = section
+		}
+		section.Rows = append(section.Rows, row)
+		section.Total.add(row)
+	}
+
+	result := make([]Form8949Section, 0, len(sections))
+	for _, section := range sections {
+		result = append(result, *section)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		return a.Year < b.Year || a.Year == b.Year && a.Box < b.Box
+	})
+	return result, nil
+}
+
+func term(longTerm bool) string {
+	if longTerm {
+		return "long-term"
+	}
+	return "short-term"
+}
+
+// ScheduleDTotals sums up the sections by tax year and Schedule D line, e.g. totals[2017]["3"].
+// The digital asset boxes share lines with the others, e.g. boxes C and I both go on line 3.
+func ScheduleDTotals(sections []Form8949Section) map[int]map[string]Form8949Total {
+	totals := map[int]map[string]Form8949Total{}
+	for _, section := range sections {
+		lines, ok := totals[section.Year]
+		if !ok {
+			lines = map[string]Form8949Total{}
+			totals[section.Year] = lines
+		}
+		line := section.Box.ScheduleDLine()
+		total := lines[line]
+		total.Proceeds = total.Proceeds.Add(section.Total.Proceeds)
+		total.CostBasis = total.CostBasis.Add(section.Total.CostBasis)
+		total.Adjustment = total.Adjustment.Add(section.Total.Adjustment)
+		total.Gain = total.Gain.Add(section.Total.Gain)
+		lines[line] = total
+	}
+	return totals
+}
+
+// PrintForm8949 prints the sections laid out like Form 8949, with the totals for each Schedule D line at the end.
+func PrintForm8949(sections []Form8949Section) string {
+	b := &bytes.Buffer{}
+	dollars := func(d Decimal) string { return d.StringFixed(2) }
+	for _, section := range sections {
+		part := "Part I (short-term)"
+		if section.Box.IsLongTerm() {
+			part = "Part II (long-term)"
+		}
+		fmt.Fprintf(b, "%d Form 8949 %s, box %s\n", section.Year, part, section.Box)
+		tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "(a) description\t(b) acquired\t(c) sold\t(d) proceeds\t(e) cost basis\t(f) code\t(g) adjustment\t(h) gain or loss")
+		for _, row := range section.Rows {
+			adjustment := ""
+			if !row.Adjustment.Amount.IsZero() {
+				adjustment = dollars(row.Adjustment.Amount)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", row.Description, row.Acquired.Format("01/02/2006"),
+				row.Sold.Format("01/02/2006"), dollars(row.Proceeds), dollars(row.CostBasis), row.Adjustment.Codes,
+				adjustment, dollars(row.Gain))
+		}
+		t := section.Total
+		fmt.Fprintf(tw, "totals\t\t\t%s\t%s\t\t%s\t%s\n", dollars(t.Proceeds), dollars(t.CostBasis), dollars(t.Adjustment), dollars(t.Gain))
+		if err := tw.Flush(); err != nil {
+			panic(err.Error())
+		}
+		fmt.Fprintln(b)
+	}
+
+	totals := ScheduleDTotals(sections)
+	years := make([]int, 0, len(totals))
+	for year := range totals {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	for i, year := range years {
+		if i > 0 {
+			fmt.Fprintln(b)
+		}
+		fmt.Fprintf(b, "%d Schedule D\n", year)
+		tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "line\t(d) proceeds\t(e) cost basis\t(g) adjustments\t(h) gain or loss")
+		for _, line := range []string{"1b", "2", "3", "8b", "9", "10"} {
+			if t, ok := totals[year][line]; ok {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", line, dollars(t.Proceeds), dollars(t.CostBasis), dollars(t.Adjustment), dollars(t.Gain))
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			panic(err.Error())
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package ledger_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// form8949Ledger sells a BTC lot in three parts: short-term in 2017, long-term at a loss in 2018, and long-term in 2025.
+func form8949Ledger(g *WithT) *ledger.Ledger {
+	l := ledger.New(USD, historicalPrices)
+	_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("1000"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("1"), n("1000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2017-12-01"), "1.1", BTC, n("0.25"), n("400"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2018-06-01"), "1.1", BTC, n("0.25"), n("150"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxable(d("2025-03-01"), "1.1", BTC, n("0.03976678"), n("3400.50"))
+	g.Expect(err).NotTo(HaveOccurred())
+	return l
+}
+
+func TestForm8949(t *testing.T) {
+	t.Run("default boxes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := form8949Ledger(g)
+
+		sections, err := l.Form8949(ledger.Form8949Options{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintForm8949(sections)).To(Equal(
+			`2017 Form 8949 Part I (short-term), box C
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+0.250000000 BTC  04/06/2017    12/01/2017  400.00        250.00                                    150.00
+totals                                     400.00        250.00                    0.00            150.00
+
+2018 Form 8949 Part II (long-term), box F
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+0.250000000 BTC  04/06/2017    06/01/2018  150.00        250.00                                    -100.00
+totals                                     150.00        250.00                    0.00            -100.00
+
+2025 Form 8949 Part II (long-term), box L
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+0.039766780 BTC  04/06/2017    03/01/2025  3400.50       39.77                                     3360.73
+totals                                     3400.50       39.77                     0.00            3360.73
+
+2017 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+3     400.00        250.00          0.00             150.00
+
+2018 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+10    150.00        250.00          0.00             -100.00
+
+2025 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+10    3400.50       39.77           0.00             3360.73
+`))
+	})
+
+	t.Run("chosen boxes and adjustments", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := form8949Ledger(g)
+
+		sections, err := l.Form8949(ledger.Form8949Options{
+			Box: func(lot *ledger.Lot) ledger.Form8949Box {
+				details := lot.TaxableGainsDetails()
+				switch {
+				case details.DateOfSale().Year() >= ledger.FirstDigitalAssetBoxYear:
+					return ledger.BoxJ // reported on Form 1099-DA
+				case details.IsLongTerm():
+					return ledger.BoxF
+				}
+				return ledger.BoxB // reported on Form 1099-B, without the basis
+			},
+			Adjustment: func(lot *ledger.Lot) ledger.Form8949Adjustment {
+				if lot.TaxableGainsDetails().Gains().Sign() < 0 {
+					return ledger.Form8949Adjustment{Codes: "W", Amount: n("40")}
+				}
+				return ledger.Form8949Adjustment{}
+			},
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintForm8949(sections)).To(Equal(
+			`2017 Form 8949 Part I (short-term), box B
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+0.250000000 BTC  04/06/2017    12/01/2017  400.00        250.00                                    150.00
+totals                                     400.00        250.00                    0.00            150.00
+
+2018 Form 8949 Part II (long-term), box F
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+0.250000000 BTC  04/06/2017    06/01/2018  150.00        250.00          W         40.00           -60.00
+totals                                     150.00        250.00                    40.00           -60.00
+
+2025 Form 8949 Part II (long-term), box J
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+0.039766780 BTC  04/06/2017    03/01/2025  3400.50       39.77                                     3360.73
+totals                                     3400.50       39.77                     0.00            3360.73
+
+2017 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+2     400.00        250.00          0.00             150.00
+
+2018 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+10    150.00        250.00          40.00            -60.00
+
+2025 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+8b    3400.50       39.77           0.00             3360.73
+`))
+
+		g.Expect(sections).To(HaveLen(3))
+		g.Expect(sections[1].Rows[0].Gain.String()).To(Equal("-60.00"))
+		totals := ledger.ScheduleDTotals(sections)
+		g.Expect(totals[2017]).To(HaveKey("2"))
+		g.Expect(totals[2018]["10"].Adjustment.String()).To(Equal("40"))
+		g.Expect(totals[2025]["8b"].Gain.String()).To(Equal("3360.73"))
+	})
+
+	t.Run("invalid boxes", func(t *testing.T) {
+		for box, msg := range map[ledger.Form8949Box]string{
+			ledger.BoxA: "Form8949: lot 1.1.2: box A is for short-term sales",
+			ledger.BoxJ: "Form8949: lot 1.1.2: box J is only on Form 8949 from 2025",
+			'Z':         `Form8949: lot 1.1.2: "Z" isn't a Form 8949 box`,
+		} {
+			g := NewGomegaWithT(t)
+			l := form8949Ledger(g)
+
+			_, err := l.Form8949(ledger.Form8949Options{Box: func(lot *ledger.Lot) ledger.Form8949Box {
+				if lot.TaxableGainsDetails().IsLongTerm() {
+					return box
+				}
+				return ledger.BoxC
+			}})
+			var opErr *ledger.InvalidOperationError
+			g.Expect(errors.As(err, &opErr)).To(BeTrue(), box.String())
+			g.Expect(err.Error()).To(Equal(msg))
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// Bitfinex report columns.
+const (
+	bitfinexPair        = "PAIR"
+	bitfinexAmount      = "AMOUNT"
+	bitfinexPrice       = "PRICE"
+	bitfinexFee         = "FEE"
+	bitfinexFeeCurrency = "FEE CURRENCY"
+	bitfinexDate        = "DATE"
+	bitfinexCurrencyCol = "CURRENCY"
+	bitfinexStatus      = "STATUS"
+	bitfinexFees        = "FEES"
+	bitfinexDateStarted = "DATE STARTED"
+)
+
+var (
+	bitfinexDateLayouts = []string{"06-01-02 15:04:05", "2006-01-02 15:04:05", "02-01-06 15:04:05", "2006-01-02T15:04:05Z07:00"}
+
+	// BitfinexCurrencies maps Bitfinex's currency symbols to the usual ones, where they differ.
+	BitfinexCurrencies = map[string]ledger.Currency{
+		"DSH": "DASH",
+		"BAB": "BCH",
+		"IOT": "IOTA",
+		"UST": "USDT",
+		"QTM": "QTUM",
+	}
+)
+
+// bitfinexCurrency returns the currency with the given Bitfinex symbol.
+func bitfinexCurrency(symbol string) ledger.Currency {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	if currency, ok := BitfinexCurrencies[symbol]; ok {
+		return currency
+	}
+	return ledger.Currency(symbol)
+}
+
+// bitfinexPairCurrencies splits a trading pair like "BTC/USD", "BTCUSD" or "DUSK:USD" into its currencies.
+func bitfinexPairCurrencies(pair string) (base, quote ledger.Currency, ok bool) {
+	pair = strings.ToUpper(strings.TrimSpace(pair))
+	for _, separator := range []string{"/", ":"} {
+		if parts := strings.Split(pair, separator); len(parts) == 2 {
+			return bitfinexCurrency(parts[0]), bitfinexCurrency(parts[1]), true
+		}
+	}
+	if len(pair) != 6 {
+		return "", "", false
+	}
+	return bitfinexCurrency(pair[:3]), bitfinexCurrency(pair[3:]), true
+}
+
+// ReadBitfinexTrades reads the trades report exported from Bitfinex for the given account, and queues its operations.
+//
+// A positive AMOUNT buys the pair's first currency with the second, and a negative AMOUNT sells it. Trading fees are
+// taken into account: a fee in the currency received reduces the amount received, a fee in the local currency adds
+// to the cost of a purchase, and a fee in the currency sold is removed along with it.
+func (im *Importer) ReadBitfinexTrades(r io.Reader, file string, account ledger.Account) error {
+	t, err := newTable(r, file, headerStartingWith("#"))
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{bitfinexPair, bitfinexAmount, bitfinexPrice, bitfinexDate} {
+		if err := t.require(column); err != nil {
+			return err
+		}
+	}
+
+	return im.addRows(t, func(t *table) (Operation, error) {
+		return im.bitfinexTrade(t, account)
+	})
+}
+
+func (im *Importer) bitfinexTrade(t *table, account ledger.Account) (Operation, error) {
+	pair := t.get(bitfinexPair)
+	op := t.operation("trade " + pair)
+	op.Account = account
+	op.Type = Trade
+
+	var err error
+	if op.Date, err = t.date(bitfinexDate, bitfinexDateLayouts...); err != nil {
+		return op, err
+	}
+	base, quote, ok := bitfinexPairCurrencies(pair)
+	if !ok {
+		return op, t.errorf("unrecognized pair %q", pair)
+	}
+	amount, err := t.decimal(bitfinexAmount)
+	if err != nil {
+		return op, err
+	}
+	price, err := t.decimal(bitfinexPrice)
+	if err != nil {
+		return op, err
+	}
+	fee, err := t.decimal(bitfinexFee)
+	if err != nil {
+		return op, err
+	}
+	if amount.IsZero() || price.Sign() <= 0 {
+		return op, t.errorf("invalid trade of %s %s at %s", amount, base, price)
+	}
+
+	total := amount.Abs().Mul(price).Round(im.ledger.Precision(quote))
+	if amount.Sign() > 0 {
+		op.Sent = Amount{quote, total}
+		op.Received = Amount{base, amount}
+	} else {
+		op.Sent = Amount{base, amount.Abs()}
+		op.Received = Amount{quote, total}
+	}
+	if !fee.IsZero() {
+		feeCurrency := bitfinexCurrency(t.get(bitfinexFeeCurrency))
+		if feeCurrency == "" {
+			return op, t.errorf("missing the fee currency")
+		}
+		// fees are worked out as a percentage, so they can be more precise than the currency
+		op.Fee = Amount{feeCurrency, fee.Abs().Round(im.ledger.Precision(feeCurrency))}
+		if feeCurrency == op.Received.Currency {
+			op.Received.Amount = op.Received.Amount.Sub(op.Fee.Amount)
+		}
+	}
+	return op, nil
+}
+
+// ReadBitfinexMovements reads the deposits and withdrawals report exported from Bitfinex for the given account,
+// and queues its operations. Movements which weren't completed are skipped.
+//
+// Deposits of the local currency are new money, whose cost basis includes any deposit fee. Deposits of other
+// currencies are matched with sends from other accounts, and withdrawals wait in transit for a matching receive.
+func (im *Importer) ReadBitfinexMovements(r io.Reader, file string, account ledger.Account) error {
+	t, err := newTable(r, file, headerStartingWith("#"))
+	if err != nil {
+		return err
+	}
+	for _, column := range [][]string{{bitfinexCurrencyCol}, {bitfinexAmount}, {bitfinexDateStarted, bitfinexDate}} {
+		if err := t.require(column...); err != nil {
+			return err
+		}
+	}
+
+	return im.addRows(t, func(t *table) (Operation, error) {
+		return im.bitfinexMovement(t, account)
+	})
+}
+
+func (im *Importer) bitfinexMovement(t *table, account ledger.Account) (Operation, error) {
+	op := t.operation("")
+	op.Account = account
+
+	var err error
+	dateColumn := bitfinexDateStarted
+	if !t.has(dateColumn) {
+		dateColumn = bitfinexDate
+	}
+	if op.Date, err = t.date(dateColumn, bitfinexDateLayouts...); err != nil {
+		return op, err
+	}
+	currency := bitfinexCurrency(t.get(bitfinexCurrencyCol))
+	amount, err := t.decimal(bitfinexAmount)
+	if err != nil {
+		return op, err
+	}
+	fee, err := t.decimal(bitfinexFees, bitfinexFee)
+	if err != nil {
+		return op, err
+	}
+	if !fee.IsZero() {
+		op.Fee = Amount{currency, fee.Abs()}
+	}
+
+	switch amount.Sign() {
+	case 1:
+		op.RowType = "deposit " + currency.String()
+		op.Type = Deposit
+		op.Received = Amount{currency, amount.Sub(op.Fee.Amount)}
+	case -1:
+		op.RowType = "withdrawal " + currency.String()
+		op.Type = Send
+		op.Sent = Amount{currency, amount.Abs()}
+	default:
+		return op, t.errorf("invalid movement of %s %s", amount, currency)
+	}
+
+	if status := t.get(bitfinexStatus); status != "" && !strings.EqualFold(status, "COMPLETED") {
+		op.Type = Ignore
+		op.SkipReason = fmt.Sprintf("status is %s", status)
+	}
+	return op, nil
+}
@@ -0,0 +1,82 @@
+package importer_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/importer"
+)
+
+const bitfinexMovementsCSV = `#,CURRENCY,STATUS,AMOUNT,FEES,DESTINATION ADDRESS,TRANSACTION ID,DATE STARTED,DATE UPDATED
+1,USD,COMPLETED,1000.00,-40.00,,,17-04-06 10:00:00,17-04-06 10:05:00
+2,BTC,COMPLETED,-0.7,-0.0005,1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2,abc123,17-11-01 12:00:00,17-11-01 12:30:00
+3,BTC,CANCELED,-0.1,-0.0005,1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2,,17-11-01 13:00:00,17-11-01 13:05:00
+`
+
+const bitfinexTradesCSV = `#,PAIR,AMOUNT,PRICE,FEE,FEE PERC,FEE CURRENCY,DATE,ORDER ID
+3,BTC/USD,-0.03,6900,-0.207,0.1%,USD,17-11-01 10:00:00,1003
+2,DSH/BTC,2.0,0.05,-0.004,0.2%,DSH,17-11-01 09:00:00,1002
+1,BTCUSD,0.83976678,1140.00,-1.00,0.1%,USD,17-04-06 11:00:00,1001
+`
+
+func TestBitfinex(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, ledger.NearestPrevious(prices))
+	im := importer.New(l, importer.Options{})
+	g.Expect(im.ReadBitfinexTrades(strings.NewReader(bitfinexTradesCSV), "trades.csv", Bitfinex)).To(Succeed())
+	g.Expect(im.ReadBitfinexMovements(strings.NewReader(bitfinexMovementsCSV), "movements.csv", Bitfinex)).To(Succeed())
+	im.Add(importer.Operation{File: "coinbase.csv", Line: 2, RowType: "Receive", Type: importer.Receive,
+		Date: d("2017-11-02"), Account: Coinbase, Received: importer.Amount{Currency: BTC, Amount: n("0.7")}})
+	report := im.Apply()
+
+	g.Expect(report.String()).To(Equal(
+		`movements.csv:2  2017-04-06  Bitfinex  deposit USD     imported  lots:1
+trades.csv:4     2017-04-06  Bitfinex  trade BTCUSD    imported  lots:1.1
+trades.csv:3     2017-11-01  Bitfinex  trade DSH/BTC   imported  lots:1.1.1,1.1.2
+trades.csv:2     2017-11-01  Bitfinex  trade BTC/USD   imported  lots:1.1.3
+movements.csv:3  2017-11-01  Bitfinex  withdrawal BTC  imported  lots:1.1.4,1.1.4.spendCapitalGains,1.1.4.spendCapitalGains.1  in transit until received in another account
+movements.csv:4  2017-11-01  Bitfinex  withdrawal BTC  skipped                                                                 status is CANCELED
+coinbase.csv:2   2017-11-02  Coinbase  Receive         imported  lots:1.1.4.1                                                  received from Bitfinex (movements.csv:3)
+(imported:6 skipped:1 failed:0)
+`))
+	g.Expect(l.PrintLots()).To(Equal(
+		`1                          2017-04-06 Bitfinex USD 1.670000000   (basis:$1.740000    price:$1.041916)
+1.1                        2017-04-06 Bitfinex BTC 0.009266780   (basis:$11.020000   price:$1189.194089)
+1.1.1                      2017-11-01 Bitfinex DASH 1.996000000  (basis:$676.730000  price:$339.043086)
+1.1.2                      2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.100000000 originally purchased 2017-04-06 for USD 118.870000. proceeds=USD 676.730000, gains=USD 557.860000, note=exchanging BTC for DASH
+1.1.3                      2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.030000000 originally purchased 2017-04-06 for USD 35.660000. proceeds=USD 206.790000, gains=USD 171.130000, note=sold BTC for USD
+1.1.4                      2017-04-06 In Transit BTC 0.000000000  (basis:$0.000000  price:$NaN)
+1.1.4.spendCapitalGains    0001-01-01  BTC 0.000000000            (basis:$0.000000  price:$NaN)
+1.1.4.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.000500000 originally purchased 2017-04-06 for USD 0.590000. proceeds=USD 3.380000, gains=USD 2.790000, note=fee for transferring from Bitfinex to In Transit
+1.1.4.1                    2017-04-06 Coinbase BTC 0.700000000  (basis:$835.500000  price:$1193.571429)
+`))
+}
+
+func TestBitfinexErrors(t *testing.T) {
+	for _, tc := range []struct {
+		trades bool
+		csv    string
+		want   string
+	}{
+		{true, "PAIR,AMOUNT\n", "bitfinex.csv:1: couldn't find the header row"},
+		{true, "#,PAIR,AMOUNT,PRICE\n", `bitfinex.csv:1: missing column "DATE"`},
+		{true, "#,PAIR,AMOUNT,PRICE,DATE\n1,BTCUSDT,1,1000,17-04-06 11:00:00\n", `bitfinex.csv:2: unrecognized pair "BTCUSDT"`},
+		{true, "#,PAIR,AMOUNT,PRICE,DATE\n1,BTCUSD,0,1000,17-04-06 11:00:00\n", "bitfinex.csv:2: invalid trade of 0 BTC at 1000"},
+		{true, "#,PAIR,AMOUNT,PRICE,FEE,DATE\n1,BTCUSD,1,1000,-1,17-04-06 11:00:00\n", "bitfinex.csv:2: missing the fee currency"},
+		{true, "#,PAIR,AMOUNT,PRICE,DATE\n1,BTCUSD,1,1000,April 6th\n", `bitfinex.csv:2: invalid date "April 6th" in column "DATE"`},
+		{false, "#,CURRENCY,AMOUNT\n", `bitfinex.csv:1: missing column "DATE STARTED"`},
+		{false, "#,CURRENCY,AMOUNT,DATE\n1,BTC,0,17-04-06 11:00:00\n", "bitfinex.csv:2: invalid movement of 0 BTC"},
+	} {
+		g := NewGomegaWithT(t)
+		im := importer.New(ledger.New(USD, prices), importer.Options{})
+		read := im.ReadBitfinexMovements
+		if tc.trades {
+			read = im.ReadBitfinexTrades
+		}
+		g.Expect(read(strings.NewReader(tc.csv), "bitfinex.csv", Bitfinex)).To(MatchError(tc.want), tc.csv)
+	}
+}
@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// Coinbase transaction history columns. Older exports name some of them differently, so those have alternatives.
+var (
+	coinbaseTimestamp  = "Timestamp"
+	coinbaseType       = "Transaction Type"
+	coinbaseAsset      = "Asset"
+	coinbaseQuantity   = "Quantity Transacted"
+	coinbasePriceCcy   = []string{"Spot Price Currency", "Price Currency"}
+	coinbasePrice      = []string{"Spot Price at Transaction", "Price at Transaction"}
+	coinbaseSubtotal   = "Subtotal"
+	coinbaseTotal      = []string{"Total (inclusive of fees and/or spread)", "Total (inclusive of fees)", "Total"}
+	coinbaseFees       = []string{"Fees and/or Spread", "Fees"}
+	coinbaseNotes      = "Notes"
+	coinbaseDateLayout = []string{"2006-01-02T15:04:05Z07:00", "2006-01-02 15:04:05 MST", "2006-01-02 15:04:05"}
+
+	// e.g. "Converted 0.1 ETH to 0.00540833 BTC"
+	coinbaseConvertNote = regexp.MustCompile(`(?i)converted\s+([\d.,]+)\s+(\w+)\s+to\s+([\d.,]+)\s+(\w+)`)
+)
+
+// coinbaseIncomeTypes are the transaction types for assets received as income.
+var coinbaseIncomeTypes = map[string]bool{
+	"rewards income":   true,
+	"staking income":   true,
+	"coinbase earn":    true,
+	"learning reward":  true,
+	"inflation reward": true,
+	"interest":         true,
+}
+
+// ReadCoinbase reads Coinbase's transaction history CSV for the given account, and queues its operations.
+//
+// Buys are paid from a linked bank account or card, so each is recorded as new money deposited and spent at once.
+// For that reason Deposit and Withdrawal rows (of the local currency) are skipped. Sells, Converts, Sends and Receives
+// draw from the account's lots using the Importer's selector, and rewards are recorded as income at their value
+// when received. Fees in the local currency are part of the cost of a buy, and have already been deducted from the
+// proceeds of a sell. A Convert's fee is included in its exchange rate.
+func (im *Importer) ReadCoinbase(r io.Reader, file string, account ledger.Account) error {
+	t, err := newTable(r, file, headerStartingWith(coinbaseTimestamp))
+	if err != nil {
+		return err
+	}
+	for _, column := range [][]string{{coinbaseType}, {coinbaseAsset}, {coinbaseQuantity}, coinbaseTotal} {
+		if err := t.require(column...); err != nil {
+			return err
+		}
+	}
+
+	local := im.ledger.LocalCurrency()
+	return im.addRows(t, func(t *table) (Operation, error) {
+		return im.coinbaseOperation(t, account, local)
+	})
+}
+
+func (im *Importer) coinbaseOperation(t *table, account ledger.Account, local ledger.Currency) (Operation, error) {
+	rowType := t.get(coinbaseType)
+	op := t.operation(rowType)
+	op.Account = account
+	op.Note = t.get(coinbaseNotes)
+
+	var err error
+	if op.Date, err = t.date(coinbaseTimestamp, coinbaseDateLayout...); err != nil {
+		return op, err
+	}
+	if ccy := t.get(coinbasePriceCcy...); ccy != "" && ledger.Currency(ccy) != local {
+		return op, t.errorf("prices are in %s, but the ledger's local currency is %s", ccy, local)
+	}
+
+	asset := ledger.Currency(strings.ToUpper(t.get(coinbaseAsset)))
+	numbers := map[string]ledger.Decimal{}
+	for name, columns := range map[string][]string{
+		"quantity": {coinbaseQuantity},
+		"price":    coinbasePrice,
+		"subtotal": {coinbaseSubtotal},
+		"total":    coinbaseTotal,
+		"fees":     coinbaseFees,
+	} {
+		n, err := t.decimal(columns...)
+		if err != nil {
+			return op, err
+		}
+		// newer exports show amounts leaving the account as negative
+		numbers[name] = n.Abs()
+	}
+	quantity, subtotal, total, fees := numbers["quantity"], numbers["subtotal"], numbers["total"], numbers["fees"]
+	if subtotal.IsZero() {
+		subtotal = total.Sub(fees)
+	}
+	localPlaces := im.ledger.Precision(local)
+
+	switch kind := strings.ToLower(rowType); {
+	case kind == "buy" || kind == "advanced trade buy":
+		op.Type = Buy
+		op.NewMoney = true
+		op.Sent = Amount{local, subtotal.Round(localPlaces)}
+		op.Received = Amount{asset, quantity}
+		op.Fee = Amount{local, fees.Round(localPlaces)}
+
+	case kind == "sell" || kind == "advanced trade sell":
+		op.Type = Sell
+		op.Sent = Amount{asset, quantity}
+		op.Received = Amount{local, total.Round(localPlaces)}
+		op.Fee = Amount{local, fees.Round(localPlaces)}
+
+	case kind == "convert":
+		m := coinbaseConvertNote.FindStringSubmatch(op.Note)
+		if m == nil {
+			return op, t.errorf("couldn't find the amount received in the notes %q", op.Note)
+		}
+		received, err := parseNumber(m[3])
+		if err != nil {
+			return op, t.errorf("invalid amount %q in the notes", m[3])
+		}
+		op.Type = Trade
+		op.Sent = Amount{asset, quantity}
+		op.Received = Amount{ledger.Currency(strings.ToUpper(m[4])), received}
+
+	case kind == "send":
+		op.Type = Send
+		op.Sent = Amount{asset, quantity}
+		if !fees.IsZero() {
+			price := numbers["price"]
+			if price.IsZero() {
+				return op, t.errorf("can't convert the fee to %s without a price", asset)
+			}
+			op.Fee = Amount{asset, fees.Quo(price, im.ledger.Precision(asset))}
+		}
+
+	case kind == "receive":
+		op.Type = Receive
+		op.Received = Amount{asset, quantity}
+
+	case coinbaseIncomeTypes[kind]:
+		op.Type = Income
+		op.Received = Amount{asset, quantity}
+		op.Value = subtotal
+		if op.Value.IsZero() {
+			op.Value = quantity.Mul(numbers["price"])
+		}
+		op.Value = op.Value.Round(localPlaces)
+		op.Note = rowType
+
+	case kind == "deposit" || kind == "withdrawal":
+		op.Type = Ignore
+		op.SkipReason = fmt.Sprintf("%s balances aren't tracked, buys are recorded as new money", asset)
+
+	default:
+		op.Type = Ignore
+		op.SkipReason = fmt.Sprintf("unsupported transaction type %q", rowType)
+	}
+	return op, nil
+}
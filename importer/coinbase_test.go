@@ -0,0 +1,80 @@
+package importer_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/importer"
+)
+
+const coinbaseCSV = `You can use this transaction report to inform your likely tax obligations.
+
+Transactions
+User,someone@example.com,abc123
+Timestamp,Transaction Type,Asset,Quantity Transacted,Spot Price Currency,Spot Price at Transaction,Subtotal,Total (inclusive of fees and/or spread),Fees and/or Spread,Notes
+2017-04-06T15:04:05Z,Buy,BTC,0.5,USD,1200.00,600.00,608.99,8.99,Bought 0.5 BTC for $608.99 USD
+2017-04-07T10:00:00Z,Deposit,USD,100,USD,1.00,100.00,100.00,0.00,Deposited 100 USD
+2017-11-01T09:00:00Z,Convert,BTC,0.1,USD,6767.31,676.73,676.73,0.00,Converted 0.1 BTC to 2.3 ETH
+2017-11-01T12:00:00Z,Rewards Income,ETH,0.01,USD,291.69,2.92,2.92,0.00,Received 0.01 ETH from Coinbase Rewards
+2017-11-02T08:30:00Z,Send,BTC,0.1,USD,6960.07,,,,Sent 0.1 BTC to 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2
+2017-11-02T09:00:00Z,Sell,BTC,0.2,USD,6960.07,"1,392.01","1,372.01",20.00,Sold 0.2 BTC for $1372.01 USD
+2017-11-03T00:00:00Z,Bonus,BTC,1,USD,7000,7000,7000,0,Something new
+`
+
+func TestCoinbase(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, ledger.NearestPrevious(prices))
+	im := importer.New(l, importer.Options{})
+	g.Expect(im.ReadCoinbase(strings.NewReader(coinbaseCSV), "coinbase.csv", Coinbase)).To(Succeed())
+	report := im.Apply()
+
+	g.Expect(report.String()).To(Equal(
+		`coinbase.csv:6   2017-04-06  Coinbase  Buy             imported  lots:1,1.1
+coinbase.csv:7   2017-04-07  Coinbase  Deposit         skipped     USD balances aren't tracked, buys are recorded as new money
+coinbase.csv:8   2017-11-01  Coinbase  Convert         imported  lots:1.1.1,1.1.2
+coinbase.csv:9   2017-11-01  Coinbase  Rewards Income  imported  lots:2
+coinbase.csv:10  2017-11-02  Coinbase  Send            imported  lots:1.1.3  in transit until received in another account
+coinbase.csv:11  2017-11-02  Coinbase  Sell            imported  lots:1.1.4
+coinbase.csv:12  2017-11-03  Coinbase  Bonus           skipped     unsupported transaction type "Bonus"
+(imported:5 skipped:2 failed:0)
+`))
+
+	g.Expect(l.PrintLots()).To(Equal(
+		`1      2017-04-06 Coinbase USD 0.000000000  (basis:$0.000000    price:$NaN)
+1.1    2017-04-06 Coinbase BTC 0.100000000  (basis:$121.800000  price:$1218.000000)
+1.1.1  2017-11-01 Coinbase ETH 2.300000000  (basis:$676.730000  price:$294.230435)
+1.1.2  2017-11-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.100000000 originally purchased 2017-04-06 for USD 121.800000. proceeds=USD 676.730000, gains=USD 554.930000, note=exchanging BTC for ETH
+2      2017-11-01 Coinbase ETH 0.010000000    (basis:$2.920000    price:$292.000000)
+1.1.3  2017-04-06 In Transit BTC 0.100000000  (basis:$121.800000  price:$1218.000000)
+1.1.4  2017-11-02 Taxable Gains (short-term) from sale on Coinbase of BTC 0.200000000 originally purchased 2017-04-06 for USD 243.590000. proceeds=USD 1372.010000, gains=USD 1128.420000, note=sold BTC for USD
+`))
+	income, ok := l.TransactionForLot("2")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(income.Note).To(Equal("Rewards Income"))
+	g.Expect(im.InTransit()).To(HaveLen(1))
+}
+
+func TestCoinbaseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		csv, want string
+	}{
+		{"Date,Type\n", "coinbase.csv:1: couldn't find the header row"},
+		{"Timestamp,Transaction Type,Asset\n", `coinbase.csv:1: missing column "Quantity Transacted"`},
+		{"Timestamp,Transaction Type,Asset,Quantity Transacted,Spot Price Currency,Total\n" +
+			"2017-04-06T15:04:05Z,Buy,BTC,abc,USD,1\n", `coinbase.csv:2: invalid number "abc" in column "Quantity Transacted"`},
+		{"Timestamp,Transaction Type,Asset,Quantity Transacted,Spot Price Currency,Total\n" +
+			"yesterday,Buy,BTC,1,USD,1\n", `coinbase.csv:2: invalid date "yesterday" in column "Timestamp"`},
+		{"Timestamp,Transaction Type,Asset,Quantity Transacted,Spot Price Currency,Total\n" +
+			"2017-04-06T15:04:05Z,Buy,BTC,1,EUR,1\n", "coinbase.csv:2: prices are in EUR, but the ledger's local currency is USD"},
+		{"Timestamp,Transaction Type,Asset,Quantity Transacted,Spot Price Currency,Total,Notes\n" +
+			"2017-04-06T15:04:05Z,Convert,BTC,1,USD,1,Converted it all\n", `coinbase.csv:2: couldn't find the amount received in the notes "Converted it all"`},
+	} {
+		g := NewGomegaWithT(t)
+		im := importer.New(ledger.New(USD, prices), importer.Options{})
+		g.Expect(im.ReadCoinbase(strings.NewReader(tc.csv), "coinbase.csv", Coinbase)).To(MatchError(tc.want), tc.csv)
+	}
+}
@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// table reads the rows of a CSV file with a header row, giving access to fields by column name.
+type table struct {
+	file       string
+	reader     *csv.Reader
+	headerLine int
+	columns    map[string]int
+	record     []string
+	line       int
+}
+
+// newTable reads up to and including the header row, which is the first row for which isHeader returns true.
+// Exports sometimes begin with a few lines of preamble, which are skipped.
+func newTable(r io.Reader, file string, isHeader func(record []string) bool) (*table, error) {
+	t := &table{file: file, reader: csv.NewReader(r)}
+	t.reader.FieldsPerRecord = -1
+	t.reader.LazyQuotes = true
+	for {
+		more, err := t.next()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return nil, &ledger.LineError{File: file, Line: t.line, Err: errors.New("couldn't find the header row")}
+		}
+		if isHeader(t.record) {
+			break
+		}
+	}
+	t.headerLine = t.line
+	t.columns = map[string]int{}
+	for i, name := range t.record {
+		t.columns[normalizeColumn(name)] = i
+	}
+	return t, nil
+}
+
+// headerStartingWith returns a function recognizing a header row which starts with the given column.
+func headerStartingWith(column string) func(record []string) bool {
+	return func(record []string) bool {
+		return len(record) > 0 && normalizeColumn(record[0]) == normalizeColumn(column)
+	}
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+// next reads the next row, returning false at the end of the file. Blank rows are skipped.
+func (t *table) next() (bool, error) {
+	for {
+		record, err := t.reader.Read()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return false, &ledger.LineError{File: t.file, Line: parseErr.Line, Err: parseErr.Err}
+			}
+			return false, err
+		}
+		t.record = record
+		t.line, _ = t.reader.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		return true, nil
+	}
+}
+
+// require returns an error unless the header has at least one of the named columns.
+func (t *table) require(names ...string) error {
+	if !t.has(names...) {
+		return &ledger.LineError{File: t.file, Line: t.headerLine, Err: fmt.Errorf("missing column %q", names[0])}
+	}
+	return nil
+}
+
+// has returns true if the header has one of the named columns.
+func (t *table) has(names ...string) bool {
+	_, ok := t.index(names...)
+	return ok
+}
+
+func (t *table) index(names ...string) (int, bool) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if i, ok := t.columns[normalizeColumn(name)]; ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// get returns the current row's field in the first of the named columns in the header, or "" if there's none.
+func (t *table) get(names ...string) string {
+	i, ok := t.index(names...)
+	if !ok || i >= len(t.record) {
+		return ""
+	}
+	return strings.TrimSpace(t.record[i])
+}
+
+// decimal parses the field as a number, ignoring currency symbols and thousands separators.
+// An empty field is zero.
+func (t *table) decimal(names ...string) (ledger.Decimal, error) {
+	s := t.get(names...)
+	if s == "" {
+		return ledger.Decimal{}, nil
+	}
+	d, err := parseNumber(s)
+	if err != nil {
+		return ledger.Decimal{}, t.errorf("invalid number %q in column %q", s, names[0])
+	}
+	return d, nil
+}
+
+// date parses the field using the first layout that fits. Dates without a time zone are taken to be UTC.
+func (t *table) date(name string, layouts ...string) (time.Time, error) {
+	s := t.get(name)
+	for _, layout := range layouts {
+		if date, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return date.UTC(), nil
+		}
+	}
+	return time.Time{}, t.errorf("invalid date %q in column %q", s, name)
+}
+
+// errorf returns a *ledger.LineError for the current row.
+func (t *table) errorf(format string, args ...interface{}) error {
+	return &ledger.LineError{File: t.file, Line: t.line, Err: fmt.Errorf(format, args...)}
+}
+
+// addRows reads the remaining rows of the table into operations, and queues them once they've all been read.
+func (im *Importer) addRows(t *table, read func(t *table) (Operation, error)) error {
+	var operations []Operation
+	for {
+		more, err := t.next()
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+		op, err := read(t)
+		if err != nil {
+			return err
+		}
+		operations = append(operations, op)
+	}
+	im.Add(operations...)
+	return nil
+}
+
+// operation returns a new Operation read from the current row.
+func (t *table) operation(rowType string) Operation {
+	return Operation{File: t.file, Line: t.line, RowType: rowType}
+}
+
+// parseNumber parses a number, ignoring currency symbols and thousands separators, e.g. "$1,234.50".
+func parseNumber(s string) (ledger.Decimal, error) {
+	return ledger.ParseDecimal(strings.NewReplacer("$", "", ",", "", " ", "").Replace(s))
+}
@@ -0,0 +1,461 @@
+// Package importer reads the activity exported by exchanges and wallets, and records it in a ledger.Ledger.
+//
+// Each format is read into Operations, which describe what happened in terms of amounts sent, received and paid in
+// fees. The Importer then applies the operations to the ledger in date order, using a ledger.LotSelector to decide
+// which lots are drawn from, and reports what became of each row.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+type (
+	// Importer applies Operations read from exchange exports to a Ledger.
+	// Operations from several files can be added before applying them, so that sends and receives between
+	// accounts can be matched up regardless of which file they came from.
+	Importer struct {
+		ledger     *ledger.Ledger
+		options    Options
+		operations []Operation
+		// inTransit are the sends waiting for a matching receive, oldest first
+		inTransit []transit
+	}
+
+	// Options configure an Importer.
+	Options struct {
+		// Selector decides which lots are drawn from when selling, trading or sending. Defaults to ledger.FIFO.
+		Selector ledger.LotSelector
+		// TransitAccount holds amounts sent from an account until a matching receive shows up in another account.
+		// Defaults to "In Transit".
+		TransitAccount ledger.Account
+	}
+
+	// Operation is something that happened in an account, as read from one row (or a few related rows) of an export.
+	Operation struct {
+		// File and Line locate the row the operation was read from.
+		File string
+		Line int
+		// RowType is the type of activity as it appears in the file, e.g. "Rewards Income".
+		RowType string
+
+		Type    OperationType
+		Date    time.Time
+		Account ledger.Account
+		// Sent is the amount leaving the account, not including any fee.
+		Sent Amount
+		// Received is the amount arriving in the account, after any fee charged in that currency.
+		Received Amount
+		// Fee is the fee charged. A fee in the local currency adds to the cost of a purchase or deposit,
+		// and has already been deducted from the proceeds of a sale. A fee in the sent currency is removed from
+		// the account along with the amount sent.
+		Fee Amount
+		// Value is the value of the amount received in the local currency, for Income.
+		// If it's zero, it's looked up in the ledger's historical prices.
+		Value ledger.Decimal
+		// NewMoney means a purchase was paid from outside the ledger (e.g. with a bank card),
+		// so its cost is recorded as new money deposited into the account.
+		NewMoney bool
+		// ToAccount is where a Send went, if known. Otherwise the amount waits in transit for a matching Receive.
+		ToAccount ledger.Account
+		Note      string
+		// SkipReason explains why a row has no effect on the ledger. The operation is reported as skipped.
+		SkipReason string
+
+		// from are the lots drawn from when the file names them (e.g. Beancount lots), instead of Options.Selector.
+		from lotRefs
+		// to is given the names of the lots the operation creates, so later operations can draw from them.
+		to *lotRef
+	}
+
+	// Amount is an amount of some currency.
+	Amount struct {
+		Currency ledger.Currency
+		Amount   ledger.Decimal
+	}
+
+	// OperationType is the kind of activity an Operation records.
+	OperationType int
+
+	// lotRef is a lot named in a file, and the ledger lots it became once its operation was applied.
+	lotRef struct {
+		names []string
+	}
+
+	// lotRefs selects the ledger lots which the lots named in a file became, in the order they're named.
+	lotRefs []*lotRef
+
+	// transit is an amount sent, waiting for a matching receive
+	transit struct {
+		operation Operation
+		amount    ledger.Decimal
+		lotNames  []string
+	}
+)
+
+const (
+	// Deposit is local currency deposited from outside the ledger, e.g. a wire transfer.
+	// Deposits of other currencies are treated as a Receive.
+	Deposit OperationType = iota
+	// Buy is an exchange of local currency for some other currency.
+	Buy
+	// Sell is an exchange of some currency for local currency.
+	Sell
+	// Trade is an exchange of one currency for another. It's recorded as a Buy or Sell if either is the local currency.
+	Trade
+	// Send is an amount withdrawn from the account.
+	Send
+	// Receive is an amount arriving in the account, matched with an earlier Send from another account.
+	Receive
+	// Income is an amount received as income, e.g. staking rewards.
+	Income
+	// Ignore is a row with no effect on the ledger, see Operation.SkipReason.
+	Ignore
+)
+
+var operationTypeNames = map[OperationType]string{
+	Deposit: "deposit",
+	Buy:     "buy",
+	Sell:    "sell",
+	Trade:   "trade",
+	Send:    "send",
+	Receive: "receive",
+	Income:  "income",
+	Ignore:  "ignore",
+}
+
+// String returns the name of the operation type, e.g. "buy".
+func (t OperationType) String() string {
+	if name, ok := operationTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseOperationType returns the operation type with the given name, see OperationType.String.
+func ParseOperationType(name string) (OperationType, error) {
+	for t, n := range operationTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown operation type %q", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t OperationType) MarshalText() ([]byte, error) {
+	if _, ok := operationTypeNames[t]; !ok {
+		return nil, fmt.Errorf("unknown operation type %d", t)
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so operation types can be given by name in configuration files.
+func (t *OperationType) UnmarshalText(text []byte) error {
+	parsed, err := ParseOperationType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// IsZero returns true if there's no amount.
+func (a Amount) IsZero() bool {
+	return a.Amount.IsZero()
+}
+
+// String returns the amount, e.g. "0.5 BTC".
+func (a Amount) String() string {
+	return fmt.Sprintf("%s %s", a.Amount, a.Currency)
+}
+
+// New creates an Importer which records activity in the given ledger.
+func New(l *ledger.Ledger, options Options) *Importer {
+	if options.Selector == nil {
+		options.Selector = ledger.FIFO
+	}
+	if options.TransitAccount == "" {
+		options.TransitAccount = "In Transit"
+	}
+	return &Importer{ledger: l, options: options}
+}
+
+// Add queues operations to be applied.
+func (im *Importer) Add(operations ...Operation) {
+	im.operations = append(im.operations, operations...)
+}
+
+// Apply records the queued operations in the ledger, in date order, and reports what became of each.
+// An operation which fails is reported and leaves the ledger unchanged; the rest are still applied.
+func (im *Importer) Apply() *Report {
+	operations := im.operations
+	im.operations = nil
+	sort.SliceStable(operations, func(i, j int) bool { return operations[i].Date.Before(operations[j].Date) })
+
+	report := &Report{}
+	for _, op := range operations {
+		report.Results = append(report.Results, im.apply(op))
+	}
+	return report
+}
+
+// InTransit returns the sends still waiting for a matching receive.
+func (im *Importer) InTransit() []Operation {
+	operations := make([]Operation, len(im.inTransit))
+	for i, t := range im.inTransit {
+		operations[i] = t.operation
+	}
+	return operations
+}
+
+func (im *Importer) apply(op Operation) Result {
+	if op.Type == Ignore || op.SkipReason != "" {
+		return Result{Operation: op, Status: Skipped, Message: op.SkipReason}
+	}
+
+	numTransactions := im.ledger.NumTransactions()
+	message, err := im.dispatch(op)
+	result := Result{Operation: op, Status: Imported, Message: message}
+	if err != nil {
+		var skip skipError
+		if errors.As(err, &skip) {
+			result.Status = Skipped
+		} else {
+			result.Status = Failed
+		}
+		result.Message = err.Error()
+	}
+	for _, tx := range im.ledger.TransactionsFrom(numTransactions) {
+		result.LotNames = append(result.LotNames, tx.LotNames...)
+	}
+	if op.to != nil && result.Status == Imported {
+		op.to.names = im.createdLots(op, result.LotNames)
+	}
+	return result
+}
+
+// createdLots returns the lots holding what the operation received (or sent to another account), out of the lots
+// created for it.
+func (im *Importer) createdLots(op Operation, lotNames []string) []string {
+	currency, account := op.Received.Currency, op.Account
+	if op.Type == Send {
+		currency, account = op.Sent.Currency, op.ToAccount
+	}
+	var names []string
+	for _, name := range lotNames {
+		lot, err := im.ledger.FindLotByName(name, currency)
+		if err == nil && lot.Type() != ledger.TaxableGains && lot.Account() == account {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// selector returns the LotSelector for the operation.
+func (im *Importer) selector(op Operation) ledger.LotSelector {
+	if op.from != nil {
+		return op.from
+	}
+	return im.options.Selector
+}
+
+// SelectLots implements ledger.LotSelector.
+func (refs lotRefs) SelectLots(candidates []*ledger.Lot) ([]*ledger.Lot, error) {
+	var lots []*ledger.Lot
+	for _, ref := range refs {
+		for _, name := range ref.names {
+			for _, lot := range candidates {
+				if lot.Name() == name {
+					lots = append(lots, lot)
+				}
+			}
+		}
+	}
+	return lots, nil
+}
+
+// skipError explains why an operation was skipped rather than failing.
+type skipError string
+
+func (e skipError) Error() string { return string(e) }
+
+// dispatch records the operation using the corresponding Ledger operations.
+// Operations made of several Ledger operations (e.g. a deposit followed by a purchase) aren't atomic,
+// so each step validates what it can before changing anything.
+func (im *Importer) dispatch(op Operation) (string, error) {
+	local := im.ledger.LocalCurrency()
+	switch op.Type {
+	case Deposit:
+		if op.Received.Currency != local {
+			op.Type = Receive
+			return im.dispatch(op)
+		}
+		if err := im.checkFee(op, local); err != nil {
+			return "", err
+		}
+		basis := op.Received.Amount
+		if op.Fee.Currency == local {
+			basis = basis.Add(op.Fee.Amount)
+		}
+		_, err := im.ledger.DepositNewMoney(op.Date, op.Account, op.Received.Amount, basis)
+		return "", err
+
+	case Buy, Sell, Trade:
+		switch {
+		case op.Sent.Currency == local:
+			return im.buy(op)
+		case op.Received.Currency == local:
+			return im.sell(op)
+		default:
+			return im.trade(op)
+		}
+
+	case Send:
+		return im.send(op)
+
+	case Receive:
+		return im.receive(op)
+
+	case Income:
+		value := op.Value
+		if value.IsZero() {
+			var err error
+			if value, err = im.ledger.Value(op.Received.Currency, op.Received.Amount, op.Date); err != nil {
+				return "", err
+			}
+		}
+		note := op.Note
+		if note == "" {
+			note = op.RowType
+		}
+		_, err := im.ledger.Income(op.Date, op.Account, op.Received.Currency, op.Received.Amount, value, note)
+		return "", err
+	}
+	return "", fmt.Errorf("unsupported operation type %s", op.Type)
+}
+
+func (im *Importer) buy(op Operation) (string, error) {
+	local := im.ledger.LocalCurrency()
+	if err := im.checkFee(op, local, op.Received.Currency); err != nil {
+		return "", err
+	}
+	cost := op.Sent.Amount
+	if op.Fee.Currency == local {
+		cost = cost.Add(op.Fee.Amount)
+	}
+	if !op.NewMoney {
+		_, err := im.ledger.PurchaseFromAccount(op.Date, op.Account, im.selector(op), op.Received.Currency, op.Received.Amount, cost)
+		return "", err
+	}
+	_, err := im.ledger.PurchaseWithNewMoney(op.Date, op.Account, op.Received.Currency, op.Received.Amount, cost)
+	return "", err
+}
+
+func (im *Importer) sell(op Operation) (string, error) {
+	local := im.ledger.LocalCurrency()
+	if err := im.checkFee(op, local, op.Sent.Currency); err != nil {
+		return "", err
+	}
+	sold := op.Sent.Amount
+	if op.Fee.Currency == op.Sent.Currency {
+		sold = sold.Add(op.Fee.Amount)
+	}
+	_, err := im.ledger.SellTaxableFromAccount(op.Date, op.Account, im.selector(op), op.Sent.Currency, sold, op.Received.Amount)
+	return "", err
+}
+
+func (im *Importer) trade(op Operation) (string, error) {
+	if err := im.checkFee(op, op.Sent.Currency, op.Received.Currency); err != nil {
+		return "", err
+	}
+	var (
+		sold = op.Sent.Amount
+		fee  ledger.Decimal
+	)
+	if op.Fee.Currency == op.Sent.Currency {
+		sold = sold.Add(op.Fee.Amount)
+		fee = op.Fee.Amount
+	}
+	exchange := func(lookupSoldPrice bool) error {
+		_, err := im.ledger.ExchangeTaxableFromAccount(op.Date, op.Account, im.selector(op),
+			op.Sent.Currency, sold, fee, lookupSoldPrice, op.Received.Currency, op.Received.Amount)
+		return err
+	}
+	// value the trade with the price of the currency sold, or failing that, the currency received
+	err := exchange(true)
+	var missingPrice *ledger.MissingPriceError
+	if errors.As(err, &missingPrice) {
+		if err := exchange(false); err == nil {
+			return fmt.Sprintf("valued with the price of %s", op.Received.Currency), nil
+		}
+	}
+	return "", err
+}
+
+func (im *Importer) send(op Operation) (string, error) {
+	if err := im.checkFee(op, op.Sent.Currency); err != nil {
+		return "", err
+	}
+	var (
+		removed = op.Sent.Amount
+		fee     ledger.Decimal
+	)
+	if !op.Fee.IsZero() {
+		removed = removed.Add(op.Fee.Amount)
+		fee = op.Fee.Amount
+	}
+	if op.ToAccount != "" {
+		_, err := im.ledger.TransferFromAccount(op.Date, op.Account, im.selector(op), op.Sent.Currency, removed, fee, op.ToAccount)
+		return "", err
+	}
+
+	lots, err := im.ledger.TransferFromAccount(op.Date, op.Account, im.selector(op), op.Sent.Currency, removed, fee, im.options.TransitAccount)
+	if err != nil {
+		return "", err
+	}
+	t := transit{operation: op, amount: op.Sent.Amount}
+	for _, lot := range lots {
+		t.lotNames = append(t.lotNames, lot.Name())
+	}
+	im.inTransit = append(im.inTransit, t)
+	return "in transit until received in another account", nil
+}
+
+// receive matches the operation with the oldest send of at least the amount received, from another account.
+// Any difference between the amounts sent and received is treated as a fee for the transfer.
+func (im *Importer) receive(op Operation) (string, error) {
+	for i, t := range im.inTransit {
+		sent := t.operation
+		if sent.Account == op.Account || t.amount.Cmp(op.Received.Amount) < 0 ||
+			sent.Sent.Currency != op.Received.Currency || sent.Date.After(op.Date) {
+			continue
+		}
+		fee := t.amount.Sub(op.Received.Amount)
+		_, err := im.ledger.TransferFromAccount(op.Date, im.options.TransitAccount, ledger.SpecificLots(t.lotNames...),
+			op.Received.Currency, t.amount, fee, op.Account)
+		if err != nil {
+			return "", err
+		}
+		im.inTransit = append(im.inTransit[:i:i], im.inTransit[i+1:]...)
+		return fmt.Sprintf("received from %s (%s:%d)", sent.Account, sent.File, sent.Line), nil
+	}
+	return "", skipError(fmt.Sprintf("no matching send of %s from another account", op.Received))
+}
+
+// checkFee verifies the fee is in one of the given currencies, if there's a fee at all.
+func (im *Importer) checkFee(op Operation, currencies ...ledger.Currency) error {
+	if op.Fee.IsZero() {
+		return nil
+	}
+	for _, currency := range currencies {
+		if op.Fee.Currency == currency {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported fee of %s for a %s of %s", op.Fee, op.Type, op.Sent.Currency)
+}
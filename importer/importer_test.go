@@ -0,0 +1,100 @@
+package importer_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/importer"
+)
+
+const (
+	USD = ledger.Currency("USD")
+
+	BTC = ledger.Currency("BTC")
+	ETH = ledger.Currency("ETH")
+
+	Bitfinex = ledger.Account("Bitfinex")
+	Coinbase = ledger.Account("Coinbase")
+	Kraken   = ledger.Account("Kraken")
+)
+
+var prices = ledger.PriceMap{
+	BTC: {
+		d("2017-11-01"): n("6767.31"),
+		d("2017-11-02"): n("6960.07"),
+		d("2017-12-01"): n("10975.60"),
+	},
+	ETH: {
+		d("2017-11-01"): n("292.00"),
+	},
+}
+
+func TestTransfersBetweenAccounts(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, ledger.NearestPrevious(prices))
+	im := importer.New(l, importer.Options{})
+	im.Add(
+		// the receive comes from another file, but is applied in date order
+		importer.Operation{File: "coinbase.csv", Line: 2, RowType: "Receive", Type: importer.Receive,
+			Date: d("2017-11-02"), Account: Coinbase, Received: importer.Amount{Currency: BTC, Amount: n("0.0999")}},
+		importer.Operation{File: "bitfinex.csv", Line: 2, RowType: "deposit", Type: importer.Deposit,
+			Date: d("2017-04-06"), Account: Bitfinex, Received: importer.Amount{Currency: USD, Amount: n("1000")},
+			Fee: importer.Amount{Currency: USD, Amount: n("15")}},
+		importer.Operation{File: "bitfinex.csv", Line: 3, RowType: "trade", Type: importer.Trade,
+			Date: d("2017-04-06"), Account: Bitfinex, Sent: importer.Amount{Currency: USD, Amount: n("1000")},
+			Received: importer.Amount{Currency: BTC, Amount: n("1")}},
+		importer.Operation{File: "bitfinex.csv", Line: 4, RowType: "withdrawal", Type: importer.Send,
+			Date: d("2017-11-01"), Account: Bitfinex, Sent: importer.Amount{Currency: BTC, Amount: n("0.1")},
+			Fee: importer.Amount{Currency: BTC, Amount: n("0.0004")}},
+		importer.Operation{File: "coinbase.csv", Line: 3, RowType: "Receive", Type: importer.Receive,
+			Date: d("2017-11-03"), Account: Coinbase, Received: importer.Amount{Currency: BTC, Amount: n("1")}},
+		importer.Operation{File: "bitfinex.csv", Line: 5, RowType: "trade", Type: importer.Trade,
+			Date: d("2017-12-01"), Account: Bitfinex, Sent: importer.Amount{Currency: BTC, Amount: n("5")},
+			Received: importer.Amount{Currency: USD, Amount: n("50000")}},
+	)
+	report := im.Apply()
+
+	g.Expect(report.String()).To(Equal(
+		`bitfinex.csv:2  2017-04-06  Bitfinex  deposit     imported  lots:1
+bitfinex.csv:3  2017-04-06  Bitfinex  trade       imported  lots:1.1
+bitfinex.csv:4  2017-11-01  Bitfinex  withdrawal  imported  lots:1.1.1,1.1.1.spendCapitalGains,1.1.1.spendCapitalGains.1        in transit until received in another account
+coinbase.csv:2  2017-11-02  Coinbase  Receive     imported  lots:1.1.1.1,1.1.1.1.spendCapitalGains,1.1.1.1.spendCapitalGains.1  received from Bitfinex (bitfinex.csv:4)
+coinbase.csv:3  2017-11-03  Coinbase  Receive     skipped                                                                       no matching send of 1 BTC from another account
+bitfinex.csv:5  2017-12-01  Bitfinex  trade       failed                                                                        insufficient BTC: requested 5, available 0.8996
+(imported:4 skipped:1 failed:1)
+`))
+	g.Expect(im.InTransit()).To(BeEmpty())
+
+	summary := l.AccountSummary()
+	g.Expect(summary[Coinbase][BTC].Balance.String()).To(Equal("0.0999"))
+	g.Expect(summary[Bitfinex][BTC].Balance.String()).To(Equal("0.8996"))
+	g.Expect(summary).NotTo(HaveKey(ledger.Account("In Transit")))
+}
+
+func TestOperationTypes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, name := range []string{"deposit", "buy", "sell", "trade", "send", "receive", "income", "ignore"} {
+		opType, err := importer.ParseOperationType(name)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(opType.String()).To(Equal(name))
+	}
+	_, err := importer.ParseOperationType("steal")
+	g.Expect(err).To(MatchError(`unknown operation type "steal"`))
+}
+
+func n(s string) ledger.Decimal {
+	return ledger.MustParseDecimal(s)
+}
+
+func d(date string) time.Time {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
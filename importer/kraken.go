@@ -0,0 +1,220 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// Kraken ledger columns.
+const (
+	krakenTxID    = "txid"
+	krakenRefID   = "refid"
+	krakenTime    = "time"
+	krakenType    = "type"
+	krakenSubtype = "subtype"
+	krakenAsset   = "asset"
+	krakenAmount  = "amount"
+	krakenFee     = "fee"
+)
+
+var (
+	krakenDateLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05Z07:00"}
+
+	// KrakenCurrencies maps Kraken's asset codes to the usual ones, where they differ.
+	// Suffixes marking staked or opt-in rewards balances (e.g. "DOT.S") are removed beforehand.
+	KrakenCurrencies = map[string]ledger.Currency{
+		"XXBT": "BTC", "XBT": "BTC", "XXDG": "DOGE", "XDG": "DOGE", "ETH2": "ETH",
+		"XETC": "ETC", "XETH": "ETH", "XLTC": "LTC", "XMLN": "MLN", "XREP": "REP",
+		"XXLM": "XLM", "XXMR": "XMR", "XXRP": "XRP", "XZEC": "ZEC",
+		"ZAUD": "AUD", "ZCAD": "CAD", "ZEUR": "EUR", "ZGBP": "GBP", "ZJPY": "JPY", "ZUSD": "USD",
+	}
+)
+
+// krakenCurrency returns the currency with the given Kraken asset code.
+func krakenCurrency(asset string) ledger.Currency {
+	asset = strings.ToUpper(strings.TrimSpace(asset))
+	for _, suffix := range []string{".S", ".M", ".F", ".B", ".P"} {
+		asset = strings.TrimSuffix(asset, suffix)
+	}
+	if currency, ok := KrakenCurrencies[asset]; ok {
+		return currency
+	}
+	return ledger.Currency(asset)
+}
+
+// krakenRow is a row of a Kraken ledger, which is only one side of a trade.
+type krakenRow struct {
+	line          int
+	txID, refID   string
+	date          time.Time
+	kind, subtype string
+	currency      ledger.Currency
+	amount, fee   ledger.Decimal
+}
+
+// ReadKrakenLedger reads the ledgers.csv exported from Kraken for the given account, and queues its operations.
+//
+// Each trade appears as two rows with the same refid, one for each currency, which are combined into one operation.
+// Fees are charged in either currency: a fee in the currency received reduces the amount received, and a fee in the
+// currency sent is removed along with it. Staking rewards are income, and moves between Kraken's spot and staking
+// balances are skipped, since they're the same currency in the same account. Rows without a txid haven't been
+// confirmed yet (Kraken lists each deposit twice), and are skipped too.
+func (im *Importer) ReadKrakenLedger(r io.Reader, file string, account ledger.Account) error {
+	t, err := newTable(r, file, func(record []string) bool {
+		return len(record) > 1 && normalizeColumn(record[0]) == krakenTxID && normalizeColumn(record[1]) == krakenRefID
+	})
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{krakenTime, krakenType, krakenAsset, krakenAmount} {
+		if err := t.require(column); err != nil {
+			return err
+		}
+	}
+
+	var rows []krakenRow
+	byRefID := map[string][]int{}
+	for {
+		more, err := t.next()
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+		row, err := im.krakenRow(t)
+		if err != nil {
+			return err
+		}
+		if row.txID != "" && krakenIsTrade(row.kind) {
+			byRefID[row.refID] = append(byRefID[row.refID], len(rows))
+		}
+		rows = append(rows, row)
+	}
+
+	var operations []Operation
+	for i, row := range rows {
+		op := Operation{File: file, Line: row.line, RowType: row.kind, Date: row.date, Account: account}
+		if row.subtype != "" {
+			op.RowType += " " + row.subtype
+		}
+		switch {
+		case row.txID == "":
+			op.Type = Ignore
+			op.SkipReason = "not confirmed yet"
+
+		case krakenIsTrade(row.kind):
+			group := byRefID[row.refID]
+			if group[0] != i {
+				// already part of the operation for the group's first row
+				continue
+			}
+			if len(group) != 2 {
+				return &ledger.LineError{File: file, Line: row.line,
+					Err: fmt.Errorf("trade %s has %d rows, expected 2", row.refID, len(group))}
+			}
+			if err := krakenTrade(&op, rows[group[0]], rows[group[1]]); err != nil {
+				return err
+			}
+
+		default:
+			krakenMovement(&op, row)
+		}
+		operations = append(operations, op)
+	}
+	im.Add(operations...)
+	return nil
+}
+
+func (im *Importer) krakenRow(t *table) (krakenRow, error) {
+	row := krakenRow{
+		line:     t.line,
+		txID:     t.get(krakenTxID),
+		refID:    t.get(krakenRefID),
+		kind:     strings.ToLower(t.get(krakenType)),
+		subtype:  strings.ToLower(t.get(krakenSubtype)),
+		currency: krakenCurrency(t.get(krakenAsset)),
+	}
+	var err error
+	if row.date, err = t.date(krakenTime, krakenDateLayouts...); err != nil {
+		return row, err
+	}
+	if row.amount, err = t.decimal(krakenAmount); err != nil {
+		return row, err
+	}
+	if row.fee, err = t.decimal(krakenFee); err != nil {
+		return row, err
+	}
+	// fiat amounts are given to 4 decimal places
+	places := im.ledger.Precision(row.currency)
+	row.amount, row.fee = row.amount.Round(places), row.fee.Abs().Round(places)
+	return row, nil
+}
+
+// krakenIsTrade returns true for the types of rows which are one side of a trade.
+func krakenIsTrade(kind string) bool {
+	return kind == "trade" || kind == "spend" || kind == "receive"
+}
+
+// krakenTrade sets up op to exchange the currency of one row for the currency of the other. At most one of the rows
+// can have a fee.
+func krakenTrade(op *Operation, a, b krakenRow) error {
+	sent, received := a, b
+	if sent.amount.Sign() > 0 {
+		sent, received = b, a
+	}
+	if sent.amount.Sign() >= 0 || received.amount.Sign() <= 0 {
+		return &ledger.LineError{File: op.File, Line: op.Line,
+			Err: fmt.Errorf("trade %s doesn't both send and receive an amount", a.refID)}
+	}
+	if !sent.fee.IsZero() && !received.fee.IsZero() {
+		// an operation has only one fee, so one of them would be lost
+		return &ledger.LineError{File: op.File, Line: op.Line,
+			Err: fmt.Errorf("trade %s has a fee on both the sent and the received amount", a.refID)}
+	}
+	op.Type = Trade
+	op.Sent = Amount{sent.currency, sent.amount.Abs()}
+	op.Received = Amount{received.currency, received.amount.Sub(received.fee)}
+	if !received.fee.IsZero() {
+		op.Fee = Amount{received.currency, received.fee}
+	}
+	if !sent.fee.IsZero() {
+		op.Fee = Amount{sent.currency, sent.fee}
+	}
+	return nil
+}
+
+// krakenMovement sets up op for a row which isn't part of a trade.
+func krakenMovement(op *Operation, row krakenRow) {
+	if !row.fee.IsZero() {
+		op.Fee = Amount{row.currency, row.fee}
+	}
+	switch {
+	case row.kind == "deposit" && row.amount.Sign() > 0:
+		op.Type = Deposit
+		op.Received = Amount{row.currency, row.amount.Sub(row.fee)}
+
+	case row.kind == "withdrawal" && row.amount.Sign() < 0:
+		op.Type = Send
+		op.Sent = Amount{row.currency, row.amount.Abs()}
+
+	case row.kind == "staking" && row.amount.Sign() > 0,
+		row.kind == "earn" && row.subtype == "reward" && row.amount.Sign() > 0:
+		op.Type = Income
+		op.Received = Amount{row.currency, row.amount.Sub(row.fee)}
+		op.Fee = Amount{}
+
+	case row.kind == "transfer" && (strings.Contains(row.subtype, "spot") || strings.Contains(row.subtype, "staking")),
+		row.kind == "earn" && row.subtype != "reward":
+		op.Type = Ignore
+		op.SkipReason = "moved between Kraken's spot and staking balances"
+
+	default:
+		op.Type = Ignore
+		op.SkipReason = fmt.Sprintf("unsupported ledger entry type %q", op.RowType)
+	}
+}
@@ -0,0 +1,86 @@
+package importer_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/importer"
+)
+
+const krakenLedgerCSV = `"txid","refid","time","type","subtype","aclass","asset","amount","fee","balance"
+"","QCCBDAG-7ENDR-TPAL3H","2017-11-01 08:00:00","deposit","","currency","ZUSD",1000.0000,0.0000,""
+"LJ2DYC-PBGSP-7UPF4N","QCCBDAG-7ENDR-TPAL3H","2017-11-01 08:10:00","deposit","","currency","ZUSD",1000.0000,0.0000,1000.0000
+"L4UESK-KG3EQ-UFO4T5","TJKLXX-PESRG-CHBAUB","2017-11-01 09:00:00","trade","","currency","ZUSD",-500.0000,1.3000,498.7000
+"LKZ3KS-5NJYM-YMHEJO","TJKLXX-PESRG-CHBAUB","2017-11-01 09:00:00","trade","","currency","XXBT",0.0700000000,0.0000000000,0.0700000000
+"LWWT6N-XBOB4-3Q4GYB","TKH2SE-M7IF5-CFI7LT","2017-11-02 10:00:00","trade","","currency","XETH",0.2300000000,0.0004000000,0.2296000000
+"LAMXMN-F2DZA-6MROV2","TKH2SE-M7IF5-CFI7LT","2017-11-02 10:00:00","trade","","currency","XXBT",-0.0100000000,0.0000000000,0.0600000000
+"LFAX5J-QMDKN-5D6VB2","RUSB7W6-BHYVU-C6W5JZ","2017-11-03 11:00:00","transfer","spottostaking","currency","XETH",-0.1000000000,0.0000000000,0.1296000000
+"LGOIGO-5KNBW-KQGJNB","RUSB7W6-BHYVU-C6W5JZ","2017-11-03 11:00:05","transfer","stakingfromspot","currency","ETH2.S",0.1000000000,0.0000000000,0.1000000000
+"LOYKNF-X5LQR-2HQC2F","STHFSYV-RRAKH-XK6VLY","2017-11-05 00:00:00","staking","","currency","ETH2.S",0.0010000000,0.0000000000,0.1010000000
+"LSRFA6-O7KXQ-QXLTOY","AUU3OXG-6KM3C-NSKR2L","2017-11-06 12:00:00","withdrawal","","currency","XXBT",-0.0500000000,0.0005000000,0.0095000000
+"LCHTJX-5B2RZ-QKMMWS","MG5RTAF-SQBHN-ZJ2XKA","2017-11-07 00:00:00","rollover","","currency","ZUSD",0.0000,0.0200,498.6800
+`
+
+func TestKraken(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, ledger.NearestPrevious(prices))
+	im := importer.New(l, importer.Options{})
+	g.Expect(im.ReadKrakenLedger(strings.NewReader(krakenLedgerCSV), "ledgers.csv", Kraken)).To(Succeed())
+	im.Add(importer.Operation{File: "coinbase.csv", Line: 2, RowType: "Receive", Type: importer.Receive,
+		Date: d("2017-11-07"), Account: Coinbase, Received: importer.Amount{Currency: BTC, Amount: n("0.05")}})
+	report := im.Apply()
+
+	g.Expect(report.String()).To(Equal(
+		`ledgers.csv:2   2017-11-01  Kraken    deposit                   skipped     not confirmed yet
+ledgers.csv:3   2017-11-01  Kraken    deposit                   imported  lots:1
+ledgers.csv:4   2017-11-01  Kraken    trade                     imported  lots:1.1
+ledgers.csv:6   2017-11-02  Kraken    trade                     imported  lots:1.1.1,1.1.2
+ledgers.csv:8   2017-11-03  Kraken    transfer spottostaking    skipped     moved between Kraken's spot and staking balances
+ledgers.csv:9   2017-11-03  Kraken    transfer stakingfromspot  skipped     moved between Kraken's spot and staking balances
+ledgers.csv:10  2017-11-05  Kraken    staking                   imported  lots:2
+ledgers.csv:11  2017-11-06  Kraken    withdrawal                imported  lots:1.1.3,1.1.3.spendCapitalGains,1.1.3.spendCapitalGains.1  in transit until received in another account
+ledgers.csv:12  2017-11-07  Kraken    rollover                  skipped                                                                 unsupported ledger entry type "rollover"
+coinbase.csv:2  2017-11-07  Coinbase  Receive                   imported  lots:1.1.3.1                                                  received from Kraken (ledgers.csv:11)
+(imported:6 skipped:4 failed:0)
+`))
+	g.Expect(l.PrintLots()).To(Equal(
+		`1                          2017-11-01 Kraken USD 498.700000000  (basis:$498.700000  price:$1.000000)
+1.1                        2017-11-01 Kraken BTC 0.009500000    (basis:$68.030000   price:$7161.052632)
+1.1.1                      2017-11-02 Kraken ETH 0.229600000    (basis:$69.600000   price:$303.135889)
+1.1.2                      2017-11-02 Taxable Gains (short-term) from sale on Kraken of BTC 0.010000000 originally purchased 2017-11-01 for USD 71.610000. proceeds=USD 69.600000, gains=USD -2.010000, note=exchanging BTC for ETH
+2                          2017-11-05 Kraken ETH 0.001000000      (basis:$0.290000  price:$290.000000)
+1.1.3                      2017-11-01 In Transit BTC 0.000000000  (basis:$0.000000  price:$NaN)
+1.1.3.spendCapitalGains    0001-01-01  BTC 0.000000000            (basis:$0.000000  price:$NaN)
+1.1.3.spendCapitalGains.1  2017-11-06 Taxable Gains (short-term) from sale on Kraken of BTC 0.000500000 originally purchased 2017-11-01 for USD 3.580000. proceeds=USD 3.480000, gains=USD -0.100000, note=fee for transferring from Kraken to In Transit
+1.1.3.1                    2017-11-01 Coinbase BTC 0.050000000  (basis:$361.560000  price:$7231.200000)
+`))
+	g.Expect(im.InTransit()).To(BeEmpty())
+}
+
+func TestKrakenErrors(t *testing.T) {
+	const header = "txid,refid,time,type,subtype,aclass,asset,amount,fee,balance\n"
+	for _, tc := range []struct {
+		csv, want string
+	}{
+		{"time,type\n", "ledgers.csv:1: couldn't find the header row"},
+		{"txid,refid,type\n", `ledgers.csv:1: missing column "time"`},
+		{header + "L1,T1,2017-11-01 09:00:00,trade,,currency,ZUSD,-500,0,0\n",
+			"ledgers.csv:2: trade T1 has 1 rows, expected 2"},
+		{header + "L1,T1,2017-11-01 09:00:00,trade,,currency,ZUSD,500,0,0\nL2,T1,2017-11-01 09:00:00,trade,,currency,XXBT,0.07,0,0\n",
+			"ledgers.csv:2: trade T1 doesn't both send and receive an amount"},
+		{header + "L1,T1,2017-11-01 09:00:00,trade,,currency,ZUSD,-500,1.3,0\nL2,T1,2017-11-01 09:00:00,trade,,currency,XXBT,0.07,0.0001,0\n",
+			"ledgers.csv:2: trade T1 has a fee on both the sent and the received amount"},
+		{header + "L1,T1,yesterday,deposit,,currency,ZUSD,500,0,0\n",
+			`ledgers.csv:2: invalid date "yesterday" in column "time"`},
+		{header + "L1,T1,2017-11-01 09:00:00,deposit,,currency,ZUSD,lots,0,0\n",
+			`ledgers.csv:2: invalid number "lots" in column "amount"`},
+	} {
+		g := NewGomegaWithT(t)
+		im := importer.New(ledger.New(USD, prices), importer.Options{})
+		g.Expect(im.ReadKrakenLedger(strings.NewReader(tc.csv), "ledgers.csv", Kraken)).To(MatchError(tc.want), tc.csv)
+	}
+}
@@ -0,0 +1,821 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+var (
+	ledgerFileDateLayouts = []string{"2006-01-02", "2006/01/02", "2006/1/2", "2006-1-2"}
+
+	// beancountDirectives are the Beancount directives which aren't transactions, and are skipped.
+	beancountDirectives = map[string]bool{"open": true, "close": true, "commodity": true, "price": true, "balance": true,
+		"pad": true, "note": true, "document": true, "event": true, "query": true, "custom": true}
+)
+
+type (
+	// LedgerFileOptions configure ReadLedgerFile.
+	LedgerFileOptions struct {
+		// Accounts maps the file's asset accounts to ledger accounts, e.g. "Assets:Exchanges:Kraken" to "Kraken".
+		// Other accounts default to the name without "Assets:", e.g. "Assets:Coinbase" is "Coinbase".
+		Accounts map[string]ledger.Account
+		// Currencies maps the file's commodities to currencies, e.g. "XBT" to "BTC". Other commodities are upper
+		// cased, and "$" is the ledger's local currency.
+		Currencies map[string]ledger.Currency
+	}
+
+	// textTransaction is a transaction read from a Beancount or ledger-cli file.
+	textTransaction struct {
+		line      int
+		date      time.Time
+		narration string
+		postings  []*textPosting
+	}
+
+	// textPosting is one line of a textTransaction. Amounts may be left out, in which case missing is set.
+	textPosting struct {
+		account   string
+		missing   bool
+		amount    ledger.Decimal
+		commodity string
+		cost      *textCost
+		// price is the price per unit, from "@", or the total price from "@@"
+		price          *ledger.Decimal
+		priceIsTotal   bool
+		priceCommodity string
+	}
+
+	// textCost is a lot annotation, e.g. {1292.03 USD, 2017-04-06, "1.1"} or {{1085 USD}}.
+	textCost struct {
+		perUnit, total *ledger.Decimal
+		commodity      string
+		date           *time.Time
+		label          string
+	}
+
+	// textLot is a lot held in the file, as a Beancount inventory holds it.
+	textLot struct {
+		units ledger.Decimal
+		// cost is the cost per unit, if known
+		cost  *ledger.Decimal
+		date  *time.Time
+		label string
+		ref   *lotRef
+	}
+
+	// assetPosting is a posting to an asset account, mapped to the ledger's account and currency.
+	assetPosting struct {
+		*textPosting
+		account  ledger.Account
+		currency ledger.Currency
+	}
+
+	// ledgerFileReader turns the transactions of a file into operations, keeping track of the lots held.
+	ledgerFileReader struct {
+		im      *Importer
+		file    string
+		options LedgerFileOptions
+		local   ledger.Currency
+		// lots held, by account and currency
+		lots map[string][]*textLot
+	}
+)
+
+// ReadLedgerFile reads a Beancount or ledger-cli file, and queues its operations.
+//
+// It reads the subset of those formats dealing in commodities held at cost: transactions whose postings to "Assets:"
+// accounts deposit, buy, sell, trade, transfer or receive as income a commodity, with lot annotations such as
+// `{1292.03 USD, 2017-04-06, "1.1"}`, `{{1085 USD}}`, or ledger-cli's `{$1292.03} [2017/04/06] (1.1)`. A posting which
+// reduces a lot draws from the ledger lots it became, matched by label, date and cost, or first in, first out when
+// the posting doesn't say. The other accounts (income, expenses and equity) only explain where the commodities came
+// from or went. Taxable gains are worked out by the ledger, rather than read from the file.
+//
+// Other directives, and transactions which don't fit one of the operations, are skipped.
+func (im *Importer) ReadLedgerFile(r io.Reader, file string, options LedgerFileOptions) error {
+	transactions, err := parseLedgerFile(r, file)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].date.Before(transactions[j].date) })
+
+	lr := &ledgerFileReader{im: im, file: file, options: options, local: im.ledger.LocalCurrency(), lots: map[string][]*textLot{}}
+	for _, tx := range transactions {
+		operations, err := lr.operations(tx)
+		if err != nil {
+			return &ledger.LineError{File: file, Line: tx.line, Err: err}
+		}
+		im.Add(operations...)
+	}
+	return nil
+}
+
+// parseLedgerFile reads the transactions from the file, skipping everything else.
+func parseLedgerFile(r io.Reader, file string) ([]*textTransaction, error) {
+	var (
+		transactions []*textTransaction
+		current      *textTransaction
+		line         int
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.ContainsRune(";#%|*", rune(trimmed[0])) {
+			continue
+		}
+
+		if text[0] == ' ' || text[0] == '\t' {
+			// a posting or metadata of the current transaction, or part of a directive being skipped
+			if current == nil || isMetadata(trimmed) {
+				continue
+			}
+			p, err := parsePosting(trimmed)
+			if err != nil {
+				return nil, &ledger.LineError{File: file, Line: line, Err: err}
+			}
+			if p != nil {
+				current.postings = append(current.postings, p)
+			}
+			continue
+		}
+
+		current = nil
+		word, rest := cutWord(text)
+		if date, _, _ := strings.Cut(word, "="); date != "" && unicode.IsDigit(rune(date[0])) {
+			t, ok := parseLedgerFileDate(date)
+			if !ok {
+				return nil, &ledger.LineError{File: file, Line: line, Err: fmt.Errorf("invalid date %q", date)}
+			}
+			if keyword, _ := cutWord(rest); beancountDirectives[keyword] {
+				continue
+			}
+			current = &textTransaction{line: line, date: t, narration: parseNarration(rest)}
+			transactions = append(transactions, current)
+		}
+		// anything else (options, includes, automated transactions, ledger-cli prices) is skipped
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return transactions, nil
+}
+
+func parseLedgerFileDate(s string) (time.Time, bool) {
+	for _, layout := range ledgerFileDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isMetadata returns true for Beancount metadata, e.g. `txid: "abc"`.
+func isMetadata(s string) bool {
+	key, _ := cutWord(s)
+	return strings.HasSuffix(key, ":") && unicode.IsLower(rune(key[0]))
+}
+
+// parseNarration returns the narration of a transaction from the rest of its first line: Beancount's last quoted
+// string, or ledger-cli's payee.
+func parseNarration(s string) string {
+	s = strings.TrimSpace(stripComment(s))
+	flag, rest := cutWord(s)
+	if flag == "*" || flag == "!" || flag == "txn" {
+		s = rest
+	}
+	if strings.HasPrefix(s, `"`) {
+		var narration string
+		for strings.HasPrefix(s, `"`) {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				break
+			}
+			narration, _ = strconv.Unquote(quoted)
+			s = strings.TrimSpace(s[len(quoted):])
+		}
+		return narration
+	}
+	if strings.HasPrefix(s, "(") {
+		if _, after, ok := strings.Cut(s, ")"); ok {
+			s = after
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// stripComment removes a comment starting with ';', outside of quotes.
+func stripComment(s string) string {
+	quoted := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func cutWord(s string) (word, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+// parsePosting parses a posting, e.g. `Assets:Coinbase  0.799 BTC {1300.50 USD, 2017-04-06, "1.1.1"} @ 6767.31 USD`.
+// It returns nil for ledger-cli's virtual postings, e.g. `(Budget:Food)  10 USD`.
+func parsePosting(s string) (*textPosting, error) {
+	s = strings.TrimSpace(stripComment(s))
+	if strings.HasPrefix(s, "* ") || strings.HasPrefix(s, "! ") {
+		s = strings.TrimSpace(s[2:])
+	}
+	if strings.HasPrefix(s, "(") || strings.HasPrefix(s, "[") {
+		return nil, nil
+	}
+
+	// ledger-cli accounts may contain single spaces, so they end at two spaces or a tab if there are any
+	p := &textPosting{account: s, missing: true}
+	separated := true
+	i := strings.Index(strings.ReplaceAll(s, "\t", "  "), "  ")
+	if i < 0 {
+		separated, i = false, strings.IndexByte(s, ' ')
+	}
+	if i < 0 {
+		return p, nil
+	}
+	amount, commodity, rest, err := parseTextAmount(s[i:])
+	switch {
+	case err != nil && separated:
+		return nil, err
+	case err != nil:
+		// an account with a space in its name, and no amount
+		return p, nil
+	}
+	p.account, p.missing, p.amount, p.commodity = s[:i], false, amount, commodity
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "{"):
+			if p.cost, rest, err = parseCost(rest); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(rest, "[") || strings.HasPrefix(rest, "("):
+			// ledger-cli lot date or note
+			end := strings.IndexAny(rest, "])")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated lot annotation %q", rest)
+			}
+			if p.cost == nil {
+				p.cost = &textCost{}
+			}
+			if value := strings.TrimSpace(rest[1:end]); rest[0] == '[' {
+				date, ok := parseLedgerFileDate(strings.TrimPrefix(value, "="))
+				if !ok {
+					return nil, fmt.Errorf("invalid lot date %q", value)
+				}
+				p.cost.date = &date
+			} else {
+				p.cost.label = value
+			}
+			rest = strings.TrimSpace(rest[end+1:])
+		case strings.HasPrefix(rest, "@"):
+			p.priceIsTotal = strings.HasPrefix(rest, "@@")
+			var price ledger.Decimal
+			if price, p.priceCommodity, rest, err = parseTextAmount(strings.TrimLeft(rest, "@")); err != nil {
+				return nil, err
+			}
+			p.price = &price
+		default:
+			return nil, fmt.Errorf("unexpected %q after the amount", rest)
+		}
+	}
+	return p, nil
+}
+
+// parseTextAmount parses an amount at the start of s, e.g. "-0.8 BTC", "$1,085.00" or "-$5", returning the rest.
+func parseTextAmount(s string) (amount ledger.Decimal, commodity, rest string, err error) {
+	s = strings.TrimSpace(s)
+	text := s
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = strings.TrimSpace(s[1:])
+	}
+	commodityEnd := func(s string) int {
+		if strings.HasPrefix(s, "$") {
+			return 1
+		}
+		if i := strings.IndexFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_.'-", r)
+		}); i >= 0 {
+			return i
+		}
+		return len(s)
+	}
+	if s != "" && !unicode.IsDigit(rune(s[0])) && s[0] != '.' && s[0] != '-' {
+		end := commodityEnd(s)
+		commodity, s = s[:end], strings.TrimSpace(s[end:])
+		if strings.HasPrefix(s, "-") {
+			negative = !negative
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' })
+	if end < 0 {
+		end = len(s)
+	}
+	number := strings.ReplaceAll(s[:end], ",", "")
+	if amount, err = ledger.ParseDecimal(number); err != nil {
+		return ledger.Decimal{}, "", "", fmt.Errorf("invalid amount %q", text)
+	}
+	if negative {
+		amount = amount.Neg()
+	}
+	s = strings.TrimSpace(s[end:])
+	if commodity == "" {
+		end := commodityEnd(s)
+		commodity, s = s[:end], strings.TrimSpace(s[end:])
+	}
+	if commodity == "" {
+		return ledger.Decimal{}, "", "", fmt.Errorf("missing the commodity of %s", number)
+	}
+	return amount, commodity, s, nil
+}
+
+// parseCost parses a lot annotation at the start of s, e.g. {1292.03 USD, 2017-04-06, "1.1"} or {{1085 USD}}.
+func parseCost(s string) (*textCost, string, error) {
+	open, close := "{", "}"
+	if strings.HasPrefix(s, "{{") {
+		open, close = "{{", "}}"
+	}
+	end := strings.Index(s, close)
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated lot annotation %q", s)
+	}
+	cost := &textCost{}
+	for _, component := range strings.Split(s[len(open):end], ",") {
+		component = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(component), "="))
+		if component == "" {
+			continue
+		}
+		if strings.HasPrefix(component, `"`) {
+			label, err := strconv.Unquote(component)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid lot label %s", component)
+			}
+			cost.label = label
+			continue
+		}
+		if date, ok := parseLedgerFileDate(component); ok {
+			cost.date = &date
+			continue
+		}
+		perUnit, total, _ := strings.Cut(component, "#")
+		if total != "" {
+			// {per # total CUR}, where either may be left out
+			amount, commodity, _, err := parseTextAmount(total)
+			if err != nil {
+				return nil, "", err
+			}
+			cost.total, cost.commodity = &amount, commodity
+			if strings.TrimSpace(perUnit) == "" {
+				continue
+			}
+			perUnit += " " + commodity
+		}
+		amount, commodity, rest, err := parseTextAmount(perUnit)
+		if err != nil || rest != "" {
+			return nil, "", fmt.Errorf("invalid lot annotation %q", component)
+		}
+		cost.commodity = commodity
+		if open == "{{" {
+			cost.total = &amount
+		} else {
+			cost.perUnit = &amount
+		}
+	}
+	return cost, strings.TrimSpace(s[end+len(close):]), nil
+}
+
+// weight returns the value of the posting in the local currency, if it's known, rounded to the local currency's
+// precision.
+func (lr *ledgerFileReader) weight(p *textPosting) (ledger.Decimal, bool) {
+	places := lr.im.ledger.Precision(lr.local)
+	switch {
+	case p.missing:
+		return ledger.Decimal{}, false
+	case p.cost != nil && p.cost.total != nil && lr.currency(p.cost.commodity) == lr.local:
+		if p.amount.Sign() < 0 {
+			return p.cost.total.Neg(), true
+		}
+		return *p.cost.total, true
+	case p.cost != nil && p.cost.perUnit != nil && lr.currency(p.cost.commodity) == lr.local:
+		return p.amount.Mul(*p.cost.perUnit).Round(places), true
+	case p.price != nil && lr.currency(p.priceCommodity) == lr.local:
+		if p.priceIsTotal {
+			if p.amount.Sign() < 0 {
+				return p.price.Neg(), true
+			}
+			return *p.price, true
+		}
+		return p.amount.Mul(*p.price).Round(places), true
+	case lr.currency(p.commodity) == lr.local:
+		return p.amount, true
+	}
+	return ledger.Decimal{}, false
+}
+
+func (lr *ledgerFileReader) currency(commodity string) ledger.Currency {
+	if currency, ok := lr.options.Currencies[commodity]; ok {
+		return currency
+	}
+	if commodity == "$" {
+		return lr.local
+	}
+	return ledger.Currency(strings.ToUpper(strings.Trim(commodity, `"`)))
+}
+
+// accountType returns the first component of the account's name, e.g. "assets".
+func accountType(account string) string {
+	root, _, _ := strings.Cut(account, ":")
+	return strings.ToLower(strings.TrimSpace(root))
+}
+
+func (lr *ledgerFileReader) account(account string) ledger.Account {
+	if mapped, ok := lr.options.Accounts[account]; ok {
+		return mapped
+	}
+	if _, name, ok := strings.Cut(account, ":"); ok {
+		return ledger.Account(name)
+	}
+	return ledger.Account(account)
+}
+
+// operations works out what the transaction did, as operations.
+func (lr *ledgerFileReader) operations(tx *textTransaction) ([]Operation, error) {
+	// fill in a missing amount from the others, when they're all in the local currency
+	var (
+		sum     ledger.Decimal
+		missing []*textPosting
+		known   = true
+	)
+	for _, p := range tx.postings {
+		if p.missing {
+			missing = append(missing, p)
+			continue
+		}
+		weight, ok := lr.weight(p)
+		known = known && ok
+		sum = sum.Add(weight)
+	}
+	if len(missing) == 1 && known {
+		missing[0].missing, missing[0].amount, missing[0].commodity = false, sum.Neg(), string(lr.local)
+	}
+
+	var assets, counters []*textPosting
+	for _, p := range tx.postings {
+		if accountType(p.account) == "assets" || accountType(p.account) == "asset" {
+			if p.missing {
+				return nil, fmt.Errorf("can't work out the amount posted to %s", p.account)
+			}
+			assets = append(assets, p)
+		} else {
+			counters = append(counters, p)
+		}
+	}
+
+	var localIn, localOut, in, out []assetPosting
+	for _, p := range assets {
+		a := assetPosting{textPosting: p, account: lr.account(p.account), currency: lr.currency(p.commodity)}
+		switch {
+		case a.currency == lr.local && a.amount.Sign() > 0:
+			localIn = append(localIn, a)
+		case a.currency == lr.local && a.amount.Sign() < 0:
+			localOut = append(localOut, a)
+		case a.amount.Sign() > 0:
+			in = append(in, a)
+		case a.amount.Sign() < 0:
+			out = append(out, a)
+		}
+	}
+	in, out = netReopenedLots(in, out)
+
+	op := Operation{File: lr.file, Line: tx.line, Date: tx.date, Note: tx.narration}
+	skip := func(reason string) ([]Operation, error) {
+		op.Type, op.RowType, op.SkipReason = Ignore, "transaction", reason
+		return []Operation{op}, nil
+	}
+	switch {
+	case len(in) == 0 && len(out) == 0:
+		return lr.localOperations(op, localIn, localOut, counters, skip)
+
+	case len(out) == 0:
+		if len(in) > 1 {
+			return skip("receives more than one lot")
+		}
+		return lr.receiveOperation(op, in[0], localOut, counters, skip)
+	}
+
+	currency, account := out[0].currency, out[0].account
+	for _, a := range out {
+		if a.currency != currency || a.account != account {
+			return skip("sends lots from more than one account or currency")
+		}
+	}
+	from, fromLot, err := lr.reduce(out)
+	if err != nil {
+		return nil, err
+	}
+	op.from = from
+	sent := sumAmounts(out).Neg()
+
+	switch {
+	case len(in) == 0:
+		// a sale, or spending
+		op.Type, op.RowType, op.Account = Sell, "sell", account
+		op.Sent = Amount{Currency: currency, Amount: sent}
+		proceeds, ok := lr.proceeds(out, localIn, counters)
+		if !ok {
+			return skip("can't tell the proceeds of the sale")
+		}
+		if len(localIn) == 0 && hasAccountType(counters, "expenses") {
+			op.RowType = "spend"
+		}
+		op.Received = Amount{Currency: lr.local, Amount: proceeds}
+		return []Operation{op}, nil
+
+	case in[0].currency != currency:
+		if len(in) > 1 {
+			return skip("trades for more than one lot")
+		}
+		op.Type, op.RowType, op.Account = Trade, "trade", account
+		op.Sent = Amount{Currency: currency, Amount: sent}
+		op.Received = Amount{Currency: in[0].currency, Amount: in[0].amount}
+		op.to = lr.augment(in[0], nil, op.Date)
+		return []Operation{op}, nil
+	}
+
+	// a transfer to other accounts, which may have kept some as a fee
+	received := sumAmounts(in)
+	for _, a := range in {
+		if a.currency != currency || a.account == account {
+			return skip("mixes a transfer with other postings")
+		}
+	}
+	if len(in) > 1 {
+		return skip("transfers to more than one lot")
+	}
+	if received.Cmp(sent) > 0 {
+		return skip("receives more than was sent")
+	}
+	op.Type, op.RowType, op.Account, op.ToAccount = Send, "transfer", account, in[0].account
+	op.Sent = Amount{Currency: currency, Amount: received}
+	op.Fee = Amount{Currency: currency, Amount: sent.Sub(received)}
+	op.to = lr.augment(in[0], fromLot, op.Date)
+	return []Operation{op}, nil
+}
+
+// localOperations works out a transaction which only moves the local currency.
+func (lr *ledgerFileReader) localOperations(op Operation, localIn, localOut []assetPosting, counters []*textPosting,
+	skip func(string) ([]Operation, error)) ([]Operation, error) {
+
+	switch {
+	case len(localIn) == 1 && len(localOut) == 0:
+		// a deposit, where any more than the amount coming from equity or income was paid in fees
+		op.Type, op.RowType, op.Account = Deposit, "deposit", localIn[0].account
+		op.Received = Amount{Currency: lr.local, Amount: localIn[0].amount}
+		var paid ledger.Decimal
+		for _, p := range counters {
+			if weight, ok := lr.weight(p); ok && weight.Sign() < 0 {
+				paid = paid.Sub(weight)
+			}
+		}
+		if fee := paid.Sub(op.Received.Amount); fee.Sign() > 0 {
+			op.Fee = Amount{Currency: lr.local, Amount: fee}
+		}
+		return []Operation{op}, nil
+
+	case len(localIn) == 1 && len(localOut) == 1 && localIn[0].account != localOut[0].account:
+		sent, received := localOut[0].amount.Neg(), localIn[0].amount
+		if received.Cmp(sent) > 0 {
+			return skip("receives more than was sent")
+		}
+		op.Type, op.RowType, op.Account, op.ToAccount = Send, "transfer", localOut[0].account, localIn[0].account
+		op.Sent = Amount{Currency: lr.local, Amount: received}
+		op.Fee = Amount{Currency: lr.local, Amount: sent.Sub(received)}
+		return []Operation{op}, nil
+	}
+	return skip(fmt.Sprintf("only moves %s", lr.local))
+}
+
+// receiveOperation works out a transaction which adds a lot without removing any: a purchase, or income.
+func (lr *ledgerFileReader) receiveOperation(op Operation, a assetPosting, localOut []assetPosting, counters []*textPosting,
+	skip func(string) ([]Operation, error)) ([]Operation, error) {
+
+	op.Account = a.account
+	op.Received = Amount{Currency: a.currency, Amount: a.amount}
+	paidFromAccount := len(localOut) > 0
+	for _, p := range localOut {
+		paidFromAccount = paidFromAccount && p.account == a.account
+	}
+	switch {
+	case paidFromAccount:
+		op.Type, op.RowType = Buy, "buy"
+		op.Sent = Amount{Currency: lr.local, Amount: sumAmounts(localOut).Neg()}
+
+	case len(localOut) == 0 && hasAccountType(counters, "income"):
+		op.Type, op.RowType = Income, "income"
+		op.Value, _ = lr.weight(a.textPosting)
+
+	default:
+		// paid from outside of the account
+		cost, ok := lr.weight(a.textPosting)
+		if !ok {
+			return skip(fmt.Sprintf("can't tell what %s cost", a.currency))
+		}
+		op.Type, op.RowType, op.NewMoney = Buy, "buy", true
+		op.Sent = Amount{Currency: lr.local, Amount: cost}
+	}
+	op.to = lr.augment(a, nil, op.Date)
+	return []Operation{op}, nil
+}
+
+// proceeds returns the local currency received for the sale: what arrived in the asset accounts, or else the price
+// given, or else what was posted to the expense and equity accounts (e.g. the value of something bought with it).
+func (lr *ledgerFileReader) proceeds(out, localIn []assetPosting, counters []*textPosting) (ledger.Decimal, bool) {
+	if len(localIn) > 0 {
+		return sumAmounts(localIn), true
+	}
+	var proceeds ledger.Decimal
+	priced := true
+	for _, a := range out {
+		if a.price == nil || lr.currency(a.priceCommodity) != lr.local {
+			priced = false
+			break
+		}
+		weight, _ := lr.weight(&textPosting{amount: a.amount, price: a.price, priceIsTotal: a.priceIsTotal,
+			priceCommodity: a.priceCommodity, commodity: a.commodity})
+		proceeds = proceeds.Sub(weight)
+	}
+	if priced {
+		return proceeds, true
+	}
+
+	proceeds = ledger.Decimal{}
+	for _, p := range counters {
+		if kind := accountType(p.account); kind != "expenses" && kind != "equity" {
+			continue
+		}
+		if weight, ok := lr.weight(p); ok && weight.Sign() > 0 {
+			proceeds = proceeds.Add(weight)
+		}
+	}
+	return proceeds, proceeds.Sign() > 0
+}
+
+// reduce removes the posted amounts from the lots held, and returns the lots they were drawn from, along with
+// the first of them.
+func (lr *ledgerFileReader) reduce(out []assetPosting) (lotRefs, *textLot, error) {
+	var (
+		refs  lotRefs
+		first *textLot
+	)
+	for _, a := range out {
+		key := string(a.account) + " " + string(a.currency)
+		remaining := a.amount.Neg()
+		for _, lot := range lr.lots[key] {
+			if remaining.Sign() == 0 {
+				break
+			}
+			if lot.units.Sign() <= 0 || !lot.matches(a.cost) {
+				continue
+			}
+			taken := lot.units
+			if taken.Cmp(remaining) > 0 {
+				taken = remaining
+			}
+			lot.units = lot.units.Sub(taken)
+			remaining = remaining.Sub(taken)
+			refs = append(refs, lot.ref)
+			if first == nil {
+				first = lot
+			}
+		}
+		if remaining.Sign() > 0 {
+			return nil, nil, fmt.Errorf("%s doesn't hold %s %s in lots matching %s", a.textPosting.account,
+				a.amount.Neg(), a.commodity, a.cost.String())
+		}
+	}
+	return refs, first, nil
+}
+
+// augment adds the posted lot to the lots held. A lot transferred from another account keeps its cost and date,
+// unless they're given, and other lots are dated by the transaction.
+func (lr *ledgerFileReader) augment(a assetPosting, from *textLot, date time.Time) *lotRef {
+	lot := &textLot{units: a.amount, date: &date, ref: &lotRef{}}
+	if from != nil {
+		lot.cost, lot.date = from.cost, from.date
+	}
+	if c := a.cost; c != nil {
+		switch {
+		case c.perUnit != nil:
+			lot.cost = c.perUnit
+		case c.total != nil:
+			perUnit := c.total.Quo(a.amount, 18)
+			lot.cost = &perUnit
+		}
+		if c.date != nil {
+			lot.date = c.date
+		}
+		lot.label = c.label
+	}
+	key := string(a.account) + " " + string(a.currency)
+	lr.lots[key] = append(lr.lots[key], lot)
+	return lot.ref
+}
+
+// matches returns true if the lot matches the annotation of a posting reducing it. A missing annotation matches any lot.
+func (lot *textLot) matches(c *textCost) bool {
+	if c == nil {
+		return true
+	}
+	return (c.label == "" || c.label == lot.label) &&
+		(c.date == nil || lot.date != nil && c.date.Equal(*lot.date)) &&
+		(c.perUnit == nil || lot.cost != nil && c.perUnit.Cmp(*lot.cost) == 0)
+}
+
+// String returns the annotation as Beancount writes it.
+func (c *textCost) String() string {
+	if c == nil {
+		return "{}"
+	}
+	var components []string
+	if c.perUnit != nil {
+		components = append(components, fmt.Sprintf("%s %s", c.perUnit, c.commodity))
+	}
+	if c.total != nil {
+		components = append(components, fmt.Sprintf("# %s %s", c.total, c.commodity))
+	}
+	if c.date != nil {
+		components = append(components, c.date.Format("2006-01-02"))
+	}
+	if c.label != "" {
+		components = append(components, strconv.Quote(c.label))
+	}
+	return "{" + strings.Join(components, ", ") + "}"
+}
+
+// netReopenedLots cancels out a lot posted back to the account it was reduced from, with the same label, as Beancount
+// files do when only part of a lot is removed. What remains is the amount actually removed from the lot.
+func netReopenedLots(in, out []assetPosting) ([]assetPosting, []assetPosting) {
+	var keptIn []assetPosting
+	for _, a := range in {
+		reopened := false
+		for i, b := range out {
+			if a.cost != nil && b.cost != nil && a.cost.label != "" && a.cost.label == b.cost.label &&
+				a.account == b.account && a.currency == b.currency {
+				out[i].textPosting = &textPosting{account: b.textPosting.account, amount: b.amount.Add(a.amount),
+					commodity: b.commodity, cost: b.cost, price: b.price, priceIsTotal: b.priceIsTotal, priceCommodity: b.priceCommodity}
+				reopened = true
+				break
+			}
+		}
+		if !reopened {
+			keptIn = append(keptIn, a)
+		}
+	}
+	var keptOut []assetPosting
+	for _, b := range out {
+		if b.amount.Sign() < 0 {
+			keptOut = append(keptOut, b)
+		}
+	}
+	return keptIn, keptOut
+}
+
+func sumAmounts(postings []assetPosting) ledger.Decimal {
+	var sum ledger.Decimal
+	for _, p := range postings {
+		sum = sum.Add(p.amount)
+	}
+	return sum
+}
+
+func hasAccountType(postings []*textPosting, kind string) bool {
+	for _, p := range postings {
+		if accountType(p.account) == kind {
+			return true
+		}
+	}
+	return false
+}
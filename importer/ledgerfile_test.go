@@ -0,0 +1,244 @@
+package importer_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/importer"
+)
+
+const beancountFile = `option "operating_currency" "USD"
+
+2017-01-01 open Assets:Bitfinex
+2017-01-01 open Assets:Coinbase
+2017-01-01 commodity BTC
+  name: "Bitcoin"
+
+2017-04-06 * "Bank" "wire to Bitfinex"
+  Assets:Bitfinex        960 USD
+  Expenses:Fees          125 USD
+  Equity:Opening        -1085 USD
+
+2017-04-06 * "buy BTC"
+  txid: "abc"
+  Assets:Bitfinex        0.83976678 BTC {{960 USD}}
+  Assets:Bitfinex       -960.00 USD ; including the trade fee
+
+2017-11-01 price BTC 6767.31 USD
+
+2017-11-01 * "to Coinbase"
+  Assets:Bitfinex       -0.8 BTC {}
+  Assets:Coinbase        0.799 BTC
+  Expenses:Fees          0.001 BTC
+
+2017-11-02 * "mined"
+  Assets:Coinbase        0.01 XBT {6960.0012 USD}
+  Income:Mining
+
+2017-11-02 * "BTC for ETH"
+  Assets:Coinbase       -0.1 BTC {} @ 6960.07 USD
+  Assets:Coinbase        2.3 ETH {{696.01 USD}}
+  Income:CapitalGains
+
+2017-12-01 * "cash out"
+  Assets:Coinbase       -0.5 BTC {2017-04-06} @@ 5487.80 USD
+  Assets:Bank            5487.80 USD
+  Income:CapitalGains
+
+2017-12-02 ! "pizza"
+  Assets:Coinbase       -0.01 XBT
+  Expenses:Food          110 USD
+  Income:CapitalGains
+
+2017-12-03 * "moved"
+  Assets:Bitfinex       -0.02 BTC
+  Assets:Coinbase        0.01 ETH
+  Assets:Kraken          0.01 BTC
+
+2017-12-31 balance Assets:Coinbase  2.3 ETH
+`
+
+const ledgerCLIFile = `; a ledger-cli journal
+P 2017/11/01 BTC $6767.31
+account Assets:Bitfinex
+
+2017/04/06 * (1) Bank wire
+    Assets:Bitfinex            $960
+    Expenses:Fees              $125
+    Equity:Opening Balances
+
+2017/4/6 * Buy BTC
+    Assets:Bitfinex            0.83976678 BTC {=$1143.1878} [2017/04/06] (first)
+    Assets:Bitfinex           -$960.00
+    Expenses:Rounding
+
+2017/11/01=2017/11/02 * Transfer
+    Assets:Bitfinex           -0.8 BTC {$1143.1878} (first)
+    Assets:Coinbase Wallet     0.799 BTC
+    Expenses:Fees              0.001 BTC
+    (Budget:Crypto)            $10
+
+2017/12/01 Sold
+    Assets:Coinbase Wallet    -0.5 BTC @ $10,975.60
+    Income:Capital Gains
+`
+
+func TestReadLedgerFile(t *testing.T) {
+	t.Run("beancount", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, prices)
+		im := importer.New(l, importer.Options{})
+		g.Expect(im.ReadLedgerFile(strings.NewReader(beancountFile), "main.beancount", importer.LedgerFileOptions{
+			Currencies: map[string]ledger.Currency{"XBT": BTC},
+		})).To(Succeed())
+		report := im.Apply()
+
+		g.Expect(report.String()).To(Equal(
+			`main.beancount:8   2017-04-06  Bitfinex  deposit      imported  lots:1
+main.beancount:13  2017-04-06  Bitfinex  buy          imported  lots:1.1
+main.beancount:20  2017-11-01  Bitfinex  transfer     imported  lots:1.1.1,1.1.1.spendCapitalGains,1.1.1.spendCapitalGains.1
+main.beancount:25  2017-11-02  Coinbase  income       imported  lots:2
+main.beancount:29  2017-11-02  Coinbase  trade        imported  lots:1.1.1.1,1.1.1.2
+main.beancount:34  2017-12-01  Coinbase  sell         imported  lots:1.1.1.3
+main.beancount:39  2017-12-02  Coinbase  spend        imported  lots:1.1.1.4
+main.beancount:44  2017-12-03            transaction  skipped     trades for more than one lot
+(imported:7 skipped:1 failed:0)
+`))
+		g.Expect(l.PrintLots()).To(Equal(
+			`1                          2017-04-06 Bitfinex USD 0.000000000  (basis:$0.000000    price:$NaN)
+1.1                        2017-04-06 Bitfinex BTC 0.039766780  (basis:$51.380000   price:$1292.033200)
+1.1.1                      2017-04-06 Coinbase BTC 0.189000000  (basis:$245.790000  price:$1300.476190)
+1.1.1.spendCapitalGains    0001-01-01  BTC 0.000000000          (basis:$0.000000    price:$NaN)
+1.1.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.290000. proceeds=USD 6.770000, gains=USD 5.480000, note=fee for transferring from Bitfinex to Coinbase
+2                          2017-11-02 Coinbase BTC 0.010000000  (basis:$69.600000   price:$6960.000000)
+1.1.1.1                    2017-11-02 Coinbase ETH 2.300000000  (basis:$696.010000  price:$302.613043)
+1.1.1.2                    2017-11-02 Taxable Gains (short-term) from sale on Coinbase of BTC 0.100000000 originally purchased 2017-04-06 for USD 130.050000. proceeds=USD 696.010000, gains=USD 565.960000, note=exchanging BTC for ETH
+1.1.1.3                    2017-12-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.500000000 originally purchased 2017-04-06 for USD 650.250000. proceeds=USD 5487.800000, gains=USD 4837.550000, note=sold BTC for USD
+1.1.1.4                    2017-12-02 Taxable Gains (short-term) from sale on Coinbase of BTC 0.010000000 originally purchased 2017-04-06 for USD 13.010000. proceeds=USD 110.000000, gains=USD 96.990000, note=sold BTC for USD
+`))
+	})
+
+	t.Run("ledger-cli", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, prices)
+		im := importer.New(l, importer.Options{})
+		g.Expect(im.ReadLedgerFile(strings.NewReader(ledgerCLIFile), "main.ledger", importer.LedgerFileOptions{
+			Accounts: map[string]ledger.Account{"Assets:Coinbase Wallet": Coinbase},
+		})).To(Succeed())
+		report := im.Apply()
+
+		g.Expect(report.String()).To(Equal(
+			`main.ledger:5   2017-04-06  Bitfinex  deposit   imported  lots:1
+main.ledger:10  2017-04-06  Bitfinex  buy       imported  lots:1.1
+main.ledger:15  2017-11-01  Bitfinex  transfer  imported  lots:1.1.1,1.1.1.spendCapitalGains,1.1.1.spendCapitalGains.1
+main.ledger:21  2017-12-01  Coinbase  sell      imported  lots:1.1.1.1
+(imported:4 skipped:0 failed:0)
+`))
+		g.Expect(l.PrintLots()).To(Equal(
+			`1                          2017-04-06 Bitfinex USD 0.000000000  (basis:$0.000000    price:$NaN)
+1.1                        2017-04-06 Bitfinex BTC 0.039766780  (basis:$51.380000   price:$1292.033200)
+1.1.1                      2017-04-06 Coinbase BTC 0.299000000  (basis:$388.850000  price:$1300.501672)
+1.1.1.spendCapitalGains    0001-01-01  BTC 0.000000000          (basis:$0.000000    price:$NaN)
+1.1.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.290000. proceeds=USD 6.770000, gains=USD 5.480000, note=fee for transferring from Bitfinex to Coinbase
+1.1.1.1                    2017-12-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.500000000 originally purchased 2017-04-06 for USD 650.250000. proceeds=USD 5487.800000, gains=USD 4837.550000, note=sold BTC for USD
+`))
+	})
+
+	t.Run("round trip through WriteBeancount", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, prices)
+		_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.83976678"), n("960"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Income(d("2017-08-01"), Bitfinex, ETH, n("0.5"), n("150"), "staking")
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.8"), n("0.001"), Coinbase)
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2017-12-01"), "1.1.1", BTC, n("0.1"), n("1097.56"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Spend(d("2017-12-01"), Bitfinex, "1.1", BTC, n("0.01"), "pizza")
+		g.Expect(err).NotTo(HaveOccurred())
+		b := &bytes.Buffer{}
+		g.Expect(l.WriteBeancount(b)).To(Succeed())
+
+		imported := ledger.New(USD, prices)
+		im := importer.New(imported, importer.Options{})
+		g.Expect(im.ReadLedgerFile(b, "export.beancount", importer.LedgerFileOptions{})).To(Succeed())
+		report := im.Apply()
+		g.Expect(report.Count(importer.Failed)).To(BeZero(), report.String())
+
+		// spending is imported as a sale, so compare the sales rather than the names of their lots
+		want, err := l.Form8949(ledger.Form8949Options{})
+		g.Expect(err).NotTo(HaveOccurred())
+		got, err := imported.Form8949(ledger.Form8949Options{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintForm8949(got)).To(Equal(ledger.PrintForm8949(want)))
+		g.Expect(imported.PrintAccounts()).To(Equal(l.PrintAccounts()))
+	})
+
+	t.Run("round trip through WriteBeancount under each cost basis method", func(t *testing.T) {
+		for _, method := range []ledger.CostBasisMethod{ledger.LotCostBasis, ledger.UKShareMatching, ledger.CanadianACB, ledger.AverageCost} {
+			t.Run(method.String(), func(t *testing.T) {
+				g := NewGomegaWithT(t)
+
+				// the pooled methods give the amounts exchanged and sold a different cost basis than their lots
+				l := ledger.New(USD, prices)
+				g.Expect(l.SetCostBasisMethod(method)).To(Succeed())
+				_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("3000"), n("3000"))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.5"), n("1000"))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = l.Purchase(d("2017-06-01"), "1", Bitfinex, BTC, n("0.5"), n("2000"))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = l.ExchangeTaxable(d("2017-11-02"), "1.2", BTC, n("0.1"), ledger.Decimal{}, true, ETH, n("2.3"))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = l.SellTaxable(d("2017-12-01"), "1.1", BTC, n("0.2"), n("2195.12"))
+				g.Expect(err).NotTo(HaveOccurred())
+				b := &bytes.Buffer{}
+				g.Expect(l.WriteBeancount(b)).To(Succeed())
+
+				imported := ledger.New(USD, prices)
+				g.Expect(imported.SetCostBasisMethod(method)).To(Succeed())
+				im := importer.New(imported, importer.Options{})
+				g.Expect(im.ReadLedgerFile(b, "export.beancount", importer.LedgerFileOptions{})).To(Succeed())
+				report := im.Apply()
+				g.Expect(report.Count(importer.Failed)).To(BeZero(), report.String())
+
+				want, err := l.Form8949(ledger.Form8949Options{})
+				g.Expect(err).NotTo(HaveOccurred())
+				got, err := imported.Form8949(ledger.Form8949Options{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ledger.PrintForm8949(got)).To(Equal(ledger.PrintForm8949(want)))
+				g.Expect(imported.PrintAccounts()).To(Equal(l.PrintAccounts()))
+			})
+		}
+	})
+}
+
+func TestReadLedgerFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"2017-13-01 * \"x\"\n", `main.beancount:1: invalid date "2017-13-01"`},
+		{"2017-04-06 * \"x\"\n  Assets:Bitfinex  lots BTC\n", `main.beancount:2: invalid amount "lots BTC"`},
+		{"2017-04-06 * \"x\"\n  Assets:Bitfinex  1 BTC {1000 USD\n", `main.beancount:2: unterminated lot annotation "{1000 USD"`},
+		{"2017-04-06 * \"x\"\n  Assets:Bitfinex  1 BTC ^link\n", `main.beancount:2: unexpected "^link" after the amount`},
+		{"2017-04-06 * \"x\"\n  Assets:Bitfinex  1 BTC\n  Assets:Coinbase\n  Income:Mining\n",
+			"main.beancount:1: can't work out the amount posted to Assets:Coinbase"},
+		{"2017-04-06 * \"x\"\n  Assets:Bitfinex  -1 BTC {\"1.1\"}\n  Equity:Withdrawals  1000 USD\n",
+			`main.beancount:1: Assets:Bitfinex doesn't hold 1 BTC in lots matching {"1.1"}`},
+	} {
+		g := NewGomegaWithT(t)
+		im := importer.New(ledger.New(USD, prices), importer.Options{})
+		g.Expect(im.ReadLedgerFile(strings.NewReader(tc.text), "main.beancount", importer.LedgerFileOptions{})).
+			To(MatchError(tc.want), tc.text)
+	}
+}
@@ -0,0 +1,224 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// Mapping describes the columns of a CSV file, so exchanges without a dedicated reader can be imported with ReadMapped.
+// It's usually loaded from a YAML or JSON file with LoadMapping, e.g.
+//
+//	columns:
+//	  date: Time
+//	  type: Kind
+//	  sentCurrency: From
+//	  sentAmount: From Amount
+//	  receivedCurrency: To
+//	  receivedAmount: To Amount
+//	  feeCurrency: Fee Currency
+//	  feeAmount: Fee
+//	dateFormats: ["2006-01-02 15:04"]
+//	types:
+//	  Exchange: trade
+//	  Withdraw: send
+//	  Dust: ignore
+//	account: SomeExchange
+type Mapping struct {
+	Columns MappingColumns `yaml:"columns"`
+	// DateFormats are the layouts the dates may be in, as for time.Parse. Dates without a time zone are taken to be UTC.
+	// Defaults to DefaultMappingDateFormats.
+	DateFormats []string `yaml:"dateFormats"`
+	// Types maps the values of the type column (ignoring case) to operation types. Rows with any other type are
+	// reported as skipped. Without a type column, each row is a trade, send or deposit according to its amounts.
+	Types map[string]OperationType `yaml:"types"`
+	// Account is the account for all of the rows, if there's no account column.
+	Account ledger.Account `yaml:"account"`
+	// NewMoney means buys were paid from outside the ledger, see Operation.NewMoney.
+	NewMoney bool `yaml:"newMoney"`
+	// Currencies maps the file's currency symbols to the usual ones, where they differ.
+	Currencies map[string]ledger.Currency `yaml:"currencies"`
+}
+
+// MappingColumns names the columns of a file, as they appear in its header. Only Date is required.
+type MappingColumns struct {
+	Date    string `yaml:"date"`
+	Type    string `yaml:"type"`
+	Account string `yaml:"account"`
+
+	SentCurrency     string `yaml:"sentCurrency"`
+	SentAmount       string `yaml:"sentAmount"`
+	ReceivedCurrency string `yaml:"receivedCurrency"`
+	ReceivedAmount   string `yaml:"receivedAmount"`
+	// Without a fee currency column, fees are in the currency sent, or received if nothing was sent.
+	FeeCurrency string `yaml:"feeCurrency"`
+	FeeAmount   string `yaml:"feeAmount"`
+
+	// Value is the value of income in the local currency. If it's missing, the value is looked up.
+	Value string `yaml:"value"`
+	Note  string `yaml:"note"`
+}
+
+// DefaultMappingDateFormats are the date layouts tried for a Mapping without any.
+var DefaultMappingDateFormats = []string{"2006-01-02T15:04:05Z07:00", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// LoadMapping reads a Mapping from YAML or JSON, rejecting unknown settings.
+func LoadMapping(r io.Reader) (*Mapping, error) {
+	var m Mapping
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("it's empty")
+		}
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	return &m, nil
+}
+
+func (m *Mapping) validate() error {
+	c := m.Columns
+	switch {
+	case c.Date == "":
+		return errors.New("missing the date column")
+	case c.Account == "" && m.Account == "":
+		return errors.New("missing the account column, or the account for all of the rows")
+	case c.SentAmount == "" && c.ReceivedAmount == "":
+		return errors.New("missing the sent or received amount column")
+	case (c.SentAmount == "") != (c.SentCurrency == ""):
+		return errors.New("the sent amount and currency columns go together")
+	case (c.ReceivedAmount == "") != (c.ReceivedCurrency == ""):
+		return errors.New("the received amount and currency columns go together")
+	case c.Type != "" && len(m.Types) == 0:
+		return errors.New("there's a type column, but no types")
+	}
+	return nil
+}
+
+// ReadMapped reads a CSV file laid out as described by the mapping, and queues its operations.
+// The header row is the first row containing the date column.
+func (im *Importer) ReadMapped(r io.Reader, file string, m *Mapping) error {
+	if err := m.validate(); err != nil {
+		return fmt.Errorf("invalid mapping: %w", err)
+	}
+	t, err := newTable(r, file, func(record []string) bool {
+		for _, name := range record {
+			if normalizeColumn(name) == normalizeColumn(m.Columns.Date) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	c := m.Columns
+	for _, column := range []string{c.Type, c.Account, c.SentCurrency, c.SentAmount, c.ReceivedCurrency, c.ReceivedAmount,
+		c.FeeCurrency, c.FeeAmount, c.Value, c.Note} {
+		if column == "" {
+			continue
+		}
+		if err := t.require(column); err != nil {
+			return err
+		}
+	}
+	types := map[string]OperationType{}
+	for name, opType := range m.Types {
+		types[strings.ToLower(strings.TrimSpace(name))] = opType
+	}
+
+	return im.addRows(t, func(t *table) (Operation, error) {
+		return m.operation(t, types)
+	})
+}
+
+func (m *Mapping) operation(t *table, types map[string]OperationType) (Operation, error) {
+	c := m.Columns
+	op := t.operation(t.get(c.Type))
+	op.Account = m.Account
+	if account := t.get(c.Account); account != "" {
+		op.Account = ledger.Account(account)
+	}
+	op.Note = t.get(c.Note)
+	op.NewMoney = m.NewMoney
+
+	var err error
+	formats := m.DateFormats
+	if len(formats) == 0 {
+		formats = DefaultMappingDateFormats
+	}
+	if op.Date, err = t.date(c.Date, formats...); err != nil {
+		return op, err
+	}
+	if op.Sent, err = m.amount(t, c.SentCurrency, c.SentAmount); err != nil {
+		return op, err
+	}
+	if op.Received, err = m.amount(t, c.ReceivedCurrency, c.ReceivedAmount); err != nil {
+		return op, err
+	}
+	feeCurrency := c.FeeCurrency
+	if feeCurrency == "" {
+		feeCurrency = c.SentCurrency
+		if op.Sent.IsZero() {
+			feeCurrency = c.ReceivedCurrency
+		}
+	}
+	if op.Fee, err = m.amount(t, feeCurrency, c.FeeAmount); err != nil {
+		return op, err
+	}
+	if op.Value, err = t.decimal(c.Value); err != nil {
+		return op, err
+	}
+
+	if c.Type != "" {
+		opType, ok := types[strings.ToLower(op.RowType)]
+		if !ok {
+			op.Type = Ignore
+			op.SkipReason = fmt.Sprintf("unmapped type %q", op.RowType)
+			return op, nil
+		}
+		op.Type = opType
+		return op, nil
+	}
+	switch {
+	case !op.Sent.IsZero() && !op.Received.IsZero():
+		op.Type = Trade
+	case !op.Received.IsZero():
+		op.Type = Deposit
+	case !op.Sent.IsZero():
+		op.Type = Send
+	default:
+		op.Type = Ignore
+		op.SkipReason = "nothing sent or received"
+	}
+	op.RowType = op.Type.String()
+	return op, nil
+}
+
+// amount reads an amount from the given columns. Amounts may be negative, e.g. for an amount sent.
+func (m *Mapping) amount(t *table, currencyColumn, amountColumn string) (Amount, error) {
+	if amountColumn == "" {
+		return Amount{}, nil
+	}
+	amount, err := t.decimal(amountColumn)
+	if err != nil || amount.IsZero() {
+		return Amount{}, err
+	}
+	symbol := strings.ToUpper(t.get(currencyColumn))
+	if symbol == "" {
+		return Amount{}, t.errorf("missing the currency in column %q", currencyColumn)
+	}
+	currency, ok := m.Currencies[symbol]
+	if !ok {
+		currency = ledger.Currency(symbol)
+	}
+	return Amount{currency, amount.Abs()}, nil
+}
@@ -0,0 +1,139 @@
+package importer_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/importer"
+)
+
+const mappingYAML = `
+columns:
+  date: Time
+  type: Kind
+  account: Wallet
+  sentCurrency: From
+  sentAmount: From Amount
+  receivedCurrency: To
+  receivedAmount: To Amount
+  feeCurrency: Fee Currency
+  feeAmount: Fee
+dateFormats: ["2006-01-02 15:04", "2006-01-02"]
+types:
+  Buy: buy
+  Exchange: trade
+  Withdraw: send
+  Interest: income
+newMoney: true
+currencies:
+  XBT: BTC
+`
+
+const mappedCSV = `Exported from SomeExchange
+Time,Kind,From,From Amount,To,To Amount,Fee,Fee Currency,Wallet
+2017-11-01 09:00,Buy,USD,-1000,XBT,0.14,5,USD,SomeExchange
+2017-11-02 10:00,Exchange,XBT,-0.01,ETH,0.23,,,SomeExchange
+2017-11-03 11:00,Withdraw,XBT,-0.05,,,0.001,XBT,SomeExchange
+2017-11-04 12:00,Airdrop,,,XYZ,10,,,SomeExchange
+2017-11-05,interest,,,ETH,0.01,,,SomeExchange
+`
+
+func TestMapped(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	mapping, err := importer.LoadMapping(strings.NewReader(mappingYAML))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	l := ledger.New(USD, ledger.NearestPrevious(prices))
+	im := importer.New(l, importer.Options{})
+	g.Expect(im.ReadMapped(strings.NewReader(mappedCSV), "some.csv", mapping)).To(Succeed())
+	report := im.Apply()
+
+	g.Expect(report.String()).To(Equal(
+		`some.csv:3  2017-11-01  SomeExchange  Buy       imported  lots:1,1.1
+some.csv:4  2017-11-02  SomeExchange  Exchange  imported  lots:1.1.1,1.1.2
+some.csv:5  2017-11-03  SomeExchange  Withdraw  imported  lots:1.1.3,1.1.3.spendCapitalGains,1.1.3.spendCapitalGains.1  in transit until received in another account
+some.csv:6  2017-11-04  SomeExchange  Airdrop   skipped                                                                 unmapped type "Airdrop"
+some.csv:7  2017-11-05  SomeExchange  interest  imported  lots:2
+(imported:4 skipped:1 failed:0)
+`))
+	g.Expect(l.PrintLots()).To(Equal(
+		`1                          2017-11-01 SomeExchange USD 0.000000000  (basis:$0.000000    price:$NaN)
+1.1                        2017-11-01 SomeExchange BTC 0.079000000  (basis:$567.100000  price:$7178.481013)
+1.1.1                      2017-11-02 SomeExchange ETH 0.230000000  (basis:$69.600000   price:$302.608696)
+1.1.2                      2017-11-02 Taxable Gains (short-term) from sale on SomeExchange of BTC 0.010000000 originally purchased 2017-11-01 for USD 71.790000. proceeds=USD 69.600000, gains=USD -2.190000, note=exchanging BTC for ETH
+1.1.3                      2017-11-01 In Transit BTC 0.050000000  (basis:$365.890000  price:$7317.800000)
+1.1.3.spendCapitalGains    0001-01-01  BTC 0.000000000            (basis:$0.000000    price:$NaN)
+1.1.3.spendCapitalGains.1  2017-11-03 Taxable Gains (short-term) from sale on SomeExchange of BTC 0.001000000 originally purchased 2017-11-01 for USD 7.180000. proceeds=USD 6.960000, gains=USD -0.220000, note=fee for transferring from SomeExchange to In Transit
+2                          2017-11-05 SomeExchange ETH 0.010000000  (basis:$2.920000  price:$292.000000)
+`))
+}
+
+func TestMappedWithoutTypes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// JSON works too, and without a type column the operations follow from the amounts
+	mapping, err := importer.LoadMapping(strings.NewReader(`{
+		"columns": {"date": "Date", "sentCurrency": "Sent", "sentAmount": "Sent Amount",
+			"receivedCurrency": "Received", "receivedAmount": "Received Amount", "feeAmount": "Fee"},
+		"account": "Wallet"
+	}`))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	l := ledger.New(USD, ledger.NearestPrevious(prices))
+	im := importer.New(l, importer.Options{})
+	g.Expect(im.ReadMapped(strings.NewReader(`Date,Sent,Sent Amount,Received,Received Amount,Fee
+2017-11-01,,,USD,1000,
+2017-11-02,USD,500,BTC,0.07,1
+2017-11-03,BTC,0.02,,,0.0001
+2017-11-04,,,,,
+2017-11-05,,,BTC,1,
+`), "wallet.csv", mapping)).To(Succeed())
+	report := im.Apply()
+
+	g.Expect(report.String()).To(Equal(
+		`wallet.csv:2  2017-11-01  Wallet  deposit  imported  lots:1
+wallet.csv:3  2017-11-02  Wallet  trade    imported  lots:1.1
+wallet.csv:4  2017-11-03  Wallet  send     imported  lots:1.1.1,1.1.1.spendCapitalGains,1.1.1.spendCapitalGains.1  in transit until received in another account
+wallet.csv:5  2017-11-04  Wallet  ignore   skipped                                                                 nothing sent or received
+wallet.csv:6  2017-11-05  Wallet  deposit  skipped                                                                 no matching send of 1 BTC from another account
+(imported:3 skipped:2 failed:0)
+`))
+}
+
+func TestMappingErrors(t *testing.T) {
+	for _, tc := range []struct {
+		mapping, want string
+	}{
+		{"", "invalid mapping: it's empty"},
+		{"columns: {date: Time, when: Time}", "invalid mapping: yaml: unmarshal errors:\n  line 1: field when not found in type importer.MappingColumns"},
+		{"columns: {sentAmount: Amount, sentCurrency: Asset}\naccount: A", "invalid mapping: missing the date column"},
+		{"columns: {date: Time, sentAmount: Amount, sentCurrency: Asset}", "invalid mapping: missing the account column, or the account for all of the rows"},
+		{"columns: {date: Time, sentAmount: Amount}\naccount: A", "invalid mapping: the sent amount and currency columns go together"},
+		{"columns: {date: Time, type: Kind, sentAmount: Amount, sentCurrency: Asset}\naccount: A", "invalid mapping: there's a type column, but no types"},
+		{"columns: {date: Time, type: Kind, sentAmount: Amount, sentCurrency: Asset}\naccount: A\ntypes: {Buy: purchase}",
+			`invalid mapping: unknown operation type "purchase"`},
+	} {
+		g := NewGomegaWithT(t)
+		_, err := importer.LoadMapping(strings.NewReader(tc.mapping))
+		g.Expect(err).To(MatchError(tc.want), tc.mapping)
+	}
+
+	g := NewGomegaWithT(t)
+	mapping, err := importer.LoadMapping(strings.NewReader(mappingYAML))
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, tc := range []struct {
+		csv, want string
+	}{
+		{"Date,Kind\n", "some.csv:1: couldn't find the header row"},
+		{"Time,Kind,From,From Amount\n", `some.csv:1: missing column "Wallet"`},
+		{"Time,Kind,From,From Amount,To,To Amount,Fee,Fee Currency,Wallet\n2017-11-01 09:00,Buy,,-1000,XBT,0.14,5,USD,A\n",
+			`some.csv:2: missing the currency in column "From"`},
+	} {
+		im := importer.New(ledger.New(USD, prices), importer.Options{})
+		g.Expect(im.ReadMapped(strings.NewReader(tc.csv), "some.csv", mapping)).To(MatchError(tc.want), tc.csv)
+	}
+}
@@ -0,0 +1,86 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+type (
+	// Report describes what became of each operation applied by an Importer, in the order they were applied.
+	Report struct {
+		Results []Result
+	}
+
+	// Result describes what became of an operation.
+	Result struct {
+		Operation Operation
+		Status    Status
+		// LotNames are the names of the lots created for the operation.
+		LotNames []string
+		// Message explains why the operation was skipped or failed, or notes how it was recorded.
+		Message string
+	}
+
+	// Status is the outcome of applying an operation.
+	Status int
+)
+
+const (
+	// Imported means the operation was recorded in the ledger.
+	Imported Status = iota
+	// Skipped means the operation doesn't affect the ledger, or couldn't be matched with another (e.g. a receive).
+	Skipped
+	// Failed means the ledger rejected the operation, and it wasn't recorded.
+	Failed
+)
+
+var statusNames = map[Status]string{
+	Imported: "imported",
+	Skipped:  "skipped",
+	Failed:   "failed",
+}
+
+// String returns the name of the status, e.g. "imported".
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Count returns the number of operations with the given status.
+func (r *Report) Count(status Status) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// String prints a line for each operation, followed by a summary.
+func (r *Report) String() string {
+	b := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	for _, result := range r.Results {
+		op := result.Operation
+		fmt.Fprintf(tw, "%s:%d\t%s\t%s\t%s\t%s", op.File, op.Line, op.Date.Format("2006-01-02"), op.Account, op.RowType, result.Status)
+		if len(result.LotNames) > 0 {
+			fmt.Fprintf(tw, "\tlots:%s", strings.Join(result.LotNames, ","))
+		} else {
+			fmt.Fprint(tw, "\t")
+		}
+		if result.Message != "" {
+			fmt.Fprintf(tw, "\t%s", result.Message)
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		panic(err.Error())
+	}
+	fmt.Fprintf(b, "(imported:%d skipped:%d failed:%d)\n", r.Count(Imported), r.Count(Skipped), r.Count(Failed))
+	return b.String()
+}
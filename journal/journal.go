@@ -0,0 +1,461 @@
+// Package journal reads a plain-text journal of activity, and replays it into a ledger.Ledger.
+//
+// A journal has one dated directive per line, each calling the Ledger method of the same name:
+//
+//	; comments start with ';' or '#'
+//	2017-04-06 deposit Bitfinex 960 USD basis 1085        ; basis defaults to the amount deposited
+//	2017-04-06 purchase 1 0.83976678 BTC cost 960         ; optionally "to ACCOUNT", defaults to the lot's account
+//	2017-11-01 transfer 1.1 0.8 BTC fee 0.001 to Coinbase ; the fee is optional
+//	2017-11-02 exchange 1.1.1 0.1 BTC fee 0.0001 for 2.3 ETH priced-by ETH
+//	2017-11-03 sell 1.1.1 0.2 BTC for 1372.01 USD
+//	2017-11-04 spend 1.1.1 0.001 BTC "coffee"             ; optionally "from ACCOUNT", defaults to the lot's account
+//	2017-11-05 fee 1.1.1 0.0001 BTC on 1.1 "wallet fee"
+//	2017-11-06 income Coinbase 0.01 ETH value 2.92 "staking reward"
+//	2017-11-07 merge BTC 1.3.1 2.1 3.1                    ; the date is the lots' purchase date
+//	2025-01-01 allocate pro-rata                          ; the unused basis, as of the date, or "keep-lots"
+//
+// Amounts are followed by their currency; the local currency may be left out of amounts that are always in it.
+// Account names and notes containing spaces are quoted with double quotes. An exchange is valued with the price of the
+// currency sold, unless "priced-by" names the currency purchased.
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// DateLayouts are the layouts a directive's date may be in. Dates without a time zone are taken to be UTC.
+var DateLayouts = []string{"2006-01-02", "2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05"}
+
+// Entry is a directive read from a journal.
+type Entry struct {
+	File      string
+	Line      int
+	Date      time.Time
+	Directive string
+
+	apply func(l *ledger.Ledger) error
+}
+
+// Apply records the entry in the ledger. Errors are *ledger.LineError values locating the entry.
+func (e Entry) Apply(l *ledger.Ledger) error {
+	if err := e.apply(l); err != nil {
+		return &ledger.LineError{File: e.File, Line: e.Line, Err: err}
+	}
+	return nil
+}
+
+// Replay parses the journal and applies its entries to the ledger in the order they appear, stopping at the first error.
+// Errors are *ledger.LineError values.
+func Replay(r io.Reader, file string, l *ledger.Ledger) error {
+	entries, err := Parse(r, file, l.LocalCurrency())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := entry.Apply(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayFiles replays the journal files into the ledger, one after the other.
+func ReplayFiles(l *ledger.Ledger, paths ...string) error {
+	for _, path := range paths {
+		if err := replayFile(l, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayFile(l *ledger.Ledger, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Replay(f, path, l)
+}
+
+// Parse reads the entries of a journal, without applying them. The local currency is the currency of amounts which
+// leave it out. Errors are *ledger.LineError values.
+func Parse(r io.Reader, file string, localCurrency ledger.Currency) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for i, text := range strings.Split(string(data), "\n") {
+		tokens, err := tokenize(text)
+		if err == nil && len(tokens) == 0 {
+			continue
+		}
+		var entry Entry
+		if err == nil {
+			entry, err = parseEntry(tokens, localCurrency)
+		}
+		if err != nil {
+			return nil, &ledger.LineError{File: file, Line: i + 1, Err: err}
+		}
+		entry.File, entry.Line = file, i+1
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// tokenize splits a line into whitespace-separated tokens, unquoting quoted ones and dropping any comment.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		switch {
+		case line == "" || line[0] == ';' || line[0] == '#':
+			return tokens, nil
+
+		case line[0] == '"':
+			end := 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated quoted string")
+			}
+			token, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[:end+1])
+			}
+			tokens = append(tokens, token)
+			line = line[end+1:]
+
+		default:
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end < 0 {
+				end = len(line)
+			}
+			tokens = append(tokens, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+func parseEntry(tokens []string, localCurrency ledger.Currency) (Entry, error) {
+	var (
+		entry Entry
+		err   error
+	)
+	if entry.Date, err = parseDate(tokens[0]); err != nil {
+		return entry, err
+	}
+	if len(tokens) < 2 {
+		return entry, errors.New("missing the directive after the date")
+	}
+	entry.Directive = tokens[1]
+	parse, ok := directives[entry.Directive]
+	if !ok {
+		return entry, fmt.Errorf("unknown directive %q", entry.Directive)
+	}
+	a := &args{directive: entry.Directive, tokens: tokens[2:], localCurrency: localCurrency}
+	entry.apply = parse(entry.Date, a)
+	if a.err == nil && len(a.tokens) > 0 {
+		a.err = fmt.Errorf("unexpected %q at the end of %s", a.tokens[0], entry.Directive)
+	}
+	return entry, a.err
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range DateLayouts {
+		if date, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return date.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// directives parse the arguments of each directive, returning a function that applies it to a ledger.
+var directives = map[string]func(date time.Time, a *args) func(l *ledger.Ledger) error{
+	"deposit": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		account := a.account()
+		amount := a.local()
+		basis := amount
+		if a.keyword("basis") {
+			basis = a.local()
+		}
+		return func(l *ledger.Ledger) error {
+			_, err := l.DepositNewMoney(date, account, amount, basis)
+			return err
+		}
+	},
+
+	"purchase": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		fromLot := a.word("lot name")
+		amount, currency := a.amount()
+		a.expect("cost")
+		cost := a.local()
+		var toAccount ledger.Account
+		if a.keyword("to") {
+			toAccount = a.account()
+		}
+		return func(l *ledger.Ledger) error {
+			lot, err := l.FindLotByName(fromLot, l.LocalCurrency())
+			if err != nil {
+				return err
+			}
+			to := lot.Account()
+			if toAccount != "" {
+				to = toAccount
+			}
+			_, err = l.Purchase(date, fromLot, to, currency, amount, cost)
+			return err
+		}
+	},
+
+	"transfer": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		fromLot := a.word("lot name")
+		amount, currency := a.amount()
+		fee := a.fee(currency)
+		a.expect("to")
+		toAccount := a.account()
+		return func(l *ledger.Ledger) error {
+			_, err := l.Transfer(date, fromLot, currency, amount, fee, toAccount)
+			return err
+		}
+	},
+
+	"exchange": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		fromLot := a.word("lot name")
+		soldAmount, sold := a.amount()
+		fee := a.fee(sold)
+		a.expect("for")
+		purchasedAmount, purchased := a.amount()
+		lookupSoldPrice := true
+		if a.keyword("priced-by") {
+			switch pricedBy := a.currency(); pricedBy {
+			case sold:
+			case purchased:
+				lookupSoldPrice = false
+			default:
+				a.fail("priced-by %s must be %s or %s", pricedBy, sold, purchased)
+			}
+		}
+		return func(l *ledger.Ledger) error {
+			_, err := l.ExchangeTaxable(date, fromLot, sold, soldAmount, fee, lookupSoldPrice, purchased, purchasedAmount)
+			return err
+		}
+	},
+
+	"sell": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		fromLot := a.word("lot name")
+		amount, currency := a.amount()
+		a.expect("for")
+		proceeds := a.local()
+		return func(l *ledger.Ledger) error {
+			_, err := l.SellTaxable(date, fromLot, currency, amount, proceeds)
+			return err
+		}
+	},
+
+	"spend": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		fromLot := a.word("lot name")
+		amount, currency := a.amount()
+		var account ledger.Account
+		if a.keyword("from") {
+			account = a.account()
+		}
+		note := a.note()
+		return func(l *ledger.Ledger) error {
+			lot, err := l.FindLotByName(fromLot, currency)
+			if err != nil {
+				return err
+			}
+			from := lot.Account()
+			if account != "" {
+				from = account
+			}
+			_, err = l.Spend(date, from, fromLot, currency, amount, note)
+			return err
+		}
+	},
+
+	"fee": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		fromLot := a.word("lot name")
+		amount, currency := a.amount()
+		a.expect("on")
+		appliedTo := a.word("lot name")
+		note := a.note()
+		return func(l *ledger.Ledger) error {
+			return l.Fee(date, fromLot, currency, amount, appliedTo, note)
+		}
+	},
+
+	"income": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		account := a.account()
+		amount, currency := a.amount()
+		a.expect("value")
+		value := a.local()
+		note := a.note()
+		return func(l *ledger.Ledger) error {
+			_, err := l.Income(date, account, currency, amount, value, note)
+			return err
+		}
+	},
+
+	"allocate": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		var method ledger.BasisAllocationMethod
+		if name := a.word("allocation method"); a.err == nil && method.UnmarshalText([]byte(name)) != nil {
+			a.fail("unknown allocation method %q, expected pro-rata or keep-lots", name)
+		}
+		return func(l *ledger.Ledger) error {
+			_, err := l.AllocateUnusedBasis(date, method)
+			return err
+		}
+	},
+
+	"merge": func(date time.Time, a *args) func(l *ledger.Ledger) error {
+		currency := a.currency()
+		var lots []string
+		for len(a.tokens) > 0 {
+			lots = append(lots, a.word("lot name"))
+		}
+		if len(lots) == 0 {
+			a.fail("missing the lots to merge")
+		}
+		return func(l *ledger.Ledger) error {
+			_, err := l.MergeIdenticalLots(date, currency, lots)
+			return err
+		}
+	},
+}
+
+// args reads the arguments of a directive. After the first problem, err is set and the rest are zero values.
+type args struct {
+	directive     string
+	tokens        []string
+	localCurrency ledger.Currency
+	err           error
+}
+
+func (a *args) fail(format string, values ...interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf(format, values...)
+	}
+}
+
+// word returns the next argument, described by what for the error if it's missing.
+func (a *args) word(what string) string {
+	if a.err != nil {
+		return ""
+	}
+	if len(a.tokens) == 0 {
+		a.fail("missing the %s for %s", what, a.directive)
+		return ""
+	}
+	word := a.tokens[0]
+	a.tokens = a.tokens[1:]
+	return word
+}
+
+// keyword consumes the next argument if it's the given keyword.
+func (a *args) keyword(keyword string) bool {
+	if a.err != nil || len(a.tokens) == 0 || a.tokens[0] != keyword {
+		return false
+	}
+	a.tokens = a.tokens[1:]
+	return true
+}
+
+func (a *args) expect(keyword string) {
+	if a.err == nil && !a.keyword(keyword) {
+		if len(a.tokens) == 0 {
+			a.fail("missing %q for %s", keyword, a.directive)
+		} else {
+			a.fail("expected %q instead of %q for %s", keyword, a.tokens[0], a.directive)
+		}
+	}
+}
+
+func (a *args) account() ledger.Account {
+	return ledger.Account(a.word("account"))
+}
+
+func (a *args) currency() ledger.Currency {
+	word := a.word("currency")
+	if a.err == nil && !isCurrency(word) {
+		a.fail("invalid currency %q", word)
+	}
+	return ledger.Currency(word)
+}
+
+func (a *args) decimal() ledger.Decimal {
+	word := a.word("amount")
+	if a.err != nil {
+		return ledger.Decimal{}
+	}
+	d, err := ledger.ParseDecimal(word)
+	if err != nil {
+		a.fail("invalid amount %q", word)
+	}
+	return d
+}
+
+// amount reads an amount followed by its currency.
+func (a *args) amount() (ledger.Decimal, ledger.Currency) {
+	return a.decimal(), a.currency()
+}
+
+// local reads an amount of the local currency, optionally followed by the currency.
+func (a *args) local() ledger.Decimal {
+	d := a.decimal()
+	if a.err == nil && len(a.tokens) > 0 && isCurrency(a.tokens[0]) {
+		if currency := a.currency(); currency != a.localCurrency {
+			a.fail("%s must be in %s, not %s", d, a.localCurrency, currency)
+		}
+	}
+	return d
+}
+
+// fee reads an optional fee, in the given currency if it's followed by one.
+func (a *args) fee(currency ledger.Currency) ledger.Decimal {
+	if !a.keyword("fee") {
+		return ledger.Decimal{}
+	}
+	fee := a.decimal()
+	if a.err == nil && len(a.tokens) > 0 && isCurrency(a.tokens[0]) {
+		if feeCurrency := a.currency(); feeCurrency != currency {
+			a.fail("the fee must be in %s, not %s", currency, feeCurrency)
+		}
+	}
+	return fee
+}
+
+// note reads an optional note at the end of the directive.
+func (a *args) note() string {
+	if a.err != nil || len(a.tokens) == 0 {
+		return ""
+	}
+	return a.word("note")
+}
+
+// isCurrency returns true for currency symbols, which are upper case letters and digits, e.g. "BTC" or "USDT".
+func isCurrency(s string) bool {
+	if s == "" || !unicode.IsUpper(rune(s[0])) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsUpper(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package journal_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+	"github.com/slatteryjim/cost-basis-tracking/journal"
+)
+
+const (
+	USD = ledger.Currency("USD")
+
+	BCH  = ledger.Currency("BCH")
+	BTC  = ledger.Currency("BTC")
+	BTG  = ledger.Currency("BTG")
+	DASH = ledger.Currency("DASH")
+	ETH  = ledger.Currency("ETH")
+
+	Bitfinex = ledger.Account("Bitfinex")
+	Coinbase = ledger.Account("Coinbase")
+)
+
+var historicalPrices = ledger.PriceMap{
+	BTC: {
+		d("2017-11-01"): n("6767.31"),
+		d("2017-11-02"): n("6960.07"),
+		d("2017-12-01"): n("10975.60"),
+	},
+	ETH: {
+		d("2017-11-01"): n("291.69"),
+	},
+}
+
+const largerScenarioJournal = `
+; put up some money to invest, with $85 wire transfer and $40 deposit fees
+2017-04-06 deposit  Bitfinex 960 USD basis 1085
+2017-04-06 purchase 1 0.41988338 BTC cost 504.35 ; including the $1 trade fee
+2017-04-06 purchase 1 9.2000 ETH cost 228.33 to Bitfinex
+2017-04-06 purchase 1 4.000 DASH cost 227.32
+
+2017-08-01 income Bitfinex 0.35853168 BCH value 212.25 "fork from BTC"
+2017-10-23 income Bitfinex 0.41988338 BTG value 57.39  "fork from BTC"
+
+2017-11-01 transfer 1.1 0.41988338 BTC fee 0.001 to Coinbase
+2017-11-01 transfer 1.2 9.2000 ETH fee 0.01 ETH to Coinbase
+
+# exchanged for BTC, after the BTC fees
+2017-11-02 exchange 1.3 4.000 DASH for 0.15014768 BTC priced-by BTC
+2017-11-02 exchange 2 0.35853168 BCH fee 0 for 0.02764547 BTC priced-by BTC
+2017-11-02 exchange 3 0.41988338 BTG for 0.00673906 BTC priced-by BTC
+2017-11-02 merge BTC 1.3.1 2.1 3.1
+
+2017-11-01 transfer 4 0.18453221 BTC fee 0.0005 to Coinbase
+2017-12-01 fee 1.1.1 0.00001 BTC on 1.1.1 "some random fee"
+2017-12-01 spend 1.1.1 0.0001 BTC "coffee"
+2017-12-01 sell 1.2.1 1 ETH for 450.00 USD
+`
+
+// largerScenarioLedger records the same activity as largerScenarioJournal, through the Ledger's methods.
+func largerScenarioLedger() *ledger.Ledger {
+	l := ledger.New(USD, historicalPrices)
+	l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085"))
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.41988338"), n("504.35"))
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, ETH, n("9.2000"), n("228.33"))
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, DASH, n("4.000"), n("227.32"))
+	l.Income(d("2017-08-01"), Bitfinex, BCH, n("0.35853168"), n("212.25"), "fork from BTC")
+	l.Income(d("2017-10-23"), Bitfinex, BTG, n("0.41988338"), n("57.39"), "fork from BTC")
+	l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.41988338"), n("0.001"), Coinbase)
+	l.Transfer(d("2017-11-01"), "1.2", ETH, n("9.2000"), n("0.01"), Coinbase)
+	l.ExchangeTaxable(d("2017-11-02"), "1.3", DASH, n("4.000"), n("0"), false, BTC, n("0.15014768"))
+	l.ExchangeTaxable(d("2017-11-02"), "2", BCH, n("0.35853168"), n("0"), false, BTC, n("0.02764547"))
+	l.ExchangeTaxable(d("2017-11-02"), "3", BTG, n("0.41988338"), n("0"), false, BTC, n("0.00673906"))
+	l.MergeIdenticalLots(d("2017-11-02"), BTC, []string{"1.3.1", "2.1", "3.1"})
+	l.Transfer(d("2017-11-01"), "4", BTC, n("0.18453221"), n("0.0005"), Coinbase)
+	l.Fee(d("2017-12-01"), "1.1.1", BTC, n("0.00001"), "1.1.1", "some random fee")
+	l.Spend(d("2017-12-01"), Coinbase, "1.1.1", BTC, n("0.0001"), "coffee")
+	l.SellTaxable(d("2017-12-01"), "1.2.1", ETH, n("1"), n("450.00"))
+	return l
+}
+
+func TestReplay(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	g.Expect(journal.Replay(strings.NewReader(largerScenarioJournal), "books.journal", l)).To(Succeed())
+
+	want := largerScenarioLedger()
+	g.Expect(l.PrintLots()).To(Equal(want.PrintLots()))
+	g.Expect(l.PrintTransactions()).To(Equal(want.PrintTransactions()))
+	g.Expect(l.Transactions()).To(HaveLen(16))
+}
+
+func TestReplayAllocation(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	g.Expect(journal.Replay(strings.NewReader(largerScenarioJournal+"2025-01-01 allocate pro-rata\n"), "books.journal", l)).To(Succeed())
+
+	want := largerScenarioLedger()
+	_, err := want.AllocateUnusedBasis(d("2025-01-01"), ledger.ProRataAllocation)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(l.PrintLots()).To(Equal(want.PrintLots()))
+	g.Expect(l.BasisAllocations()).To(Equal(want.BasisAllocations()))
+}
+
+func TestReplayFiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "2017-1.journal"), filepath.Join(dir, "2017-2.journal")
+	g.Expect(os.WriteFile(first, []byte("2017-04-06 deposit Bitfinex 960 basis 1085 USD\n"), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(second, []byte("2017-04-06 purchase 1 0.41988338 BTC cost 504.35\n\n2017-04-07 purchase 1 1 ETH cost 500\n"), 0644)).To(Succeed())
+
+	l := ledger.New(USD, historicalPrices)
+	err := journal.ReplayFiles(l, first, second)
+	g.Expect(err).To(MatchError(second + ":3: lot 1 has insufficient USD: requested 500, available 455.65"))
+	var lineErr *ledger.LineError
+	g.Expect(errors.As(err, &lineErr)).To(BeTrue())
+	g.Expect(lineErr.Line).To(Equal(3))
+
+	// the entries before the error were recorded
+	g.Expect(l.PrintLots()).To(ContainSubstring("1.1  2017-04-06 Bitfinex BTC 0.419883380"))
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line, want string
+	}{
+		{"April 6th deposit Bitfinex 960", `invalid date "April"`},
+		{"2017-04-06", "missing the directive after the date"},
+		{"2017-04-06 withdraw Bitfinex 960", `unknown directive "withdraw"`},
+		{"2017-04-06 deposit Bitfinex", "missing the amount for deposit"},
+		{"2017-04-06 deposit Bitfinex lots", `invalid amount "lots"`},
+		{"2017-04-06 deposit Bitfinex 960 EUR", "960 must be in USD, not EUR"},
+		{"2017-04-06 deposit Bitfinex 960 cost 1085", `unexpected "cost" at the end of deposit`},
+		{"2017-04-06 purchase 1 0.5 btc cost 500", `invalid currency "btc"`},
+		{"2017-04-06 purchase 1 0.5 BTC for 500", `expected "cost" instead of "for" for purchase`},
+		{"2017-11-01 transfer 1.1 0.4 BTC fee 0.001", `missing "to" for transfer`},
+		{"2017-11-01 transfer 1.1 0.4 BTC fee 0.001 ETH to Coinbase", "the fee must be in BTC, not ETH"},
+		{"2017-11-02 exchange 1.3 4 DASH for 0.15 BTC priced-by ETH", "priced-by ETH must be DASH or BTC"},
+		{"2017-11-02 merge BTC", "missing the lots to merge"},
+		{"2025-01-01 allocate evenly", `unknown allocation method "evenly", expected pro-rata or keep-lots`},
+		{`2017-12-01 spend 1.1.1 0.0001 BTC "coffee`, "unterminated quoted string"},
+	} {
+		g := NewGomegaWithT(t)
+		_, err := journal.Parse(strings.NewReader("; books\n"+tc.line), "books.journal", USD)
+		g.Expect(err).To(MatchError("books.journal:2: "+tc.want), tc.line)
+	}
+}
+
+func TestParse(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	entries, err := journal.Parse(strings.NewReader(`2017-12-01T15:04:05Z income "Cold Storage" 0.1 BTC value 1097.56 "a \"gift\""`), "books.journal", USD)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entries).To(HaveLen(1))
+	g.Expect(entries[0].Directive).To(Equal("income"))
+	g.Expect(entries[0].Date).To(Equal(time.Date(2017, 12, 1, 15, 4, 5, 0, time.UTC)))
+
+	l := ledger.New(USD, nil)
+	g.Expect(entries[0].Apply(l)).To(Succeed())
+	g.Expect(l.PrintLots()).To(Equal("1  2017-12-01 Cold Storage BTC 0.100000000  (basis:$1097.560000  price:$10975.600000)\n"))
+	tx, ok := l.TransactionForLot("1")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(tx.Note).To(Equal(`a "gift"`))
+}
+
+func n(s string) ledger.Decimal {
+	return ledger.MustParseDecimal(s)
+}
+
+func d(date string) time.Time {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
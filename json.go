@@ -0,0 +1,324 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FileVersion is the version of the JSON schema written by Save and Ledger.MarshalJSON.
+// Files written by older versions are upgraded as they're loaded.
+//
+// Version 2 added the cost basis method, the share matches and superficial losses it records, the tax rules, the
+// lot tracking changes and the basis allocations. They change how the lots are read, so older code rejects these
+// files rather than misreading them.
+const FileVersion = 2
+
+type (
+	ledgerJSON struct {
+		Version           int                       `json:"version"`
+		LocalCurrency     Currency                  `json:"localCurrency"`
+		Precisions        map[Currency]int32        `json:"precisions,omitempty"`
+		WashSaleRules     map[Currency]WashSaleRule `json:"washSaleRules,omitempty"`
+		CostBasisMethod   CostBasisMethod           `json:"costBasisMethod,omitempty"`
+		TaxRules          string                    `json:"taxRules,omitempty"`
+		LotTracking       []LotTrackingChange       `json:"lotTracking,omitempty"`
+		SequenceGenerator int                       `json:"sequenceGenerator"`
+		Lots              []*Lot                    `json:"lots"`
+		Transactions      []*Transaction            `json:"transactions"`
+		BasisAllocations  []BasisAllocation         `json:"basisAllocations,omitempty"`
+	}
+
+	lotJSON struct {
+		Name                   string               `json:"name"`
+		Parent                 string               `json:"parent,omitempty"`
+		Type                   LotType              `json:"type"`
+		Account                Account              `json:"account,omitempty"`
+		Currency               Currency             `json:"currency"`
+		OriginalPurchaseTime   time.Time            `json:"originalPurchaseTime"`
+		OriginalPurchaseAmount Decimal              `json:"originalPurchaseAmount"`
+		OriginalCostBasis      Decimal              `json:"originalCostBasis"`
+		TaxableGainsDetails    *TaxableGainsDetails `json:"taxableGainsDetails,omitempty"`
+		Amount                 Decimal              `json:"amount"`
+		CostBasis              Decimal              `json:"costBasis"`
+		SequenceGenerator      int                  `json:"sequenceGenerator,omitempty"`
+		WashSaleOf             string               `json:"washSaleOf,omitempty"`
+		WashSaleLoss           *Decimal             `json:"washSaleLoss,omitempty"`
+	}
+
+	taxableGainsDetailsJSON struct {
+		Account              Account      `json:"account"`
+		Currency             Currency     `json:"currency"`
+		OriginalPurchaseTime time.Time    `json:"originalPurchaseTime"`
+		CostBasis            Decimal      `json:"costBasis"`
+		LotCostBasis         *Decimal     `json:"lotCostBasis,omitempty"`
+		DateOfSale           time.Time    `json:"dateOfSale"`
+		Proceeds             Decimal      `json:"proceeds"`
+		SoldAmount           Decimal      `json:"soldAmount"`
+		Note                 string       `json:"note,omitempty"`
+		PricePath            []Currency   `json:"pricePath,omitempty"`
+		WashSaleReplaced     *Decimal     `json:"washSaleReplaced,omitempty"`
+		WashSaleDisallowed   *Decimal     `json:"washSaleDisallowed,omitempty"`
+		ShareMatches         []ShareMatch `json:"shareMatches,omitempty"`
+		SuperficialLoss      *Decimal     `json:"superficialLoss,omitempty"`
+	}
+)
+
+var lotTypeNames = enumNames[LotType]{
+	Asset:        "asset",
+	AssetIncome:  "assetIncome",
+	TaxableGains: "taxableGains",
+}
+
+// Save writes the ledger to w as JSON, see MarshalJSON.
+func (l *Ledger) Save(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(l)
+}
+
+// Load reads a ledger written by Save.
+// The historical prices aren't part of the file, so they're provided here just like for New.
+func Load(r io.Reader, prices PriceSource) (*Ledger, error) {
+	l := &Ledger{}
+	if err := json.NewDecoder(r).Decode(l); err != nil {
+		return nil, err
+	}
+	l.prices = prices
+	return l, nil
+}
+
+// MarshalJSON encodes the ledger's lots, transactions and lot naming state, along with the FileVersion.
+// The historical prices are reference data, and aren't included.
+func (l *Ledger) MarshalJSON() ([]byte, error) {
+	var taxRules string
+	if l.taxRules != nil {
+		taxRules = l.taxRules.Name()
+	}
+	return json.Marshal(ledgerJSON{
+		Version:           FileVersion,
+		LocalCurrency:     l.localCurrency,
+		Precisions:        l.precisions,
+		WashSaleRules:     l.washSaleRules,
+		CostBasisMethod:   l.costBasisMethod,
+		TaxRules:          taxRules,
+		LotTracking:       l.lotTracking,
+		SequenceGenerator: l.sequenceGenerator,
+		Lots:              l.lots,
+		Transactions:      l.transactions,
+		BasisAllocations:  l.basisAllocations,
+	})
+}
+
+// UnmarshalJSON decodes a ledger encoded by MarshalJSON, relinking each lot to its parent.
+// It has no historical prices; see Load.
+func (l *Ledger) UnmarshalJSON(data []byte) error {
+	var v ledgerJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Version < 1 || v.Version > FileVersion {
+		return fmt.Errorf("unsupported ledger file version %d (this version of the code reads up to %d)", v.Version, FileVersion)
+	}
+	// Upgrades from older versions go here, as the schema evolves.
+	taxRules, err := loadTaxRules(v.TaxRules)
+	if err != nil {
+		return err
+	}
+
+	// Lot.UnmarshalJSON leaves a placeholder parent holding just the name.
+	// Parents always precede their children, and names are reused as lots are updated,
+	// so link to the latest lot with that name.
+	latest := map[string]*Lot{}
+	for _, lot := range v.Lots {
+		if lot.parent != nil {
+			parent, ok := latest[lot.parent.name]
+			if !ok {
+				return fmt.Errorf("lot %s: couldn't find parent lot %s", lot.name, lot.parent.name)
+			}
+			lot.parent = parent
+		}
+		latest[lot.name] = lot
+	}
+
+	if v.Precisions == nil {
+		v.Precisions = map[Currency]int32{}
+	}
+	*l = Ledger{
+		localCurrency:     v.LocalCurrency,
+		precisions:        v.Precisions,
+		washSaleRules:     v.WashSaleRules,
+		costBasisMethod:   v.CostBasisMethod,
+		taxRules:          taxRules,
+		lotTracking:       v.LotTracking,
+		lots:              v.Lots,
+		sequenceGenerator: v.SequenceGenerator,
+		transactions:      v.Transactions,
+		basisAllocations:  v.BasisAllocations,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the lot, referring to its parent by name.
+func (lot *Lot) MarshalJSON() ([]byte, error) {
+	v := lotJSON{
+		Name:                   lot.name,
+		Type:                   lot.lotType,
+		Account:                lot.account,
+		Currency:               lot.currency,
+		OriginalPurchaseTime:   lot.originalPurchaseTime,
+		OriginalPurchaseAmount: lot.originalPurchaseAmount,
+		OriginalCostBasis:      lot.originalCostBasis,
+		TaxableGainsDetails:    lot.taxableGainsDetails,
+		Amount:                 lot.amount,
+		CostBasis:              lot.costBasis,
+		SequenceGenerator:      lot.sequenceGenerator,
+		WashSaleOf:             lot.washSaleOf,
+		WashSaleLoss:           nonZero(lot.washSaleLoss),
+	}
+	if lot.parent != nil {
+		v.Parent = lot.parent.name
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a lot encoded by MarshalJSON.
+// On its own it can't find the parent lot, so the parent is a placeholder with just the name,
+// until Ledger.UnmarshalJSON links it to the real one.
+func (lot *Lot) UnmarshalJSON(data []byte) error {
+	var v lotJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*lot = Lot{
+		name:                   v.Name,
+		lotType:                v.Type,
+		account:                v.Account,
+		currency:               v.Currency,
+		originalPurchaseTime:   v.OriginalPurchaseTime,
+		originalPurchaseAmount: v.OriginalPurchaseAmount,
+		originalCostBasis:      v.OriginalCostBasis,
+		taxableGainsDetails:    v.TaxableGainsDetails,
+		amount:                 v.Amount,
+		costBasis:              v.CostBasis,
+		sequenceGenerator:      v.SequenceGenerator,
+		washSaleOf:             v.WashSaleOf,
+	}
+	if v.WashSaleLoss != nil {
+		lot.washSaleLoss = *v.WashSaleLoss
+	}
+	if v.Parent != "" {
+		lot.parent = &Lot{name: v.Parent}
+	}
+	if lot.lotType == TaxableGains && lot.taxableGainsDetails == nil {
+		return fmt.Errorf("lot %s: taxable gains lot is missing its details", lot.name)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the taxable gains details.
+func (d *TaxableGainsDetails) MarshalJSON() ([]byte, error) {
+	v := taxableGainsDetailsJSON{
+		Account:              d.account,
+		Currency:             d.currency,
+		OriginalPurchaseTime: d.originalPurchaseTime,
+		CostBasis:            d.costBasis,
+		DateOfSale:           d.dateOfSale,
+		Proceeds:             d.proceeds,
+		SoldAmount:           d.soldAmount,
+		Note:                 d.note,
+		PricePath:            d.pricePath,
+		WashSaleReplaced:     nonZero(d.washSaleReplaced),
+		WashSaleDisallowed:   nonZero(d.washSaleDisallowed),
+		ShareMatches:         d.shareMatches,
+		SuperficialLoss:      nonZero(d.superficialLoss),
+	}
+	if d.lotCostBasis.Cmp(d.costBasis) != 0 {
+		v.LotCostBasis = &d.lotCostBasis
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes taxable gains details encoded by MarshalJSON.
+func (d *TaxableGainsDetails) UnmarshalJSON(data []byte) error {
+	var v taxableGainsDetailsJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*d = *NewTaxableGainsDetails(v.Account, v.Currency, v.OriginalPurchaseTime, v.CostBasis, v.DateOfSale, v.Proceeds, v.SoldAmount, v.Note)
+	if v.LotCostBasis != nil {
+		d.lotCostBasis = *v.LotCostBasis
+	}
+	d.pricePath = v.PricePath
+	if v.WashSaleReplaced != nil {
+		d.washSaleReplaced = *v.WashSaleReplaced
+	}
+	if v.WashSaleDisallowed != nil {
+		d.washSaleDisallowed = *v.WashSaleDisallowed
+	}
+	d.shareMatches = v.ShareMatches
+	if v.SuperficialLoss != nil {
+		d.superficialLoss = *v.SuperficialLoss
+	}
+	return nil
+}
+
+// nonZero returns a pointer to d, or nil if it's zero, so it's left out of the JSON.
+func nonZero(d Decimal) *Decimal {
+	if d.IsZero() {
+		return nil
+	}
+	return &d
+}
+
+// enumNames maps the values of an enum type to their names, for the type's String, MarshalText and UnmarshalText.
+type enumNames[T ~int] map[T]string
+
+// name returns the name of value, or "unknown".
+func (names enumNames[T]) name(value T) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// marshal encodes value by name. kind describes the enum type in errors, e.g. "lot type".
+func (names enumNames[T]) marshal(kind string, value T) ([]byte, error) {
+	name, ok := names[value]
+	if !ok {
+		return nil, fmt.Errorf("unknown %s %d", kind, int(value))
+	}
+	return []byte(name), nil
+}
+
+// unmarshal decodes a name encoded by marshal into value.
+func (names enumNames[T]) unmarshal(kind string, text []byte, value *T) error {
+	for v, name := range names {
+		if name == string(text) {
+			*value = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown %s %q", kind, text)
+}
+
+// MarshalText encodes the LotType by name, e.g. "taxableGains".
+func (t LotType) MarshalText() ([]byte, error) {
+	return lotTypeNames.marshal("lot type", t)
+}
+
+// UnmarshalText decodes a LotType encoded by MarshalText.
+func (t *LotType) UnmarshalText(text []byte) error {
+	return lotTypeNames.unmarshal("lot type", text, t)
+}
+
+// MarshalText encodes the TransactionType by name, e.g. "purchase".
+func (t TransactionType) MarshalText() ([]byte, error) {
+	return transactionTypeNames.marshal("transaction type", t)
+}
+
+// UnmarshalText decodes a TransactionType encoded by MarshalText.
+func (t *TransactionType) UnmarshalText(text []byte) error {
+	return transactionTypeNames.unmarshal("transaction type", text, t)
+}
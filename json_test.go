@@ -0,0 +1,63 @@
+package ledger_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	original := largerScenarioLedger()
+
+	b := &bytes.Buffer{}
+	g.Expect(original.Save(b)).To(Succeed())
+	g.Expect(b.String()).To(HavePrefix("{\n  \"version\": 2,\n"))
+
+	loaded, err := ledger.Load(b, historicalPrices)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// every report comes out the same
+	g.Expect(loaded.PrintLots()).To(Equal(original.PrintLots()))
+	g.Expect(loaded.PrintIncome()).To(Equal(original.PrintIncome()))
+	g.Expect(loaded.PrintTaxableGains()).To(Equal(original.PrintTaxableGains()))
+	g.Expect(loaded.PrintAccounts()).To(Equal(original.PrintAccounts()))
+	g.Expect(loaded.PrintTransactions()).To(Equal(original.PrintTransactions()))
+	g.Expect(loaded.TotalInvestment()).To(Equal(original.TotalInvestment()))
+
+	// and both ledgers carry on naming lots identically
+	for _, l := range []*ledger.Ledger{original, loaded} {
+		_, err := l.DepositNewMoney(d("2017-12-01"), Bitfinex, n("100"), n("100"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Spend(d("2017-12-01"), Coinbase, "1.1.1", BTC, n("0.0001"), "coffee")
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Transfer(d("2017-12-01"), "1.1.1", BTC, n("0.1"), n("0.0001"), Bitfinex)
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(loaded.PrintLots()).To(Equal(original.PrintLots()))
+	g.Expect(loaded.PrintLots()).To(ContainSubstring("\n5 "))
+	g.Expect(loaded.PrintLots()).To(ContainSubstring("\n1.1.1.spendCapitalGains.3 "))
+	g.Expect(loaded.PrintLots()).To(ContainSubstring("\n1.1.1.1 "))
+}
+
+func TestLoadErrors(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := ledger.Load(strings.NewReader(`{"version": 99, "localCurrency": "USD"}`), historicalPrices)
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported ledger file version 99")))
+
+	_, err = ledger.Load(strings.NewReader(`{"version": 1, "localCurrency": "USD", "lots": [
+		{"name": "1.1", "parent": "1", "type": "asset", "currency": "BTC", "amount": "1", "costBasis": "100"}
+	]}`), historicalPrices)
+	g.Expect(err).To(MatchError(ContainSubstring("couldn't find parent lot 1")))
+
+	_, err = ledger.Load(strings.NewReader(`{"version": 1, "localCurrency": "USD", "lots": [
+		{"name": "1", "type": "bogus", "currency": "BTC", "amount": "1", "costBasis": "100"}
+	]}`), historicalPrices)
+	g.Expect(err).To(MatchError(ContainSubstring(`unknown lot type "bogus"`)))
+}
@@ -4,32 +4,43 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
-	"math"
-	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/samber/lo"
 )
 
-// InsignificantAmount is close enough to zero to call it zero.
-// A lot of floating point operations will end up with a number that is
-// basically zero, but with some cruft after 12 or more decimal places.
-// We can use this constant to compare to "zero", for practical purposes.
-const InsignificantAmount = 1e-13
-
 type (
 	// Ledger lets you record financial activity, tracking cost basis lots.
 	Ledger struct {
 		// fixed reference data
-		localCurrency    Currency
-		historicalPrices map[Currency]map[time.Time]float64
+		localCurrency Currency
+		prices        PriceSource
+		precisions    map[Currency]int32
+		washSaleRules map[Currency]WashSaleRule
+		// costBasisMethod is how the cost basis of amounts sold is worked out
+		costBasisMethod CostBasisMethod
+		// taxRules classify the capital gains in the reports, or nil for the default
+		taxRules TaxRules
+		// lotTracking is the changes of lot tracking mode, in date order
+		lotTracking []LotTrackingChange
 
 		// mutable data
 		lots              []*Lot
 		sequenceGenerator int
+		transactions      []*Transaction
+		// basisAllocations are the allocations made by AllocateUnusedBasis
+		basisAllocations []BasisAllocation
+
+		// pending is the transaction being recorded by the operation in progress, if any
+		pending *Transaction
+		// replay is the pooled cost basis method's replay of the first replayed transactions, or nil until it's needed,
+		// see replayCostBasisMethod
+		replay   costBasisReplay
+		replayed int
 	}
 
 	// Currency is a name of a currency, e.g. "USD"
@@ -47,58 +58,227 @@ func (c Currency) String() string { return string(c) }
 // String returns the Account as a string.
 func (a Account) String() string { return string(a) }
 
-// New creates a new Ledger.
-func New(localCurrency Currency, historicalPrices map[Currency]map[time.Time]float64) *Ledger {
+// DefaultPrecisions is the number of decimal places tracked for some well-known currencies.
+var DefaultPrecisions = map[Currency]int32{
+	"USD": 2, "EUR": 2, "GBP": 2, "CAD": 2, "AUD": 2,
+	"BTC": 8, "BCH": 8, "BTG": 8, "LTC": 8, "DASH": 8,
+	"ETH": 18,
+}
+
+// DefaultPrecision is the number of decimal places tracked for currencies missing from DefaultPrecisions.
+const DefaultPrecision = 18
+
+// New creates a new Ledger, which looks up historical prices in the local currency from the given source.
+// The source may be nil if no prices are needed.
+func New(localCurrency Currency, prices PriceSource) *Ledger {
 	return &Ledger{
-		localCurrency:    localCurrency,
-		historicalPrices: historicalPrices,
+		localCurrency: localCurrency,
+		prices:        prices,
+		precisions:    map[Currency]int32{},
+	}
+}
+
+// LocalCurrency returns the currency that cost basis, proceeds and gains are measured in.
+func (l *Ledger) LocalCurrency() Currency {
+	return l.localCurrency
+}
+
+// Value returns the value of the amount of currency on the given date in the local currency,
+// using the ledger's historical prices.
+func (l *Ledger) Value(currency Currency, amount Decimal, date time.Time) (Decimal, error) {
+	value, _, err := l.valueInLocalCurrency(currency, amount, date)
+	return value, err
+}
+
+// SetPrecision overrides the number of decimal places tracked for the given currency.
+// It should be called before recording any activity in that currency.
+func (l *Ledger) SetPrecision(currency Currency, places int32) {
+	l.precisions[currency] = places
+}
+
+// Precision returns the number of decimal places tracked for the given currency.
+// Cost basis, proceeds and gains are tracked with the precision of the local currency.
+func (l *Ledger) Precision(currency Currency) int32 {
+	if places, ok := l.precisions[currency]; ok {
+		return places
+	}
+	if places, ok := DefaultPrecisions[currency]; ok {
+		return places
+	}
+	return DefaultPrecision
+}
+
+// basisPlaces is the number of decimal places tracked for cost basis and proceeds.
+func (l *Ledger) basisPlaces() int32 {
+	return l.Precision(l.localCurrency)
+}
+
+// checkAmount verifies the amount is not negative, and is no more precise than the currency allows.
+func (l *Ledger) checkAmount(currency Currency, amount Decimal) error {
+	if amount.Sign() < 0 {
+		return &InvalidAmountError{Currency: currency, Amount: amount, Reason: "must not be negative"}
+	}
+	if places := l.Precision(currency); amount.HasPlacesBeyond(places) {
+		return &InvalidAmountError{Currency: currency, Amount: amount, Reason: fmt.Sprintf("more than %d decimal places", places)}
+	}
+	return nil
+}
+
+// firstError returns the first non-nil error.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // DepositNewMoney represents new investment added. There may have been transfer/deposit fees involved,
 // so the costBasis may be greater than the amount ultimately deposited.
-func (l *Ledger) DepositNewMoney(date time.Time, account Account, amountLocalCurrency, costBasis float64) {
-	l.lots = append(l.lots, NewLot(nil, l.nameLot(), Asset, date, account, l.localCurrency, amountLocalCurrency, costBasis))
+func (l *Ledger) DepositNewMoney(date time.Time, account Account, amountLocalCurrency, costBasis Decimal) (*Lot, error) {
+	if err := firstError(l.checkAmount(l.localCurrency, amountLocalCurrency), l.checkAmount(l.localCurrency, costBasis)); err != nil {
+		return nil, err
+	}
+	var newLot *Lot
+	err := l.transact(DepositTransaction, date, "", func() error {
+		newLot = NewLot(nil, l.nameLot(), Asset, date, account, l.localCurrency, amountLocalCurrency, costBasis)
+		l.lots = append(l.lots, newLot)
+		return nil
+	})
+	return newLot, err
 }
 
 // Income records a new lot for income.
-func (l *Ledger) Income(date time.Time, account Account, currency Currency, amount float64, cost float64, note string) {
-	// TODO: record the note somewhere
+func (l *Ledger) Income(date time.Time, account Account, currency Currency, amount Decimal, cost Decimal, note string) (*Lot, error) {
+	if err := firstError(l.checkAmount(currency, amount), l.checkAmount(l.localCurrency, cost)); err != nil {
+		return nil, err
+	}
+	var newLot *Lot
+	err := l.transact(IncomeTransaction, date, note, func() error {
+		newLot = NewLot(nil, l.nameLot(), AssetIncome, date, account, currency, amount, cost)
+		l.lots = append(l.lots, newLot)
+		return nil
+	})
+	return newLot, err
+}
 
-	l.lots = append(l.lots, NewLot(nil, l.nameLot(), AssetIncome, date, account, currency, amount, cost))
+// Purchase represents an exchange of localCurrency for some other currency.
+func (l *Ledger) Purchase(date time.Time, fromLotName string, toAccount Account, currency Currency, amount Decimal, cost Decimal) (*Lot, error) {
+	if err := firstError(l.checkAmount(currency, amount), l.checkAmount(l.localCurrency, cost)); err != nil {
+		return nil, err
+	}
+	var newLot *Lot
+	err := l.transact(PurchaseTransaction, date, "", func() (err error) {
+		newLot, err = l.purchase(date, fromLotName, toAccount, currency, amount, cost)
+		return err
+	})
+	return newLot, err
 }
 
-// Purchase represents an exchange of
-func (l *Ledger) Purchase(date time.Time, fromLotName string, toAccount Account, currency Currency, amount float64, cost float64) *Lot {
+func (l *Ledger) purchase(date time.Time, fromLotName string, toAccount Account, currency Currency, amount Decimal, cost Decimal) (*Lot, error) {
 	// find the given lot
-	lot := l.FindLotByName(fromLotName, l.localCurrency)
+	lot, err := l.FindLotByName(fromLotName, l.localCurrency)
+	if err != nil {
+		return nil, err
+	}
 
-	costBasis := lot.Remove(l.localCurrency, cost)
+	costBasis, err := lot.Remove(l.localCurrency, cost, l.basisPlaces())
+	if err != nil {
+		return nil, err
+	}
+	l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: l.localCurrency, Amount: cost, CostBasis: costBasis})
 
 	// create a new lot
 	newLot := NewChildLot(lot, Asset, date, toAccount, currency, amount, costBasis)
 	l.lots = append(l.lots, newLot)
-	return newLot
+	return newLot, nil
 }
 
-// Purchase represents an exchange of
-func (l *Ledger) Fee(date time.Time, fromLotName string, currency Currency, amount float64, applyFeeToCostBasisOfLot string, note string) {
+// PurchaseWithNewMoney deposits new money costing cost, and spends it all on a purchase, recording a deposit and a
+// purchase transaction. If the purchase fails, the deposit is undone as well.
+func (l *Ledger) PurchaseWithNewMoney(date time.Time, account Account, currency Currency, amount Decimal, cost Decimal) (*Lot, error) {
+	if err := firstError(l.checkAmount(currency, amount), l.checkAmount(l.localCurrency, cost)); err != nil {
+		return nil, err
+	}
+	numLots, numTransactions, sequenceGenerator := len(l.lots), len(l.transactions), l.sequenceGenerator
+	deposit, err := l.DepositNewMoney(date, account, cost, cost)
+	if err != nil {
+		return nil, err
+	}
+	newLot, err := l.Purchase(date, deposit.name, account, currency, amount, cost)
+	if err != nil {
+		// the deposit only added its lot and transaction: money of the local currency isn't pooled or averaged
+		l.lots, l.transactions, l.sequenceGenerator = l.lots[:numLots], l.transactions[:numTransactions], sequenceGenerator
+		if l.replayed > numTransactions {
+			l.replayed = numTransactions
+		}
+		return nil, err
+	}
+	return newLot, nil
+}
+
+// Fee records a fee paid from the given lot, adding its value to the cost basis of another lot.
+func (l *Ledger) Fee(date time.Time, fromLotName string, currency Currency, amount Decimal, applyFeeToCostBasisOfLot string, note string) error {
+	if err := l.checkAmount(currency, amount); err != nil {
+		return err
+	}
+	return l.transact(FeeTransaction, date, note, func() error {
+		return l.fee(date, fromLotName, currency, amount, applyFeeToCostBasisOfLot, note)
+	})
+}
+
+func (l *Ledger) fee(date time.Time, fromLotName string, currency Currency, amount Decimal, applyFeeToCostBasisOfLot string, note string) error {
 	// Model the fee as a "sale" for localCurrency, and then record that as capital gains, and
 	//  add it to some other lot's cost basis.
-	feeAppliedToLot := l.findLotByName(applyFeeToCostBasisOfLot)
+	feeAppliedToLot, err := l.findLotByName(applyFeeToCostBasisOfLot)
+	if err != nil {
+		return err
+	}
+	fromLot, err := l.FindLotByName(fromLotName, currency)
+	if err != nil {
+		return err
+	}
+	if err := l.checkWallet("Fee", date, fromLot, feeAppliedToLot.account); err != nil {
+		return err
+	}
 
-	valueInLocalCurrency := l.Spend(date, feeAppliedToLot.account, fromLotName, currency, amount, "fee applied: "+note)
+	valueInLocalCurrency, err := l.spend(date, feeAppliedToLot.account, fromLotName, currency, amount, "fee applied: "+note, true)
+	if err != nil {
+		return err
+	}
 
-	feeAppliedToLot.costBasis += valueInLocalCurrency
+	feeAppliedToLot.costBasis = feeAppliedToLot.costBasis.Add(valueInLocalCurrency)
+	l.recordAdjustment(Posting{LotName: feeAppliedToLot.name, Account: feeAppliedToLot.account,
+		Currency: feeAppliedToLot.currency, CostBasis: valueInLocalCurrency})
+	return nil
 }
 
 // Transfer removes the given amount from the existing lot, and transfers it to a new account (minus the given fee).
 // The new lot has the reduced amount, but preserves the original cost basis.
-func (l *Ledger) Transfer(date time.Time, fromLotName string, currency Currency, amountRemoved, feePaidFromAmount float64, toAccount Account) *Lot {
-	// TODO: use `date` for something.. maybe record a separate Transactions list, associated with multiple lots
+func (l *Ledger) Transfer(date time.Time, fromLotName string, currency Currency, amountRemoved, feePaidFromAmount Decimal, toAccount Account) (*Lot, error) {
+	if err := firstError(l.checkAmount(currency, amountRemoved), l.checkAmount(currency, feePaidFromAmount)); err != nil {
+		return nil, err
+	}
+	var newLot *Lot
+	err := l.transact(TransferTransaction, date, "", func() (err error) {
+		newLot, err = l.transfer(date, fromLotName, currency, amountRemoved, feePaidFromAmount, toAccount)
+		return err
+	})
+	return newLot, err
+}
+
+func (l *Ledger) transfer(date time.Time, fromLotName string, currency Currency, amountRemoved, feePaidFromAmount Decimal, toAccount Account) (*Lot, error) {
 	// find the given lot
-	lot := l.FindLotByName(fromLotName, currency)
-	costBasis := lot.Remove(currency, amountRemoved)
+	lot, err := l.FindLotByName(fromLotName, currency)
+	if err != nil {
+		return nil, err
+	}
+	costBasis, err := lot.Remove(currency, amountRemoved, l.basisPlaces())
+	if err != nil {
+		return nil, err
+	}
+	l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: currency, Amount: amountRemoved, CostBasis: costBasis})
 
 	// create a new lot
 	newLot := NewChildLot(lot, Asset, lot.originalPurchaseTime, toAccount, currency, amountRemoved, costBasis)
@@ -107,73 +287,117 @@ func (l *Ledger) Transfer(date time.Time, fromLotName string, currency Currency,
 	// "Spend" the feePaidFromAmount.
 	// We treat it as a "sale" for localCurrency, and then record it as capital gains,
 	// and add the amount to the new lot's cost basis.
-	valueInLocalCurrency := l.Spend(date, lot.account, newLot.name, currency, feePaidFromAmount,
-		fmt.Sprintf("fee for transferring from %s to %s", lot.account, toAccount))
-	newLot.costBasis += valueInLocalCurrency
+	valueInLocalCurrency, err := l.spend(date, lot.account, newLot.name, currency, feePaidFromAmount,
+		fmt.Sprintf("fee for transferring from %s to %s", lot.account, toAccount), true)
+	if err != nil {
+		return nil, err
+	}
+	newLot.costBasis = newLot.costBasis.Add(valueInLocalCurrency)
 
-	return newLot
+	return newLot, nil
 }
 
 // TransferMultipleLots removes amounts from the existing lots, in order, transfers them to a new account,
 // spreading the given fee proportionally across the amount removed from each lot.
 // Each new lot has the reduced amount, but preserves the original cost basis.
-func (l *Ledger) TransferMultipleLots(date time.Time, fromLotNames []string, currency Currency, totalAmountToMove, feePaidFromAmount float64, toAccount Account) []*Lot {
-	var (
-		newLots []*Lot
-
-		remainingToTransfer = totalAmountToMove
-	)
-	// find the given lots
-	for _, name := range fromLotNames {
-		lot := l.FindLotByName(name, currency)
-		if remainingToTransfer <= InsignificantAmount {
-			panic("There's nothing left to remove from this lot: " + lot.String())
-		}
+func (l *Ledger) TransferMultipleLots(date time.Time, fromLotNames []string, currency Currency, totalAmountToMove, feePaidFromAmount Decimal, toAccount Account) ([]*Lot, error) {
+	if err := firstError(l.checkAmount(currency, totalAmountToMove), l.checkAmount(currency, feePaidFromAmount)); err != nil {
+		return nil, err
+	}
+	var newLots []*Lot
+	err := l.transact(TransferTransaction, date, "", func() error {
+		var (
+			remainingToTransfer = totalAmountToMove
+			remainingFee        = feePaidFromAmount
+		)
+		// find the given lots
+		for _, name := range fromLotNames {
+			lot, err := l.FindLotByName(name, currency)
+			if err != nil {
+				return err
+			}
+			if remainingToTransfer.Sign() <= 0 {
+				return &InvalidOperationError{Op: "TransferMultipleLots", LotName: lot.name, Reason: "there's nothing left to remove from this lot"}
+			}
 
-		// remove either the full lot amount, or just the amount remaining to transfer
-		amountToRemoveFromLot := math.Min(lot.amount, remainingToTransfer)
-		remainingToTransfer -= amountToRemoveFromLot
+			// remove either the full lot amount, or just the amount remaining to transfer
+			amountToRemoveFromLot := minDecimal(lot.amount, remainingToTransfer)
+			remainingToTransfer = remainingToTransfer.Sub(amountToRemoveFromLot)
 
-		feePortion := feePaidFromAmount * (amountToRemoveFromLot / totalAmountToMove)
+			// the last lot takes whatever is left of the fee, so none is lost to rounding
+			feePortion := remainingFee
+			if remainingToTransfer.Sign() > 0 {
+				feePortion = feePaidFromAmount.Mul(amountToRemoveFromLot).Quo(totalAmountToMove, l.Precision(currency))
+			}
+			remainingFee = remainingFee.Sub(feePortion)
 
-		newLot := l.Transfer(date, lot.name, currency, amountToRemoveFromLot, feePortion, toAccount)
-		newLots = append(newLots, newLot)
-	}
+			newLot, err := l.transfer(date, lot.name, currency, amountToRemoveFromLot, feePortion, toAccount)
+			if err != nil {
+				return err
+			}
+			newLots = append(newLots, newLot)
+		}
 
-	if remainingToTransfer > InsignificantAmount {
-		panic(fmt.Sprintf("Insufficient funds in the lots. Remaining: %.9f", remainingToTransfer))
-	} else if remainingToTransfer < -InsignificantAmount {
-		panic(fmt.Sprintf("Too much funds transferred! Remaining: %.9f", remainingToTransfer))
+		if remainingToTransfer.Sign() > 0 {
+			return &InsufficientAmountError{Currency: currency, Requested: totalAmountToMove, Available: totalAmountToMove.Sub(remainingToTransfer)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return newLots
+	return newLots, nil
 }
 
 // TransferMultipleLotsFully removes all amounts from the existing lots, and transfers them to a new account,
 // spreading the given fee proportionally across the lots.
 // Each new lot has the reduced amount, but preserves the original cost basis.
-func (l *Ledger) TransferMultipleLotsFully(date time.Time, fromLotNames []string, currency Currency, amountRemoved, feePaidFromAmount float64, toAccount Account) []*Lot {
-	var (
-		lotsTotal float64
-		lots      = make([]*Lot, len(fromLotNames))
-	)
-	// find the given lots
-	for i, name := range fromLotNames {
-		lots[i] = l.FindLotByName(name, currency)
-		lotsTotal += lots[i].amount
+func (l *Ledger) TransferMultipleLotsFully(date time.Time, fromLotNames []string, currency Currency, amountRemoved, feePaidFromAmount Decimal, toAccount Account) ([]*Lot, error) {
+	if err := firstError(l.checkAmount(currency, amountRemoved), l.checkAmount(currency, feePaidFromAmount)); err != nil {
+		return nil, err
 	}
+	var newLots []*Lot
+	err := l.transact(TransferTransaction, date, "", func() error {
+		var (
+			lotsTotal Decimal
+			lots      = make([]*Lot, len(fromLotNames))
+		)
+		// find the given lots
+		for i, name := range fromLotNames {
+			lot, err := l.FindLotByName(name, currency)
+			if err != nil {
+				return err
+			}
+			lots[i] = lot
+			lotsTotal = lotsTotal.Add(lot.amount)
+		}
 
-	if math.Abs(lotsTotal-amountRemoved) > InsignificantAmount {
-		panic(fmt.Sprintf("Amount to remove %0.10f does not match the amount in the lots %0.10f (diff: %0.20f)", amountRemoved, lotsTotal, amountRemoved-lotsTotal))
-	}
+		if lotsTotal.Cmp(amountRemoved) != 0 {
+			return &InvalidOperationError{Op: "TransferMultipleLotsFully", LotName: strings.Join(fromLotNames, ","),
+				Reason: fmt.Sprintf("amount to remove %s does not match the amount in the lots %s (diff: %s)", amountRemoved, lotsTotal, amountRemoved.Sub(lotsTotal))}
+		}
 
-	var newLots []*Lot
-	for _, lot := range lots {
-		feePortion := feePaidFromAmount * (lot.amount / amountRemoved)
-		newLot := l.Transfer(date, lot.name, currency, lot.amount, feePortion, toAccount)
-		newLots = append(newLots, newLot)
+		remainingFee := feePaidFromAmount
+		for i, lot := range lots {
+			// the last lot takes whatever is left of the fee, so none is lost to rounding
+			feePortion := remainingFee
+			if i < len(lots)-1 {
+				feePortion = feePaidFromAmount.Mul(lot.amount).Quo(amountRemoved, l.Precision(currency))
+			}
+			remainingFee = remainingFee.Sub(feePortion)
+
+			newLot, err := l.transfer(date, lot.name, currency, lot.amount, feePortion, toAccount)
+			if err != nil {
+				return err
+			}
+			newLots = append(newLots, newLot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return newLots
+	return newLots, nil
 }
 
 // ExchangeTaxable records an exchange of one currency for another.
@@ -186,29 +410,66 @@ func (l *Ledger) TransferMultipleLotsFully(date time.Time, fromLotNames []string
 //	        /     \
 //	Purchase Lot   Taxable Gain Lot
 func (l *Ledger) ExchangeTaxable(date time.Time, fromLotName string,
-	soldCurrency Currency, soldAmount, feeInSoldCurrency float64, lookupSoldCurrencyPriceForTaxableGains bool,
-	purchasedCurrency Currency, purchasedAmountReceived float64) *Lot {
+	soldCurrency Currency, soldAmount, feeInSoldCurrency Decimal, lookupSoldCurrencyPriceForTaxableGains bool,
+	purchasedCurrency Currency, purchasedAmountReceived Decimal) (*Lot, error) {
 
-	// TODO: feeInSoldCurrency is never used.. maybe could just make a note of it if we record a list of transactions and associated lots.
+	if err := firstError(l.checkAmount(soldCurrency, soldAmount), l.checkAmount(soldCurrency, feeInSoldCurrency), l.checkAmount(purchasedCurrency, purchasedAmountReceived)); err != nil {
+		return nil, err
+	}
+	var newLot *Lot
+	err := l.transact(ExchangeTaxableTransaction, date, "", func() (err error) {
+		newLot, err = l.exchangeTaxable(date, fromLotName, soldCurrency, soldAmount, feeInSoldCurrency,
+			lookupSoldCurrencyPriceForTaxableGains, purchasedCurrency, purchasedAmountReceived)
+		return err
+	})
+	return newLot, err
+}
 
-	lot := l.FindLotByName(fromLotName, soldCurrency)
-	soldCostBasis := lot.Remove(soldCurrency, soldAmount)
+func (l *Ledger) exchangeTaxable(date time.Time, fromLotName string,
+	soldCurrency Currency, soldAmount, feeInSoldCurrency Decimal, lookupSoldCurrencyPriceForTaxableGains bool,
+	purchasedCurrency Currency, purchasedAmountReceived Decimal) (*Lot, error) {
 
-	// create new destination lot
-	var purchasedLocalCurrencyEquivalent float64
+	lot, err := l.FindLotByName(fromLotName, soldCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	// look up the price before touching the lot
+	var (
+		purchasedLocalCurrencyEquivalent Decimal
+		pricePath                        []Currency
+	)
 	if lookupSoldCurrencyPriceForTaxableGains {
-		purchasedLocalCurrencyEquivalent = l.lookupPrice(soldCurrency, date) * soldAmount
+		purchasedLocalCurrencyEquivalent, pricePath, err = l.valueInLocalCurrency(soldCurrency, soldAmount, date)
 	} else {
-		purchasedLocalCurrencyEquivalent = l.lookupPrice(purchasedCurrency, date) * purchasedAmountReceived
+		purchasedLocalCurrencyEquivalent, pricePath, err = l.valueInLocalCurrency(purchasedCurrency, purchasedAmountReceived, date)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	soldCostBasis, err := lot.Remove(soldCurrency, soldAmount, l.basisPlaces())
+	if err != nil {
+		return nil, err
 	}
+	l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: soldCurrency, Amount: soldAmount,
+		CostBasis: soldCostBasis, Value: purchasedLocalCurrencyEquivalent})
+	if !feeInSoldCurrency.IsZero() {
+		// the fee was already deducted by the exchange, so it's only noted for reference
+		l.recordFee(Posting{LotName: lot.name, Account: lot.account, Currency: soldCurrency, Amount: feeInSoldCurrency})
+	}
+
+	// create new destination lot
 	newDestinationLot := NewChildLot(lot, Asset, date, lot.account, purchasedCurrency, purchasedAmountReceived, purchasedLocalCurrencyEquivalent)
 	l.lots = append(l.lots, newDestinationLot)
 
 	// create taxable gains lot
-	l.lots = append(l.lots, NewTaxableGainsLot(lot, date, soldAmount, soldCostBasis, purchasedLocalCurrencyEquivalent, l.localCurrency,
-		fmt.Sprintf("exchanging %s for %s", soldCurrency, purchasedCurrency)))
+	gainsLot := NewTaxableGainsLot(lot, date, soldAmount, soldCostBasis, purchasedLocalCurrencyEquivalent, l.localCurrency,
+		fmt.Sprintf("exchanging %s for %s", soldCurrency, purchasedCurrency))
+	gainsLot.taxableGainsDetails.pricePath = pricePath
+	l.lots = append(l.lots, gainsLot)
 
-	return newDestinationLot
+	return newDestinationLot, nil
 }
 
 // SellTaxable records a sale of one currency for localCurrency, using the provided value.
@@ -222,14 +483,36 @@ func (l *Ledger) SellTaxable(
 	date time.Time,
 	fromLotName string,
 	soldCurrency Currency,
-	soldAmount float64,
-	purchasedAmountReceivedInLocalCurrency float64,
-) *Lot {
+	soldAmount Decimal,
+	purchasedAmountReceivedInLocalCurrency Decimal,
+) (*Lot, error) {
+
+	if err := firstError(l.checkAmount(soldCurrency, soldAmount), l.checkAmount(l.localCurrency, purchasedAmountReceivedInLocalCurrency)); err != nil {
+		return nil, err
+	}
+	var gainsLot *Lot
+	err := l.transact(SellTransaction, date, "", func() (err error) {
+		gainsLot, err = l.sellTaxable(date, fromLotName, soldCurrency, soldAmount, purchasedAmountReceivedInLocalCurrency)
+		return err
+	})
+	return gainsLot, err
+}
+
+func (l *Ledger) sellTaxable(date time.Time, fromLotName string, soldCurrency Currency, soldAmount Decimal,
+	purchasedAmountReceivedInLocalCurrency Decimal) (*Lot, error) {
 
 	// the code is very similar to ExchangeTaxable, just simpler.
 
-	lot := l.FindLotByName(fromLotName, soldCurrency)
-	soldCostBasis := lot.Remove(soldCurrency, soldAmount)
+	lot, err := l.FindLotByName(fromLotName, soldCurrency)
+	if err != nil {
+		return nil, err
+	}
+	soldCostBasis, err := lot.Remove(soldCurrency, soldAmount, l.basisPlaces())
+	if err != nil {
+		return nil, err
+	}
+	l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: soldCurrency, Amount: soldAmount,
+		CostBasis: soldCostBasis, Value: purchasedAmountReceivedInLocalCurrency})
 
 	// create taxable gains lot
 	gainsLot := NewTaxableGainsLot(lot, date, soldAmount, soldCostBasis, purchasedAmountReceivedInLocalCurrency, l.localCurrency,
@@ -237,30 +520,69 @@ func (l *Ledger) SellTaxable(
 	)
 
 	l.lots = append(l.lots, gainsLot)
-	return gainsLot
+	return gainsLot, nil
 }
 
 // Spend records the sale of a given currency.
 // It records short or long term gains in a separate lot.
 // It looks up the daily price of the sold Currency to determine the localCurrency value of the spend.
 func (l *Ledger) Spend(date time.Time, feeWasFromAccount Account, fromLotName string,
-	soldCurrency Currency, soldAmount float64, note string) float64 {
+	soldCurrency Currency, soldAmount Decimal, note string) (Decimal, error) {
 
-	// nothing to do if amount is zero
-	if math.Abs(soldAmount) < InsignificantAmount {
-		return 0
+	if err := l.checkAmount(soldCurrency, soldAmount); err != nil {
+		return Decimal{}, err
 	}
+	var valueInLocalCurrency Decimal
+	err := l.transact(SpendTransaction, date, note, func() error {
+		lot, err := l.FindLotByName(fromLotName, soldCurrency)
+		if err != nil {
+			return err
+		}
+		if err := l.checkWallet("Spend", date, lot, feeWasFromAccount); err != nil {
+			return err
+		}
+		valueInLocalCurrency, err = l.spend(date, feeWasFromAccount, fromLotName, soldCurrency, soldAmount, note, false)
+		return err
+	})
+	return valueInLocalCurrency, err
+}
 
-	// exchange for localCurrency, recording the capital gains
-	lot := l.FindLotByName(fromLotName, soldCurrency)
-	soldCostBasis := lot.Remove(soldCurrency, soldAmount)
+// spend does the work of Spend.
+// The spent amount is recorded as a fee in the pending transaction if isFee is set, otherwise as an input.
+func (l *Ledger) spend(date time.Time, feeWasFromAccount Account, fromLotName string,
+	soldCurrency Currency, soldAmount Decimal, note string, isFee bool) (Decimal, error) {
+
+	// nothing to do if amount is zero
+	if soldAmount.IsZero() {
+		return Decimal{}, nil
+	}
 
 	// withdrawing this money from the system.. it goes into the "ether"!
-	valueInLocalCurrency := l.lookupPrice(soldCurrency, date) * soldAmount
+	valueInLocalCurrency, pricePath, err := l.valueInLocalCurrency(soldCurrency, soldAmount, date)
+	if err != nil {
+		return Decimal{}, err
+	}
 
-	// create taxable gains lot
-	gains := valueInLocalCurrency - soldCostBasis
-	if math.Abs(gains) > InsignificantAmount {
+	// exchange for localCurrency, recording the capital gains
+	lot, err := l.FindLotByName(fromLotName, soldCurrency)
+	if err != nil {
+		return Decimal{}, err
+	}
+	soldCostBasis, err := lot.Remove(soldCurrency, soldAmount, l.basisPlaces())
+	if err != nil {
+		return Decimal{}, err
+	}
+	posting := Posting{LotName: lot.name, Account: feeWasFromAccount, Currency: soldCurrency, Amount: soldAmount,
+		CostBasis: soldCostBasis, Value: valueInLocalCurrency}
+	if isFee {
+		l.recordFee(posting)
+	} else {
+		l.recordInput(posting)
+	}
+
+	// create taxable gains lot, which a pooled cost basis method needs even without gains by the lot's cost basis
+	gains := valueInLocalCurrency.Sub(soldCostBasis)
+	if !gains.IsZero() || l.poolsDisposals() {
 		// Terrible hack here..
 		// Temporarily doing something special with the lot naming here... don't want to modify the parent lot numbering,
 		// since that will muck up some existing accounting before this Spend() behavior was added.
@@ -280,22 +602,23 @@ func (l *Ledger) Spend(date time.Time, feeWasFromAccount Account, fromLotName st
 				}
 			}
 			if spendCapitalGainsLot == nil {
-				spendCapitalGainsLot = NewLot(nil, spendCapitalGainsLotName, Asset, time.Time{}, "", lot.currency, 0, 0)
+				spendCapitalGainsLot = NewLot(nil, spendCapitalGainsLotName, Asset, time.Time{}, "", lot.currency, Decimal{}, Decimal{})
 				l.lots = append(l.lots, spendCapitalGainsLot)
 			}
 		}
 		// duplicating and modifying the NewTaxableGainsLot method logic here
 		//newLot := NewTaxableGainsLot(lot, date, soldAmount, gains, l.localCurrency)
-		newLot := NewChildLot(spendCapitalGainsLot, TaxableGains, date, "", lot.currency, 0, 0)
+		newLot := NewChildLot(spendCapitalGainsLot, TaxableGains, date, "", lot.currency, Decimal{}, Decimal{})
 
 		newLot.taxableGainsDetails = NewTaxableGainsDetails(
 			feeWasFromAccount, lot.currency, lot.originalPurchaseTime,
 			soldCostBasis, date, valueInLocalCurrency, soldAmount, note,
 		)
+		newLot.taxableGainsDetails.pricePath = pricePath
 		l.lots = append(l.lots, newLot)
 	}
 
-	return valueInLocalCurrency
+	return valueInLocalCurrency, nil
 }
 
 // ExchangeTaxableMultipleLots records an exchange of one currency for another, performed across multiple lots.
@@ -304,36 +627,48 @@ func (l *Ledger) Spend(date time.Time, feeWasFromAccount Account, fromLotName st
 //
 // It calls ExchangeTaxable to perform the overall transfer across multiple lots.
 func (l *Ledger) ExchangeTaxableMultipleLots(date time.Time, fromLotNames []string,
-	soldCurrency Currency, totalAmountToSell float64, lookupSoldCurrencyPriceForTaxableGains bool,
-	purchasedCurrency Currency, totalAmountToPurchase float64) {
+	soldCurrency Currency, totalAmountToSell Decimal, lookupSoldCurrencyPriceForTaxableGains bool,
+	purchasedCurrency Currency, totalAmountToPurchase Decimal) error {
 
-	var (
-		remainingToSell     = totalAmountToSell
-		remainingToPurchase = totalAmountToPurchase
-	)
-	// find the given lots
-	for _, name := range fromLotNames {
-		lot := l.FindLotByName(name, soldCurrency)
-		if remainingToSell <= InsignificantAmount {
-			panic("There's nothing left to sell from this lot: " + lot.String())
-		}
-		if remainingToPurchase <= InsignificantAmount {
-			panic("There's nothing left to purchase")
-		}
+	if err := firstError(l.checkAmount(soldCurrency, totalAmountToSell), l.checkAmount(purchasedCurrency, totalAmountToPurchase)); err != nil {
+		return err
+	}
+	return l.transact(ExchangeTaxableTransaction, date, "", func() error {
+		var (
+			remainingToSell     = totalAmountToSell
+			remainingToPurchase = totalAmountToPurchase
+		)
+		// find the given lots
+		for _, name := range fromLotNames {
+			lot, err := l.FindLotByName(name, soldCurrency)
+			if err != nil {
+				return err
+			}
+			if remainingToSell.Sign() <= 0 {
+				return &InvalidOperationError{Op: "ExchangeTaxableMultipleLots", LotName: lot.name, Reason: "there's nothing left to sell from this lot"}
+			}
 
-		// remove exchange the full lot amount, or just the amount remaining to sell
-		amountToSellFromLot := math.Min(lot.amount, remainingToSell)
-		remainingToSell -= amountToSellFromLot
+			// remove exchange the full lot amount, or just the amount remaining to sell
+			amountToSellFromLot := minDecimal(lot.amount, remainingToSell)
+			remainingToSell = remainingToSell.Sub(amountToSellFromLot)
 
-		purchasePortion := totalAmountToPurchase * (amountToSellFromLot / totalAmountToSell)
-		remainingToPurchase -= purchasePortion
+			// the last lot receives whatever is left to purchase, so none is lost to rounding
+			purchasePortion := remainingToPurchase
+			if remainingToSell.Sign() > 0 {
+				purchasePortion = totalAmountToPurchase.Mul(amountToSellFromLot).Quo(totalAmountToSell, l.Precision(purchasedCurrency))
+			}
+			remainingToPurchase = remainingToPurchase.Sub(purchasePortion)
 
-		l.ExchangeTaxable(date, lot.name, soldCurrency, amountToSellFromLot, 0, lookupSoldCurrencyPriceForTaxableGains, purchasedCurrency, purchasePortion)
-	}
+			if _, err := l.exchangeTaxable(date, lot.name, soldCurrency, amountToSellFromLot, Decimal{}, lookupSoldCurrencyPriceForTaxableGains, purchasedCurrency, purchasePortion); err != nil {
+				return err
+			}
+		}
 
-	if RoundPlaces(remainingToSell, 11) != 0 || RoundPlaces(remainingToPurchase, 11) != 0 {
-		panic(fmt.Sprintf("Incorrect funds sold/purchased. Remaining: sell %.13f, purchase %.13f", remainingToSell, remainingToPurchase))
-	}
+		if remainingToSell.Sign() > 0 {
+			return &InsufficientAmountError{Currency: soldCurrency, Requested: totalAmountToSell, Available: totalAmountToSell.Sub(remainingToSell)}
+		}
+		return nil
+	})
 }
 
 // ExchangeNonTaxable records an exchange of one currency for another.
@@ -345,82 +680,137 @@ func (l *Ledger) ExchangeTaxableMultipleLots(date time.Time, fromLotNames []stri
 //	        /     \
 //	Purchase Lot   Taxable Gain Lot
 func (l *Ledger) ExchangeNonTaxable(date time.Time, fromLotName string,
-	soldCurrency Currency, soldAmount, feeInSoldCurrency float64,
-	purchasedCurrency Currency, purchasedAmountReceived float64) {
-
-	// TODO: feeInSoldCurrency is never used.. maybe could just make a note of it if we record a list of transactions and associated lots.
+	soldCurrency Currency, soldAmount, feeInSoldCurrency Decimal,
+	purchasedCurrency Currency, purchasedAmountReceived Decimal) (*Lot, error) {
 
-	lot := l.FindLotByName(fromLotName, soldCurrency)
-	if !lot.originalPurchaseTime.Equal(date) && !lot.originalPurchaseTime.After(date) {
-		panic(fmt.Sprintf("This is probably taxable, since the lot was sold on a different day from %v\n%s", date, lot))
+	if err := firstError(l.checkAmount(soldCurrency, soldAmount), l.checkAmount(soldCurrency, feeInSoldCurrency), l.checkAmount(purchasedCurrency, purchasedAmountReceived)); err != nil {
+		return nil, err
 	}
-	soldCostBasis := lot.Remove(soldCurrency, soldAmount)
+	var newLot *Lot
+	err := l.transact(ExchangeNonTaxableTransaction, date, "", func() error {
+		lot, err := l.FindLotByName(fromLotName, soldCurrency)
+		if err != nil {
+			return err
+		}
+		if !lot.originalPurchaseTime.Equal(date) && !lot.originalPurchaseTime.After(date) {
+			return &InvalidOperationError{Op: "ExchangeNonTaxable", LotName: lot.name,
+				Reason: fmt.Sprintf("this is probably taxable, since the lot was sold on a different day from %s", date.Format("2006-01-02"))}
+		}
+		soldCostBasis, err := lot.Remove(soldCurrency, soldAmount, l.basisPlaces())
+		if err != nil {
+			return err
+		}
+		l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: soldCurrency, Amount: soldAmount, CostBasis: soldCostBasis})
+		if !feeInSoldCurrency.IsZero() {
+			// the fee was already deducted by the exchange, so it's only noted for reference
+			l.recordFee(Posting{LotName: lot.name, Account: lot.account, Currency: soldCurrency, Amount: feeInSoldCurrency})
+		}
 
-	// create new destination lot
-	l.lots = append(l.lots, NewChildLot(lot, Asset, date, lot.account, purchasedCurrency, purchasedAmountReceived, soldCostBasis))
+		// create new destination lot
+		newLot = NewChildLot(lot, Asset, date, lot.account, purchasedCurrency, purchasedAmountReceived, soldCostBasis)
+		l.lots = append(l.lots, newLot)
+		return nil
+	})
+	return newLot, err
 }
 
 // MergeIdenticalLots merges identical lots into one. They all must have the same purchase price, date, and account.
-func (l *Ledger) MergeIdenticalLots(purchaseDate time.Time, currency Currency, lotNames []string) *Lot {
-	var (
-		totalAmount, totalCostBasis, pricePerUnit float64
-		account                                   Account
-	)
-	for i, lotName := range lotNames {
-		lot := l.FindLotByName(lotName, currency)
+// Since each lot's cost basis was rounded to the local currency's precision, prices only need to agree to within that rounding.
+func (l *Ledger) MergeIdenticalLots(purchaseDate time.Time, currency Currency, lotNames []string) (*Lot, error) {
+	var newLot *Lot
+	err := l.transact(MergeTransaction, purchaseDate, "", func() error {
+		var (
+			totalAmount, totalCostBasis Decimal
+			first                       *Lot
+		)
+		for _, lotName := range lotNames {
+			lot, err := l.FindLotByName(lotName, currency)
+			if err != nil {
+				return err
+			}
 
-		// verify identical date
-		if lot.originalPurchaseTime != purchaseDate {
-			fmt.Fprintf(os.Stderr, "All lots must have the same date %s\n%s\n", purchaseDate, lot)
-			panic("Lots must have identical purchase date")
-		}
+			// verify identical date
+			if lot.originalPurchaseTime != purchaseDate {
+				return &InvalidOperationError{Op: "MergeIdenticalLots", LotName: lot.name,
+					Reason: fmt.Sprintf("all lots must have the same purchase date %s", purchaseDate.Format("2006-01-02"))}
+			}
 
-		// verify identical lotPrice and account
-		lotPricePerUnit := lot.costBasis / lot.amount
-		if i == 0 {
-			pricePerUnit = lotPricePerUnit
-			account = lot.account
-		} else {
-			if RoundPlaces(pricePerUnit-lotPricePerUnit, 11) != 0 {
-				fmt.Fprintf(os.Stderr, "All lots must have the same price %.9f\n%s\n", pricePerUnit, lot)
-				panic("Lots must have identical price")
+			// verify identical lotPrice and account
+			if first == nil {
+				first = lot
+			} else {
+				if !l.haveSamePrice(first, lot) {
+					return &InvalidOperationError{Op: "MergeIdenticalLots", LotName: lot.name,
+						Reason: fmt.Sprintf("all lots must have the same price %.9f", unitPrice(first.costBasis, first.amount))}
+				}
+				if first.account != lot.account {
+					return &InvalidOperationError{Op: "MergeIdenticalLots", LotName: lot.name,
+						Reason: fmt.Sprintf("all lots must have the same account %s", first.account)}
+				}
 			}
-			if account != lot.account {
-				fmt.Fprintf(os.Stderr, "All lots must have the same account %s\n%s\n", account, lot)
-				panic("Lots must have identical account")
+
+			// drain the lot
+			amount := lot.amount
+			totalAmount = totalAmount.Add(amount)
+			costBasis, err := lot.Remove(currency, amount, l.basisPlaces())
+			if err != nil {
+				return err
 			}
+			l.recordInput(Posting{LotName: lot.name, Account: lot.account, Currency: currency, Amount: amount, CostBasis: costBasis})
+			totalCostBasis = totalCostBasis.Add(costBasis)
+		}
+		if first == nil {
+			return &InvalidOperationError{Op: "MergeIdenticalLots", Reason: "no lots to merge"}
 		}
 
-		// drain the lot
-		totalAmount += lot.amount
-		totalCostBasis += lot.Remove(currency, lot.amount)
-	}
+		// create a new lot.. no parent
+		newLot = NewLot(nil, l.nameLot(), Asset, purchaseDate, first.account, currency, totalAmount, totalCostBasis)
+		l.lots = append(l.lots, newLot)
+		return nil
+	})
+	return newLot, err
+}
 
-	// create a new lot.. no parent
-	newLot := NewLot(nil, l.nameLot(), Asset, purchaseDate, account, currency, totalAmount, totalCostBasis)
-	l.lots = append(l.lots, newLot)
-	return newLot
+// haveSamePrice returns true if the lots' unit prices are the same, to within the rounding of their cost basis.
+//
+// If each costBasis = amount × price + roundingError, where |roundingError| <= half a unit of the local currency's precision,
+// then |a.costBasis × b.amount - b.costBasis × a.amount| <= halfUnit × (a.amount + b.amount).
+func (l *Ledger) haveSamePrice(a, b *Lot) bool {
+	var (
+		halfUnit  = NewDecimal(5, l.basisPlaces()+1)
+		diff      = a.costBasis.Mul(b.amount).Sub(b.costBasis.Mul(a.amount)).Abs()
+		tolerance = halfUnit.Mul(a.amount.Add(b.amount))
+	)
+	return diff.Cmp(tolerance) <= 0
+}
+
+// Lots returns all of the lots, in the order they were created, including empty lots and TaxableGains lots.
+func (l *Ledger) Lots() []*Lot {
+	return append([]*Lot(nil), l.lots...)
 }
 
-// FindLotByName finds the lot with the given name, or panics.
-func (l *Ledger) FindLotByName(name string, currency Currency) *Lot {
-	lot := l.findLotByName(name)
+// FindLotByName finds the lot with the given name, which must contain the given currency.
+func (l *Ledger) FindLotByName(name string, currency Currency) (*Lot, error) {
+	lot, err := l.findLotByName(name)
+	if err != nil {
+		return nil, err
+	}
 	if lot.currency != currency {
-		panic("Lot does not contain " + currency.String() + "\n" + lot.String())
+		return nil, &CurrencyMismatchError{LotName: lot.name, Expected: currency, Actual: lot.currency}
 	}
-	return lot
+	return lot, nil
 }
 
-func (l *Ledger) findLotByName(name string) *Lot {
+func (l *Ledger) findLotByName(name string) (*Lot, error) {
 	// lot name is reused when lot is updated
 	// so search through lots in reverse order, returning the first match we encounter
 	for i := len(l.lots) - 1; i >= 0; i-- {
 		lot := l.lots[i]
 		if lot.name == name {
-			return lot
+			return lot, nil
 		}
 	}
-	panic("Couldn't find lot with name: " + name)
+	return nil, &LotNotFoundError{Name: name}
 }
 
 func (l *Ledger) nameLot() string {
@@ -428,30 +818,79 @@ func (l *Ledger) nameLot() string {
 	return strconv.Itoa(l.sequenceGenerator)
 }
 
-func (l *Ledger) lookupPrice(currency Currency, date time.Time) float64 {
+func (l *Ledger) lookupPrice(currency Currency, date time.Time) (Quote, error) {
 	if currency == l.localCurrency {
-		return 1.0
+		return Quote{Price: NewDecimal(1, 0), Date: date}, nil
+	}
+	if l.prices == nil {
+		return Quote{}, &MissingPriceError{Currency: currency, Date: date}
 	}
-	prices, ok := l.historicalPrices[currency]
-	if !ok {
-		panic("Missing historical prices for " + currency.String())
+	return l.prices.Price(currency, date)
+}
+
+// valueInLocalCurrency looks up the value of the amount of currency on the given date,
+// rounded to the local currency's precision.
+// It also returns the path of currencies the price was derived through, if it wasn't quoted directly.
+func (l *Ledger) valueInLocalCurrency(currency Currency, amount Decimal, date time.Time) (Decimal, []Currency, error) {
+	quote, err := l.lookupPrice(currency, date)
+	if err != nil {
+		return Decimal{}, nil, err
 	}
-	price, ok := prices[date]
-	if !ok {
-		panic("Missing " + currency.String() + " historical price for " + date.String())
+	return quote.Price.Mul(amount).Round(l.basisPlaces()), quote.Path, nil
+}
+
+func minDecimal(a, b Decimal) Decimal {
+	if a.Cmp(b) <= 0 {
+		return a
 	}
-	return price
+	return b
+}
+
+// atomically runs op, restoring the ledger to its prior state if op returns an error.
+// The public operations are built from unexported steps which are only safe to call inside atomically.
+func (l *Ledger) atomically(op func() error) error {
+	type lotState struct {
+		amount, costBasis Decimal
+		sequenceGenerator int
+		// washSaleReplaced and washSaleDisallowed are from a TaxableGains lot's details
+		washSaleReplaced, washSaleDisallowed Decimal
+	}
+	var (
+		numLots           = len(l.lots)
+		sequenceGenerator = l.sequenceGenerator
+		lotStates         = make([]lotState, numLots)
+	)
+	for i, lot := range l.lots {
+		lotStates[i] = lotState{amount: lot.amount, costBasis: lot.costBasis, sequenceGenerator: lot.sequenceGenerator}
+		if details := lot.taxableGainsDetails; details != nil {
+			lotStates[i].washSaleReplaced, lotStates[i].washSaleDisallowed = details.washSaleReplaced, details.washSaleDisallowed
+		}
+	}
+
+	err := op()
+	if err != nil {
+		for i, s := range lotStates {
+			lot := l.lots[i]
+			lot.amount, lot.costBasis, lot.sequenceGenerator = s.amount, s.costBasis, s.sequenceGenerator
+			if details := lot.taxableGainsDetails; details != nil {
+				details.washSaleReplaced, details.washSaleDisallowed = s.washSaleReplaced, s.washSaleDisallowed
+			}
+		}
+		l.lots = l.lots[:numLots]
+		l.sequenceGenerator = sequenceGenerator
+	}
+	return err
 }
 
 // TotalInvestment simply looks at all of the "root" localCurrency nodes, summing up their original cost basis.
 // This may not be perfectly accurate, going forward:
 //   - Some money might still be sitting in that localCurrency lot, but not considered "invested"
 //   - Might start with non-localCurrency assets sometimes. Would need to account for them in localCurrency.
-func (l *Ledger) TotalInvestment() float64 {
-	var totalInvestment float64
+func (l *Ledger) TotalInvestment() Decimal {
+	var totalInvestment Decimal
 	for _, lot := range l.lots {
 		if lot.parent == nil && lot.currency == l.localCurrency {
-			totalInvestment += lot.originalCostBasis
+			totalInvestment = totalInvestment.Add(lot.originalCostBasis)
 		}
 	}
 	return totalInvestment
@@ -462,7 +901,7 @@ func (l *Ledger) PrintLots() string {
 	b := &bytes.Buffer{}
 	tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', tabwriter.StripEscape)
 	for _, lot := range l.lots {
-		fmt.Fprintln(tw, lot)
+		fmt.Fprintln(tw, lot.describe(l.TaxRules()))
 	}
 	if err := tw.Flush(); err != nil {
 		panic(err.Error())
@@ -473,12 +912,12 @@ func (l *Ledger) PrintLots() string {
 // PrintIncome prints out a report of the Income lots, and a summary.
 func (l *Ledger) PrintIncome() string {
 	b := &bytes.Buffer{}
-	var totalIncome float64
+	var totalIncome Decimal
 	for _, lot := range l.lots {
 		if lot.lotType == AssetIncome {
 			fmt.Fprintf(b, "%s\t%s %s %s %0.9f\t(basis:%0.9f,\tprice:$%f)\n", lot.name, lot.originalPurchaseTime.Format("2006-01-02"),
-				lot.account, lot.currency, lot.originalPurchaseAmount, lot.originalCostBasis, lot.originalCostBasis/lot.originalPurchaseAmount)
-			totalIncome += lot.originalCostBasis
+				lot.account, lot.currency, lot.originalPurchaseAmount, lot.originalCostBasis, unitPrice(lot.originalCostBasis, lot.originalPurchaseAmount))
+			totalIncome = totalIncome.Add(lot.originalCostBasis)
 		}
 	}
 	fmt.Fprintf(b, "(total income: $%.2f)\n", totalIncome)
@@ -486,51 +925,64 @@ func (l *Ledger) PrintIncome() string {
 }
 
 // PrintTaxableGains prints some details..
+// The gains are totalled by year and by the categories of the ledger's TaxRules, along with the part which is taxed
+// if any category is exempt or discounted.
 // In the future will probably want to restrict the date ranges, e.g. to a single calendar year.
 func (l *Ledger) PrintTaxableGains() string {
 	b := &bytes.Buffer{}
 
 	var (
-		totalShortTerm, totalLongTerm float64
+		rules      = l.TaxRules()
+		categories = rules.Categories()
+		adjusted   = lo.SomeBy(categories, func(c GainsCategory) bool { return c.Exempt || !c.Discount.IsZero() })
 
-		shortTermByYear = map[int]float64{}
-		longTermByYear  = map[int]float64{}
+		// the gains by category name, and the taxable gains under ""
+		total  = map[string]Decimal{}
+		byYear = map[int]map[string]Decimal{}
 	)
 	for _, lot := range l.lots {
 		if lot.lotType == TaxableGains {
 			var (
-				details = lot.taxableGainsDetails
-				gain    = details.Gains()
-				year    = lot.originalPurchaseTime.Year()
+				details  = lot.taxableGainsDetails
+				gain     = details.Gains()
+				year     = lot.originalPurchaseTime.Year()
+				category = rules.Classify(details.originalPurchaseTime, details.dateOfSale)
+				taxable  = category.TaxableGain(gain, l.basisPlaces())
 			)
-
-			if details.IsLongTerm() {
-				totalLongTerm += gain
-				longTermByYear[year] += gain
-			} else {
-				totalShortTerm += gain
-				shortTermByYear[year] += gain
+			if byYear[year] == nil {
+				byYear[year] = map[string]Decimal{}
+			}
+			for _, sums := range []map[string]Decimal{total, byYear[year]} {
+				sums[category.Name] = sums[category.Name].Add(gain)
+				sums[""] = sums[""].Add(taxable)
 			}
-			fmt.Fprintln(b, lot)
+			fmt.Fprintln(b, lot.describe(rules))
 		}
 	}
 
-	years := lo.Union(
-		lo.Keys(shortTermByYear),
-		lo.Keys(longTermByYear),
-	)
+	format := func(sums map[string]Decimal) string {
+		var parts []string
+		for _, category := range categories {
+			parts = append(parts, fmt.Sprintf("%s:$%.2f", category.Name, sums[category.Name]))
+		}
+		if adjusted {
+			parts = append(parts, fmt.Sprintf("net taxable:$%.2f", sums[""]))
+		}
+		return strings.Join(parts, " ")
+	}
+	years := lo.Keys(byYear)
 	sort.Ints(years)
 	for _, y := range years {
-		fmt.Fprintf(b, "(%d's capital gains: short-term:$%.2f long-term:$%.2f)\n", y, shortTermByYear[y], longTermByYear[y])
+		fmt.Fprintf(b, "(%d's capital gains: %s)\n", y, format(byYear[y]))
 	}
-	fmt.Fprintf(b, "(Total capital gains: short-term:$%.2f long-term:$%.2f)\n", totalShortTerm, totalLongTerm)
+	fmt.Fprintf(b, "(Total capital gains: %s)\n", format(total))
 
 	return b.String()
 }
 
 // Summary represents a summary of several lots.
 type Summary struct {
-	Balance, Basis float64
+	Balance, Basis Decimal
 	Lots           []*Lot
 }
 
@@ -538,7 +990,7 @@ type Summary struct {
 func (l *Ledger) PrintAccounts() string {
 	var (
 		accounts   = l.AccountSummary()
-		totalBasis float64
+		totalBasis Decimal
 	)
 	// sort names
 	names := make([]Account, 0, len(accounts))
@@ -561,11 +1013,11 @@ func (l *Ledger) PrintAccounts() string {
 
 		for _, currency := range currencies {
 			summary := currencyToSummary[currency]
-			fmt.Fprintf(b, "\t%s %0.9f (basis:%f\tprice:$%f)\n", currency, summary.Balance, summary.Basis, summary.Basis/summary.Balance)
-			totalBasis += summary.Basis
+			fmt.Fprintf(b, "\t%s %0.9f (basis:%f\tprice:$%f)\n", currency, summary.Balance, summary.Basis, unitPrice(summary.Basis, summary.Balance))
+			totalBasis = totalBasis.Add(summary.Basis)
 			b := tabwriter.NewWriter(b, 0, 4, 2, ' ', tabwriter.StripEscape)
 			for _, lot := range summary.Lots {
-				fmt.Fprintf(b, "\xff\t\t\xff%s\n", lot)
+				fmt.Fprintf(b, "\xff\t\t\xff%s\n", lot.describe(l.TaxRules()))
 			}
 			if err := b.Flush(); err != nil {
 				panic(err.Error())
@@ -583,7 +1035,7 @@ func (l *Ledger) AccountSummary() map[Account]map[Currency]*Summary {
 		accounts = map[Account]map[Currency]*Summary{}
 	)
 	for _, lot := range l.lots {
-		if lot.amount > InsignificantAmount && lot.lotType != TaxableGains {
+		if lot.amount.Sign() > 0 && lot.lotType != TaxableGains {
 			currencyToSummary, ok := accounts[lot.account]
 			if !ok {
 				currencyToSummary = map[Currency]*Summary{}
@@ -594,8 +1046,8 @@ func (l *Ledger) AccountSummary() map[Account]map[Currency]*Summary {
 				s = &Summary{}
 				currencyToSummary[lot.currency] = s
 			}
-			s.Balance += lot.amount
-			s.Basis += lot.costBasis
+			s.Balance = s.Balance.Add(lot.amount)
+			s.Basis = s.Basis.Add(lot.costBasis)
 			s.Lots = append(s.Lots, lot)
 		}
 	}
@@ -605,32 +1057,29 @@ func (l *Ledger) AccountSummary() map[Account]map[Currency]*Summary {
 // PrintPresentValueTSV will display information on the active lots,
 // including their present value based on the currentPrices provided.
 // Printed as tab-separated values, suitable for pasting in to a spreadsheet.
-func (l *Ledger) PrintPresentValueTSV(now time.Time, currentPrices map[Currency]float64) string {
+func (l *Ledger) PrintPresentValueTSV(now time.Time, currentPrices map[Currency]Decimal) string {
 	b := &bytes.Buffer{}
 	c := csv.NewWriter(b)
 	c.Comma = '\t'
 
 	c.Write([]string{"lotName", "account", "currency", "amount", "costBasis", "origPurchaseDate",
-		"daysSincePurchase", "shortOrLongTerm", "presentValue", "unrealizedGainLoss", "unrealizedGainLossPercent"})
+		"daysSincePurchase", "category", "presentValue", "unrealizedGainLoss", "unrealizedGainLossPercent"})
 	for _, lot := range l.lots {
-		if lot.amount > InsignificantAmount && lot.lotType != TaxableGains {
+		if lot.amount.Sign() > 0 && lot.lotType != TaxableGains {
 			daysSincePurchase := now.Sub(lot.originalPurchaseTime) / (24 * time.Hour)
-			shortOrLongTerm := "longTerm"
-			if daysSincePurchase < 365 {
-				shortOrLongTerm = "shortTerm"
-			}
+			category := l.TaxRules().Classify(lot.originalPurchaseTime, now)
 
-			var presentValue float64
+			var presentValue Decimal
 			{
 				currentPrice, ok := currentPrices[lot.currency]
 				if !ok {
 					panic("Missing prices for currency: " + lot.currency)
 				}
-				presentValue = lot.amount * currentPrice
+				presentValue = lot.amount.Mul(currentPrice).Round(l.basisPlaces())
 			}
 			var (
-				unrealizedGainLoss    = presentValue - lot.costBasis
-				unrealizedGainLossPct = unrealizedGainLoss / lot.costBasis * 100
+				unrealizedGainLoss    = presentValue.Sub(lot.costBasis)
+				unrealizedGainLossPct = unrealizedGainLoss.Float64() / lot.costBasis.Float64() * 100
 			)
 
 			c.Write([]string{
@@ -641,7 +1090,7 @@ func (l *Ledger) PrintPresentValueTSV(now time.Time, currentPrices map[Currency]
 				fmt.Sprintf("%0.2f", lot.costBasis),
 				fmt.Sprintf("%v", lot.originalPurchaseTime.Format("2006-01-02")),
 				fmt.Sprintf("%d", daysSincePurchase),
-				shortOrLongTerm,
+				category.Name,
 				fmt.Sprintf("%0.2f", presentValue),
 				fmt.Sprintf("%0.2f", unrealizedGainLoss),
 				fmt.Sprintf("%0.1f", unrealizedGainLossPct),
@@ -660,14 +1109,10 @@ func (l *Ledger) PrintCapitalGainsTSV() string {
 	c := csv.NewWriter(b)
 	c.Comma = '\t'
 
-	c.Write([]string{"lotName", "year", "account", "currency", "currencyAmount", "origPurchaseDate", "costBasis", "saleDate", "proceeds", "term", "gains", "note"})
+	c.Write([]string{"lotName", "year", "account", "currency", "currencyAmount", "origPurchaseDate", "costBasis", "saleDate", "proceeds", "category", "gains", "note", "pricePath"})
 	for _, lot := range l.lots {
 		if lot.lotType == TaxableGains {
 			details := lot.taxableGainsDetails
-			term := "short"
-			if details.IsLongTerm() {
-				term = "long"
-			}
 			c.Write([]string{
 				lot.name,
 				details.dateOfSale.Format("2006"),
@@ -678,9 +1123,10 @@ func (l *Ledger) PrintCapitalGainsTSV() string {
 				fmt.Sprintf("%0.2f", details.costBasis),
 				details.dateOfSale.Format("2006-01-02"),
 				fmt.Sprintf("%0.2f", details.proceeds),
-				term,
+				l.GainsCategory(details).Name,
 				fmt.Sprintf("%0.2f", details.Gains()),
 				details.note,
+				details.pricePathString(),
 			})
 		}
 	}
@@ -688,19 +1134,3 @@ func (l *Ledger) PrintCapitalGainsTSV() string {
 
 	return b.String()
 }
-
-// Round rounds f to the nearest integer.
-// https://gist.github.com/DavidVaini/10308388
-func Round(f float64) float64 {
-	if f < 0 {
-		return math.Ceil(f - 0.5)
-	}
-	return math.Floor(f + .5)
-}
-
-// RoundPlaces rounds f to the given number of decimal places.
-// https://gist.github.com/DavidVaini/10308388
-func RoundPlaces(f float64, places int) float64 {
-	shift := math.Pow(10, float64(places))
-	return Round(f*shift) / shift
-}
@@ -2,6 +2,7 @@ package ledger_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"testing"
@@ -28,14 +29,14 @@ const (
 )
 
 var (
-	historicalPrices = map[ledger.Currency]map[time.Time]float64{
+	historicalPrices = ledger.PriceMap{
 		BTC: {
-			d("2017-11-01"): 6767.31,
-			d("2017-11-02"): 6960.07,
-			d("2017-12-01"): 10975.60,
+			d("2017-11-01"): n("6767.31"),
+			d("2017-11-02"): n("6960.07"),
+			d("2017-12-01"): n("10975.60"),
 		},
 		ETH: {
-			d("2017-11-01"): 291.69,
+			d("2017-11-01"): n("291.69"),
 		},
 	}
 )
@@ -58,32 +59,32 @@ func TestSimpleScenario(t *testing.T) {
 		`=== Cost Basis Lots: ===
 1                          2017-04-06 Bitfinex USD 0.000000000  (basis:$0.000000     price:$NaN)
 1.1                        2017-04-06 Bitfinex BTC 0.000000000  (basis:$0.000000     price:$NaN)
-1.1.1                      2017-04-06 Coinbase BTC 0.799000000  (basis:$1039.095595  price:$1300.495113)
+1.1.1                      2017-04-06 Coinbase BTC 0.799000000  (basis:$1039.100000  price:$1300.500626)
 1.1.1.spendCapitalGains    0001-01-01  BTC 0.000000000          (basis:$0.000000     price:$NaN)
-1.1.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.292025. proceeds=USD 6.767310, gains=USD 5.475285, note=fee for transferring from Bitfinex to Coinbase
-1.1.2                      2017-12-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.039766780 originally purchased 2017-04-06 for USD 51.379689. proceeds=USD 436.460000, gains=USD 385.080311, note=sold BTC for USD
+1.1.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.290000. proceeds=USD 6.770000, gains=USD 5.480000, note=fee for transferring from Bitfinex to Coinbase
+1.1.2                      2017-12-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.039766780 originally purchased 2017-04-06 for USD 51.380000. proceeds=USD 436.460000, gains=USD 385.080000, note=sold BTC for USD
 
 === Capital Gains: ===
-1.1.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.292025. proceeds=USD 6.767310, gains=USD 5.475285, note=fee for transferring from Bitfinex to Coinbase
-1.1.2	2017-12-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.039766780 originally purchased 2017-04-06 for USD 51.379689. proceeds=USD 436.460000, gains=USD 385.080311, note=sold BTC for USD
+1.1.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.290000. proceeds=USD 6.770000, gains=USD 5.480000, note=fee for transferring from Bitfinex to Coinbase
+1.1.2	2017-12-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.039766780 originally purchased 2017-04-06 for USD 51.380000. proceeds=USD 436.460000, gains=USD 385.080000, note=sold BTC for USD
 (2017's capital gains: short-term:$390.56 long-term:$0.00)
 (Total capital gains: short-term:$390.56 long-term:$0.00)
 
 === Capital Gains, Tab-Separated (to copy into spreadsheet): ===
-lotName	year	account	currency	currencyAmount	origPurchaseDate	costBasis	saleDate	proceeds	term	gains	note
-1.1.1.spendCapitalGains.1	2017	Bitfinex	BTC	0.001000000	2017-04-06	1.29	2017-11-01	6.77	short	5.48	fee for transferring from Bitfinex to Coinbase
-1.1.2	2017	Bitfinex	BTC	0.039766780	2017-04-06	51.38	2017-12-01	436.46	short	385.08	sold BTC for USD
+lotName	year	account	currency	currencyAmount	origPurchaseDate	costBasis	saleDate	proceeds	category	gains	note	pricePath
+1.1.1.spendCapitalGains.1	2017	Bitfinex	BTC	0.001000000	2017-04-06	1.29	2017-11-01	6.77	short-term	5.48	fee for transferring from Bitfinex to Coinbase	
+1.1.2	2017	Bitfinex	BTC	0.039766780	2017-04-06	51.38	2017-12-01	436.46	short-term	385.08	sold BTC for USD	
 
 === Account balances (and their lots): ===
 Coinbase
-	BTC 0.799000000 (basis:1039.095595	price:$1300.495113)
-		1.1.1  2017-04-06 Coinbase BTC 0.799000000  (basis:$1039.095595  price:$1300.495113)
+	BTC 0.799000000 (basis:1039.100000	price:$1300.500626)
+		1.1.1  2017-04-06 Coinbase BTC 0.799000000  (basis:$1039.100000  price:$1300.500626)
 (Total basis: $1039.10)
 (Total initial investment: $1085.00)
 
 === Present Value, Tab-Separated (to copy into spreadsheet): ===
-lotName	account	currency	amount	costBasis	origPurchaseDate	daysSincePurchase	shortOrLongTerm	presentValue	unrealizedGainLoss	unrealizedGainLossPercent
-1.1.1	Coinbase	BTC	0.799000000	1039.10	2017-04-06	626	longTerm	3219.08	2179.99	209.8
+lotName	account	currency	amount	costBasis	origPurchaseDate	daysSincePurchase	category	presentValue	unrealizedGainLoss	unrealizedGainLossPercent
+1.1.1	Coinbase	BTC	0.799000000	1039.10	2017-04-06	626	long-term	3219.08	2179.98	209.8
 
 `))
 }
@@ -110,84 +111,222 @@ func TestLargerScenario(t *testing.T) {
 1.3                        2017-04-06 Bitfinex DASH 0.000000000  (basis:$0.000000    price:$NaN)
 2                          2017-08-01 Bitfinex BCH 0.000000000   (basis:$0.000000    price:$NaN)
 3                          2017-10-23 Bitfinex BTG 0.000000000   (basis:$0.000000    price:$NaN)
-1.1.1                      2017-04-06 Coinbase BTC 0.418873380   (basis:$575.526333  price:$1373.986413)
+1.1.1                      2017-04-06 Coinbase BTC 0.418873380   (basis:$575.530000  price:$1373.995168)
 1.1.1.spendCapitalGains    0001-01-01  BTC 0.000000000           (basis:$0.000000    price:$NaN)
-1.1.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.357569. proceeds=USD 6.767310, gains=USD 5.409741, note=fee for transferring from Bitfinex to Coinbase
-1.2.1                      2017-04-06 Coinbase ETH 9.190000000  (basis:$260.691223  price:$28.366836)
+1.1.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.360000. proceeds=USD 6.770000, gains=USD 5.410000, note=fee for transferring from Bitfinex to Coinbase
+1.2.1                      2017-04-06 Coinbase ETH 9.190000000  (basis:$260.700000  price:$28.367791)
 1.2.1.spendCapitalGains    0001-01-01  ETH 0.000000000          (basis:$0.000000    price:$NaN)
-1.2.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of ETH 0.010000000 originally purchased 2017-04-06 for USD 0.280494. proceeds=USD 2.916900, gains=USD 2.636406, note=fee for transferring from Bitfinex to Coinbase
+1.2.1.spendCapitalGains.1  2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of ETH 0.010000000 originally purchased 2017-04-06 for USD 0.280000. proceeds=USD 2.920000, gains=USD 2.640000, note=fee for transferring from Bitfinex to Coinbase
 1.3.1                      2017-11-02 Bitfinex BTC 0.000000000  (basis:$0.000000  price:$NaN)
-1.3.2                      2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of DASH 4.000000000 originally purchased 2017-04-06 for USD 256.924609. proceeds=USD 1045.038363, gains=USD 788.113754, note=exchanging DASH for BTC
+1.3.2                      2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of DASH 4.000000000 originally purchased 2017-04-06 for USD 256.920000. proceeds=USD 1045.040000, gains=USD 788.120000, note=exchanging DASH for BTC
 2.1                        2017-11-02 Bitfinex BTC 0.000000000  (basis:$0.000000  price:$NaN)
-2.2                        2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BCH 0.358531680 originally purchased 2017-08-01 for USD 212.250000. proceeds=USD 192.414406, gains=USD -19.835594, note=exchanging BCH for BTC
+2.2                        2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BCH 0.358531680 originally purchased 2017-08-01 for USD 212.250000. proceeds=USD 192.410000, gains=USD -19.840000, note=exchanging BCH for BTC
 3.1                        2017-11-02 Bitfinex BTC 0.000000000  (basis:$0.000000  price:$NaN)
-3.2                        2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BTG 0.419883380 originally purchased 2017-10-23 for USD 57.386000. proceeds=USD 46.904329, gains=USD -10.481671, note=exchanging BTG for BTC
+3.2                        2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BTG 0.419883380 originally purchased 2017-10-23 for USD 57.390000. proceeds=USD 46.900000, gains=USD -10.490000, note=exchanging BTG for BTC
 4                          2017-11-02 Bitfinex BTC 0.000000000  (basis:$0.000000     price:$NaN)
-4.1                        2017-11-02 Coinbase BTC 0.184032210  (basis:$1284.260719  price:$6978.456211)
+4.1                        2017-11-02 Coinbase BTC 0.184032210  (basis:$1284.250000  price:$6978.397966)
 4.1.spendCapitalGains      0001-01-01  BTC 0.000000000          (basis:$0.000000     price:$NaN)
-4.1.spendCapitalGains.1    2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.000500000 originally purchased 2017-11-02 for USD 3.480035. proceeds=USD 3.383655, gains=USD -0.096380, note=fee for transferring from Bitfinex to Coinbase
-1.1.1.spendCapitalGains.2  2017-12-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.000010000 originally purchased 2017-04-06 for USD 0.013737. proceeds=USD 0.109756, gains=USD 0.096019, note=fee applied: some random fee
+4.1.spendCapitalGains.1    2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.000500000 originally purchased 2017-11-02 for USD 3.480000. proceeds=USD 3.380000, gains=USD -0.100000, note=fee for transferring from Bitfinex to Coinbase
+1.1.1.spendCapitalGains.2  2017-12-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.000010000 originally purchased 2017-04-06 for USD 0.010000. proceeds=USD 0.110000, gains=USD 0.100000, note=fee applied: some random fee
 
 === Income: ===
 2	2017-08-01 Bitfinex BCH 0.358531680	(basis:212.250000000,	price:$591.997895)
-3	2017-10-23 Bitfinex BTG 0.419883380	(basis:57.386000000,	price:$136.671282)
+3	2017-10-23 Bitfinex BTG 0.419883380	(basis:57.390000000,	price:$136.680809)
 (total income: $269.64)
 
 === Capital Gains: ===
-1.1.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.357569. proceeds=USD 6.767310, gains=USD 5.409741, note=fee for transferring from Bitfinex to Coinbase
-1.2.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of ETH 0.010000000 originally purchased 2017-04-06 for USD 0.280494. proceeds=USD 2.916900, gains=USD 2.636406, note=fee for transferring from Bitfinex to Coinbase
-1.3.2	2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of DASH 4.000000000 originally purchased 2017-04-06 for USD 256.924609. proceeds=USD 1045.038363, gains=USD 788.113754, note=exchanging DASH for BTC
-2.2	2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BCH 0.358531680 originally purchased 2017-08-01 for USD 212.250000. proceeds=USD 192.414406, gains=USD -19.835594, note=exchanging BCH for BTC
-3.2	2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BTG 0.419883380 originally purchased 2017-10-23 for USD 57.386000. proceeds=USD 46.904329, gains=USD -10.481671, note=exchanging BTG for BTC
-4.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.000500000 originally purchased 2017-11-02 for USD 3.480035. proceeds=USD 3.383655, gains=USD -0.096380, note=fee for transferring from Bitfinex to Coinbase
-1.1.1.spendCapitalGains.2	2017-12-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.000010000 originally purchased 2017-04-06 for USD 0.013737. proceeds=USD 0.109756, gains=USD 0.096019, note=fee applied: some random fee
+1.1.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.001000000 originally purchased 2017-04-06 for USD 1.360000. proceeds=USD 6.770000, gains=USD 5.410000, note=fee for transferring from Bitfinex to Coinbase
+1.2.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of ETH 0.010000000 originally purchased 2017-04-06 for USD 0.280000. proceeds=USD 2.920000, gains=USD 2.640000, note=fee for transferring from Bitfinex to Coinbase
+1.3.2	2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of DASH 4.000000000 originally purchased 2017-04-06 for USD 256.920000. proceeds=USD 1045.040000, gains=USD 788.120000, note=exchanging DASH for BTC
+2.2	2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BCH 0.358531680 originally purchased 2017-08-01 for USD 212.250000. proceeds=USD 192.410000, gains=USD -19.840000, note=exchanging BCH for BTC
+3.2	2017-11-02 Taxable Gains (short-term) from sale on Bitfinex of BTG 0.419883380 originally purchased 2017-10-23 for USD 57.390000. proceeds=USD 46.900000, gains=USD -10.490000, note=exchanging BTG for BTC
+4.1.spendCapitalGains.1	2017-11-01 Taxable Gains (short-term) from sale on Bitfinex of BTC 0.000500000 originally purchased 2017-11-02 for USD 3.480000. proceeds=USD 3.380000, gains=USD -0.100000, note=fee for transferring from Bitfinex to Coinbase
+1.1.1.spendCapitalGains.2	2017-12-01 Taxable Gains (short-term) from sale on Coinbase of BTC 0.000010000 originally purchased 2017-04-06 for USD 0.010000. proceeds=USD 0.110000, gains=USD 0.100000, note=fee applied: some random fee
 (2017's capital gains: short-term:$765.84 long-term:$0.00)
 (Total capital gains: short-term:$765.84 long-term:$0.00)
 
 === Capital Gains, Tab-Separated (to copy into spreadsheet): ===
-lotName	year	account	currency	currencyAmount	origPurchaseDate	costBasis	saleDate	proceeds	term	gains	note
-1.1.1.spendCapitalGains.1	2017	Bitfinex	BTC	0.001000000	2017-04-06	1.36	2017-11-01	6.77	short	5.41	fee for transferring from Bitfinex to Coinbase
-1.2.1.spendCapitalGains.1	2017	Bitfinex	ETH	0.010000000	2017-04-06	0.28	2017-11-01	2.92	short	2.64	fee for transferring from Bitfinex to Coinbase
-1.3.2	2017	Bitfinex	DASH	4.000000000	2017-04-06	256.92	2017-11-02	1045.04	short	788.11	exchanging DASH for BTC
-2.2	2017	Bitfinex	BCH	0.358531680	2017-08-01	212.25	2017-11-02	192.41	short	-19.84	exchanging BCH for BTC
-3.2	2017	Bitfinex	BTG	0.419883380	2017-10-23	57.39	2017-11-02	46.90	short	-10.48	exchanging BTG for BTC
-4.1.spendCapitalGains.1	2017	Bitfinex	BTC	0.000500000	2017-11-02	3.48	2017-11-01	3.38	short	-0.10	fee for transferring from Bitfinex to Coinbase
-1.1.1.spendCapitalGains.2	2017	Coinbase	BTC	0.000010000	2017-04-06	0.01	2017-12-01	0.11	short	0.10	fee applied: some random fee
+lotName	year	account	currency	currencyAmount	origPurchaseDate	costBasis	saleDate	proceeds	category	gains	note	pricePath
+1.1.1.spendCapitalGains.1	2017	Bitfinex	BTC	0.001000000	2017-04-06	1.36	2017-11-01	6.77	short-term	5.41	fee for transferring from Bitfinex to Coinbase	
+1.2.1.spendCapitalGains.1	2017	Bitfinex	ETH	0.010000000	2017-04-06	0.28	2017-11-01	2.92	short-term	2.64	fee for transferring from Bitfinex to Coinbase	
+1.3.2	2017	Bitfinex	DASH	4.000000000	2017-04-06	256.92	2017-11-02	1045.04	short-term	788.12	exchanging DASH for BTC	
+2.2	2017	Bitfinex	BCH	0.358531680	2017-08-01	212.25	2017-11-02	192.41	short-term	-19.84	exchanging BCH for BTC	
+3.2	2017	Bitfinex	BTG	0.419883380	2017-10-23	57.39	2017-11-02	46.90	short-term	-10.49	exchanging BTG for BTC	
+4.1.spendCapitalGains.1	2017	Bitfinex	BTC	0.000500000	2017-11-02	3.48	2017-11-01	3.38	short-term	-0.10	fee for transferring from Bitfinex to Coinbase	
+1.1.1.spendCapitalGains.2	2017	Coinbase	BTC	0.000010000	2017-04-06	0.01	2017-12-01	0.11	short-term	0.10	fee applied: some random fee	
 
 === Account balances (and their lots): ===
 Coinbase
-	BTC 0.602905590 (basis:1859.787052	price:$3084.706930)
-		1.1.1  2017-04-06 Coinbase BTC 0.418873380  (basis:$575.526333   price:$1373.986413)
-		4.1    2017-11-02 Coinbase BTC 0.184032210  (basis:$1284.260719  price:$6978.456211)
-	ETH 9.190000000 (basis:260.691223	price:$28.366836)
-		1.2.1  2017-04-06 Coinbase ETH 9.190000000  (basis:$260.691223  price:$28.366836)
+	BTC 0.602905590 (basis:1859.780000	price:$3084.695234)
+		1.1.1  2017-04-06 Coinbase BTC 0.418873380  (basis:$575.530000   price:$1373.995168)
+		4.1    2017-11-02 Coinbase BTC 0.184032210  (basis:$1284.250000  price:$6978.397966)
+	ETH 9.190000000 (basis:260.700000	price:$28.367791)
+		1.2.1  2017-04-06 Coinbase ETH 9.190000000  (basis:$260.700000  price:$28.367791)
 (Total basis: $2120.48)
 (Total initial investment: $1085.00)
 
 === Present Value, Tab-Separated (to copy into spreadsheet): ===
-lotName	account	currency	amount	costBasis	origPurchaseDate	daysSincePurchase	shortOrLongTerm	presentValue	unrealizedGainLoss	unrealizedGainLossPercent
-1.1.1	Coinbase	BTC	0.418873380	575.53	2017-04-06	626	longTerm	1687.59	1112.07	193.2
-1.2.1	Coinbase	ETH	9.190000000	260.69	2017-04-06	626	longTerm	1195.07	934.38	358.4
-4.1	Coinbase	BTC	0.184032210	1284.26	2017-11-02	416	longTerm	741.45	-542.82	-42.3
+lotName	account	currency	amount	costBasis	origPurchaseDate	daysSincePurchase	category	presentValue	unrealizedGainLoss	unrealizedGainLossPercent
+1.1.1	Coinbase	BTC	0.418873380	575.53	2017-04-06	626	long-term	1687.59	1112.06	193.2
+1.2.1	Coinbase	ETH	9.190000000	260.70	2017-04-06	626	long-term	1195.07	934.37	358.4
+4.1	Coinbase	BTC	0.184032210	1284.25	2017-11-02	416	long-term	741.45	-542.80	-42.3
 
 `))
 }
 
+func TestErrors(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.83976678"), n("960"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	lotsBefore := l.PrintLots()
+
+	t.Run("lot not found", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := l.SellTaxable(d("2017-12-01"), "42", BTC, n("0.1"), n("1000"))
+
+		var notFound *ledger.LotNotFoundError
+		g.Expect(errors.As(err, &notFound)).To(BeTrue())
+		g.Expect(notFound.Name).To(Equal("42"))
+	})
+
+	t.Run("currency mismatch", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := l.Transfer(d("2017-11-01"), "1.1", ETH, n("0.1"), n("0"), Coinbase)
+
+		var mismatch *ledger.CurrencyMismatchError
+		g.Expect(errors.As(err, &mismatch)).To(BeTrue())
+		g.Expect(mismatch.Expected).To(Equal(ETH))
+		g.Expect(mismatch.Actual).To(Equal(BTC))
+	})
+
+	t.Run("insufficient amount", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := l.TransferMultipleLots(d("2017-11-01"), []string{"1.1"}, BTC, n("1.5"), n("0.001"), Coinbase)
+
+		var insufficient *ledger.InsufficientAmountError
+		g.Expect(errors.As(err, &insufficient)).To(BeTrue())
+		g.Expect(insufficient.Requested).To(Equal(n("1.5")))
+	})
+
+	t.Run("missing price", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		// the transfer itself succeeds, but the fee can't be valued on this date
+		_, err := l.Transfer(d("2017-11-05"), "1.1", BTC, n("0.5"), n("0.001"), Coinbase)
+
+		var missingPrice *ledger.MissingPriceError
+		g.Expect(errors.As(err, &missingPrice)).To(BeTrue())
+		g.Expect(missingPrice.Currency).To(Equal(BTC))
+	})
+
+	t.Run("invalid merge", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := l.MergeIdenticalLots(d("2017-11-02"), BTC, []string{"1.1"})
+
+		var invalid *ledger.InvalidOperationError
+		g.Expect(errors.As(err, &invalid)).To(BeTrue())
+		g.Expect(invalid.LotName).To(Equal("1.1"))
+	})
+
+	t.Run("invalid amount", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := l.SellTaxable(d("2017-12-01"), "1.1", BTC, n("0.000000001"), n("10"))
+
+		var invalid *ledger.InvalidAmountError
+		g.Expect(errors.As(err, &invalid)).To(BeTrue())
+		g.Expect(invalid.Currency).To(Equal(BTC))
+	})
+
+	// none of the failed operations left a trace
+	g.Expect(l.PrintLots()).To(Equal(lotsBefore))
+
+	// and the next lot names pick up where they left off
+	newLot, err := l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.5"), n("0"), Coinbase)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(newLot.Name()).To(Equal("1.1.1"))
+}
+
+func TestTransactions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	_, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.83976678"), n("960"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.8"), n("0.001"), Coinbase)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Income(d("2017-11-02"), Coinbase, BCH, n("0.8"), n("100"), "fork from BTC")
+	g.Expect(err).NotTo(HaveOccurred())
+	ethLot, err := l.PurchaseWithNewMoney(d("2017-11-03"), Coinbase, ETH, n("1.5"), n("450"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ethLot.Name()).To(Equal("3.1"))
+
+	// failed operations aren't recorded
+	_, err = l.SellTaxable(d("2017-12-01"), "1.1", BTC, n("5"), n("100"))
+	g.Expect(err).To(HaveOccurred())
+	_, err = l.PurchaseWithNewMoney(d("2017-12-01"), Coinbase, ETH, n("1.5"), n("450.001"))
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(l.Transactions()).To(HaveLen(6))
+	g.Expect(l.NumTransactions()).To(Equal(6))
+	g.Expect(l.TransactionsFrom(4)).To(Equal(l.Transactions()[4:]))
+
+	tx, ok := l.TransactionForLot("1.1.1")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(tx.Type).To(Equal(ledger.TransferTransaction))
+	g.Expect(tx.Date).To(Equal(d("2017-11-01")))
+	g.Expect(tx.Accounts).To(Equal([]ledger.Account{Bitfinex, Coinbase}))
+	g.Expect(tx.LotNames).To(Equal([]string{"1.1.1", "1.1.1.spendCapitalGains", "1.1.1.spendCapitalGains.1"}))
+	g.Expect(tx.Inputs).To(HaveLen(1))
+	g.Expect(tx.Inputs[0].Amount).To(Equal(n("0.8")))
+	g.Expect(tx.Fees).To(HaveLen(1))
+	g.Expect(tx.Fees[0].Value).To(Equal(n("6.77")))
+
+	_, ok = l.TransactionForLot("42")
+	g.Expect(ok).To(BeFalse())
+
+	//fmt.Println(l.PrintTransactions())
+	//return
+	g.Expect(l.PrintTransactions()).To(Equal(
+		`2017-04-06 deposit Bitfinex lots:1
+  out  1  Bitfinex USD 960.000000000  (basis:$1085.00)
+2017-04-06 purchase Bitfinex lots:1.1
+  in   1    Bitfinex USD 960.000000000  (basis:$1085.00)
+  out  1.1  Bitfinex BTC 0.839766780    (basis:$1085.00)
+2017-11-01 transfer Bitfinex,Coinbase lots:1.1.1,1.1.1.spendCapitalGains,1.1.1.spendCapitalGains.1
+  in   1.1    Bitfinex BTC 0.800000000  (basis:$1033.62)
+  out  1.1.1  Coinbase BTC 0.799000000  (basis:$1039.10)
+  fee  1.1.1  Bitfinex BTC 0.001000000  (basis:$1.29  value:$6.77)
+2017-11-02 income Coinbase (fork from BTC) lots:2
+  out  2  Coinbase BCH 0.800000000  (basis:$100.00)
+2017-11-03 deposit Coinbase lots:3
+  out  3  Coinbase USD 450.000000000  (basis:$450.00)
+2017-11-03 purchase Coinbase lots:3.1
+  in   3    Coinbase USD 450.000000000  (basis:$450.00)
+  out  3.1  Coinbase ETH 1.500000000    (basis:$450.00)
+`))
+}
+
 // simpleScenario creates a ledger and adds some simple activity to it.
 // Then prints various reports (lots, capital gains, account balances)
 func simpleScenario(w io.Writer) {
 	l := ledger.New(USD, historicalPrices)
 
 	// put up some money to invest, it has a higher cost-basis capturing transfer and deposit fees.
-	l.DepositNewMoney(d("2017-04-06"), Bitfinex, 960, 1085 /* cost basis: $85 wire transfer + $40 deposit fee */)
+	l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085") /* cost basis: $85 wire transfer + $40 deposit fee */)
 
 	// use the investment money to purchase BTC
-	l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, 0.83976678, 959+1.00 /*trade fee*/)
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.83976678"), n("959").Add(n("1.00") /*trade fee*/))
 
 	// transfer the BTC to another account, cost basis is preserved
-	l.Transfer(d("2017-11-01"), "1.1", BTC, 0.80000000, 0.001, Coinbase)
+	l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.80000000"), n("0.001"), Coinbase)
 
-	l.SellTaxable(d("2017-12-01"), "1.1", BTC, 0.039766780, 436.46)
+	l.SellTaxable(d("2017-12-01"), "1.1", BTC, n("0.03976678"), n("436.46"))
 
 	//
 	// Print results
@@ -204,39 +343,15 @@ func simpleScenario(w io.Writer) {
 	fmt.Fprintln(w, l.PrintAccounts())
 
 	fmt.Fprintln(w, "=== Present Value, Tab-Separated (to copy into spreadsheet): ===")
-	fmt.Fprintln(w, l.PrintPresentValueTSV(d("2018-12-23"), map[ledger.Currency]float64{
-		BTC: 4028.89,
+	fmt.Fprintln(w, l.PrintPresentValueTSV(d("2018-12-23"), map[ledger.Currency]ledger.Decimal{
+		BTC: n("4028.89"),
 	}))
 }
 
 // largerScenario creates a ledger and adds a variety of activity to it.
 // Then prints various reports (lots, income, capital gains, account balances)
 func largerScenario(w io.Writer) {
-	l := ledger.New(USD, historicalPrices)
-
-	l.DepositNewMoney(d("2017-04-06"), Bitfinex, 960, 1085 /* cost basis: $85 wire transfer + $40 deposit fee */)
-	l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, 0.41988338, 503.35+1.00 /*trade fee*/)
-	l.Purchase(d("2017-04-06"), "1", Bitfinex, ETH, 9.2000, 227.325+1.00 /*trade fee*/)
-	l.Purchase(d("2017-04-06"), "1", Bitfinex, DASH, 4.000, 226.325+1.00 /*trade fee*/)
-
-	l.Income(d("2017-08-01"), Bitfinex, BCH, 0.35853168, 212.25, "fork from BTC")
-	l.Income(d("2017-10-23"), Bitfinex, BTG, 0.41988338, 57.386, "fork from BTC")
-
-	// 11/1: transferred BTC, ETH to Coinbase
-	l.Transfer(d("2017-11-01"), "1.1", BTC, 0.419883380, 0.001, Coinbase)
-	l.Transfer(d("2017-11-01"), "1.2", ETH, 9.2000, 0.01, Coinbase)
-
-	//// 11/2 Exchanged DASH, BCH, & BTG for BTC, which we treat as a taxable event
-	l.ExchangeTaxable(d("2017-11-02"), "1.3", DASH, 4.000, 0, false, BTC, 0.15014768)   // after 0.0002018 BTC fee
-	l.ExchangeTaxable(d("2017-11-02"), "2", BCH, 0.35853168, 0, false, BTC, 0.02764547) // after 0.00005701 BTC fee
-	l.ExchangeTaxable(d("2017-11-02"), "3", BTG, 0.41988338, 0, false, BTC, 0.00673906) // after 0.00002753 BTC fee
-	l.MergeIdenticalLots(d("2017-11-02"), BTC, []string{"1.3.1", "2.1", "3.1"})
-
-	// 11/2 Transfer BTC from Bitfinex to Coinbase
-	l.Transfer(d("2017-11-01"), "4", BTC, 0.18453221, 0.0005, Coinbase)
-
-	// 12/1 Invent some random fee
-	l.Fee(d("2017-12-01"), "1.1.1", BTC, 0.00001, "1.1.1", "some random fee")
+	l := largerScenarioLedger()
 
 	//
 	// Print results
@@ -256,12 +371,48 @@ func largerScenario(w io.Writer) {
 	fmt.Fprintln(w, l.PrintAccounts())
 
 	fmt.Fprintln(w, "=== Present Value, Tab-Separated (to copy into spreadsheet): ===")
-	fmt.Fprintln(w, l.PrintPresentValueTSV(d("2018-12-23"), map[ledger.Currency]float64{
-		BTC: 4028.89,
-		ETH: 130.04,
+	fmt.Fprintln(w, l.PrintPresentValueTSV(d("2018-12-23"), map[ledger.Currency]ledger.Decimal{
+		BTC: n("4028.89"),
+		ETH: n("130.04"),
 	}))
 }
 
+// largerScenarioLedger creates a ledger and adds a variety of activity to it.
+func largerScenarioLedger() *ledger.Ledger {
+	l := ledger.New(USD, historicalPrices)
+
+	l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("960"), n("1085") /* cost basis: $85 wire transfer + $40 deposit fee */)
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, BTC, n("0.41988338"), n("503.35").Add(n("1.00") /*trade fee*/))
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, ETH, n("9.2000"), n("227.33").Add(n("1.00") /*trade fee*/))
+	l.Purchase(d("2017-04-06"), "1", Bitfinex, DASH, n("4.000"), n("226.32").Add(n("1.00") /*trade fee*/))
+
+	l.Income(d("2017-08-01"), Bitfinex, BCH, n("0.35853168"), n("212.25"), "fork from BTC")
+	l.Income(d("2017-10-23"), Bitfinex, BTG, n("0.41988338"), n("57.39"), "fork from BTC")
+
+	// 11/1: transferred BTC, ETH to Coinbase
+	l.Transfer(d("2017-11-01"), "1.1", BTC, n("0.41988338"), n("0.001"), Coinbase)
+	l.Transfer(d("2017-11-01"), "1.2", ETH, n("9.2000"), n("0.01"), Coinbase)
+
+	//// 11/2 Exchanged DASH, BCH, & BTG for BTC, which we treat as a taxable event
+	l.ExchangeTaxable(d("2017-11-02"), "1.3", DASH, n("4.000"), n("0"), false, BTC, n("0.15014768"))   // after 0.0002018 BTC fee
+	l.ExchangeTaxable(d("2017-11-02"), "2", BCH, n("0.35853168"), n("0"), false, BTC, n("0.02764547")) // after 0.00005701 BTC fee
+	l.ExchangeTaxable(d("2017-11-02"), "3", BTG, n("0.41988338"), n("0"), false, BTC, n("0.00673906")) // after 0.00002753 BTC fee
+	l.MergeIdenticalLots(d("2017-11-02"), BTC, []string{"1.3.1", "2.1", "3.1"})
+
+	// 11/2 Transfer BTC from Bitfinex to Coinbase
+	l.Transfer(d("2017-11-01"), "4", BTC, n("0.18453221"), n("0.0005"), Coinbase)
+
+	// 12/1 Invent some random fee
+	l.Fee(d("2017-12-01"), "1.1.1", BTC, n("0.00001"), "1.1.1", "some random fee")
+
+	return l
+}
+
+// n parses a decimal number.
+func n(s string) ledger.Decimal {
+	return ledger.MustParseDecimal(s)
+}
+
 func d(date string) time.Time {
 	t, err := time.Parse("2006-01-02", date)
 	if err != nil {
@@ -2,7 +2,7 @@ package ledger
 
 import (
 	"fmt"
-	"math"
+	"strings"
 	"time"
 )
 
@@ -16,15 +16,20 @@ type (
 		account                Account
 		currency               Currency
 		originalPurchaseTime   time.Time
-		originalPurchaseAmount float64 // for future reference, as we modify the amount & remaining cost basis
-		originalCostBasis      float64 // for future reference, as we modify the amount & remaining cost basis
+		originalPurchaseAmount Decimal // for future reference, as we modify the amount & remaining cost basis
+		originalCostBasis      Decimal // for future reference, as we modify the amount & remaining cost basis
 
 		// taxableGainsDetails is non-nil only for TaxableGains LotTypes
 		taxableGainsDetails *TaxableGainsDetails
 
+		// washSaleOf names the TaxableGains lot whose disallowed loss, washSaleLoss, was added to this lot's cost basis,
+		// if it replaced a wash sale. See Ledger.SetWashSaleRule.
+		washSaleOf   string
+		washSaleLoss Decimal
+
 		// mutable fields
-		amount            float64
-		costBasis         float64
+		amount            Decimal
+		costBasis         Decimal
 		sequenceGenerator int
 	}
 
@@ -39,17 +44,33 @@ type (
 		// Date of purchase: 07/10/2017
 		originalPurchaseTime time.Time
 		// Cost basis:        $1,000.00
-		costBasis float64
+		costBasis Decimal
+		// lotCostBasis is the cost basis removed from the lots sold, which the journal records.
+		// It's the same as costBasis, unless a pooled cost basis method revised that.
+		lotCostBasis Decimal
 
 		// Date of sale:     01/05/2018
 		dateOfSale time.Time
 
 		// Proceeds:         $11,636.53
-		proceeds float64
+		proceeds Decimal
 
-		soldAmount float64
+		soldAmount Decimal
 
 		note string
+
+		// pricePath lists the currencies the sold currency's price was derived through, if it wasn't quoted directly.
+		pricePath []Currency
+
+		// washSaleReplaced is the amount sold which was replaced within the wash sale window,
+		// and washSaleDisallowed is the part of the loss disallowed for it.
+		washSaleReplaced   Decimal
+		washSaleDisallowed Decimal
+
+		// shareMatches are the acquisitions the amount sold was matched with, under UKShareMatching.
+		shareMatches []ShareMatch
+		// superficialLoss is the part of the loss denied by the superficial loss rule, under CanadianACB.
+		superficialLoss Decimal
 	}
 
 	// LotType identifies what kind of lot this is.
@@ -58,13 +79,14 @@ type (
 
 // NewTaxableGainsDetails constructs a *TaxableGainsDetails
 func NewTaxableGainsDetails(account Account, currency Currency, originalPurchaseTime time.Time,
-	costBasis float64, dateOfSale time.Time, proceeds float64, soldAmount float64, note string) *TaxableGainsDetails {
+	costBasis Decimal, dateOfSale time.Time, proceeds Decimal, soldAmount Decimal, note string) *TaxableGainsDetails {
 
 	return &TaxableGainsDetails{
 		account:              account,
 		currency:             currency,
 		originalPurchaseTime: originalPurchaseTime,
 		costBasis:            costBasis,
+		lotCostBasis:         costBasis,
 		dateOfSale:           dateOfSale,
 		proceeds:             proceeds,
 		soldAmount:           soldAmount,
@@ -72,16 +94,90 @@ func NewTaxableGainsDetails(account Account, currency Currency, originalPurchase
 	}
 }
 
-// Gains returns the value of the proceeds minus the cost basis.
-func (d *TaxableGainsDetails) Gains() float64 {
-	return d.proceeds - d.costBasis
+// Account returns the account the asset was sold from.
+func (d *TaxableGainsDetails) Account() Account {
+	return d.account
+}
+
+// Currency returns the currency sold.
+func (d *TaxableGainsDetails) Currency() Currency {
+	return d.currency
+}
+
+// OriginalPurchaseTime returns when the asset sold was originally purchased.
+func (d *TaxableGainsDetails) OriginalPurchaseTime() time.Time {
+	return d.originalPurchaseTime
+}
+
+// CostBasis returns the cost basis of the amount sold.
+func (d *TaxableGainsDetails) CostBasis() Decimal {
+	return d.costBasis
+}
+
+// DateOfSale returns when the asset was sold.
+func (d *TaxableGainsDetails) DateOfSale() time.Time {
+	return d.dateOfSale
+}
+
+// Proceeds returns the value received for the amount sold, in the local currency.
+func (d *TaxableGainsDetails) Proceeds() Decimal {
+	return d.proceeds
+}
+
+// SoldAmount returns the amount sold.
+func (d *TaxableGainsDetails) SoldAmount() Decimal {
+	return d.soldAmount
+}
+
+// Note returns the note describing the sale.
+func (d *TaxableGainsDetails) Note() string {
+	return d.note
+}
+
+// PricePath returns the currencies the price used for the proceeds was derived through, e.g. [XYZ BTC USD],
+// or nil if the price was quoted directly. See CrossRates.
+func (d *TaxableGainsDetails) PricePath() []Currency {
+	return d.pricePath
+}
+
+// pricePathString returns the price path like "XYZ->BTC->USD", or "" if there's none.
+func (d *TaxableGainsDetails) pricePathString() string {
+	names := make([]string, len(d.pricePath))
+	for i, currency := range d.pricePath {
+		names[i] = currency.String()
+	}
+	return strings.Join(names, "->")
+}
+
+// WashSaleDisallowed returns the part of the loss disallowed by the wash sale rule, which was added to the cost basis
+// of the replacement lots instead. It's the adjustment reported with code W on Form 8949.
+func (d *TaxableGainsDetails) WashSaleDisallowed() Decimal {
+	return d.washSaleDisallowed
+}
+
+// ShareMatches returns how the amount sold was matched with acquisitions by the UK share matching rules, whose
+// costs add up to the cost basis. It's nil unless the ledger uses UKShareMatching.
+func (d *TaxableGainsDetails) ShareMatches() []ShareMatch {
+	return d.shareMatches
+}
+
+// SuperficialLoss returns the part of the loss denied by Canada's superficial loss rule, which was added to the
+// adjusted cost base of the currency still held instead. It's zero unless the ledger uses CanadianACB.
+func (d *TaxableGainsDetails) SuperficialLoss() Decimal {
+	return d.superficialLoss
+}
+
+// Gains returns the value of the proceeds minus the cost basis, plus any loss disallowed by the wash sale rule or
+// denied by the superficial loss rule.
+func (d *TaxableGainsDetails) Gains() Decimal {
+	return d.proceeds.Sub(d.costBasis).Add(d.washSaleDisallowed).Add(d.superficialLoss)
 }
 
-// IsLongTerm returns true if the currency was held for more than one year.
-// If false, the gains are to be considered short-term gains.
+// IsLongTerm returns true if the currency was held for more than one year by the US rule, i.e. it was sold after the
+// anniversary of its purchase. If false, the gains are to be considered short-term gains.
+// Other jurisdictions classify gains differently, see Ledger.GainsCategory.
 func (d *TaxableGainsDetails) IsLongTerm() bool {
-	duration := d.dateOfSale.Sub(d.originalPurchaseTime)
-	return duration >= OneYearForCapitalGains
+	return USTaxRules{}.Classify(d.originalPurchaseTime, d.dateOfSale).LongTerm
 }
 
 const (
@@ -92,13 +188,15 @@ const (
 	// TaxableGains represents the calculated taxable gains from a sale (or non-like exchange) of an asset.
 	TaxableGains
 
-	// OneYearForCapitalGains is used to determine shot-term vs. long-term capital gains.
-	// Not sure if we need a better definition of one year?
+	// OneYearForCapitalGains was used to determine short-term vs. long-term capital gains.
+	//
+	// Deprecated: holding periods are measured by calendar dates, which this can't do around leap years.
+	// See TaxRules.
 	OneYearForCapitalGains = 24 * time.Hour * 365
 )
 
 // NewLot creates a new lot.
-func NewLot(parent *Lot, name string, lotType LotType, purchaseTime time.Time, account Account, currency Currency, amount, costBasis float64) *Lot {
+func NewLot(parent *Lot, name string, lotType LotType, purchaseTime time.Time, account Account, currency Currency, amount, costBasis Decimal) *Lot {
 	return &Lot{
 		parent:               parent,
 		name:                 name,
@@ -115,13 +213,13 @@ func NewLot(parent *Lot, name string, lotType LotType, purchaseTime time.Time, a
 }
 
 // NewChildLot creates a child lot of the given parent, deriving the name from the parent.
-func NewChildLot(parent *Lot, lotType LotType, purchaseTime time.Time, account Account, currency Currency, amount, costBasis float64) *Lot {
+func NewChildLot(parent *Lot, lotType LotType, purchaseTime time.Time, account Account, currency Currency, amount, costBasis Decimal) *Lot {
 	return NewLot(parent, parent.nameChild(), lotType, purchaseTime, account, currency, amount, costBasis)
 }
 
 // NewTaxableGainsLot creates a TaxableGains lot, and also determines whether it's long-term or short-term.
-func NewTaxableGainsLot(parent *Lot, date time.Time, soldAmount, costBasis, proceeds float64, localCurrency Currency, note string) *Lot {
-	lot := NewChildLot(parent, TaxableGains, date, "", localCurrency, 0, 0)
+func NewTaxableGainsLot(parent *Lot, date time.Time, soldAmount, costBasis, proceeds Decimal, localCurrency Currency, note string) *Lot {
+	lot := NewChildLot(parent, TaxableGains, date, "", localCurrency, Decimal{}, Decimal{})
 
 	lot.taxableGainsDetails = NewTaxableGainsDetails(
 		parent.account, parent.currency, parent.originalPurchaseTime,
@@ -135,23 +233,99 @@ func (lot *Lot) Name() string {
 	return lot.name
 }
 
-// String returns a string describing the lot.
+// Parent returns the lot this lot was derived from, or nil.
+func (lot *Lot) Parent() *Lot {
+	return lot.parent
+}
+
+// Type returns the type of the lot.
+func (lot *Lot) Type() LotType {
+	return lot.lotType
+}
+
+// Account returns the account holding the lot.
+func (lot *Lot) Account() Account {
+	return lot.account
+}
+
+// Currency returns the currency held in the lot.
+func (lot *Lot) Currency() Currency {
+	return lot.currency
+}
+
+// OriginalPurchaseTime returns when the assets in the lot were originally acquired.
+func (lot *Lot) OriginalPurchaseTime() time.Time {
+	return lot.originalPurchaseTime
+}
+
+// Amount returns the amount remaining in the lot.
+func (lot *Lot) Amount() Decimal {
+	return lot.amount
+}
+
+// CostBasis returns the cost basis remaining in the lot.
+func (lot *Lot) CostBasis() Decimal {
+	return lot.costBasis
+}
+
+// OriginalAmount returns the amount the lot was created with.
+func (lot *Lot) OriginalAmount() Decimal {
+	return lot.originalPurchaseAmount
+}
+
+// OriginalCostBasis returns the cost basis the lot was created with.
+func (lot *Lot) OriginalCostBasis() Decimal {
+	return lot.originalCostBasis
+}
+
+// WashSaleOf returns the name of the TaxableGains lot this lot replaced in a wash sale, and the disallowed loss
+// added to its cost basis, or "" if it didn't replace one.
+func (lot *Lot) WashSaleOf() (string, Decimal) {
+	return lot.washSaleOf, lot.washSaleLoss
+}
+
+// TaxableGainsDetails returns the details of a TaxableGains lot, or nil for other lot types.
+func (lot *Lot) TaxableGainsDetails() *TaxableGainsDetails {
+	return lot.taxableGainsDetails
+}
+
+// String returns a string describing the lot. TaxableGains lots are classified by the US rules, see describe.
 func (lot *Lot) String() string {
+	return lot.describe(USTaxRules{})
+}
 
+// describe returns a string describing the lot, classifying a TaxableGains lot's gains by the rules.
+func (lot *Lot) describe(rules TaxRules) string {
 	if lot.lotType == TaxableGains {
 		details := lot.taxableGainsDetails
-		term := "short"
-		if details.IsLongTerm() {
-			term = "long"
-		}
-		return fmt.Sprintf("%s\t%s Taxable Gains (%s-term) from sale on %s of %s %0.9f originally purchased %s for USD %f. proceeds=USD %f, gains=USD %f, note=%s",
+		category := rules.Classify(details.originalPurchaseTime, details.dateOfSale)
+		s := fmt.Sprintf("%s\t%s Taxable Gains (%s) from sale on %s of %s %0.9f originally purchased %s for USD %f. proceeds=USD %f, gains=USD %f, note=%s",
 			lot.name, lot.originalPurchaseTime.Format("2006-01-02"),
-			term, details.account, details.currency, details.soldAmount, details.originalPurchaseTime.Format("2006-01-02"),
+			category.Name, details.account, details.currency, details.soldAmount, details.originalPurchaseTime.Format("2006-01-02"),
 			details.costBasis, details.proceeds, details.Gains(), details.note)
+		if len(details.pricePath) > 0 {
+			s += ", price via " + details.pricePathString()
+		}
+		if !details.washSaleDisallowed.IsZero() {
+			s += fmt.Sprintf(", wash sale (W) disallowed=USD %f", details.washSaleDisallowed)
+		}
+		if !details.superficialLoss.IsZero() {
+			s += fmt.Sprintf(", superficial loss denied=USD %f", details.superficialLoss)
+		}
+		if len(details.shareMatches) > 0 {
+			s += ", matched " + shareMatchesString(details.shareMatches)
+		}
+		return s
 	}
 
 	return fmt.Sprintf("%s\t%s %s %s %0.9f\t(basis:$%f\tprice:$%f)", lot.name, lot.originalPurchaseTime.Format("2006-01-02"),
-		lot.account, lot.currency, lot.amount, lot.costBasis, lot.costBasis/lot.amount)
+		lot.account, lot.currency, lot.amount, lot.costBasis, unitPrice(lot.costBasis, lot.amount))
+}
+
+// unitPrice returns the price per unit, for display.
+// Like any float division, it's NaN when both are zero.
+func unitPrice(costBasis, amount Decimal) float64 {
+	return costBasis.Float64() / amount.Float64()
 }
 
 func (lot *Lot) nameChild() string {
@@ -159,34 +333,32 @@ func (lot *Lot) nameChild() string {
 	return fmt.Sprintf("%s.%d", lot.name, lot.sequenceGenerator)
 }
 
-// Remove removes the given amount from the lot, and returns the costBasis represented by that.
-func (lot *Lot) Remove(currency Currency, amount float64) float64 {
+// Remove removes the given amount from the lot, and returns the costBasis represented by that,
+// rounded to basisPlaces decimal places.
+// Removing the whole amount removes the whole cost basis, so nothing is ever lost to rounding.
+// The lot is left unchanged if an error is returned.
+func (lot *Lot) Remove(currency Currency, amount Decimal, basisPlaces int32) (Decimal, error) {
 	if lot.currency != currency {
-		panic("Lot does not contain " + currency.String() + "\n" + lot.String())
-	}
-
-	// We'll soon calculate percentageToRemove. But it behaves strangely for very small numbers.
-	// For instance, if the lot only has 0.00000001 and you try to remove that, it might have an issue
-	// because the lot actually has 0.00000001001, and the difference is large percentage-wise, but
-	// insignificant in terms of the actual amount.
-	// So let's first just round the amount if it matches the lot amount to within 9 decimal places.
-	if diff := math.Abs(amount - lot.amount); diff < 0.000000001 {
-		amount = lot.amount
+		return Decimal{}, &CurrencyMismatchError{LotName: lot.name, Expected: currency, Actual: lot.currency}
 	}
-	percentageToRemove := amount / lot.amount
-	if RoundPlaces(percentageToRemove, 12) > 1.0 {
-		panic(fmt.Sprintf("Lot has less than the needed amount %f\n%s", amount, lot))
+	if amount.Sign() < 0 {
+		return Decimal{}, &InvalidAmountError{Currency: currency, Amount: amount, Reason: "can't remove a negative amount"}
 	}
 
-	if percentageToRemove > 0.999999999999999 {
-		// let's just round up to 100%
-		percentageToRemove = 1.0
+	var costBasisToRemove Decimal
+	switch amount.Cmp(lot.amount) {
+	case 1:
+		return Decimal{}, &InsufficientAmountError{LotName: lot.name, Currency: currency, Requested: amount, Available: lot.amount}
+	case 0:
+		costBasisToRemove = lot.costBasis
+	default:
+		// split the cost basis in proportion to the amount removed
+		costBasisToRemove = lot.costBasis.Mul(amount).Quo(lot.amount, basisPlaces)
 	}
 
 	// Update the existing lot
-	costBasisToRemove := lot.costBasis * percentageToRemove
-	lot.costBasis = lot.costBasis - costBasisToRemove
-	lot.amount = lot.amount - (lot.amount * percentageToRemove)
+	lot.costBasis = lot.costBasis.Sub(costBasisToRemove)
+	lot.amount = lot.amount.Sub(amount)
 
-	return costBasisToRemove
+	return costBasisToRemove, nil
 }
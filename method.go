@@ -0,0 +1,231 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CostBasisMethod chooses how the cost basis of an amount sold is worked out, see Ledger.SetCostBasisMethod.
+type CostBasisMethod int
+
+const (
+	// LotCostBasis uses the cost basis of the lots the amount was removed from, which are chosen when it's sold
+	// (e.g. by a LotSelector). It's the default.
+	LotCostBasis CostBasisMethod = iota
+	// UKShareMatching follows HMRC's share matching rules, where the lots sold from don't matter: see
+	// Ledger.Section104Pools.
+	UKShareMatching
+	// CanadianACB uses a running adjusted cost base for each currency across all accounts, and Canada's superficial
+	// loss rule: see SuperficialLossDays and Ledger.Schedule3.
+	CanadianACB
+	// AverageCost pools the lots of each currency in each account at their weighted average cost, so an amount sold
+	// or transferred out takes the average cost of what the account holds.
+	AverageCost
+)
+
+var costBasisMethodNames = enumNames[CostBasisMethod]{
+	LotCostBasis:    "lots",
+	UKShareMatching: "uk",
+	CanadianACB:     "acb",
+	AverageCost:     "average",
+}
+
+// String returns the name of the method, e.g. "uk".
+func (m CostBasisMethod) String() string {
+	return costBasisMethodNames.name(m)
+}
+
+// MarshalText encodes the CostBasisMethod by name, e.g. "uk".
+func (m CostBasisMethod) MarshalText() ([]byte, error) {
+	return costBasisMethodNames.marshal("cost basis method", m)
+}
+
+// UnmarshalText decodes a CostBasisMethod encoded by MarshalText.
+func (m *CostBasisMethod) UnmarshalText(text []byte) error {
+	return costBasisMethodNames.unmarshal("cost basis method", text, m)
+}
+
+// SetCostBasisMethod chooses how the cost basis of amounts sold is worked out. It must be chosen before any
+// transactions are recorded.
+//
+// Lots are still created and drawn from as usual. Under AverageCost the lots of each currency in an account share the
+// account's average cost, so that's what PrintAccounts shows and what's removed with any amount sold. The other
+// methods leave the lots with their own cost basis, so PrintAccounts shows the same holdings, and only decide the
+// cost basis in each TaxableGains lot's details, which the gains reports use.
+func (l *Ledger) SetCostBasisMethod(method CostBasisMethod) error {
+	if _, ok := costBasisMethodNames[method]; !ok {
+		return fmt.Errorf("unknown cost basis method %d", int(method))
+	}
+	if len(l.transactions) > 0 && method != l.costBasisMethod {
+		return fmt.Errorf("can't change the cost basis method to %s after transactions have been recorded", method)
+	}
+	l.costBasisMethod = method
+	return nil
+}
+
+// CostBasisMethod returns how the cost basis of amounts sold is worked out.
+func (l *Ledger) CostBasisMethod() CostBasisMethod {
+	return l.costBasisMethod
+}
+
+// applyCostBasisMethod revises the cost basis of the amounts sold so far, for methods where later transactions
+// can change it. It's run after each transaction is recorded. AverageCost restates the lots held as the transaction
+// is recorded instead, see applyAverageCost.
+func (l *Ledger) applyCostBasisMethod() {
+	switch l.costBasisMethod {
+	case UKShareMatching, CanadianACB:
+		l.replayCostBasisMethod()
+	}
+}
+
+// poolsDisposals returns true if the cost basis method works out the cost basis of amounts disposed of from a pool,
+// so every disposal needs a TaxableGains lot, even one without gains by the cost basis of the lot it came from.
+func (l *Ledger) poolsDisposals() bool {
+	return l.costBasisMethod == UKShareMatching || l.costBasisMethod == CanadianACB
+}
+
+// costBasisEvent is a change to the amount of a currency held across all accounts, or to its cost basis,
+// as the pooled cost basis methods see it.
+type costBasisEvent struct {
+	date     time.Time
+	currency Currency
+	// event is one of the event names below
+	event  string
+	amount Decimal
+	cost   Decimal
+	// gains is the TaxableGains lot recording a disposal
+	gains *Lot
+	// carry links an amount withdrawn by a non-taxable exchange with the amount received for it, which takes the
+	// cost basis the method removes with the amount withdrawn, rather than the cost basis of its lot.
+	carry *costBasisCarry
+}
+
+// costBasisCarry is the cost basis a non-taxable exchange carries over from the amount withdrawn to the amount
+// received, as the method works it out.
+type costBasisCarry struct {
+	// from is the currency withdrawn, on the date of the exchange
+	from Currency
+	date time.Time
+	cost Decimal
+}
+
+// The kinds of costBasisEvent.
+const (
+	// acquiredEvent is an amount purchased, received in a taxable exchange, or received as income, at its cost.
+	acquiredEvent = "acquired"
+	// disposedEvent is an amount sold, exchanged or spent. The cost is worked out by the method.
+	disposedEvent = "disposed"
+	// feeEvent is cost basis added by a fee, with no change in the amount.
+	feeEvent = "fee"
+	// withdrawnEvent is an amount given up in a non-taxable exchange. The cost basis the method removes with it is
+	// carried over to the currency received.
+	withdrawnEvent = "withdrawn"
+)
+
+// costBasisReplay is a pooled cost basis method's replay of the journal, see Ledger.replayCostBasisMethod.
+type costBasisReplay interface {
+	// add adds an event. Events are added in the order they were recorded, though they needn't be in date order.
+	add(e costBasisEvent)
+	// replay works out the cost basis again, after events dated changed or later were added. It only goes back as
+	// far as those events can make a difference.
+	replay(changed time.Time)
+}
+
+// replayCostBasisMethod brings the pooled cost basis method's replay up to date with the journal, and returns it.
+// The replay is built from the whole journal when it's first needed, e.g. after the ledger is loaded. After that
+// only the transactions recorded since are added, and the events are only replayed from as far back as they can
+// make a difference, rather than from the start.
+func (l *Ledger) replayCostBasisMethod() costBasisReplay {
+	if l.replay == nil {
+		switch l.costBasisMethod {
+		case UKShareMatching:
+			l.replay = newShareMatcher(l.basisPlaces(), true)
+		case CanadianACB:
+			l.replay = newAdjustedCostBase(l.basisPlaces())
+		}
+		l.replayed = 0
+	}
+	if changed, ok := l.addCostBasisEvents(l.replay, l.transactions[l.replayed:]); ok {
+		l.replay.replay(changed)
+	}
+	l.replayed = len(l.transactions)
+	return l.replay
+}
+
+// addCostBasisEvents adds the events of the transactions to the replay, and returns the earliest date of any event
+// added, if there were any.
+func (l *Ledger) addCostBasisEvents(r costBasisReplay, transactions []*Transaction) (changed time.Time, ok bool) {
+	lots := l.lots
+	if len(transactions) == 1 && transactions[0] == l.transactions[len(l.transactions)-1] {
+		// the lots generated by the transaction just recorded are the last ones
+		lots = lots[len(lots)-len(transactions[0].LotNames):]
+	}
+	lotsByName := map[string]*Lot{}
+	for _, lot := range lots {
+		lotsByName[lot.name] = lot
+	}
+	for _, tx := range transactions {
+		for _, e := range l.transactionCostBasisEvents(tx, lotsByName) {
+			r.add(e)
+			if !ok || e.date.Before(changed) {
+				changed, ok = e.date, true
+			}
+		}
+	}
+	return changed, ok
+}
+
+// transactionCostBasisEvents returns the events of the transaction for each currency other than the local currency.
+// Transfers and merges don't change what's held across all accounts, so they aren't events.
+func (l *Ledger) transactionCostBasisEvents(tx *Transaction, lotsByName map[string]*Lot) []costBasisEvent {
+	var events []costBasisEvent
+	add := func(e costBasisEvent) {
+		if e.currency != l.localCurrency {
+			e.date = tx.Date
+			events = append(events, e)
+		}
+	}
+	var carries []*costBasisCarry
+	if tx.Type == ExchangeNonTaxableTransaction {
+		for _, p := range tx.Inputs {
+			var carry *costBasisCarry
+			if p.Currency != l.localCurrency {
+				carry = &costBasisCarry{from: p.Currency, date: tx.Date}
+			}
+			carries = append(carries, carry)
+			add(costBasisEvent{currency: p.Currency, event: withdrawnEvent, amount: p.Amount, cost: p.CostBasis, carry: carry})
+		}
+	}
+	switch tx.Type {
+	case PurchaseTransaction, ExchangeTaxableTransaction, IncomeTransaction, ExchangeNonTaxableTransaction:
+		for i, p := range tx.Outputs {
+			e := costBasisEvent{currency: p.Currency, event: acquiredEvent, amount: p.Amount, cost: p.CostBasis}
+			if i < len(carries) {
+				// each lot received is the child of the lot withdrawn from, in order
+				e.carry = carries[i]
+			}
+			add(e)
+		}
+	}
+	for _, p := range tx.Adjustments {
+		add(costBasisEvent{currency: p.Currency, event: feeEvent, cost: p.CostBasis})
+	}
+	for _, name := range tx.LotNames {
+		if lot := lotsByName[name]; lot != nil && lot.lotType == TaxableGains {
+			details := lot.taxableGainsDetails
+			add(costBasisEvent{currency: details.currency, event: disposedEvent, amount: details.soldAmount, gains: lot})
+		}
+	}
+	return events
+}
+
+// sortedCurrencies returns the currencies in the map, in order.
+func sortedCurrencies[V any](m map[Currency]V) []Currency {
+	currencies := make([]Currency, 0, len(m))
+	for currency := range m {
+		currencies = append(currencies, currency)
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i] < currencies[j] })
+	return currencies
+}
@@ -0,0 +1,239 @@
+package ledger
+
+import (
+	"sort"
+	"time"
+)
+
+type (
+	// PriceSource provides historical prices of currencies, in the ledger's local currency.
+	PriceSource interface {
+		// Price returns the price of one unit of the currency at the given time.
+		// It returns a *MissingPriceError if no price is known.
+		Price(currency Currency, date time.Time) (Quote, error)
+	}
+
+	// Quote is a price provided by a PriceSource.
+	Quote struct {
+		Price Decimal
+		// Date is when the price was quoted. It's the date asked for, unless the price was derived from a nearby date.
+		Date time.Time
+		// Path lists the currencies the price was derived through, starting with the currency priced and ending with
+		// the local currency, e.g. [XYZ BTC USD]. It's empty when the price was quoted directly.
+		Path []Currency
+	}
+
+	// PriceMap is a PriceSource holding prices for exact dates. A price is only found for the exact time.Time it's
+	// recorded under, so times of day must match as well.
+	PriceMap map[Currency]map[time.Time]Decimal
+
+	// pricePoint is a price known for a date.
+	pricePoint struct {
+		date  time.Time
+		price Decimal
+	}
+
+	// pricePoints is a list of prices sorted by date.
+	pricePoints []pricePoint
+
+	// priceSeries holds the known prices of each currency.
+	priceSeries map[Currency]pricePoints
+
+	nearestPreviousSource struct{ series priceSeries }
+
+	interpolatedSource struct{ series priceSeries }
+
+	crossRatesSource struct {
+		localCurrency   Currency
+		local           PriceSource
+		quotes          map[Currency]PriceSource
+		quoteCurrencies []Currency
+	}
+
+	maxStalenessSource struct {
+		source       PriceSource
+		maxStaleness time.Duration
+	}
+)
+
+// interpolationPlaces is the number of decimal places kept in interpolated prices.
+const interpolationPlaces = 18
+
+// Price implements PriceSource.
+func (m PriceMap) Price(currency Currency, date time.Time) (Quote, error) {
+	price, ok := m[currency][date]
+	if !ok {
+		return Quote{}, &MissingPriceError{Currency: currency, Date: date}
+	}
+	return Quote{Price: price, Date: date}, nil
+}
+
+// NearestPrevious returns a PriceSource which uses the latest price known at or before the time asked for,
+// e.g. a trade at 14:32 uses that day's price, and a trade on a day with no price uses the day before.
+// It's best combined with WithMaxStaleness, so that a gap in the prices doesn't go unnoticed.
+func NearestPrevious(prices PriceMap) PriceSource {
+	return nearestPreviousSource{newPriceSeries(prices)}
+}
+
+// Price implements PriceSource.
+func (s nearestPreviousSource) Price(currency Currency, date time.Time) (Quote, error) {
+	points := s.series[currency]
+	i := points.search(date)
+	if i < len(points) && points[i].date.Equal(date) {
+		return Quote{Price: points[i].price, Date: points[i].date}, nil
+	}
+	if i == 0 {
+		return Quote{}, &MissingPriceError{Currency: currency, Date: date}
+	}
+	return Quote{Price: points[i-1].price, Date: points[i-1].date}, nil
+}
+
+// Interpolated returns a PriceSource which interpolates linearly between the known prices either side of the time
+// asked for. After the last known price, that price is used. The quote's Date is that of the nearest known price,
+// so WithMaxStaleness limits how far apart the known prices may be.
+func Interpolated(prices PriceMap) PriceSource {
+	return interpolatedSource{newPriceSeries(prices)}
+}
+
+// Price implements PriceSource.
+func (s interpolatedSource) Price(currency Currency, date time.Time) (Quote, error) {
+	points := s.series[currency]
+	i := points.search(date)
+	switch {
+	case i < len(points) && points[i].date.Equal(date):
+		return Quote{Price: points[i].price, Date: points[i].date}, nil
+	case i == 0:
+		return Quote{}, &MissingPriceError{Currency: currency, Date: date}
+	case i == len(points):
+		return Quote{Price: points[i-1].price, Date: points[i-1].date}, nil
+	}
+
+	before, after := points[i-1], points[i]
+	var (
+		elapsed = durationDecimal(date.Sub(before.date))
+		span    = durationDecimal(after.date.Sub(before.date))
+		price   = before.price.Add(after.price.Sub(before.price).Mul(elapsed).Quo(span, interpolationPlaces))
+		nearest = before
+	)
+	if after.date.Sub(date) < date.Sub(before.date) {
+		nearest = after
+	}
+	return Quote{Price: price, Date: nearest.date}, nil
+}
+
+// WithMaxStaleness returns a PriceSource which returns a *StalePriceError when the source's quote is further than
+// maxStaleness from the time asked for.
+func WithMaxStaleness(source PriceSource, maxStaleness time.Duration) PriceSource {
+	return maxStalenessSource{source: source, maxStaleness: maxStaleness}
+}
+
+// Price implements PriceSource.
+func (s maxStalenessSource) Price(currency Currency, date time.Time) (Quote, error) {
+	quote, err := s.source.Price(currency, date)
+	if err != nil {
+		return Quote{}, err
+	}
+	if absDuration(date.Sub(quote.Date)) > s.maxStaleness {
+		return Quote{}, &StalePriceError{Currency: currency, Date: date, QuoteDate: quote.Date, MaxStaleness: s.maxStaleness}
+	}
+	return quote, nil
+}
+
+// CrossRates returns a PriceSource which uses prices from local, or when local has no price for a currency,
+// derives one by chaining through prices in other currencies on the same date. quotes holds a source of prices
+// denominated in each quote currency, e.g. quotes["BTC"] might price small altcoins in BTC.
+// The shortest chain is used (e.g. XYZ→BTC→USD), and recorded in the quote's Path.
+// The quote's Date is the one furthest from the date asked for, of all the prices in the chain.
+func CrossRates(localCurrency Currency, local PriceSource, quotes map[Currency]PriceSource) PriceSource {
+	s := crossRatesSource{localCurrency: localCurrency, local: local, quotes: quotes}
+	for currency := range quotes {
+		s.quoteCurrencies = append(s.quoteCurrencies, currency)
+	}
+	sort.Slice(s.quoteCurrencies, func(i, j int) bool { return s.quoteCurrencies[i] < s.quoteCurrencies[j] })
+	return s
+}
+
+// Price implements PriceSource.
+func (s crossRatesSource) Price(currency Currency, date time.Time) (Quote, error) {
+	quote, directErr := s.local.Price(currency, date)
+	if directErr == nil {
+		return quote, nil
+	}
+
+	// breadth first search for the shortest chain of quotes ending with a local price
+	type step struct {
+		currency Currency
+		// price of the original currency in this currency
+		price Decimal
+		date  time.Time
+		path  []Currency
+	}
+	var (
+		queue   = []step{{currency: currency, price: NewDecimal(1, 0), date: date, path: []Currency{currency}}}
+		visited = map[Currency]bool{currency: true}
+	)
+	further := func(a, b time.Time) time.Time {
+		if absDuration(b.Sub(date)) > absDuration(a.Sub(date)) {
+			return b
+		}
+		return a
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if len(current.path) > 1 {
+			if q, err := s.local.Price(current.currency, date); err == nil {
+				path := append(append([]Currency(nil), current.path...), s.localCurrency)
+				return Quote{Price: current.price.Mul(q.Price), Date: further(current.date, q.Date), Path: path}, nil
+			}
+		}
+		for _, quoteCurrency := range s.quoteCurrencies {
+			if visited[quoteCurrency] {
+				continue
+			}
+			q, err := s.quotes[quoteCurrency].Price(current.currency, date)
+			if err != nil {
+				continue
+			}
+			visited[quoteCurrency] = true
+			queue = append(queue, step{
+				currency: quoteCurrency,
+				price:    current.price.Mul(q.Price),
+				date:     further(current.date, q.Date),
+				path:     append(append([]Currency(nil), current.path...), quoteCurrency),
+			})
+		}
+	}
+	return Quote{}, directErr
+}
+
+func newPriceSeries(prices PriceMap) priceSeries {
+	series := priceSeries{}
+	for currency, byDate := range prices {
+		points := make(pricePoints, 0, len(byDate))
+		for date, price := range byDate {
+			points = append(points, pricePoint{date: date, price: price})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].date.Before(points[j].date) })
+		series[currency] = points
+	}
+	return series
+}
+
+// search returns the index of the first point at or after the date.
+func (points pricePoints) search(date time.Time) int {
+	return sort.Search(len(points), func(i int) bool { return !points[i].date.Before(date) })
+}
+
+// durationDecimal returns the duration in nanoseconds.
+func durationDecimal(d time.Duration) Decimal {
+	return NewDecimal(int64(d), 0)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
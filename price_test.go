@@ -0,0 +1,153 @@
+package ledger_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestPriceSources(t *testing.T) {
+	var (
+		tradeTime = d("2017-11-01").Add(14*time.Hour + 32*time.Minute)
+		gapDay    = d("2017-11-15")
+	)
+
+	t.Run("exact", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		quote, err := historicalPrices.Price(BTC, d("2017-11-01"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("6767.31"))
+
+		_, err = historicalPrices.Price(BTC, tradeTime)
+		var missing *ledger.MissingPriceError
+		g.Expect(errors.As(err, &missing)).To(BeTrue())
+	})
+
+	t.Run("nearest previous", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		source := ledger.NearestPrevious(historicalPrices)
+
+		quote, err := source.Price(BTC, tradeTime)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("6767.31"))
+		g.Expect(quote.Date).To(Equal(d("2017-11-01")))
+
+		quote, err = source.Price(BTC, gapDay)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("6960.07"))
+		g.Expect(quote.Date).To(Equal(d("2017-11-02")))
+
+		_, err = source.Price(BTC, d("2017-10-31"))
+		var missing *ledger.MissingPriceError
+		g.Expect(errors.As(err, &missing)).To(BeTrue())
+	})
+
+	t.Run("interpolated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		source := ledger.Interpolated(ledger.PriceMap{
+			ETH: {d("2017-11-01"): n("300"), d("2017-11-05"): n("340")},
+		})
+
+		quote, err := source.Price(ETH, d("2017-11-02"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("310"))
+		g.Expect(quote.Date).To(Equal(d("2017-11-01")))
+
+		quote, err = source.Price(ETH, d("2017-11-04").Add(12*time.Hour))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("335"))
+		g.Expect(quote.Date).To(Equal(d("2017-11-05")))
+
+		// after the last known price, that price is used
+		quote, err = source.Price(ETH, d("2017-11-06"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("340"))
+	})
+
+	t.Run("max staleness", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		source := ledger.WithMaxStaleness(ledger.NearestPrevious(historicalPrices), 3*24*time.Hour)
+
+		_, err := source.Price(BTC, tradeTime)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = source.Price(BTC, gapDay)
+		var stale *ledger.StalePriceError
+		g.Expect(errors.As(err, &stale)).To(BeTrue())
+		g.Expect(stale.QuoteDate).To(Equal(d("2017-11-02")))
+		g.Expect(err).To(MatchError("stale BTC historical price for 2017-11-15: the nearest price is from 2017-11-02, more than 72h0m0s away"))
+	})
+
+	t.Run("ledger", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := ledger.New(USD, ledger.NearestPrevious(historicalPrices))
+		lot, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("1000"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-04-06"), lot.Name(), Bitfinex, BTC, n("1"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		value, err := l.Spend(tradeTime, Bitfinex, "1.1", BTC, n("0.1"), "lunch")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(value).To(equalDecimal("676.73"))
+	})
+}
+
+func TestCrossRates(t *testing.T) {
+	const XYZ = ledger.Currency("XYZ")
+
+	source := ledger.CrossRates(USD, historicalPrices, map[ledger.Currency]ledger.PriceSource{
+		BTC: ledger.PriceMap{XYZ: {d("2017-11-01"): n("0.0001")}},
+		ETH: ledger.PriceMap{BCH: {d("2017-11-01"): n("3")}},
+	})
+
+	t.Run("direct", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		quote, err := source.Price(BTC, d("2017-11-01"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("6767.31"))
+		g.Expect(quote.Path).To(BeEmpty())
+	})
+
+	t.Run("chained", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		quote, err := source.Price(XYZ, d("2017-11-01"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("0.676731"))
+		g.Expect(quote.Path).To(Equal([]ledger.Currency{XYZ, BTC, USD}))
+
+		quote, err = source.Price(BCH, d("2017-11-01"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("875.07"))
+		g.Expect(quote.Path).To(Equal([]ledger.Currency{BCH, ETH, USD}))
+	})
+
+	t.Run("no path", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := source.Price(XYZ, d("2017-11-02"))
+		var missing *ledger.MissingPriceError
+		g.Expect(errors.As(err, &missing)).To(BeTrue())
+		g.Expect(missing.Currency).To(Equal(XYZ))
+	})
+
+	t.Run("capital gains report", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := ledger.New(USD, source)
+		lot, err := l.DepositNewMoney(d("2017-04-06"), Bitfinex, n("1000"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-04-06"), lot.Name(), Bitfinex, XYZ, n("10000"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.ExchangeTaxable(d("2017-11-01"), "1.1", XYZ, n("1000"), n("0"), true, ETH, n("2"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		gainsLot, err := l.FindLotByName("1.1.2", USD)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(gainsLot.TaxableGainsDetails().PricePath()).To(Equal([]ledger.Currency{XYZ, BTC, USD}))
+		g.Expect(gainsLot.TaxableGainsDetails().Proceeds()).To(equalDecimal("676.73"))
+		g.Expect(l.PrintCapitalGainsTSV()).To(HaveSuffix("\tshort-term\t576.73\texchanging XYZ for ETH\tXYZ->BTC->USD\n"))
+		g.Expect(l.PrintTaxableGains()).To(ContainSubstring("note=exchanging XYZ for ETH, price via XYZ->BTC->USD"))
+	})
+}
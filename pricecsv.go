@@ -0,0 +1,183 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PriceCSVFormat describes the layout of a CSV file of historical prices, with a header row naming the columns.
+// Column names are matched ignoring case and surrounding spaces.
+type PriceCSVFormat struct {
+	// DateColumn names the column holding the date of each price.
+	DateColumn string
+	// DateFormat is the layout of the dates, see time.Parse. Defaults to "2006-01-02".
+	// Dates without a time zone are taken to be UTC.
+	DateFormat string
+	// PriceColumn names the column holding the price, in the local currency.
+	// Currency symbols and thousands separators (e.g. "$6,767.31") are ignored.
+	PriceColumn string
+	// CurrencyColumn names the column holding the currency priced on each row.
+	// If empty, every row is a price of Currency.
+	CurrencyColumn string
+	// Currency is the currency priced throughout the file, when there's no CurrencyColumn.
+	// If it's empty too, the currency is taken from the file name, up to the first '-', '_' or '.'
+	// (e.g. "btc-usd-max.csv" holds BTC prices).
+	Currency Currency
+}
+
+// CoinGeckoPriceCSV is the format of the price history downloads from CoinGecko, e.g. "btc-usd-max.csv".
+var CoinGeckoPriceCSV = PriceCSVFormat{
+	DateColumn:  "snapped_at",
+	DateFormat:  "2006-01-02 15:04:05 MST",
+	PriceColumn: "price",
+}
+
+// LoadPriceCSVFiles reads the prices in each of the CSV files into a new PriceMap. See PriceMap.LoadCSV.
+func LoadPriceCSVFiles(format PriceCSVFormat, paths ...string) (PriceMap, error) {
+	prices := PriceMap{}
+	for _, path := range paths {
+		if err := prices.loadCSVFile(format, path); err != nil {
+			return nil, err
+		}
+	}
+	return prices, nil
+}
+
+func (m PriceMap) loadCSVFile(format PriceCSVFormat, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.LoadCSV(f, path, format)
+}
+
+// LoadCSV reads the prices in the CSV file into the map. The file name is used in error messages,
+// and possibly for the currency (see PriceCSVFormat.Currency).
+// Prices must be positive, and each currency may only have one price for any date, even across files.
+// Errors about the contents of the file are *LineError values.
+func (m PriceMap) LoadCSV(r io.Reader, fileName string, format PriceCSVFormat) error {
+	if format.DateFormat == "" {
+		format.DateFormat = "2006-01-02"
+	}
+	fileCurrency := format.Currency
+	if format.CurrencyColumn == "" && fileCurrency == "" {
+		base := filepath.Base(fileName)
+		if i := strings.IndexAny(base, "-_."); i >= 0 {
+			base = base[:i]
+		}
+		fileCurrency = Currency(strings.ToUpper(base))
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	lineError := func(line int, format string, args ...interface{}) error {
+		return &LineError{File: fileName, Line: line, Err: fmt.Errorf(format, args...)}
+	}
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return lineError(1, "missing header row")
+	}
+	if err != nil {
+		return csvLineError(fileName, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(name string) (int, error) {
+		i, ok := columns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, lineError(1, "missing column %q", name)
+		}
+		return i, nil
+	}
+	dateColumn, err := column(format.DateColumn)
+	if err != nil {
+		return err
+	}
+	priceColumn, err := column(format.PriceColumn)
+	if err != nil {
+		return err
+	}
+	currencyColumn := -1
+	if format.CurrencyColumn != "" {
+		if currencyColumn, err = column(format.CurrencyColumn); err != nil {
+			return err
+		}
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return csvLineError(fileName, err)
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(i int) (string, error) {
+			if i >= len(record) {
+				return "", lineError(line, "missing column %q", header[i])
+			}
+			return strings.TrimSpace(record[i]), nil
+		}
+
+		dateField, err := field(dateColumn)
+		if err != nil {
+			return err
+		}
+		date, err := time.ParseInLocation(format.DateFormat, dateField, time.UTC)
+		if err != nil {
+			return lineError(line, "invalid date %q, expected the format %q", dateField, format.DateFormat)
+		}
+
+		priceField, err := field(priceColumn)
+		if err != nil {
+			return err
+		}
+		price, err := ParseDecimal(strings.NewReplacer("$", "", ",", "").Replace(priceField))
+		if err != nil {
+			return lineError(line, "invalid price %q", priceField)
+		}
+		if price.Sign() <= 0 {
+			return lineError(line, "price must be positive, got %s", price)
+		}
+
+		currency := fileCurrency
+		if currencyColumn >= 0 {
+			currencyField, err := field(currencyColumn)
+			if err != nil {
+				return err
+			}
+			if currencyField == "" {
+				return lineError(line, "missing currency")
+			}
+			currency = Currency(strings.ToUpper(currencyField))
+		}
+
+		if m[currency] == nil {
+			m[currency] = map[time.Time]Decimal{}
+		}
+		if existing, ok := m[currency][date]; ok {
+			return lineError(line, "duplicate %s price for %s (already have %s)", currency, dateField, existing)
+		}
+		m[currency][date] = price
+	}
+}
+
+// csvLineError converts a CSV syntax error to a *LineError.
+func csvLineError(fileName string, err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &LineError{File: fileName, Line: parseErr.Line, Err: parseErr.Err}
+	}
+	return err
+}
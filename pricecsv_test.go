@@ -0,0 +1,97 @@
+package ledger_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestLoadPriceCSV(t *testing.T) {
+	t.Run("files", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir := t.TempDir()
+		write := func(name, contents string) string {
+			path := filepath.Join(dir, name)
+			g.Expect(os.WriteFile(path, []byte(contents), 0o644)).To(Succeed())
+			return path
+		}
+		btc := write("btc-usd-max.csv", `snapped_at,price,market_cap,total_volume
+2017-11-01 00:00:00 UTC,6767.31,112919123456.7,2176010000
+2017-11-02 00:00:00 UTC,6960.07,115922123456.7,3876250000
+`)
+		eth := write("eth-usd-max.csv", `snapped_at,price,market_cap,total_volume
+2017-11-01 00:00:00 UTC,291.69,27847123456.7,789210000
+`)
+
+		prices, err := ledger.LoadPriceCSVFiles(ledger.CoinGeckoPriceCSV, btc, eth)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(prices).To(HaveLen(2))
+		g.Expect(prices[BTC]).To(HaveLen(2))
+		g.Expect(prices[BTC][d("2017-11-02")]).To(equalDecimal("6960.07"))
+		g.Expect(prices[ETH][d("2017-11-01")]).To(equalDecimal("291.69"))
+
+		// the same file twice duplicates every price
+		_, err = ledger.LoadPriceCSVFiles(ledger.CoinGeckoPriceCSV, btc, btc)
+		g.Expect(err).To(MatchError(ContainSubstring("btc-usd-max.csv:2: duplicate BTC price for 2017-11-01 00:00:00 UTC")))
+	})
+
+	t.Run("currency column", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		prices := ledger.PriceMap{}
+		err := prices.LoadCSV(strings.NewReader(`Date,Symbol,Close
+11/01/2017,btc,"$6,767.31"
+11/01/2017,ETH,291.69
+`), "closes.csv", ledger.PriceCSVFormat{
+			DateColumn:     "date",
+			DateFormat:     "01/02/2006",
+			PriceColumn:    "close",
+			CurrencyColumn: "symbol",
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(prices[BTC][d("2017-11-01")]).To(equalDecimal("6767.31"))
+		g.Expect(prices[ETH][d("2017-11-01")]).To(equalDecimal("291.69"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		format := ledger.PriceCSVFormat{DateColumn: "date", PriceColumn: "price", Currency: BTC}
+		for _, tc := range []struct {
+			csv, want string
+		}{
+			{"date,close\n", "prices.csv:1: missing column \"price\""},
+			{"date,price\n2017-11-01,6767.31\n2017-11-01,6767.31\n", "prices.csv:3: duplicate BTC price for 2017-11-01 (already have 6767.31)"},
+			{"date,price\n2017-11-01,0\n", "prices.csv:2: price must be positive, got 0"},
+			{"date,price\n2017-11-01,-1.5\n", "prices.csv:2: price must be positive, got -1.5"},
+			{"date,price\n2017-11-01,abc\n", "prices.csv:2: invalid price \"abc\""},
+			{"date,price\n\n11/01/2017,1\n", "prices.csv:3: invalid date \"11/01/2017\", expected the format \"2006-01-02\""},
+			{"date,price\n2017-11-01\n", "prices.csv:2: missing column \"price\""},
+			{"date,price\n2017-11-01,\"1\n", "prices.csv:2: extraneous or missing \" in quoted-field"},
+		} {
+			g := NewGomegaWithT(t)
+			err := ledger.PriceMap{}.LoadCSV(strings.NewReader(tc.csv), "prices.csv", format)
+			g.Expect(err).To(MatchError(tc.want), tc.csv)
+
+			var lineErr *ledger.LineError
+			g.Expect(errors.As(err, &lineErr)).To(BeTrue(), tc.csv)
+		}
+	})
+
+	t.Run("used by the ledger", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		prices := ledger.PriceMap{}
+		g.Expect(prices.LoadCSV(strings.NewReader("date,price\n2017-11-01,6767.31\n"), "prices.csv",
+			ledger.PriceCSVFormat{DateColumn: "date", PriceColumn: "price", Currency: BTC})).To(Succeed())
+		quote, err := ledger.NearestPrevious(prices).Price(BTC, d("2017-11-01").Add(12*time.Hour))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(quote.Price).To(equalDecimal("6767.31"))
+	})
+}
@@ -0,0 +1,111 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+type (
+	// Schedule3Row is one disposition on Canada's Schedule 3, taken from a TaxableGains lot.
+	Schedule3Row struct {
+		LotName string
+		// Description is the amount and currency disposed of, e.g. "0.100000000 BTC".
+		Description string
+		Disposed    time.Time
+		Proceeds    Decimal
+		// AdjustedCostBase is the cost basis of the amount disposed of.
+		AdjustedCostBase Decimal
+		// SuperficialLoss is the part of the loss denied, see TaxableGainsDetails.SuperficialLoss.
+		SuperficialLoss Decimal
+		// Gain is the gain or loss, after any superficial loss is denied.
+		Gain Decimal
+	}
+
+	// Schedule3Year is the dispositions of one year, with their totals.
+	Schedule3Year struct {
+		Year int
+		Rows []Schedule3Row
+		// Total adds up the rows.
+		Total Schedule3Row
+		// TaxableGain is the part of the total gain included in income, at the inclusion rate of one half.
+		// It's negative for an allowable capital loss, which can only be applied against taxable capital gains.
+		TaxableGain Decimal
+	}
+)
+
+// Schedule3 returns the dispositions recorded in the TaxableGains lots, by year of disposition, laid out like the
+// section of Canada's Schedule 3 for shares and other properties. It's meant for ledgers using CanadianACB.
+func (l *Ledger) Schedule3() []Schedule3Year {
+	byYear := map[int]*Schedule3Year{}
+	for _, lot := range l.lots {
+		details := lot.taxableGainsDetails
+		if details == nil {
+			continue
+		}
+		row := Schedule3Row{
+			LotName:          lot.name,
+			Description:      fmt.Sprintf("%0.9f %s", details.soldAmount, details.currency),
+			Disposed:         details.dateOfSale,
+			Proceeds:         details.proceeds,
+			AdjustedCostBase: details.costBasis,
+			SuperficialLoss:  details.superficialLoss,
+			Gain:             details.Gains(),
+		}
+		year := byYear[row.Disposed.Year()]
+		if year == nil {
+			year = &Schedule3Year{Year: row.Disposed.Year()}
+			byYear[year.Year] = year
+		}
+		year.Rows = append(year.Rows, row)
+		year.Total.Proceeds = year.Total.Proceeds.Add(row.Proceeds)
+		year.Total.AdjustedCostBase = year.Total.AdjustedCostBase.Add(row.AdjustedCostBase)
+		year.Total.SuperficialLoss = year.Total.SuperficialLoss.Add(row.SuperficialLoss)
+		year.Total.Gain = year.Total.Gain.Add(row.Gain)
+	}
+
+	years := make([]Schedule3Year, 0, len(byYear))
+	for _, year := range byYear {
+		sort.SliceStable(year.Rows, func(i, j int) bool { return year.Rows[i].Disposed.Before(year.Rows[j].Disposed) })
+		year.TaxableGain = year.Total.Gain.Quo(NewDecimal(2, 0), l.basisPlaces())
+		years = append(years, *year)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].Year < years[j].Year })
+	return years
+}
+
+// PrintSchedule3 prints each year's dispositions laid out like Schedule 3, followed by the taxable capital gain.
+func PrintSchedule3(years []Schedule3Year) string {
+	b := &bytes.Buffer{}
+	dollars := func(d Decimal) string { return d.StringFixed(2) }
+	for i, year := range years {
+		if i > 0 {
+			fmt.Fprintln(b)
+		}
+		fmt.Fprintf(b, "%d Schedule 3\n", year.Year)
+		tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "description\tdisposed\tproceeds of disposition\tadjusted cost base\tsuperficial loss\tgain (or loss)")
+		for _, row := range year.Rows {
+			superficialLoss := ""
+			if !row.SuperficialLoss.IsZero() {
+				superficialLoss = dollars(row.SuperficialLoss)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", row.Description, row.Disposed.Format("2006-01-02"),
+				dollars(row.Proceeds), dollars(row.AdjustedCostBase), superficialLoss, dollars(row.Gain))
+		}
+		t := year.Total
+		fmt.Fprintf(tw, "totals\t\t%s\t%s\t%s\t%s\n", dollars(t.Proceeds), dollars(t.AdjustedCostBase),
+			dollars(t.SuperficialLoss), dollars(t.Gain))
+		if err := tw.Flush(); err != nil {
+			panic(err.Error())
+		}
+		label := "taxable capital gain"
+		if year.TaxableGain.Sign() < 0 {
+			label = "allowable capital loss"
+		}
+		fmt.Fprintf(b, "(%s: %s)\n", label, dollars(year.TaxableGain.Abs()))
+	}
+	return b.String()
+}
@@ -0,0 +1,403 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LotSelector chooses which lots to draw from, and in what order, when an amount is removed from an account
+// without naming the lots. The ledger draws from the selected lots in order until the amount is covered.
+type LotSelector interface {
+	// SelectLots returns the candidates in the order they should be drawn from, possibly leaving some out.
+	// The candidates are the lots holding the currency in the account, in the order they were created. When the amount
+	// is sold, exchanged or spent under UniversalTracking, they're the lots holding the currency in every account.
+	SelectLots(candidates []*Lot) ([]*Lot, error)
+}
+
+var (
+	// FIFO draws from the lots acquired first.
+	FIFO LotSelector = sortedSelector(func(a, b *Lot) bool {
+		return a.originalPurchaseTime.Before(b.originalPurchaseTime)
+	})
+
+	// LIFO draws from the lots acquired last.
+	LIFO LotSelector = sortedSelector(func(a, b *Lot) bool {
+		return a.originalPurchaseTime.After(b.originalPurchaseTime)
+	})
+
+	// HIFO draws from the lots with the highest unit cost first ("highest in, first out"), which minimizes gains.
+	HIFO LotSelector = sortedSelector(func(a, b *Lot) bool {
+		return compareUnitCost(a, b) > 0
+	})
+
+	// LowestCost draws from the lots with the lowest unit cost first, which maximizes gains.
+	LowestCost LotSelector = sortedSelector(func(a, b *Lot) bool {
+		return compareUnitCost(a, b) < 0
+	})
+)
+
+// sortedSelector selects every candidate, ordered by less. Ties keep the order the lots were created.
+type sortedSelector func(a, b *Lot) bool
+
+// SelectLots implements LotSelector.
+func (less sortedSelector) SelectLots(candidates []*Lot) ([]*Lot, error) {
+	lots := append([]*Lot(nil), candidates...)
+	sort.SliceStable(lots, func(i, j int) bool { return less(lots[i], lots[j]) })
+	return lots, nil
+}
+
+// compareUnitCost compares the cost basis per unit of the lots, exactly.
+func compareUnitCost(a, b *Lot) int {
+	return a.costBasis.Mul(b.amount).Cmp(b.costBasis.Mul(a.amount))
+}
+
+// SpecificLots draws from the named lots, in the given order ("specific identification").
+// Every named lot must be one of the candidates.
+func SpecificLots(names ...string) LotSelector {
+	return specificLots(names)
+}
+
+type specificLots []string
+
+// SelectLots implements LotSelector.
+func (names specificLots) SelectLots(candidates []*Lot) ([]*Lot, error) {
+	lots := make([]*Lot, 0, len(names))
+	for _, name := range names {
+		var found *Lot
+		for _, lot := range candidates {
+			if lot.name == name {
+				found = lot
+			}
+		}
+		if found == nil {
+			return nil, &LotNotFoundError{Name: name}
+		}
+		lots = append(lots, found)
+	}
+	return lots, nil
+}
+
+// allocation is an amount to be removed from a lot.
+type allocation struct {
+	lot    *Lot
+	amount Decimal
+}
+
+// candidateLots returns the lots holding some of the currency in the account, in the order they were created.
+func (l *Ledger) candidateLots(account Account, currency Currency) []*Lot {
+	var lots []*Lot
+	for _, lot := range l.lots {
+		if lot.lotType != TaxableGains && lot.account == account && lot.currency == currency && lot.amount.Sign() > 0 {
+			lots = append(lots, lot)
+		}
+	}
+	return lots
+}
+
+// allocateDisposal is allocate for an amount sold, exchanged or spent from the account on the given date. Under
+// PerWalletTracking the selector chooses among the account's own lots. Under UniversalTracking it chooses among every
+// account's lots, but that only identifies the cost basis and holding period of the amount disposed of: the amount still
+// leaves the account. So an amount identified in another account's lot is replaced there by as much of the account's
+// lots which weren't identified, in the order they were created, as if it had been transferred there first.
+func (l *Ledger) allocateDisposal(op string, date time.Time, account Account, selector LotSelector,
+	currency Currency, amount Decimal) ([]allocation, error) {
+
+	own := l.candidateLots(account, currency)
+	if l.LotTracking(date) == PerWalletTracking {
+		return l.allocate(op, date, account, own, selector, currency, amount)
+	}
+	var held Decimal
+	for _, lot := range own {
+		held = held.Add(lot.amount)
+	}
+	if held.Cmp(amount) < 0 {
+		return nil, &InsufficientAmountError{Currency: currency, Requested: amount, Available: held}
+	}
+	var pooled []*Lot
+	for _, lot := range l.lots {
+		if lot.lotType != TaxableGains && lot.currency == currency && lot.amount.Sign() > 0 {
+			pooled = append(pooled, lot)
+		}
+	}
+	allocations, err := l.allocate(op, date, account, pooled, selector, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// the account holds at least the amount, so its lots which weren't identified cover the rest
+	spare := map[*Lot]Decimal{}
+	for _, lot := range own {
+		spare[lot] = lot.amount
+	}
+	for _, a := range allocations {
+		if a.lot.account == account {
+			spare[a.lot] = spare[a.lot].Sub(a.amount)
+		}
+	}
+	for _, a := range allocations {
+		if a.lot.account == account {
+			continue
+		}
+		remaining := a.amount
+		for _, lot := range own {
+			moved := minDecimal(spare[lot], remaining)
+			if moved.Sign() <= 0 {
+				continue
+			}
+			if _, err := l.transfer(date, lot.name, currency, moved, Decimal{}, a.lot.account); err != nil {
+				return nil, err
+			}
+			spare[lot] = spare[lot].Sub(moved)
+			remaining = remaining.Sub(moved)
+		}
+	}
+	return allocations, nil
+}
+
+// disposedFrom moves the lots created by disposing of a lot to the account the amount was disposed of from, which may
+// differ from the lot's under UniversalTracking: the account receives the purchased currency, and the gains are from
+// its sale.
+func disposedFrom(account Account, lots []*Lot) {
+	for _, lot := range lots {
+		if lot.lotType == TaxableGains {
+			lot.taxableGainsDetails.account = account
+		} else {
+			lot.account = account
+		}
+	}
+}
+
+// allocate uses the selector to decide how much of the amount to remove from each of the candidate lots, for the
+// operation disposing of the amount from the account on the given date. Under PerWalletTracking, the selector may only
+// choose the account's lots.
+func (l *Ledger) allocate(op string, date time.Time, account Account, candidates []*Lot, selector LotSelector,
+	currency Currency, amount Decimal) ([]allocation, error) {
+
+	lots, err := selector.SelectLots(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		allocations []allocation
+		remaining   = amount
+	)
+	for _, lot := range lots {
+		if remaining.Sign() <= 0 {
+			break
+		}
+		if lot.currency != currency {
+			return nil, &CurrencyMismatchError{LotName: lot.name, Expected: currency, Actual: lot.currency}
+		}
+		if err := l.checkWallet(op, date, lot, account); err != nil {
+			return nil, err
+		}
+		a := minDecimal(lot.amount, remaining)
+		if a.Sign() <= 0 {
+			continue
+		}
+		allocations = append(allocations, allocation{lot: lot, amount: a})
+		remaining = remaining.Sub(a)
+	}
+	if remaining.Sign() > 0 {
+		return nil, &InsufficientAmountError{Currency: currency, Requested: amount, Available: amount.Sub(remaining)}
+	}
+	return allocations, nil
+}
+
+// portions splits total in proportion to the amount of each allocation, rounded to the given places.
+// The last allocation gets whatever remains, so the portions always add up to the total.
+func portions(total Decimal, allocations []allocation, places int32) []Decimal {
+	var whole Decimal
+	for _, a := range allocations {
+		whole = whole.Add(a.amount)
+	}
+
+	result := make([]Decimal, len(allocations))
+	remaining := total
+	for i, a := range allocations {
+		if i == len(allocations)-1 {
+			result[i] = remaining
+			break
+		}
+		result[i] = total.Mul(a.amount).Quo(whole, places)
+		remaining = remaining.Sub(result[i])
+	}
+	return result
+}
+
+// PurchaseFromAccount is like Purchase, but lets the selector decide which of the account's localCurrency lots pay
+// for the purchase. The purchased amount is split across new lots, in proportion to the cost paid from each lot.
+// It returns the new lots.
+func (l *Ledger) PurchaseFromAccount(date time.Time, account Account, selector LotSelector,
+	currency Currency, amount Decimal, cost Decimal) ([]*Lot, error) {
+
+	if err := firstError(l.checkAmount(currency, amount), l.checkAmount(l.localCurrency, cost)); err != nil {
+		return nil, err
+	}
+	var newLots []*Lot
+	err := l.transact(PurchaseTransaction, date, "", func() error {
+		candidates := l.candidateLots(account, l.localCurrency)
+		allocations, err := l.allocate("PurchaseFromAccount", date, account, candidates, selector, l.localCurrency, cost)
+		if err != nil {
+			return err
+		}
+		purchased := portions(amount, allocations, l.Precision(currency))
+		for i, a := range allocations {
+			newLot, err := l.purchase(date, a.lot.name, account, currency, purchased[i], a.amount)
+			if err != nil {
+				return err
+			}
+			newLots = append(newLots, newLot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newLots, nil
+}
+
+// SellTaxableFromAccount is like SellTaxable, but lets the selector decide which lots are sold: under UniversalTracking
+// any account's lots, and under PerWalletTracking only the account's own (see SetLotTracking).
+// The proceeds are split across the lots in proportion to the amount sold from each.
+// It returns the TaxableGains lots.
+func (l *Ledger) SellTaxableFromAccount(date time.Time, account Account, selector LotSelector,
+	soldCurrency Currency, soldAmount Decimal, purchasedAmountReceivedInLocalCurrency Decimal) ([]*Lot, error) {
+
+	if err := firstError(l.checkAmount(soldCurrency, soldAmount), l.checkAmount(l.localCurrency, purchasedAmountReceivedInLocalCurrency)); err != nil {
+		return nil, err
+	}
+	var gainsLots []*Lot
+	err := l.transact(SellTransaction, date, "", func() error {
+		allocations, err := l.allocateDisposal("SellTaxableFromAccount", date, account, selector, soldCurrency, soldAmount)
+		if err != nil {
+			return err
+		}
+		proceeds := portions(purchasedAmountReceivedInLocalCurrency, allocations, l.basisPlaces())
+		for i, a := range allocations {
+			numLots := len(l.lots)
+			gainsLot, err := l.sellTaxable(date, a.lot.name, soldCurrency, a.amount, proceeds[i])
+			if err != nil {
+				return err
+			}
+			disposedFrom(account, l.lots[numLots:])
+			gainsLots = append(gainsLots, gainsLot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gainsLots, nil
+}
+
+// ExchangeTaxableFromAccount is like ExchangeTaxable, but lets the selector decide which lots are sold: under
+// UniversalTracking any account's lots, and under PerWalletTracking only the account's own (see SetLotTracking).
+// The purchased amount is split across new lots, in proportion to the amount sold from each lot.
+// It returns the new lots holding the purchased currency.
+func (l *Ledger) ExchangeTaxableFromAccount(date time.Time, account Account, selector LotSelector,
+	soldCurrency Currency, soldAmount, feeInSoldCurrency Decimal, lookupSoldCurrencyPriceForTaxableGains bool,
+	purchasedCurrency Currency, purchasedAmountReceived Decimal) ([]*Lot, error) {
+
+	if err := firstError(
+		l.checkAmount(soldCurrency, soldAmount),
+		l.checkAmount(soldCurrency, feeInSoldCurrency),
+		l.checkAmount(purchasedCurrency, purchasedAmountReceived),
+	); err != nil {
+		return nil, err
+	}
+	var newLots []*Lot
+	err := l.transact(ExchangeTaxableTransaction, date, "", func() error {
+		allocations, err := l.allocateDisposal("ExchangeTaxableFromAccount", date, account, selector, soldCurrency, soldAmount)
+		if err != nil {
+			return err
+		}
+		var (
+			purchased = portions(purchasedAmountReceived, allocations, l.Precision(purchasedCurrency))
+			fees      = portions(feeInSoldCurrency, allocations, l.Precision(soldCurrency))
+		)
+		for i, a := range allocations {
+			numLots := len(l.lots)
+			newLot, err := l.exchangeTaxable(date, a.lot.name, soldCurrency, a.amount, fees[i],
+				lookupSoldCurrencyPriceForTaxableGains, purchasedCurrency, purchased[i])
+			if err != nil {
+				return err
+			}
+			disposedFrom(account, l.lots[numLots:])
+			newLots = append(newLots, newLot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newLots, nil
+}
+
+// TransferFromAccount is like Transfer, but lets the selector decide which of the account's lots are transferred.
+// The fee is split across the lots in proportion to the amount transferred from each.
+// It returns the new lots in the destination account.
+func (l *Ledger) TransferFromAccount(date time.Time, account Account, selector LotSelector,
+	currency Currency, amountRemoved, feePaidFromAmount Decimal, toAccount Account) ([]*Lot, error) {
+
+	if err := firstError(l.checkAmount(currency, amountRemoved), l.checkAmount(currency, feePaidFromAmount)); err != nil {
+		return nil, err
+	}
+	var newLots []*Lot
+	err := l.transact(TransferTransaction, date, "", func() error {
+		candidates := l.candidateLots(account, currency)
+		allocations, err := l.allocate("TransferFromAccount", date, account, candidates, selector, currency, amountRemoved)
+		if err != nil {
+			return err
+		}
+		fees := portions(feePaidFromAmount, allocations, l.Precision(currency))
+		for i, a := range allocations {
+			if fees[i].Cmp(a.amount) > 0 {
+				return &InvalidOperationError{Op: "TransferFromAccount", LotName: a.lot.name,
+					Reason: fmt.Sprintf("the fee %s is more than the %s transferred", fees[i], a.amount)}
+			}
+			newLot, err := l.transfer(date, a.lot.name, currency, a.amount, fees[i], toAccount)
+			if err != nil {
+				return err
+			}
+			newLots = append(newLots, newLot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newLots, nil
+}
+
+// SpendFromAccount is like Spend, but lets the selector decide which lots are spent: under UniversalTracking any
+// account's lots, and under PerWalletTracking only the account's own (see SetLotTracking).
+// It returns the total value of the amount spent, in the local currency.
+func (l *Ledger) SpendFromAccount(date time.Time, account Account, selector LotSelector,
+	soldCurrency Currency, soldAmount Decimal, note string) (Decimal, error) {
+
+	if err := l.checkAmount(soldCurrency, soldAmount); err != nil {
+		return Decimal{}, err
+	}
+	var totalValue Decimal
+	err := l.transact(SpendTransaction, date, note, func() error {
+		allocations, err := l.allocateDisposal("SpendFromAccount", date, account, selector, soldCurrency, soldAmount)
+		if err != nil {
+			return err
+		}
+		for _, a := range allocations {
+			value, err := l.spend(date, account, a.lot.name, soldCurrency, a.amount, note, false)
+			if err != nil {
+				return err
+			}
+			totalValue = totalValue.Add(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return Decimal{}, err
+	}
+	return totalValue, nil
+}
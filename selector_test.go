@@ -0,0 +1,126 @@
+package ledger_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// threeLotsLedger buys BTC three times on Coinbase: lot 1.1 at $4000, lot 2.1 at $6000, and lot 3.1 at $5000.
+func threeLotsLedger(g *GomegaWithT) *ledger.Ledger {
+	l := ledger.New(USD, historicalPrices)
+	for i, buy := range []struct {
+		date, cost string
+	}{
+		{"2017-01-01", "4000"},
+		{"2017-02-01", "6000"},
+		{"2017-03-01", "5000"},
+	} {
+		lot, err := l.DepositNewMoney(d(buy.date), Coinbase, n(buy.cost), n(buy.cost))
+		g.Expect(err).NotTo(HaveOccurred(), i)
+		_, err = l.Purchase(d(buy.date), lot.Name(), Coinbase, BTC, n("1"), n(buy.cost))
+		g.Expect(err).NotTo(HaveOccurred(), i)
+	}
+	return l
+}
+
+// equalDecimal matches a Decimal with the same value, regardless of its scale.
+func equalDecimal(s string) types.GomegaMatcher {
+	return WithTransform(func(x ledger.Decimal) int { return x.Cmp(n(s)) }, BeZero())
+}
+
+func TestLotSelectors(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, tc := range []struct {
+		name     string
+		selector ledger.LotSelector
+		want     []string
+	}{
+		{"FIFO", ledger.FIFO, []string{"1.1", "2.1"}},
+		{"LIFO", ledger.LIFO, []string{"3.1", "2.1"}},
+		{"HIFO", ledger.HIFO, []string{"2.1", "3.1"}},
+		{"LowestCost", ledger.LowestCost, []string{"1.1", "3.1"}},
+		{"SpecificLots", ledger.SpecificLots("3.1", "1.1"), []string{"3.1", "1.1"}},
+	} {
+		l := threeLotsLedger(g)
+
+		// selling 1.5 BTC takes all of one lot and half of another
+		gainsLots, err := l.SellTaxableFromAccount(d("2017-12-01"), Coinbase, tc.selector, BTC, n("1.5"), n("15000"))
+		g.Expect(err).NotTo(HaveOccurred(), tc.name)
+		g.Expect(gainsLots).To(HaveLen(2), tc.name)
+
+		var soldFrom []string
+		for _, lot := range gainsLots {
+			soldFrom = append(soldFrom, lot.Parent().Name())
+		}
+		g.Expect(soldFrom).To(Equal(tc.want), tc.name)
+
+		// the proceeds are split in proportion to the amount sold from each lot
+		first, second := gainsLots[0].TaxableGainsDetails(), gainsLots[1].TaxableGainsDetails()
+		g.Expect(first.SoldAmount()).To(equalDecimal("1"), tc.name)
+		g.Expect(first.Proceeds()).To(equalDecimal("10000"), tc.name)
+		g.Expect(second.SoldAmount()).To(equalDecimal("0.5"), tc.name)
+		g.Expect(second.Proceeds()).To(equalDecimal("5000"), tc.name)
+	}
+}
+
+func TestSelectorDrivenOperations(t *testing.T) {
+	t.Run("transfer", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := threeLotsLedger(g)
+		newLots, err := l.TransferFromAccount(d("2017-12-01"), Coinbase, ledger.FIFO, BTC, n("1.5"), n("0.0003"), Bitfinex)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(newLots).To(HaveLen(2))
+		g.Expect(newLots[0].Account()).To(Equal(Bitfinex))
+		g.Expect(newLots[0].Amount()).To(equalDecimal("0.9998"))
+		g.Expect(newLots[1].Amount()).To(equalDecimal("0.4999"))
+		g.Expect(newLots[1].CostBasis()).To(equalDecimal("3000.50")) // the fee is sold, and its value added to the basis
+	})
+
+	t.Run("exchange", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := threeLotsLedger(g)
+		newLots, err := l.ExchangeTaxableFromAccount(d("2017-11-01"), Coinbase, ledger.HIFO, BTC, n("2"), n("0"), true, ETH, n("40"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(newLots).To(HaveLen(2))
+		g.Expect(newLots[0].Parent().Name()).To(Equal("2.1"))
+		g.Expect(newLots[1].Parent().Name()).To(Equal("3.1"))
+		g.Expect(newLots[0].Amount().Add(newLots[1].Amount())).To(equalDecimal("40"))
+	})
+
+	t.Run("spend", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := threeLotsLedger(g)
+		value, err := l.SpendFromAccount(d("2017-11-01"), Coinbase, ledger.LIFO, BTC, n("1.25"), "pizza")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(value).To(equalDecimal("8459.14"))
+		g.Expect(l.PrintCapitalGainsTSV()).To(ContainSubstring("\n3.1.spendCapitalGains.1\t"))
+		g.Expect(l.PrintCapitalGainsTSV()).To(ContainSubstring("\n2.1.spendCapitalGains.1\t"))
+	})
+
+	t.Run("not enough", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := threeLotsLedger(g)
+		before := l.PrintLots()
+		_, err := l.SellTaxableFromAccount(d("2017-12-01"), Coinbase, ledger.FIFO, BTC, n("3.5"), n("35000"))
+		var insufficient *ledger.InsufficientAmountError
+		g.Expect(errors.As(err, &insufficient)).To(BeTrue())
+		g.Expect(insufficient.Available).To(equalDecimal("3"))
+		g.Expect(l.PrintLots()).To(Equal(before))
+		g.Expect(l.Transactions()).To(HaveLen(6))
+	})
+
+	t.Run("unknown specific lot", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := threeLotsLedger(g)
+		_, err := l.SellTaxableFromAccount(d("2017-12-01"), Coinbase, ledger.SpecificLots("1.1", "9"), BTC, n("1"), n("10000"))
+		var notFound *ledger.LotNotFoundError
+		g.Expect(errors.As(err, &notFound)).To(BeTrue())
+		g.Expect(notFound.Name).To(Equal("9"))
+	})
+}
@@ -0,0 +1,466 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// BedAndBreakfastDays is the number of days after a disposal in which an acquisition is matched with it, under HMRC's
+// "bed and breakfast" rule.
+const BedAndBreakfastDays = 30
+
+type (
+	// ShareMatchingRule is the HMRC share matching rule an amount disposed of was matched by, see UKShareMatching.
+	ShareMatchingRule int
+
+	// ShareMatch is part of an amount disposed of, matched with acquisitions by one of the UK share matching rules.
+	ShareMatch struct {
+		Rule   ShareMatchingRule `json:"rule"`
+		Amount Decimal           `json:"amount"`
+		// Cost is the allowable cost of the amount, i.e. its cost basis.
+		Cost Decimal `json:"cost"`
+		// Acquired is the day of the acquisition matched by the same day or bed and breakfast rule.
+		// It's zero for the Section 104 pool, which is made up of all the acquisitions before.
+		Acquired time.Time `json:"acquired"`
+	}
+
+	// Section104PoolEntry is a change to a currency's Section 104 pool, see Ledger.Section104Pools.
+	Section104PoolEntry struct {
+		Date     time.Time
+		Currency Currency
+		// Event is what changed the pool: "acquired", "disposed", "fee" (added to the cost),
+		// or "withdrawn" (removed without a disposal by a non-taxable exchange, at the pool's average cost, which is
+		// carried over to the currency received).
+		Event string
+		// Amount and Cost are how much was added to the pool, and are negative when removed from it.
+		Amount, Cost Decimal
+		// PoolAmount and PoolCost are what's in the pool afterwards.
+		PoolAmount, PoolCost Decimal
+	}
+
+	// shareMatchingDay is everything that happened to a currency on one day, as far as the share matching rules care,
+	// and how it was matched and costed.
+	shareMatchingDay struct {
+		date time.Time
+		// acquired is the amount acquired, and acquiredCost the cost of the acquisitions other than those received
+		// in non-taxable exchanges, whose cost is carried over from the amount withdrawn.
+		acquired, acquiredCost Decimal
+		carried                []costBasisEvent
+		// feeCost is cost basis added to the currency's lots by fees.
+		feeCost Decimal
+		// withdrawals are the amounts removed without being disposed of, which carry their cost basis over to the
+		// currency they were exchanged for, and withdrawn is their total.
+		withdrawals []costBasisEvent
+		withdrawn   Decimal
+		// disposals are the TaxableGains lots of the day's disposals, in the order they were recorded,
+		// and disposed is the amount they disposed of.
+		disposals []*Lot
+		disposed  Decimal
+
+		// sameDay is the amount disposed of which is matched with the day's acquisitions. bedAndBreakfast are the
+		// matches with later acquisitions, and taken the matches of the day's acquisitions with earlier disposals,
+		// both in the order they were made.
+		sameDay         Decimal
+		bedAndBreakfast []*shareMatchingTake
+		taken           []*shareMatchingTake
+
+		// sameDayCost is the cost of the amount matched on the same day, and section104 is the match with the pool.
+		// entries are the day's changes to the pool, which holds poolAmount and poolCost afterwards.
+		sameDayCost          Decimal
+		section104           ShareMatch
+		entries              []Section104PoolEntry
+		poolAmount, poolCost Decimal
+		// costing is set while the day is costed, and costed once it has been.
+		costing, costed bool
+	}
+
+	// shareMatchingTake is an amount disposed of on one day, matched with acquisitions on a later day by the
+	// bed and breakfast rule.
+	shareMatchingTake struct {
+		disposed, acquired *shareMatchingDay
+		amount, cost       Decimal
+	}
+
+	// shareMatcher replays the journal, matching the disposals of each currency with its acquisitions under the UK
+	// share matching rules, see Ledger.Section104Pools.
+	shareMatcher struct {
+		basisPlaces int32
+		// record is set if the matches are recorded in the TaxableGains lots' details, under UKShareMatching.
+		record bool
+		// days are what happened to each currency on each day, in date order.
+		days map[Currency][]*shareMatchingDay
+	}
+)
+
+const (
+	// SameDayRule matches a disposal with acquisitions on the same day.
+	SameDayRule ShareMatchingRule = iota
+	// BedAndBreakfastRule matches a disposal with acquisitions in the BedAndBreakfastDays after it, earliest first.
+	BedAndBreakfastRule
+	// Section104Rule matches a disposal with the Section 104 pool, at its average cost.
+	Section104Rule
+)
+
+var shareMatchingRuleNames = enumNames[ShareMatchingRule]{
+	SameDayRule:         "same day",
+	BedAndBreakfastRule: "bed and breakfast",
+	Section104Rule:      "section 104",
+}
+
+// String returns the name of the rule, e.g. "same day".
+func (r ShareMatchingRule) String() string {
+	return shareMatchingRuleNames.name(r)
+}
+
+// MarshalText encodes the ShareMatchingRule by name, e.g. "same day".
+func (r ShareMatchingRule) MarshalText() ([]byte, error) {
+	return shareMatchingRuleNames.marshal("share matching rule", r)
+}
+
+// UnmarshalText decodes a ShareMatchingRule encoded by MarshalText.
+func (r *ShareMatchingRule) UnmarshalText(text []byte) error {
+	return shareMatchingRuleNames.unmarshal("share matching rule", text, r)
+}
+
+// Section104Pools returns the changes to each currency's Section 104 pool, by currency and then date,
+// as worked out by the UK share matching rules.
+//
+// Under the rules, all the disposals of a currency on one day are treated as one, and so are the acquisitions.
+// Disposals are matched first with acquisitions on the same day, then with acquisitions in the BedAndBreakfastDays
+// after, and the rest with the pool, at its average cost. The pool holds all the acquisitions which weren't matched
+// by the first two rules. Purchases, taxable exchanges and income are acquisitions, and every sale, exchange or
+// spend which records a TaxableGains lot is a disposal. Transfers and merges don't change the pool.
+//
+// With SetCostBasisMethod(UKShareMatching), the matches are recorded in each TaxableGains lot's details, see
+// TaxableGainsDetails.ShareMatches. Otherwise this shows what they would be.
+func (l *Ledger) Section104Pools() []Section104PoolEntry {
+	var m *shareMatcher
+	if l.costBasisMethod == UKShareMatching {
+		m = l.replayCostBasisMethod().(*shareMatcher)
+	} else {
+		m = newShareMatcher(l.basisPlaces(), false)
+		if changed, ok := l.addCostBasisEvents(m, l.transactions); ok {
+			m.replay(changed)
+		}
+	}
+	var pools []Section104PoolEntry
+	for _, currency := range sortedCurrencies(m.days) {
+		for _, day := range m.days[currency] {
+			pools = append(pools, day.entries...)
+		}
+	}
+	return pools
+}
+
+// PrintSection104Pools prints the changes to each currency's Section 104 pool, see Section104Pools.
+func (l *Ledger) PrintSection104Pools() string {
+	b := &bytes.Buffer{}
+	var tw *tabwriter.Writer
+	var currency Currency
+	for _, entry := range l.Section104Pools() {
+		if tw == nil || entry.Currency != currency {
+			if tw != nil {
+				if err := tw.Flush(); err != nil {
+					panic(err.Error())
+				}
+				fmt.Fprintln(b)
+			}
+			currency = entry.Currency
+			fmt.Fprintf(b, "%s Section 104 pool\n", currency)
+			tw = tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "date\tevent\tamount\tcost\tpool amount\tpool cost")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%0.9f\t%s\t%0.9f\t%s\n", entry.Date.Format("2006-01-02"), entry.Event,
+			entry.Amount, entry.Cost.StringFixed(2), entry.PoolAmount, entry.PoolCost.StringFixed(2))
+	}
+	if tw != nil {
+		if err := tw.Flush(); err != nil {
+			panic(err.Error())
+		}
+	}
+	return b.String()
+}
+
+func newShareMatcher(basisPlaces int32, record bool) *shareMatcher {
+	return &shareMatcher{basisPlaces: basisPlaces, record: record, days: map[Currency][]*shareMatchingDay{}}
+}
+
+// add adds the event to its day.
+func (m *shareMatcher) add(e costBasisEvent) {
+	days := m.days[e.currency]
+	date := shareMatchingDate(e.date)
+	i := firstShareMatchingDay(days, date)
+	if i == len(days) || !days[i].date.Equal(date) {
+		days = append(days, nil)
+		copy(days[i+1:], days[i:])
+		days[i] = &shareMatchingDay{date: date}
+		m.days[e.currency] = days
+	}
+	day := days[i]
+	switch e.event {
+	case acquiredEvent:
+		day.acquired = day.acquired.Add(e.amount)
+		if e.carry != nil {
+			day.carried = append(day.carried, e)
+		} else {
+			day.acquiredCost = day.acquiredCost.Add(e.cost)
+		}
+	case feeEvent:
+		day.feeCost = day.feeCost.Add(e.cost)
+	case withdrawnEvent:
+		day.withdrawals = append(day.withdrawals, e)
+		day.withdrawn = day.withdrawn.Add(e.amount)
+	case disposedEvent:
+		day.disposals = append(day.disposals, e.gains)
+		day.disposed = day.disposed.Add(e.amount)
+	}
+}
+
+// replay matches and costs the days from BedAndBreakfastDays before the changed date onwards, since the disposals
+// before then can't be matched with anything that changed. It costs them in date order, since non-taxable exchanges
+// carry cost basis over from one currency to another. If the matches are recorded, it records them for the days whose
+// bed and breakfast matches may have been costed again.
+func (m *shareMatcher) replay(changed time.Time) {
+	from := shareMatchingDate(changed).AddDate(0, 0, -BedAndBreakfastDays)
+	type dayIndex struct {
+		currency Currency
+		i        int
+	}
+	var toCost []dayIndex
+	for _, currency := range sortedCurrencies(m.days) {
+		days := m.days[currency]
+		start := firstShareMatchingDay(days, from)
+		m.match(days, start, from)
+		for i := start; i < len(days); i++ {
+			days[i].costed = false
+			toCost = append(toCost, dayIndex{currency, i})
+		}
+	}
+	sort.SliceStable(toCost, func(i, j int) bool {
+		return m.days[toCost[i].currency][toCost[i].i].date.Before(m.days[toCost[j].currency][toCost[j].i].date)
+	})
+	for _, d := range toCost {
+		m.cost(d.currency, d.i)
+	}
+
+	if m.record {
+		for _, days := range m.days {
+			for _, day := range days[firstShareMatchingDay(days, from.AddDate(0, 0, -BedAndBreakfastDays)):] {
+				day.recordMatches(m.basisPlaces)
+			}
+		}
+	}
+}
+
+// match matches the disposals of the days from start onwards, which is the first day from the given date, first with
+// acquisitions on the same day, then with acquisitions in the BedAndBreakfastDays after. The matches of the days
+// before are kept.
+func (m *shareMatcher) match(days []*shareMatchingDay, start int, from time.Time) {
+	for _, day := range days[start:] {
+		kept := 0
+		for kept < len(day.taken) && day.taken[kept].disposed.date.Before(from) {
+			kept++
+		}
+		day.taken = day.taken[:kept]
+		day.bedAndBreakfast = nil
+		day.sameDay = minDecimal(day.disposed, day.acquired)
+	}
+	for i := start; i < len(days); i++ {
+		day := days[i]
+		for _, later := range days[i+1:] {
+			if later.date.After(day.date.AddDate(0, 0, BedAndBreakfastDays)) {
+				break
+			}
+			if amount := minDecimal(day.unmatchedDisposed(), later.unmatchedAcquired()); amount.Sign() > 0 {
+				take := &shareMatchingTake{disposed: day, acquired: later, amount: amount}
+				day.bedAndBreakfast = append(day.bedAndBreakfast, take)
+				later.taken = append(later.taken, take)
+			}
+		}
+	}
+}
+
+// cost works out the cost of the i'th day's acquisitions and changes to the currency's pool, once the day before has
+// been costed. The day's acquisitions are matched with its disposals first, then with the earlier disposals by the bed
+// and breakfast rule, each taking their share of the cost, and the rest join the pool. The rest of the disposals, and
+// the amounts withdrawn, leave the pool at its average cost.
+func (m *shareMatcher) cost(currency Currency, i int) {
+	days := m.days[currency]
+	day := days[i]
+	if day.costed || day.costing {
+		return
+	}
+	day.costing = true
+	defer func() { day.costing, day.costed = false, true }()
+
+	var poolAmount, poolCost Decimal
+	if i > 0 {
+		poolAmount, poolCost = days[i-1].poolAmount, days[i-1].poolCost
+	}
+	day.entries = nil
+	change := func(event string, amount, cost Decimal) {
+		poolAmount, poolCost = poolAmount.Add(amount), poolCost.Add(cost)
+		day.entries = append(day.entries, Section104PoolEntry{Date: day.date, Currency: currency, Event: event,
+			Amount: amount, Cost: cost, PoolAmount: poolAmount, PoolCost: poolCost})
+	}
+	averageCost := func(amount Decimal) Decimal {
+		if amount.Cmp(poolAmount) >= 0 {
+			return poolCost
+		}
+		return poolCost.Mul(amount).Quo(poolAmount, m.basisPlaces)
+	}
+
+	acquired, acquiredCost := day.acquired, day.acquiredCost
+	for _, e := range day.carried {
+		acquiredCost = acquiredCost.Add(m.carried(e))
+	}
+	take := func(amount Decimal) Decimal {
+		cost := acquiredCost
+		if amount.Cmp(acquired) < 0 {
+			cost = cost.Mul(amount).Quo(acquired, m.basisPlaces)
+		}
+		acquired, acquiredCost = acquired.Sub(amount), acquiredCost.Sub(cost)
+		return cost
+	}
+	day.sameDayCost = take(day.sameDay)
+	for _, t := range day.taken {
+		t.cost = take(t.amount)
+	}
+	if acquired.Sign() > 0 {
+		change(acquiredEvent, acquired, acquiredCost)
+	}
+	if !day.feeCost.IsZero() {
+		change(feeEvent, Decimal{}, day.feeCost)
+	}
+	day.section104 = ShareMatch{}
+	if amount := minDecimal(day.unmatchedDisposed(), poolAmount); amount.Sign() > 0 {
+		cost := averageCost(amount)
+		change(disposedEvent, amount.Neg(), cost.Neg())
+		day.section104 = ShareMatch{Rule: Section104Rule, Amount: amount, Cost: cost}
+	}
+	if day.withdrawn.Sign() > 0 {
+		// the cost basis leaving the pool is carried over to the currencies received, so none is lost or made up
+		cost := averageCost(day.withdrawn)
+		change(withdrawnEvent, day.withdrawn.Neg(), cost.Neg())
+		remaining := cost
+		for j, e := range day.withdrawals {
+			share := remaining
+			if j < len(day.withdrawals)-1 {
+				share = cost.Mul(e.amount).Quo(day.withdrawn, m.basisPlaces)
+			}
+			remaining = remaining.Sub(share)
+			if e.carry != nil {
+				e.carry.cost = share
+			}
+		}
+	}
+	day.poolAmount, day.poolCost = poolAmount, poolCost
+}
+
+// carried returns the cost basis carried over to an amount received in a non-taxable exchange, which is what left the
+// pool of the currency withdrawn for it. If that depends on the amount received, because currencies were exchanged
+// both ways on the day, it's the cost basis of the lot received instead.
+func (m *shareMatcher) carried(e costBasisEvent) Decimal {
+	days := m.days[e.carry.from]
+	i := firstShareMatchingDay(days, shareMatchingDate(e.carry.date))
+	if i == len(days) || days[i].costing {
+		return e.cost
+	}
+	m.cost(e.carry.from, i)
+	return e.carry.cost
+}
+
+// unmatchedDisposed returns the amount disposed of which isn't matched with acquisitions.
+func (day *shareMatchingDay) unmatchedDisposed() Decimal {
+	amount := day.disposed.Sub(day.sameDay)
+	for _, t := range day.bedAndBreakfast {
+		amount = amount.Sub(t.amount)
+	}
+	return amount
+}
+
+// unmatchedAcquired returns the amount acquired which isn't matched with disposals.
+func (day *shareMatchingDay) unmatchedAcquired() Decimal {
+	amount := day.acquired.Sub(day.sameDay)
+	for _, t := range day.taken {
+		amount = amount.Sub(t.amount)
+	}
+	return amount
+}
+
+// matches returns the matches of the day's disposals, in the order the rules apply.
+func (day *shareMatchingDay) matches() []ShareMatch {
+	var matches []ShareMatch
+	if day.sameDay.Sign() > 0 {
+		matches = append(matches, ShareMatch{Rule: SameDayRule, Amount: day.sameDay, Cost: day.sameDayCost, Acquired: day.date})
+	}
+	for _, t := range day.bedAndBreakfast {
+		matches = append(matches, ShareMatch{Rule: BedAndBreakfastRule, Amount: t.amount, Cost: t.cost, Acquired: t.acquired.date})
+	}
+	if day.section104.Amount.Sign() > 0 {
+		matches = append(matches, day.section104)
+	}
+	return matches
+}
+
+// recordMatches sets the cost basis of the day's TaxableGains lots to the allowable cost of the amount disposed of.
+// The matches are shared out between the disposals in the order they were recorded.
+func (day *shareMatchingDay) recordMatches(basisPlaces int32) {
+	matches := day.matches()
+	for _, lot := range day.disposals {
+		details := lot.taxableGainsDetails
+		details.shareMatches, matches = takeShareMatches(matches, details.soldAmount, basisPlaces)
+		details.costBasis = Decimal{}
+		for _, m := range details.shareMatches {
+			details.costBasis = details.costBasis.Add(m.Cost)
+		}
+	}
+}
+
+// takeShareMatches takes the given amount from the start of the matches, splitting one if needed,
+// and returns them along with the rest.
+func takeShareMatches(matches []ShareMatch, amount Decimal, basisPlaces int32) (taken, rest []ShareMatch) {
+	for amount.Sign() > 0 && len(matches) > 0 {
+		m := matches[0]
+		if m.Amount.Cmp(amount) > 0 {
+			part := m
+			part.Amount = amount
+			part.Cost = m.Cost.Mul(amount).Quo(m.Amount, basisPlaces)
+			taken = append(taken, part)
+			m.Amount, m.Cost = m.Amount.Sub(part.Amount), m.Cost.Sub(part.Cost)
+			return taken, append([]ShareMatch{m}, matches[1:]...)
+		}
+		taken = append(taken, m)
+		amount = amount.Sub(m.Amount)
+		matches = matches[1:]
+	}
+	return taken, matches
+}
+
+// shareMatchingDate returns the day of the time, since the rules treat each day's acquisitions and disposals as one.
+func shareMatchingDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// firstShareMatchingDay returns the index of the first day on or after the date.
+func firstShareMatchingDay(days []*shareMatchingDay, date time.Time) int {
+	return sort.Search(len(days), func(i int) bool { return !days[i].date.Before(date) })
+}
+
+// shareMatchesString describes the matches like "same day 1.000000000 (2018-06-01, cost 100.000000)".
+func shareMatchesString(matches []ShareMatch) string {
+	parts := make([]string, len(matches))
+	for i, m := range matches {
+		if m.Rule == Section104Rule {
+			parts[i] = fmt.Sprintf("%s %0.9f (cost %f)", m.Rule, m.Amount, m.Cost)
+		} else {
+			parts[i] = fmt.Sprintf("%s %0.9f (%s, cost %f)", m.Rule, m.Amount, m.Acquired.Format("2006-01-02"), m.Cost)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,168 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestUKShareMatching(t *testing.T) {
+	newLedger := func(g *GomegaWithT) *ledger.Ledger {
+		l := ledger.New(USD, nil)
+		g.Expect(l.SetCostBasisMethod(ledger.UKShareMatching)).To(Succeed())
+		_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("10"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-03-01"), "1", Coinbase, BTC, n("5"), n("1500"))
+		g.Expect(err).NotTo(HaveOccurred())
+		return l
+	}
+
+	t.Run("same day, then bed and breakfast, then the pool", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		_, err := l.Purchase(d("2018-06-01"), "1", Coinbase, BTC, n("2"), n("800"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// the lots sold from don't matter, and the day's disposals are matched as one
+		_, err = l.SellTaxable(d("2018-06-01"), "1.1", BTC, n("4"), n("1600"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2018-06-01"), "1.2", BTC, n("2"), n("800"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// bought back within 30 days, which revises the sales
+		_, err = l.Purchase(d("2018-06-20"), "1", Coinbase, BTC, n("3"), n("900"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// too late to be matched, so it joins the pool
+		_, err = l.Purchase(d("2018-07-02"), "1", Coinbase, BTC, n("1"), n("400"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2018-08-01"), "1.3", BTC, n("2"), n("900"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(l.PrintTaxableGains()).To(Equal(
+			`1.1.1	2018-06-01 Taxable Gains (short-term) from sale on Coinbase of BTC 4.000000000 originally purchased 2018-01-02 for USD 1400.000000. proceeds=USD 1600.000000, gains=USD 200.000000, note=sold BTC for USD, matched same day 2.000000000 (2018-06-01, cost 800.000000); bed and breakfast 2.000000000 (2018-06-20, cost 600.000000)
+1.2.1	2018-06-01 Taxable Gains (short-term) from sale on Coinbase of BTC 2.000000000 originally purchased 2018-03-01 for USD 466.670000. proceeds=USD 800.000000, gains=USD 333.330000, note=sold BTC for USD, matched bed and breakfast 1.000000000 (2018-06-20, cost 300.000000); section 104 1.000000000 (cost 166.670000)
+1.3.1	2018-08-01 Taxable Gains (short-term) from sale on Coinbase of BTC 2.000000000 originally purchased 2018-06-01 for USD 364.440000. proceeds=USD 900.000000, gains=USD 535.560000, note=sold BTC for USD, matched section 104 2.000000000 (cost 364.440000)
+(2018's capital gains: short-term:$1068.89 long-term:$0.00)
+(Total capital gains: short-term:$1068.89 long-term:$0.00)
+`))
+		g.Expect(l.PrintSection104Pools()).To(Equal(
+			`BTC Section 104 pool
+date        event     amount        cost     pool amount   pool cost
+2018-01-02  acquired  10.000000000  1000.00  10.000000000  1000.00
+2018-03-01  acquired  5.000000000   1500.00  15.000000000  2500.00
+2018-06-01  disposed  -1.000000000  -166.67  14.000000000  2333.33
+2018-07-02  acquired  1.000000000   400.00   15.000000000  2733.33
+2018-08-01  disposed  -2.000000000  -364.44  13.000000000  2368.89
+`))
+
+		saved := &bytes.Buffer{}
+		g.Expect(l.Save(saved)).To(Succeed())
+		loaded, err := ledger.Load(saved, nil)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.CostBasisMethod()).To(Equal(ledger.UKShareMatching))
+		g.Expect(loaded.PrintTaxableGains()).To(Equal(l.PrintTaxableGains()))
+
+		// the loaded ledger carries on the same way
+		for _, l := range []*ledger.Ledger{l, loaded} {
+			_, err = l.Purchase(d("2018-08-10"), "1", Coinbase, BTC, n("1"), n("500"))
+			g.Expect(err).NotTo(HaveOccurred())
+		}
+		g.Expect(loaded.PrintTaxableGains()).To(Equal(l.PrintTaxableGains()))
+		g.Expect(loaded.PrintSection104Pools()).To(Equal(l.PrintSection104Pools()))
+	})
+
+	t.Run("transactions recorded out of date order", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		inOrder, outOfOrder := newLedger(g), newLedger(g)
+		for _, l := range []*ledger.Ledger{inOrder, outOfOrder} {
+			_, err := l.SellTaxable(d("2018-06-01"), "1.1", BTC, n("4"), n("1600"))
+			g.Expect(err).NotTo(HaveOccurred())
+			if l == inOrder {
+				_, err = l.Purchase(d("2018-06-20"), "1", Coinbase, BTC, n("3"), n("900"))
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			_, err = l.SellTaxable(d("2018-08-01"), "1.2", BTC, n("2"), n("900"))
+			g.Expect(err).NotTo(HaveOccurred())
+		}
+		// matched with the sale before it by the bed and breakfast rule, which changes the pool for the sale after it
+		_, err := outOfOrder.Purchase(d("2018-06-20"), "1", Coinbase, BTC, n("3"), n("900"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(outOfOrder.PrintSection104Pools()).To(Equal(inOrder.PrintSection104Pools()))
+		g.Expect(outOfOrder.PrintTaxableGains()).To(Equal(inOrder.PrintTaxableGains()))
+	})
+
+	t.Run("fees and non-taxable exchanges", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		err := l.Fee(d("2018-04-01"), "1", USD, n("15"), "1.1", "trading fee")
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-05-01"), "1", Coinbase, BTC, n("2"), n("700"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// leaves the pool at its average cost, which is carried over to ETH
+		_, err = l.ExchangeNonTaxable(d("2018-05-01"), "1.3", BTC, n("2"), n("0"), ETH, n("20"))
+		g.Expect(err).NotTo(HaveOccurred())
+		gainsLot, err := l.SellTaxable(d("2018-08-01"), "1.1", BTC, n("5"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(gainsLot.TaxableGainsDetails().ShareMatches()).To(HaveLen(1))
+		g.Expect(l.PrintSection104Pools()).To(Equal(
+			`BTC Section 104 pool
+date        event      amount        cost     pool amount   pool cost
+2018-01-02  acquired   10.000000000  1000.00  10.000000000  1000.00
+2018-03-01  acquired   5.000000000   1500.00  15.000000000  2500.00
+2018-04-01  fee        0.000000000   15.00    15.000000000  2515.00
+2018-05-01  acquired   2.000000000   700.00   17.000000000  3215.00
+2018-05-01  withdrawn  -2.000000000  -378.24  15.000000000  2836.76
+2018-08-01  disposed   -5.000000000  -945.59  10.000000000  1891.17
+
+ETH Section 104 pool
+date        event     amount        cost    pool amount   pool cost
+2018-05-01  acquired  20.000000000  378.24  20.000000000  378.24
+`))
+	})
+
+	t.Run("spending at the lot's cost basis is still a disposal", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, ledger.PriceMap{BTC: {d("2018-04-01"): n("150")}})
+		g.Expect(l.SetCostBasisMethod(ledger.UKShareMatching)).To(Succeed())
+		_, err := l.DepositNewMoney(d("2018-01-02"), Coinbase, n("10000"), n("10000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-01-02"), "1", Coinbase, BTC, n("10"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-03-01"), "1", Coinbase, BTC, n("10"), n("1500"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// no gains by the lot's cost basis, but there are by the pool's
+		_, err = l.Spend(d("2018-04-01"), Coinbase, "1.2", BTC, n("5"), "pizza")
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(l.PrintTaxableGains()).To(Equal(
+			`1.2.spendCapitalGains.1	2018-04-01 Taxable Gains (short-term) from sale on Coinbase of BTC 5.000000000 originally purchased 2018-03-01 for USD 625.000000. proceeds=USD 750.000000, gains=USD 125.000000, note=pizza, matched section 104 5.000000000 (cost 625.000000)
+(2018's capital gains: short-term:$125.00 long-term:$0.00)
+(Total capital gains: short-term:$125.00 long-term:$0.00)
+`))
+		g.Expect(l.PrintSection104Pools()).To(Equal(
+			`BTC Section 104 pool
+date        event     amount        cost     pool amount   pool cost
+2018-01-02  acquired  10.000000000  1000.00  10.000000000  1000.00
+2018-03-01  acquired  10.000000000  1500.00  20.000000000  2500.00
+2018-04-01  disposed  -5.000000000  -625.00  15.000000000  1875.00
+`))
+	})
+
+	t.Run("can't change after transactions are recorded", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g)
+		g.Expect(l.SetCostBasisMethod(ledger.LotCostBasis)).To(MatchError(
+			"can't change the cost basis method to lots after transactions have been recorded"))
+		g.Expect(l.SetCostBasisMethod(ledger.UKShareMatching)).To(Succeed())
+	})
+}
@@ -0,0 +1,173 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// TaxRules classify capital gains for a tax jurisdiction, by how long the asset was held. See Ledger.SetTaxRules.
+	TaxRules interface {
+		// Name identifies the rules, e.g. "US". It's saved with the ledger, see LookupTaxRules.
+		Name() string
+		// Categories lists every category Classify returns, in the order the reports show them.
+		Categories() []GainsCategory
+		// Classify returns the category of a gain or loss on an asset acquired and disposed of at the given times.
+		Classify(acquired, disposed time.Time) GainsCategory
+	}
+
+	// GainsCategory is a class of capital gains which are taxed alike, e.g. US long-term gains.
+	GainsCategory struct {
+		// Name describes the category in the reports, e.g. "long-term".
+		Name string
+		// LongTerm is set for gains on assets held long enough to be taxed differently.
+		LongTerm bool
+		// Exempt is set for gains which aren't taxed at all, and losses which can't be deducted.
+		Exempt bool
+		// Discount is the fraction of a gain which isn't taxed, e.g. one half for Australia's CGT discount.
+		// Losses aren't discounted.
+		Discount Decimal
+	}
+
+	// USTaxRules are the US rules: assets held for more than one year have long-term gains, and the rest short-term.
+	// They're the default.
+	USTaxRules struct{}
+
+	// DETaxRules are the German rules for private sales: gains on assets held for more than one year are exempt.
+	DETaxRules struct{}
+
+	// AUTaxRules are the Australian rules for individuals: gains on assets held for at least 12 months, not counting
+	// the days they were acquired and disposed of, get the 50% CGT discount.
+	AUTaxRules struct{}
+
+	// FlatTaxRules tax all gains alike, however long the asset was held.
+	FlatTaxRules struct{}
+)
+
+var (
+	usShortTerm   = GainsCategory{Name: "short-term"}
+	usLongTerm    = GainsCategory{Name: "long-term", LongTerm: true}
+	deTaxable     = GainsCategory{Name: "taxable"}
+	deExempt      = GainsCategory{Name: "exempt", LongTerm: true, Exempt: true}
+	auNonDiscount = GainsCategory{Name: "non-discount"}
+	auDiscount    = GainsCategory{Name: "discount", LongTerm: true, Discount: NewDecimal(5, 1)}
+	flatGains     = GainsCategory{Name: "gains"}
+)
+
+// Name returns "US".
+func (USTaxRules) Name() string { return "US" }
+
+// Categories returns the short-term and long-term categories.
+func (USTaxRules) Categories() []GainsCategory { return []GainsCategory{usShortTerm, usLongTerm} }
+
+// Classify returns the long-term category if the asset was disposed of after the anniversary of its acquisition.
+func (USTaxRules) Classify(acquired, disposed time.Time) GainsCategory {
+	if heldMoreThanOneYear(acquired, disposed) {
+		return usLongTerm
+	}
+	return usShortTerm
+}
+
+// Name returns "DE".
+func (DETaxRules) Name() string { return "DE" }
+
+// Categories returns the taxable and exempt categories.
+func (DETaxRules) Categories() []GainsCategory { return []GainsCategory{deTaxable, deExempt} }
+
+// Classify returns the exempt category if the asset was disposed of after the anniversary of its acquisition.
+func (DETaxRules) Classify(acquired, disposed time.Time) GainsCategory {
+	if heldMoreThanOneYear(acquired, disposed) {
+		return deExempt
+	}
+	return deTaxable
+}
+
+// Name returns "AU".
+func (AUTaxRules) Name() string { return "AU" }
+
+// Categories returns the non-discount and discount categories.
+func (AUTaxRules) Categories() []GainsCategory { return []GainsCategory{auNonDiscount, auDiscount} }
+
+// Classify returns the discount category if the asset was disposed of after the anniversary of its acquisition,
+// so that there were 12 whole months in between.
+func (AUTaxRules) Classify(acquired, disposed time.Time) GainsCategory {
+	if heldMoreThanOneYear(acquired, disposed) {
+		return auDiscount
+	}
+	return auNonDiscount
+}
+
+// Name returns "flat".
+func (FlatTaxRules) Name() string { return "flat" }
+
+// Categories returns the one category.
+func (FlatTaxRules) Categories() []GainsCategory { return []GainsCategory{flatGains} }
+
+// Classify returns the one category.
+func (FlatTaxRules) Classify(acquired, disposed time.Time) GainsCategory { return flatGains }
+
+// LookupTaxRules returns the built-in rules with the given name, ignoring case: "US", "DE", "AU" or "flat".
+func LookupTaxRules(name string) (TaxRules, bool) {
+	for _, rules := range []TaxRules{USTaxRules{}, DETaxRules{}, AUTaxRules{}, FlatTaxRules{}} {
+		if strings.EqualFold(rules.Name(), name) {
+			return rules, true
+		}
+	}
+	return nil, false
+}
+
+// TaxableGain returns the part of the gain or loss which is taxed, or can be deducted.
+func (c GainsCategory) TaxableGain(gain Decimal, places int32) Decimal {
+	switch {
+	case c.Exempt:
+		return Decimal{}
+	case gain.Sign() > 0 && !c.Discount.IsZero():
+		return gain.Sub(gain.Mul(c.Discount).Round(places))
+	}
+	return gain
+}
+
+// heldMoreThanOneYear returns true if the asset was disposed of on a later calendar date than the anniversary of its
+// acquisition. An asset acquired on February 29th has its anniversary on February 28th.
+func heldMoreThanOneYear(acquired, disposed time.Time) bool {
+	y, m, d := acquired.Date()
+	anniversary := time.Date(y+1, m, d, 0, 0, 0, 0, time.UTC)
+	if anniversary.Month() != m {
+		// the day doesn't exist that year, so it's the last day of the month instead
+		anniversary = anniversary.AddDate(0, 0, -anniversary.Day())
+	}
+	y, m, d = disposed.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).After(anniversary)
+}
+
+// SetTaxRules sets the rules which classify the capital gains in the reports. The default is USTaxRules.
+// Form 8949 and TXF files are US forms, so they always use the US rules.
+func (l *Ledger) SetTaxRules(rules TaxRules) {
+	l.taxRules = rules
+}
+
+// TaxRules returns the rules which classify the capital gains in the reports.
+func (l *Ledger) TaxRules() TaxRules {
+	if l.taxRules == nil {
+		return USTaxRules{}
+	}
+	return l.taxRules
+}
+
+// GainsCategory returns the category of the gain or loss in the details, under the ledger's rules.
+func (l *Ledger) GainsCategory(details *TaxableGainsDetails) GainsCategory {
+	return l.TaxRules().Classify(details.originalPurchaseTime, details.dateOfSale)
+}
+
+// loadTaxRules returns the built-in rules with the name saved with a ledger, or the default if there's none.
+func loadTaxRules(name string) (TaxRules, error) {
+	if name == "" {
+		return nil, nil
+	}
+	rules, ok := LookupTaxRules(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown tax rules %q: only the built-in rules can be loaded", name)
+	}
+	return rules, nil
+}
@@ -0,0 +1,96 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestTaxRulesClassify(t *testing.T) {
+	for _, tc := range []struct {
+		rules              ledger.TaxRules
+		acquired, disposed string
+		want               string
+	}{
+		{ledger.USTaxRules{}, "2017-03-01", "2018-03-01", "short-term"},
+		{ledger.USTaxRules{}, "2017-03-01", "2018-03-02", "long-term"},
+		// 366 days, but not more than one year
+		{ledger.USTaxRules{}, "2019-03-01", "2020-03-01", "short-term"},
+		// the anniversary is February 28th
+		{ledger.USTaxRules{}, "2016-02-29", "2017-02-28", "short-term"},
+		{ledger.USTaxRules{}, "2016-02-29", "2017-03-01", "long-term"},
+		{ledger.DETaxRules{}, "2017-03-01", "2018-03-01", "taxable"},
+		{ledger.DETaxRules{}, "2017-03-01", "2018-03-02", "exempt"},
+		{ledger.AUTaxRules{}, "2017-03-01", "2018-03-01", "non-discount"},
+		{ledger.AUTaxRules{}, "2017-03-01", "2018-03-02", "discount"},
+		{ledger.FlatTaxRules{}, "2010-01-01", "2018-03-02", "gains"},
+	} {
+		g := NewGomegaWithT(t)
+		g.Expect(tc.rules.Classify(d(tc.acquired), d(tc.disposed)).Name).To(Equal(tc.want),
+			"%s %s-%s", tc.rules.Name(), tc.acquired, tc.disposed)
+	}
+}
+
+func TestTaxRulesInReports(t *testing.T) {
+	newLedger := func(g *GomegaWithT, rules ledger.TaxRules) *ledger.Ledger {
+		l := ledger.New(USD, nil)
+		l.SetTaxRules(rules)
+		_, err := l.DepositNewMoney(d("2017-01-02"), Coinbase, n("3000"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2017-01-02"), "1", Coinbase, BTC, n("2"), n("2000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2017-06-01"), "1.1", BTC, n("0.5"), n("700"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2018-06-01"), "1.1", BTC, n("1"), n("5000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		return l
+	}
+
+	t.Run("DE", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g, ledger.DETaxRules{})
+		g.Expect(l.PrintTaxableGains()).To(Equal(
+			`1.1.1	2017-06-01 Taxable Gains (taxable) from sale on Coinbase of BTC 0.500000000 originally purchased 2017-01-02 for USD 500.000000. proceeds=USD 700.000000, gains=USD 200.000000, note=sold BTC for USD
+1.1.2	2018-06-01 Taxable Gains (exempt) from sale on Coinbase of BTC 1.000000000 originally purchased 2017-01-02 for USD 1000.000000. proceeds=USD 5000.000000, gains=USD 4000.000000, note=sold BTC for USD
+(2017's capital gains: taxable:$200.00 exempt:$0.00 net taxable:$200.00)
+(2018's capital gains: taxable:$0.00 exempt:$4000.00 net taxable:$0.00)
+(Total capital gains: taxable:$200.00 exempt:$4000.00 net taxable:$200.00)
+`))
+
+		saved := &bytes.Buffer{}
+		g.Expect(l.Save(saved)).To(Succeed())
+		loaded, err := ledger.Load(saved, nil)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.TaxRules()).To(Equal(ledger.DETaxRules{}))
+		g.Expect(loaded.PrintTaxableGains()).To(Equal(l.PrintTaxableGains()))
+	})
+
+	t.Run("AU", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g, ledger.AUTaxRules{})
+		g.Expect(l.PrintTaxableGains()).To(Equal(
+			`1.1.1	2017-06-01 Taxable Gains (non-discount) from sale on Coinbase of BTC 0.500000000 originally purchased 2017-01-02 for USD 500.000000. proceeds=USD 700.000000, gains=USD 200.000000, note=sold BTC for USD
+1.1.2	2018-06-01 Taxable Gains (discount) from sale on Coinbase of BTC 1.000000000 originally purchased 2017-01-02 for USD 1000.000000. proceeds=USD 5000.000000, gains=USD 4000.000000, note=sold BTC for USD
+(2017's capital gains: non-discount:$200.00 discount:$0.00 net taxable:$200.00)
+(2018's capital gains: non-discount:$0.00 discount:$4000.00 net taxable:$2000.00)
+(Total capital gains: non-discount:$200.00 discount:$4000.00 net taxable:$2200.00)
+`))
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := newLedger(g, ledger.FlatTaxRules{})
+		g.Expect(l.PrintLots()).To(Equal(
+			`1      2017-01-02 Coinbase USD 1000.000000000  (basis:$1000.000000  price:$1.000000)
+1.1    2017-01-02 Coinbase BTC 0.500000000     (basis:$500.000000   price:$1000.000000)
+1.1.1  2017-06-01 Taxable Gains (gains) from sale on Coinbase of BTC 0.500000000 originally purchased 2017-01-02 for USD 500.000000. proceeds=USD 700.000000, gains=USD 200.000000, note=sold BTC for USD
+1.1.2  2018-06-01 Taxable Gains (gains) from sale on Coinbase of BTC 1.000000000 originally purchased 2017-01-02 for USD 1000.000000. proceeds=USD 5000.000000, gains=USD 4000.000000, note=sold BTC for USD
+`))
+	})
+}
@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LotTracking chooses whether lots are tracked in one universal pool or per wallet, see Ledger.SetLotTracking.
+type LotTracking int
+
+const (
+	// UniversalTracking treats every account's lots as one pool: an amount disposed of from one account may be taken
+	// from a lot held in another. It's the default.
+	UniversalTracking LotTracking = iota
+	// PerWalletTracking ties lots to the account holding them, as the US requires from 2025: an amount disposed of
+	// from an account must be taken from that account's lots.
+	PerWalletTracking
+)
+
+var lotTrackingNames = enumNames[LotTracking]{
+	UniversalTracking: "universal",
+	PerWalletTracking: "per-wallet",
+}
+
+// String returns the name of the mode, e.g. "per-wallet".
+func (t LotTracking) String() string {
+	return lotTrackingNames.name(t)
+}
+
+// MarshalText encodes the LotTracking by name, e.g. "per-wallet".
+func (t LotTracking) MarshalText() ([]byte, error) {
+	return lotTrackingNames.marshal("lot tracking", t)
+}
+
+// UnmarshalText decodes a LotTracking encoded by MarshalText.
+func (t *LotTracking) UnmarshalText(text []byte) error {
+	return lotTrackingNames.unmarshal("lot tracking", text, t)
+}
+
+// LotTrackingChange switches the ledger to a lot tracking mode for transactions from a date onwards.
+type LotTrackingChange struct {
+	From     time.Time   `json:"from"`
+	Tracking LotTracking `json:"tracking"`
+}
+
+// SetLotTracking switches the ledger to the lot tracking mode for transactions dated from the given date onwards,
+// until the next change. Before the first change, lots are tracked universally.
+//
+// Under UniversalTracking, SellTaxableFromAccount, ExchangeTaxableFromAccount and SpendFromAccount offer the LotSelector
+// the lots held in every account, so e.g. FIFO uses the cost basis and holding period of the oldest lot wherever it's
+// held. The amount still leaves the account it's disposed of from: an amount identified in another account's lot is
+// replaced there by the account's own lots, as if they had been transferred there first. Under PerWalletTracking those
+// operations only offer the account's own lots, and reject any other lot the selector returns, while Spend and Fee
+// reject lots held in an account other than the one they're disposed of from. PurchaseFromAccount and
+// TransferFromAccount always draw from the account's own lots, and operations which only name a lot dispose of it from
+// the account holding it, so they're unaffected.
+//
+// A change can't apply to transactions which have already been recorded under the other mode.
+func (l *Ledger) SetLotTracking(from time.Time, tracking LotTracking) error {
+	if _, ok := lotTrackingNames[tracking]; !ok {
+		return fmt.Errorf("unknown lot tracking %d", int(tracking))
+	}
+	changes := []LotTrackingChange{{From: from, Tracking: tracking}}
+	for _, c := range l.lotTracking {
+		if !c.From.Equal(from) {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].From.Before(changes[j].From) })
+
+	for _, tx := range l.transactions {
+		if lotTrackingOn(changes, tx.Date) != l.LotTracking(tx.Date) {
+			return fmt.Errorf("can't switch to %s lot tracking from %s, since a %s transaction on %s was recorded under %s lot tracking",
+				tracking, from.Format("2006-01-02"), tx.Type, tx.Date.Format("2006-01-02"), l.LotTracking(tx.Date))
+		}
+	}
+	l.lotTracking = changes
+	return nil
+}
+
+// LotTracking returns the lot tracking mode for transactions on the given date.
+func (l *Ledger) LotTracking(date time.Time) LotTracking {
+	return lotTrackingOn(l.lotTracking, date)
+}
+
+// LotTrackingChanges returns the changes of lot tracking mode, in date order.
+func (l *Ledger) LotTrackingChanges() []LotTrackingChange {
+	return append([]LotTrackingChange(nil), l.lotTracking...)
+}
+
+// lotTrackingOn returns the mode in effect on the date, given the changes in date order.
+func lotTrackingOn(changes []LotTrackingChange, date time.Time) LotTracking {
+	tracking := UniversalTracking
+	for _, c := range changes {
+		if date.Before(c.From) {
+			break
+		}
+		tracking = c.Tracking
+	}
+	return tracking
+}
+
+// checkWallet verifies that the lot may be disposed of from the account on the given date: under PerWalletTracking,
+// it must be held in that account.
+func (l *Ledger) checkWallet(op string, date time.Time, lot *Lot, account Account) error {
+	if l.LotTracking(date) != PerWalletTracking || lot.account == account {
+		return nil
+	}
+	return &InvalidOperationError{Op: op, LotName: lot.name,
+		Reason: fmt.Sprintf("the lot is held in %s, not %s, and lots are tracked per wallet on %s", lot.account, account, date.Format("2006-01-02"))}
+}
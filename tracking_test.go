@@ -0,0 +1,121 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+// selectorFunc adapts a function to a LotSelector.
+type selectorFunc func(candidates []*ledger.Lot) ([]*ledger.Lot, error)
+
+func (f selectorFunc) SelectLots(candidates []*ledger.Lot) ([]*ledger.Lot, error) {
+	return f(candidates)
+}
+
+func TestLotTracking(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	g.Expect(l.SetLotTracking(d("2017-12-01"), ledger.PerWalletTracking)).To(Succeed())
+	_, err := l.DepositNewMoney(d("2017-10-01"), Coinbase, n("10000"), n("10000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-10-01"), "1", Coinbase, BTC, n("1"), n("5000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-10-01"), "1", Bitfinex, BTC, n("1"), n("4000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	// a selector which ignores the candidates, and picks the lot held in Bitfinex
+	bitfinexLot := selectorFunc(func([]*ledger.Lot) ([]*ledger.Lot, error) {
+		lot, err := l.FindLotByName("1.2", BTC)
+		return []*ledger.Lot{lot}, err
+	})
+
+	// lots are universal until the switch-over
+	g.Expect(l.LotTracking(d("2017-11-30"))).To(Equal(ledger.UniversalTracking))
+	_, err = l.Spend(d("2017-11-01"), Coinbase, "1.2", BTC, n("0.1"), "coffee")
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxableFromAccount(d("2017-11-02"), Coinbase, bitfinexLot, BTC, n("0.1"), n("696"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// and per wallet after it
+	g.Expect(l.LotTracking(d("2017-12-01"))).To(Equal(ledger.PerWalletTracking))
+	_, err = l.Spend(d("2017-12-01"), Coinbase, "1.2", BTC, n("0.1"), "coffee")
+	g.Expect(err).To(MatchError("Spend: lot 1.2: the lot is held in Bitfinex, not Coinbase, and lots are tracked per wallet on 2017-12-01"))
+	_, err = l.SellTaxableFromAccount(d("2017-12-01"), Coinbase, bitfinexLot, BTC, n("0.1"), n("1097"))
+	g.Expect(err).To(MatchError("SellTaxableFromAccount: lot 1.2: the lot is held in Bitfinex, not Coinbase, and lots are tracked per wallet on 2017-12-01"))
+	g.Expect(l.Fee(d("2017-12-01"), "1.2", BTC, n("0.001"), "1.1", "withdrawal fee")).
+		To(MatchError("Fee: lot 1.2: the lot is held in Bitfinex, not Coinbase, and lots are tracked per wallet on 2017-12-01"))
+	_, err = l.Spend(d("2017-12-01"), Bitfinex, "1.2", BTC, n("0.1"), "coffee")
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.SellTaxableFromAccount(d("2017-12-01"), Coinbase, ledger.FIFO, BTC, n("0.1"), n("1097"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// the switch-over can't move past transactions already recorded
+	g.Expect(l.SetLotTracking(d("2017-11-01"), ledger.PerWalletTracking)).To(MatchError(
+		"can't switch to per-wallet lot tracking from 2017-11-01, since a spend transaction on 2017-11-01 was recorded under universal lot tracking"))
+	g.Expect(l.SetLotTracking(d("2018-01-01"), ledger.UniversalTracking)).To(Succeed())
+	g.Expect(l.LotTrackingChanges()).To(Equal([]ledger.LotTrackingChange{
+		{From: d("2017-12-01"), Tracking: ledger.PerWalletTracking},
+		{From: d("2018-01-01"), Tracking: ledger.UniversalTracking},
+	}))
+
+	saved := &bytes.Buffer{}
+	g.Expect(l.Save(saved)).To(Succeed())
+	loaded, err := ledger.Load(saved, historicalPrices)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.LotTrackingChanges()).To(Equal(l.LotTrackingChanges()))
+}
+
+func TestLotTrackingSelection(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := ledger.New(USD, historicalPrices)
+	g.Expect(l.SetLotTracking(d("2017-12-01"), ledger.PerWalletTracking)).To(Succeed())
+	_, err := l.DepositNewMoney(d("2017-10-01"), Coinbase, n("10000"), n("10000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-10-01"), "1", Coinbase, BTC, n("1"), n("5000"))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = l.Purchase(d("2017-10-02"), "1", Bitfinex, BTC, n("1"), n("4000"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// before the switch-over, FIFO sells the oldest lot, although it's held in Coinbase
+	gainsLots, err := l.SellTaxableFromAccount(d("2017-11-02"), Bitfinex, ledger.FIFO, BTC, n("0.1"), n("696"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(gainsLots).To(HaveLen(1))
+	g.Expect(gainsLots[0].Parent().Name()).To(Equal("1.1"))
+	g.Expect(gainsLots[0].TaxableGainsDetails().Account()).To(Equal(Bitfinex))
+	// but the amount sold still leaves Bitfinex: its own lot replaces the amount identified in Coinbase
+	g.Expect(l.PrintAccounts()).To(Equal(
+		`Bitfinex
+	BTC 0.900000000 (basis:3600.000000	price:$4000.000000)
+		1.2  2017-10-02 Bitfinex BTC 0.900000000  (basis:$3600.000000  price:$4000.000000)
+Coinbase
+	BTC 1.000000000 (basis:4900.000000	price:$4900.000000)
+		1.1    2017-10-01 Coinbase BTC 0.900000000  (basis:$4500.000000  price:$5000.000000)
+		1.2.1  2017-10-02 Coinbase BTC 0.100000000  (basis:$400.000000   price:$4000.000000)
+	USD 1000.000000000 (basis:1000.000000	price:$1.000000)
+		1  2017-10-01 Coinbase USD 1000.000000000  (basis:$1000.000000  price:$1.000000)
+(Total basis: $9500.00)
+(Total initial investment: $10000.00)
+`))
+
+	// and transfers always draw from the account's own lots
+	transferred, err := l.TransferFromAccount(d("2017-11-02"), Bitfinex, ledger.FIFO, BTC, n("0.1"), n("0"), Coinbase)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(transferred).To(HaveLen(1))
+	g.Expect(transferred[0].Parent().Name()).To(Equal("1.2"))
+
+	// after it, FIFO only sells the lots held in Bitfinex
+	gainsLots, err = l.SellTaxableFromAccount(d("2017-12-01"), Bitfinex, ledger.FIFO, BTC, n("0.1"), n("1097"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(gainsLots).To(HaveLen(1))
+	g.Expect(gainsLots[0].Parent().Name()).To(Equal("1.2"))
+	_, err = l.SpendFromAccount(d("2017-12-01"), Bitfinex, ledger.FIFO, BTC, n("1"), "coffee")
+	g.Expect(err).To(MatchError("insufficient BTC: requested 1, available 0.7"))
+
+	// the replacements are recorded in the journal, so it still balances
+	g.Expect(l.WriteBeancount(&bytes.Buffer{})).To(Succeed())
+}
@@ -0,0 +1,235 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type (
+	// Transaction records one operation performed on the Ledger: what it consumed, what it produced,
+	// and the names of the lots it generated.
+	Transaction struct {
+		Type TransactionType `json:"type"`
+		Date time.Time       `json:"date"`
+		// Accounts involved in the transaction, in order of appearance.
+		Accounts []Account `json:"accounts,omitempty"`
+		// Inputs are the amounts removed from existing lots.
+		Inputs []Posting `json:"inputs,omitempty"`
+		// Outputs are the new lots holding assets, as they stood when the transaction completed.
+		Outputs []Posting `json:"outputs,omitempty"`
+		// Fees are the fees paid. They may have been sold for their value in the local currency (see Posting.Value).
+		Fees []Posting `json:"fees,omitempty"`
+		// Adjustments are cost basis added to lots which existed before the transaction, e.g. by Ledger.Fee,
+		// or removed from them when AverageCost restates them.
+		Adjustments []Posting `json:"adjustments,omitempty"`
+		Note        string    `json:"note,omitempty"`
+		// LotNames are the names of all lots generated by the transaction, including TaxableGains lots.
+		LotNames []string `json:"lotNames,omitempty"`
+	}
+
+	// Posting is an amount of currency moving into or out of a lot.
+	Posting struct {
+		LotName  string   `json:"lotName"`
+		Account  Account  `json:"account,omitempty"`
+		Currency Currency `json:"currency"`
+		Amount   Decimal  `json:"amount"`
+		// CostBasis is the cost basis that moved along with the amount.
+		CostBasis Decimal `json:"costBasis"`
+		// Value is the amount's value in the local currency, when it was sold (i.e. the proceeds).
+		Value Decimal `json:"value"`
+	}
+
+	// TransactionType identifies which Ledger operation created a Transaction.
+	TransactionType int
+)
+
+const (
+	// DepositTransaction records new money added, see Ledger.DepositNewMoney.
+	DepositTransaction TransactionType = iota
+	// IncomeTransaction records new assets received as income, see Ledger.Income.
+	IncomeTransaction
+	// PurchaseTransaction records local currency exchanged for some other currency, see Ledger.Purchase.
+	PurchaseTransaction
+	// FeeTransaction records a fee which is added to some lot's cost basis, see Ledger.Fee.
+	FeeTransaction
+	// TransferTransaction records assets moved between accounts, see Ledger.Transfer.
+	TransferTransaction
+	// ExchangeTaxableTransaction records a taxable exchange of one currency for another, see Ledger.ExchangeTaxable.
+	ExchangeTaxableTransaction
+	// ExchangeNonTaxableTransaction records a non-taxable exchange of one currency for another, see Ledger.ExchangeNonTaxable.
+	ExchangeNonTaxableTransaction
+	// SellTransaction records a sale for local currency, see Ledger.SellTaxable.
+	SellTransaction
+	// SpendTransaction records assets spent, see Ledger.Spend.
+	SpendTransaction
+	// MergeTransaction records identical lots merged into one, see Ledger.MergeIdenticalLots.
+	MergeTransaction
+	// AllocationTransaction records the unused basis allocated to the accounts, see Ledger.AllocateUnusedBasis.
+	AllocationTransaction
+)
+
+var transactionTypeNames = enumNames[TransactionType]{
+	DepositTransaction:            "deposit",
+	IncomeTransaction:             "income",
+	PurchaseTransaction:           "purchase",
+	FeeTransaction:                "fee",
+	TransferTransaction:           "transfer",
+	ExchangeTaxableTransaction:    "exchange",
+	ExchangeNonTaxableTransaction: "exchange-nontaxable",
+	SellTransaction:               "sell",
+	SpendTransaction:              "spend",
+	MergeTransaction:              "merge",
+	AllocationTransaction:         "allocation",
+}
+
+// String returns the name of the transaction type, e.g. "purchase".
+func (t TransactionType) String() string {
+	return transactionTypeNames.name(t)
+}
+
+// Transactions returns the journal of every transaction recorded, in order.
+func (l *Ledger) Transactions() []Transaction {
+	return l.TransactionsFrom(0)
+}
+
+// NumTransactions returns the number of transactions in the journal.
+func (l *Ledger) NumTransactions() int {
+	return len(l.transactions)
+}
+
+// TransactionsFrom returns copies of the transactions in the journal from index i on, e.g. those recorded since
+// NumTransactions returned i.
+func (l *Ledger) TransactionsFrom(i int) []Transaction {
+	transactions := make([]Transaction, len(l.transactions)-i)
+	for j, tx := range l.transactions[i:] {
+		transactions[j] = *tx
+	}
+	return transactions
+}
+
+// TransactionForLot returns the transaction which generated the named lot.
+func (l *Ledger) TransactionForLot(lotName string) (Transaction, bool) {
+	for i := len(l.transactions) - 1; i >= 0; i-- {
+		for _, name := range l.transactions[i].LotNames {
+			if name == lotName {
+				return *l.transactions[i], true
+			}
+		}
+	}
+	return Transaction{}, false
+}
+
+// PrintTransactions prints the journal of transactions, each followed by its inputs, outputs and fees.
+func (l *Ledger) PrintTransactions() string {
+	b := &bytes.Buffer{}
+	for _, tx := range l.transactions {
+		accounts := make([]string, len(tx.Accounts))
+		for i, a := range tx.Accounts {
+			accounts[i] = a.String()
+		}
+		fmt.Fprintf(b, "%s %s %s", tx.Date.Format("2006-01-02"), tx.Type, strings.Join(accounts, ","))
+		if tx.Note != "" {
+			fmt.Fprintf(b, " (%s)", tx.Note)
+		}
+		fmt.Fprintf(b, " lots:%s\n", strings.Join(tx.LotNames, ","))
+
+		tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+		for _, section := range []struct {
+			label    string
+			postings []Posting
+		}{{"in", tx.Inputs}, {"out", tx.Outputs}, {"fee", tx.Fees}, {"adj", tx.Adjustments}} {
+			for _, p := range section.postings {
+				fmt.Fprintf(tw, "\t%s\t%s\t%s %s %0.9f\t(basis:$%0.2f", section.label, p.LotName, p.Account, p.Currency, p.Amount, p.CostBasis)
+				if !p.Value.IsZero() {
+					fmt.Fprintf(tw, "  value:$%0.2f", p.Value)
+				}
+				fmt.Fprintln(tw, ")")
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			panic(err.Error())
+		}
+	}
+	return b.String()
+}
+
+// transact runs op atomically, recording it in the journal if it succeeds.
+// While op runs, its steps record their inputs and fees in l.pending. Then the wash sale rules are applied, and under
+// AverageCost the lots are restated at the average cost. Once it's recorded, the cost basis method may revise the cost
+// basis of amounts sold before.
+func (l *Ledger) transact(txType TransactionType, date time.Time, note string, op func() error) error {
+	numLots := len(l.lots)
+	l.pending = &Transaction{Type: txType, Date: date, Note: note}
+	defer func() { l.pending = nil }()
+
+	err := l.atomically(func() error {
+		if err := op(); err != nil {
+			return err
+		}
+		return l.applyWashSales(txType, date, numLots)
+	})
+	if err != nil {
+		return err
+	}
+	if l.costBasisMethod == AverageCost {
+		l.applyAverageCost(numLots)
+	}
+
+	tx := l.pending
+	for _, lot := range l.lots[numLots:] {
+		tx.LotNames = append(tx.LotNames, lot.name)
+		if lot.lotType != TaxableGains && lot.amount.Sign() > 0 {
+			tx.Outputs = append(tx.Outputs, Posting{
+				LotName:   lot.name,
+				Account:   lot.account,
+				Currency:  lot.currency,
+				Amount:    lot.amount,
+				CostBasis: lot.costBasis,
+			})
+		}
+	}
+	for _, postings := range [][]Posting{tx.Inputs, tx.Outputs, tx.Fees, tx.Adjustments} {
+		for _, p := range postings {
+			tx.addAccount(p.Account)
+		}
+	}
+	l.transactions = append(l.transactions, tx)
+	l.applyCostBasisMethod()
+	return nil
+}
+
+func (tx *Transaction) addAccount(account Account) {
+	if account == "" {
+		return
+	}
+	for _, a := range tx.Accounts {
+		if a == account {
+			return
+		}
+	}
+	tx.Accounts = append(tx.Accounts, account)
+}
+
+// recordInput notes an amount removed from a lot in the pending transaction.
+func (l *Ledger) recordInput(p Posting) {
+	if l.pending != nil {
+		l.pending.Inputs = append(l.pending.Inputs, p)
+	}
+}
+
+// recordAdjustment notes cost basis added to (or removed from) an existing lot in the pending transaction.
+func (l *Ledger) recordAdjustment(p Posting) {
+	if l.pending != nil {
+		l.pending.Adjustments = append(l.pending.Adjustments, p)
+	}
+}
+
+// recordFee notes a fee in the pending transaction.
+func (l *Ledger) recordFee(p Posting) {
+	if l.pending != nil {
+		l.pending.Fees = append(l.pending.Fees, p)
+	}
+}
@@ -0,0 +1,85 @@
+package ledger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TXFOptions configure WriteTXF.
+type TXFOptions struct {
+	// Year only includes the sales in this tax year, unless it's zero.
+	Year int
+	// WholeDollars rounds each amount to whole dollars, as the IRS allows, instead of to cents.
+	WholeDollars bool
+	// Form8949 chooses the box of each sale, which decides its reference number, and its adjustment.
+	Form8949 Form8949Options
+	// Date is when the file was exported, written in its header. Defaults to today.
+	Date time.Time
+}
+
+// TXFReferenceNumber returns the Tax Exchange Format reference number for sales in the box.
+// TXF has no reference numbers for the digital asset boxes, so they use the numbers of their counterparts,
+// e.g. box I uses box C's.
+func (b Form8949Box) TXFReferenceNumber() int {
+	switch b {
+	case BoxA, BoxG:
+		return 321
+	case BoxB, BoxH:
+		return 711
+	case BoxC, BoxI:
+		return 712
+	case BoxD, BoxJ:
+		return 323
+	case BoxE, BoxK:
+		return 713
+	case BoxF, BoxL:
+		return 714
+	}
+	return 0
+}
+
+// WriteTXF writes the sales recorded in TaxableGains lots in the Tax Exchange Format (TXF, version 042),
+// which tax software such as TurboTax imports. Each sale is a detailed record of its description, the dates
+// it was acquired and sold, its cost basis and its proceeds, with the disallowed loss of a wash sale (code W).
+// Other adjustments can't be expressed in TXF, so they're an error.
+func (l *Ledger) WriteTXF(w io.Writer, options TXFOptions) error {
+	sections, err := l.Form8949(options.Form8949)
+	if err != nil {
+		return err
+	}
+	date := options.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	places := int32(2)
+	if options.WholeDollars {
+		places = 0
+	}
+	dollars := func(d Decimal) string { return d.Round(places).StringFixed(places) }
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "V042\nAcost-basis-tracking\nD%s\n^\n", date.Format("01/02/2006"))
+	for _, section := range sections {
+		if options.Year != 0 && section.Year != options.Year {
+			continue
+		}
+		for _, row := range section.Rows {
+			adjustment := row.Adjustment
+			if !adjustment.Amount.IsZero() && adjustment.Codes != "W" {
+				return &InvalidOperationError{Op: "WriteTXF", LotName: row.LotName,
+					Reason: fmt.Sprintf("adjustment code %q can't be written to TXF, only a wash sale's (W)", adjustment.Codes)}
+			}
+			fmt.Fprintf(b, "TD\nN%d\nC1\nL1\nP%s\nD%s\nD%s\n$%s\n$%s\n", section.Box.TXFReferenceNumber(),
+				strings.ReplaceAll(row.Description, "\n", " "), row.Acquired.Format("01/02/2006"),
+				row.Sold.Format("01/02/2006"), dollars(row.CostBasis), dollars(row.Proceeds))
+			if !adjustment.Amount.IsZero() {
+				fmt.Fprintf(b, "$%s\n", dollars(adjustment.Amount))
+			}
+			fmt.Fprintln(b, "^")
+		}
+	}
+	return b.Flush()
+}
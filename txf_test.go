@@ -0,0 +1,104 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+func TestWriteTXF(t *testing.T) {
+	t.Run("all years", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := form8949Ledger(g)
+
+		b := &bytes.Buffer{}
+		g.Expect(l.WriteTXF(b, ledger.TXFOptions{Date: d("2026-02-01")})).To(Succeed())
+		g.Expect(b.String()).To(Equal(
+			`V042
+Acost-basis-tracking
+D02/01/2026
+^
+TD
+N712
+C1
+L1
+P0.250000000 BTC
+D04/06/2017
+D12/01/2017
+$250.00
+$400.00
+^
+TD
+N714
+C1
+L1
+P0.250000000 BTC
+D04/06/2017
+D06/01/2018
+$250.00
+$150.00
+^
+TD
+N714
+C1
+L1
+P0.039766780 BTC
+D04/06/2017
+D03/01/2025
+$39.77
+$3400.50
+^
+`))
+	})
+
+	t.Run("one year in whole dollars, with a wash sale", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := form8949Ledger(g)
+
+		b := &bytes.Buffer{}
+		g.Expect(l.WriteTXF(b, ledger.TXFOptions{
+			Year:         2018,
+			WholeDollars: true,
+			Form8949: ledger.Form8949Options{Adjustment: func(lot *ledger.Lot) ledger.Form8949Adjustment {
+				return ledger.Form8949Adjustment{Codes: "W", Amount: n("40.50")}
+			}},
+			Date: d("2026-02-01"),
+		})).To(Succeed())
+		g.Expect(b.String()).To(Equal(
+			`V042
+Acost-basis-tracking
+D02/01/2026
+^
+TD
+N714
+C1
+L1
+P0.250000000 BTC
+D04/06/2017
+D06/01/2018
+$250
+$150
+$41
+^
+`))
+	})
+
+	t.Run("other adjustments", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := form8949Ledger(g)
+
+		err := l.WriteTXF(&bytes.Buffer{}, ledger.TXFOptions{
+			Form8949: ledger.Form8949Options{Adjustment: func(lot *ledger.Lot) ledger.Form8949Adjustment {
+				return ledger.Form8949Adjustment{Codes: "B", Amount: n("10")}
+			}},
+		})
+		g.Expect(err).To(MatchError(`WriteTXF: lot 1.1.1: adjustment code "B" can't be written to TXF, only a wash sale's (W)`))
+	})
+
+	g := NewGomegaWithT(t)
+	g.Expect(ledger.BoxC.TXFReferenceNumber()).To(Equal(712))
+	g.Expect(ledger.BoxL.TXFReferenceNumber()).To(Equal(714))
+}
@@ -0,0 +1,185 @@
+package ledger
+
+import "time"
+
+// WashSaleDays is the number of days before and after a sale at a loss in which a purchase replaces it, under the
+// US wash sale rule.
+const WashSaleDays = 30
+
+// WashSaleRule applies the wash sale rule to sales of a currency, see Ledger.SetWashSaleRule.
+type WashSaleRule struct {
+	// Class names a group of substantially identical assets, e.g. "S&P 500" for several funds tracking that index.
+	// A purchase of any currency in the class replaces a sale of another, unit for unit. Defaults to the currency.
+	Class string `json:"class,omitempty"`
+	// Days is how many days before and after a sale a purchase replaces it. Defaults to WashSaleDays.
+	Days int `json:"days,omitempty"`
+}
+
+// SetWashSaleRule applies the wash sale rule to sales of the currency from now on, e.g. for a brokerage's securities.
+// Cryptocurrencies aren't subject to it, so no currency is by default.
+//
+// When the currency is sold at a loss by SellTaxable or ExchangeTaxable (or their variants), and lots of the same
+// class are purchased (by Purchase or ExchangeTaxable) within the window before or after the sale, the loss for the
+// amount replaced is disallowed: it's recorded in the sale's TaxableGainsDetails (see WashSaleDisallowed), and added to
+// the cost basis of the replacement instead. The replacement amount is split from its lot into a child lot, which
+// carries over the holding period of the amount sold, so its purchase date is that much earlier.
+// Each amount purchased replaces only one sale, and sales and purchases are matched in the order they were recorded.
+func (l *Ledger) SetWashSaleRule(currency Currency, rule WashSaleRule) {
+	if rule.Class == "" {
+		rule.Class = string(currency)
+	}
+	if rule.Days == 0 {
+		rule.Days = WashSaleDays
+	}
+	if l.washSaleRules == nil {
+		l.washSaleRules = map[Currency]WashSaleRule{}
+	}
+	l.washSaleRules[currency] = rule
+}
+
+// WashSaleRule returns the wash sale rule applied to the currency, if there is one.
+func (l *Ledger) WashSaleRule(currency Currency) (WashSaleRule, bool) {
+	rule, ok := l.washSaleRules[currency]
+	return rule, ok
+}
+
+// washSaleClass returns the class of substantially identical assets the currency is in.
+func (l *Ledger) washSaleClass(currency Currency) string {
+	if rule, ok := l.washSaleRules[currency]; ok {
+		return rule.Class
+	}
+	return string(currency)
+}
+
+// washSalePurchase is a lot purchased on a date, which may replace a sale.
+type washSalePurchase struct {
+	lot  *Lot
+	date time.Time
+}
+
+// applyWashSales matches the sales at a loss and the purchases of the transaction in progress, whose lots start at
+// index numLots, with the purchases and sales recorded before it.
+func (l *Ledger) applyWashSales(txType TransactionType, date time.Time, numLots int) error {
+	if len(l.washSaleRules) == 0 {
+		return nil
+	}
+	sells := txType == SellTransaction || txType == ExchangeTaxableTransaction
+	purchases := txType == PurchaseTransaction || txType == ExchangeTaxableTransaction
+	if !sells && !purchases {
+		return nil
+	}
+
+	// the purchases and sales recorded so far
+	lotsByName := map[string]*Lot{}
+	for _, lot := range l.lots[:numLots] {
+		lotsByName[lot.name] = lot
+	}
+	var (
+		earlierPurchases []washSalePurchase
+		earlierSales     []*Lot
+	)
+	for _, tx := range l.transactions {
+		if tx.Type == PurchaseTransaction || tx.Type == ExchangeTaxableTransaction {
+			for _, p := range tx.Outputs {
+				earlierPurchases = append(earlierPurchases, washSalePurchase{lotsByName[p.LotName], tx.Date})
+			}
+		}
+		if tx.Type == SellTransaction || tx.Type == ExchangeTaxableTransaction {
+			for _, name := range tx.LotNames {
+				if lot := lotsByName[name]; lot != nil && lot.lotType == TaxableGains {
+					earlierSales = append(earlierSales, lot)
+				}
+			}
+		}
+	}
+
+	newLots := append([]*Lot(nil), l.lots[numLots:]...)
+	var newPurchases []washSalePurchase
+	if purchases {
+		for _, lot := range newLots {
+			if lot.lotType == Asset {
+				newPurchases = append(newPurchases, washSalePurchase{lot, date})
+			}
+		}
+	}
+	for _, lot := range newLots {
+		switch {
+		case sells && lot.lotType == TaxableGains:
+			// a sale takes the purchases around it as replacements
+			for _, p := range earlierPurchases {
+				if err := l.washSale(lot, p, false); err != nil {
+					return err
+				}
+			}
+			for _, p := range newPurchases {
+				if err := l.washSale(lot, p, true); err != nil {
+					return err
+				}
+			}
+		case purchases && lot.lotType == Asset:
+			// a purchase replaces the sales around it
+			for _, sale := range earlierSales {
+				if err := l.washSale(sale, washSalePurchase{lot, date}, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// washSale disallows the loss of the sale recorded in the gains lot for as much as the purchase replaces, if the
+// purchase is a replacement. The replacement amount is moved to a new child lot of the purchased lot, with the
+// disallowed loss added to its cost basis. isNew is set if the purchased lot is new in the transaction in progress.
+func (l *Ledger) washSale(gains *Lot, purchase washSalePurchase, isNew bool) error {
+	details := gains.taxableGainsDetails
+	rule, ok := l.washSaleRules[details.currency]
+	if !ok {
+		return nil
+	}
+	replacement := purchase.lot
+	if replacement == nil || replacement == gains.parent || replacement.washSaleOf != "" || replacement.amount.Sign() <= 0 {
+		return nil
+	}
+	if l.washSaleClass(replacement.currency) != rule.Class {
+		return nil
+	}
+	// the window is in calendar days, so neither the time of day nor a daylight saving change moves it
+	y, m, d := details.dateOfSale.Date()
+	sold := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = purchase.date.Date()
+	purchased := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if purchased.Before(sold.AddDate(0, 0, -rule.Days)) || purchased.After(sold.AddDate(0, 0, rule.Days)) {
+		return nil
+	}
+
+	loss := details.costBasis.Sub(details.proceeds)
+	remaining := details.soldAmount.Sub(details.washSaleReplaced)
+	if loss.Sign() <= 0 || remaining.Sign() <= 0 {
+		return nil
+	}
+	amount := minDecimal(remaining, replacement.amount)
+	// the last replacement takes whatever is left of the loss, so none is lost to rounding
+	disallowed := loss.Sub(details.washSaleDisallowed)
+	if amount.Cmp(remaining) < 0 {
+		disallowed = loss.Mul(amount).Quo(details.soldAmount, l.basisPlaces())
+	}
+
+	costBasis, err := replacement.Remove(replacement.currency, amount, l.basisPlaces())
+	if err != nil {
+		return err
+	}
+	if !isNew {
+		l.recordInput(Posting{LotName: replacement.name, Account: replacement.account, Currency: replacement.currency,
+			Amount: amount, CostBasis: costBasis})
+	}
+	held := details.dateOfSale.Sub(details.originalPurchaseTime)
+	newLot := NewChildLot(replacement, Asset, replacement.originalPurchaseTime.Add(-held), replacement.account,
+		replacement.currency, amount, costBasis.Add(disallowed))
+	newLot.washSaleOf, newLot.washSaleLoss = gains.name, disallowed
+	l.lots = append(l.lots, newLot)
+
+	details.washSaleReplaced = details.washSaleReplaced.Add(amount)
+	details.washSaleDisallowed = details.washSaleDisallowed.Add(disallowed)
+	return nil
+}
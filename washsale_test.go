@@ -0,0 +1,195 @@
+package ledger_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/slatteryjim/cost-basis-tracking"
+)
+
+const (
+	Brokerage = ledger.Account("Brokerage")
+
+	VTI = ledger.Currency("VTI")
+	VOO = ledger.Currency("VOO")
+	IVV = ledger.Currency("IVV")
+)
+
+func TestWashSales(t *testing.T) {
+	t.Run("repurchased after the sale", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, nil)
+		l.SetWashSaleRule(VTI, ledger.WashSaleRule{})
+		_, err := l.DepositNewMoney(d("2018-01-02"), Brokerage, n("3000"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-01-02"), "1", Brokerage, VTI, n("10"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2018-06-01"), "1.1", VTI, n("10"), n("800"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// replaces 6 of the 10 sold, so 6/10 of the $200 loss is disallowed
+		_, err = l.Purchase(d("2018-06-15"), "1", Brokerage, VTI, n("6"), n("480"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// too late to replace the rest
+		_, err = l.Purchase(d("2018-07-02"), "1", Brokerage, VTI, n("5"), n("400"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// the replacement is long-term after just over 7 months, thanks to the 5 months the sold lot was held
+		_, err = l.SellTaxable(d("2019-01-20"), "1.2.1", VTI, n("6"), n("700"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(l.PrintLots()).To(Equal(
+			`1        2018-01-02 Brokerage USD 1120.000000000  (basis:$1120.000000  price:$1.000000)
+1.1      2018-01-02 Brokerage VTI 0.000000000     (basis:$0.000000     price:$NaN)
+1.1.1    2018-06-01 Taxable Gains (short-term) from sale on Brokerage of VTI 10.000000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 800.000000, gains=USD -80.000000, note=sold VTI for USD, wash sale (W) disallowed=USD 120.000000
+1.2      2018-06-15 Brokerage VTI 0.000000000  (basis:$0.000000    price:$NaN)
+1.2.1    2018-01-16 Brokerage VTI 0.000000000  (basis:$0.000000    price:$NaN)
+1.3      2018-07-02 Brokerage VTI 5.000000000  (basis:$400.000000  price:$80.000000)
+1.2.1.1  2019-01-20 Taxable Gains (long-term) from sale on Brokerage of VTI 6.000000000 originally purchased 2018-01-16 for USD 600.000000. proceeds=USD 700.000000, gains=USD 100.000000, note=sold VTI for USD
+`))
+		g.Expect(l.PrintTaxableGains()).To(Equal(
+			`1.1.1	2018-06-01 Taxable Gains (short-term) from sale on Brokerage of VTI 10.000000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 800.000000, gains=USD -80.000000, note=sold VTI for USD, wash sale (W) disallowed=USD 120.000000
+1.2.1.1	2019-01-20 Taxable Gains (long-term) from sale on Brokerage of VTI 6.000000000 originally purchased 2018-01-16 for USD 600.000000. proceeds=USD 700.000000, gains=USD 100.000000, note=sold VTI for USD
+(2018's capital gains: short-term:$-80.00 long-term:$0.00)
+(2019's capital gains: short-term:$0.00 long-term:$100.00)
+(Total capital gains: short-term:$-80.00 long-term:$100.00)
+`))
+
+		sections, err := l.Form8949(ledger.Form8949Options{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ledger.PrintForm8949(sections)).To(Equal(
+			`2018 Form 8949 Part I (short-term), box C
+(a) description   (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+10.000000000 VTI  01/02/2018    06/01/2018  800.00        1000.00         W         120.00          -80.00
+totals                                      800.00        1000.00                   120.00          -80.00
+
+2019 Form 8949 Part II (long-term), box F
+(a) description  (b) acquired  (c) sold    (d) proceeds  (e) cost basis  (f) code  (g) adjustment  (h) gain or loss
+6.000000000 VTI  01/16/2018    01/20/2019  700.00        600.00                                    100.00
+totals                                     700.00        600.00                    0.00            100.00
+
+2018 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+3     800.00        1000.00         120.00           -80.00
+
+2019 Schedule D
+line  (d) proceeds  (e) cost basis  (g) adjustments  (h) gain or loss
+10    700.00        600.00          0.00             100.00
+`))
+
+		b := &bytes.Buffer{}
+		g.Expect(l.WriteBeancount(b)).To(Succeed())
+		// the disallowed loss is posted as it's added to the replacement's cost basis
+		g.Expect(b.String()).To(ContainSubstring(`
+2018-06-15 * "purchase"
+  Assets:Brokerage  -480 USD
+  Assets:Brokerage  6 VTI {{600.00 USD, 2018-01-16, "1.2.1"}}
+  Income:CapitalGains:WashSales  -120.00 USD
+`))
+	})
+
+	t.Run("purchased before the sale, in the same class", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, nil)
+		l.SetWashSaleRule(VOO, ledger.WashSaleRule{Class: "S&P 500"})
+		l.SetWashSaleRule(IVV, ledger.WashSaleRule{Class: "S&P 500"})
+		_, err := l.DepositNewMoney(d("2018-01-02"), Brokerage, n("3000"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-01-02"), "1", Brokerage, VOO, n("4"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-05-20"), "1", Brokerage, IVV, n("10"), n("900"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// VTI isn't in the class
+		_, err = l.Purchase(d("2018-05-21"), "1", Brokerage, VTI, n("10"), n("900"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.SellTaxable(d("2018-06-01"), "1.1", VOO, n("4"), n("900"))
+		g.Expect(err).NotTo(HaveOccurred())
+		// gains aren't affected
+		_, err = l.SellTaxable(d("2018-06-02"), "1.2", IVV, n("1"), n("95"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(l.PrintLots()).To(Equal(
+			`1      2018-01-02 Brokerage USD 200.000000000  (basis:$200.000000  price:$1.000000)
+1.1    2018-01-02 Brokerage VOO 0.000000000    (basis:$0.000000    price:$NaN)
+1.2    2018-05-20 Brokerage IVV 5.000000000    (basis:$450.000000  price:$90.000000)
+1.3    2018-05-21 Brokerage VTI 10.000000000   (basis:$900.000000  price:$90.000000)
+1.1.1  2018-06-01 Taxable Gains (short-term) from sale on Brokerage of VOO 4.000000000 originally purchased 2018-01-02 for USD 1000.000000. proceeds=USD 900.000000, gains=USD 0.000000, note=sold VOO for USD, wash sale (W) disallowed=USD 100.000000
+1.2.1  2017-12-21 Brokerage IVV 4.000000000  (basis:$460.000000  price:$115.000000)
+1.2.2  2018-06-02 Taxable Gains (short-term) from sale on Brokerage of IVV 1.000000000 originally purchased 2018-05-20 for USD 90.000000. proceeds=USD 95.000000, gains=USD 5.000000, note=sold IVV for USD
+`))
+		g.Expect(l.PrintTransactions()).To(Equal(
+			`2018-01-02 deposit Brokerage lots:1
+  out  1  Brokerage USD 3000.000000000  (basis:$3000.00)
+2018-01-02 purchase Brokerage lots:1.1
+  in   1    Brokerage USD 1000.000000000  (basis:$1000.00)
+  out  1.1  Brokerage VOO 4.000000000     (basis:$1000.00)
+2018-05-20 purchase Brokerage lots:1.2
+  in   1    Brokerage USD 900.000000000  (basis:$900.00)
+  out  1.2  Brokerage IVV 10.000000000   (basis:$900.00)
+2018-05-21 purchase Brokerage lots:1.3
+  in   1    Brokerage USD 900.000000000  (basis:$900.00)
+  out  1.3  Brokerage VTI 10.000000000   (basis:$900.00)
+2018-06-01 sell Brokerage lots:1.1.1,1.2.1
+  in   1.1    Brokerage VOO 4.000000000  (basis:$1000.00  value:$900.00)
+  in   1.2    Brokerage IVV 4.000000000  (basis:$360.00)
+  out  1.2.1  Brokerage IVV 4.000000000  (basis:$460.00)
+2018-06-02 sell Brokerage lots:1.2.2
+  in  1.2  Brokerage IVV 1.000000000  (basis:$90.00  value:$95.00)
+`))
+
+		saved := &bytes.Buffer{}
+		g.Expect(l.Save(saved)).To(Succeed())
+		loaded, err := ledger.Load(saved, nil)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.PrintLots()).To(Equal(l.PrintLots()))
+		g.Expect(loaded.PrintTaxableGains()).To(Equal(l.PrintTaxableGains()))
+		rule, ok := loaded.WashSaleRule(IVV)
+		g.Expect(ok).To(BeTrue())
+		g.Expect(rule).To(Equal(ledger.WashSaleRule{Class: "S&P 500", Days: ledger.WashSaleDays}))
+	})
+
+	t.Run("repurchased on the 30th calendar day", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		edt, est := time.FixedZone("EDT", -4*60*60), time.FixedZone("EST", -5*60*60)
+		for _, dates := range [][2]time.Time{
+			// later in the day than the sale
+			{d("2018-06-01").Add(9 * time.Hour), d("2018-07-01").Add(16 * time.Hour)},
+			// after the clocks go back
+			{time.Date(2018, 10, 15, 0, 0, 0, 0, edt), time.Date(2018, 11, 14, 0, 0, 0, 0, est)},
+		} {
+			l := ledger.New(USD, nil)
+			l.SetWashSaleRule(VTI, ledger.WashSaleRule{})
+			_, err := l.DepositNewMoney(d("2018-01-02"), Brokerage, n("3000"), n("3000"))
+			g.Expect(err).NotTo(HaveOccurred())
+			_, err = l.Purchase(d("2018-01-02"), "1", Brokerage, VTI, n("10"), n("1000"))
+			g.Expect(err).NotTo(HaveOccurred())
+			gainsLot, err := l.SellTaxable(dates[0], "1.1", VTI, n("10"), n("800"))
+			g.Expect(err).NotTo(HaveOccurred())
+			_, err = l.Purchase(dates[1], "1", Brokerage, VTI, n("10"), n("800"))
+			g.Expect(err).NotTo(HaveOccurred())
+
+			g.Expect(gainsLot.TaxableGainsDetails().WashSaleDisallowed().String()).To(Equal("200.00"))
+		}
+	})
+
+	t.Run("not applied without a rule", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		l := ledger.New(USD, nil)
+		_, err := l.DepositNewMoney(d("2018-01-02"), Brokerage, n("3000"), n("3000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-01-02"), "1", Brokerage, VTI, n("10"), n("1000"))
+		g.Expect(err).NotTo(HaveOccurred())
+		gainsLot, err := l.SellTaxable(d("2018-06-01"), "1.1", VTI, n("10"), n("800"))
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = l.Purchase(d("2018-06-15"), "1", Brokerage, VTI, n("6"), n("480"))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		g.Expect(gainsLot.TaxableGainsDetails().WashSaleDisallowed().IsZero()).To(BeTrue())
+		g.Expect(gainsLot.TaxableGainsDetails().Gains().String()).To(Equal("-200.00"))
+	})
+}